@@ -1,10 +1,19 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
 	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/resolver/wrap"
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapapi"
 	"github.com/tektoncd/pipeline/pkg/apis/resolution/v1alpha1"
 	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	"google.golang.org/grpc"
 	filteredinformerfactory "knative.dev/pkg/client/injection/kube/informers/factory/filtered"
+	"knative.dev/pkg/injection"
 	"knative.dev/pkg/injection/sharedmain"
 	"knative.dev/pkg/signals"
 )
@@ -17,7 +26,56 @@ const (
 func main() {
 	ctx := filteredinformerfactory.WithSelectors(signals.NewContext(), v1alpha1.ManagedByLabelKey)
 
-	sharedmain.MainWithContext(ctx, ControllerLogKey,
-		framework.NewController(ctx, &wrap.Resolver{}),
+	selfTester := wrap.NewSelfTester()
+	port := os.Getenv("SELFTEST_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	go func() {
+		log.Fatal(http.ListenAndServe(":"+port, selfTester))
+	}()
+
+	// The HTTP and gRPC APIs are optional
+	resolver := &wrap.Resolver{}
+	api := wrap.NewAPI(resolver)
+	imageWatcher := wrap.NewImageDigestWatcher(resolver)
+	if apiPort := os.Getenv("WRAP_API_PORT"); apiPort != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(":"+apiPort, api))
+		}()
+	}
+	if grpcPort := os.Getenv("WRAP_GRPC_PORT"); grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("failed to listen on %s: %v", grpcPort, err)
+		}
+		srv := grpc.NewServer()
+		wrapapi.RegisterWrapperServer(srv, wrap.NewGRPCService(api))
+		go func() {
+			log.Fatal(srv.Serve(lis))
+		}()
+	}
+
+	// Same as sharedmain.MainWithContext, with the client settings of the
+	// resolver configuration
+	disableHighAvailability := flag.Bool("disable-ha", false,
+		"Whether to disable high-availability functionality for this component.")
+	cfg := injection.ParseAndGetRESTConfigOrDie()
+	if *disableHighAvailability {
+		ctx = sharedmain.WithHADisabled(ctx)
+	}
+	if err := wrap.ApplyClientSettings(ctx, cfg); err != nil {
+		log.Fatalf("failed to apply the client settings of %s: %v", wrap.ResolverConfigName(), err)
+	}
+
+	// Variants of the resolver running side by side need their own
+	// component, e.g. for leader election
+	component := ControllerLogKey
+	if t := wrap.ResolverType(); t != wrap.LabelValueWrapResolverType {
+		component += "-" + t
+	}
+
+	sharedmain.MainWithConfig(ctx, component, cfg,
+		imageWatcher.Watch(api.Watch(selfTester.Watch(wrap.WithConfigValidation(framework.NewController(ctx, resolver))))),
 	)
 }
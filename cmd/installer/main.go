@@ -0,0 +1,86 @@
+// installer renders the manifests installing the wrap resolver with the
+// given options, e.g. to be applied directly or consumed by Kustomize or
+// Helm.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/install"
+	"sigs.k8s.io/yaml"
+)
+
+// configFlag collects the key=value pairs of the resolver configuration.
+type configFlag map[string]string
+
+func (c configFlag) String() string {
+	return fmt.Sprint(map[string]string(c))
+}
+
+func (c configFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("%q is not a key=value pair", value)
+	}
+	c[k] = v
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	var opts install.Options
+	config := configFlag{}
+	file := flag.String("f", "", "YAML file of the install options, the flags below override it")
+	namespace := flag.String("namespace", "", "namespace of the Tekton resolvers")
+	version := flag.String("version", "", "version labeling the resources")
+	controllerImage := flag.String("controller-image", "", "image of the resolver controller")
+	prefetcherImage := flag.String("prefetcher-image", "", "image of the prefetcher DaemonSet")
+	layerCachePath := flag.String("layer-cache-path", "", "directory of the nodes where layers are cached")
+	prefetch := flag.Bool("prefetch", false, "install the prefetcher DaemonSet")
+	flag.Var(config, "config", "key=value of the resolver configuration, can be repeated")
+	flag.Parse()
+
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := yaml.UnmarshalStrict(b, &opts); err != nil {
+			log.Fatalf("invalid options in %s: %v", *file, err)
+		}
+	}
+	for flagValue, opt := range map[string]*string{
+		*namespace:       &opts.Namespace,
+		*version:         &opts.Version,
+		*controllerImage: &opts.ControllerImage,
+		*prefetcherImage: &opts.PrefetcherImage,
+		*layerCachePath:  &opts.LayerCachePath,
+	} {
+		if flagValue != "" {
+			*opt = flagValue
+		}
+	}
+	if *prefetch {
+		opts.Prefetch = true
+	}
+	if len(config) > 0 && opts.Config == nil {
+		opts.Config = map[string]string{}
+	}
+	for k, v := range config {
+		opts.Config[k] = v
+	}
+
+	objs, err := install.Render(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := install.YAML(objs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(b)
+}
@@ -0,0 +1,86 @@
+// prefetcher runs on every node as a DaemonSet, and pre-pulls the workspace
+// images of wrapped Pipelines in the node-local layer cache, so that the
+// next task of a PipelineRun scheduled on the node starts importing its
+// workspaces from the cache while the current task is still finishing.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/prefetch"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+func main() {
+	cacheDir := flag.String("cache", "/wrap/layer-cache", "directory of the node-local layer cache")
+	jobs := flag.Int("jobs", 2, "maximum number of concurrent prefetches")
+	flag.Parse()
+
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		log.Fatal("NODE_NAME must be set")
+	}
+
+	zl, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := zl.Sugar()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		logger.Fatalf("failed to get the in-cluster config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		logger.Fatalf("failed to create the kubernetes client: %v", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 10*time.Minute,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = prefetch.Label + "=true"
+		}))
+	podInformer := factory.Core().V1().Pods()
+	p := prefetch.New(nodeName, *cacheDir, podInformer.Lister(), logger, *jobs)
+
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				p.OnPod(ctx, pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				p.OnPod(ctx, pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				p.Forget(pod)
+			}
+		},
+	})
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	logger.Infof("prefetching workspace images for node %s in %s", nodeName, *cacheDir)
+	<-ctx.Done()
+}
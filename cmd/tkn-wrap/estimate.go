@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/resolver/wrap"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// runEstimate prints what the runs of a wrapped Pipeline (or Task) store in
+// the registries and buckets, from its StorageEstimateAnnotation. The size
+// of each workspace is the one of -size, or the one of the last export of
+// the previous runs, read from the image of its target.
+func runEstimate(args []string) error {
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	file := fs.String("f", "", "file of the wrapped Pipeline, e.g. the decoded data of its ResolutionRequest, - for stdin")
+	sizesFlag := fs.String("size", "", "comma separated sizes of the workspaces, e.g. source=2Gi, instead of the ones of the previous runs")
+	runs := fs.Int("runs", 30, "number of runs to estimate the storage of the retained images for")
+	fs.Parse(args)
+
+	var b []byte
+	var err error
+	switch *file {
+	case "":
+		return fmt.Errorf("-f is required")
+	case "-":
+		b, err = io.ReadAll(os.Stdin)
+	default:
+		b, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	var obj metav1.PartialObjectMetadata
+	if err := yaml.Unmarshal(b, &obj); err != nil {
+		return fmt.Errorf("invalid %s: %v", *file, err)
+	}
+	m, err := wrap.ParseMetadata(obj.Annotations)
+	if err != nil {
+		return err
+	}
+	if m.StorageEstimate == nil {
+		return fmt.Errorf("%s has no %s annotation, is it wrapped by a recent resolver?", *file, wrap.StorageEstimateAnnotation)
+	}
+	sizes := map[string]int64{}
+	if *sizesFlag != "" {
+		for _, part := range strings.Split(*sizesFlag, ",") {
+			ws, v, ok := strings.Cut(part, "=")
+			q, err := resource.ParseQuantity(v)
+			if !ok || err != nil {
+				return fmt.Errorf("invalid -size %q, <workspace>=<size>", part)
+			}
+			sizes[ws] = q.Value()
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "WORKSPACE\tWRAPPER\tEXPORTS\tLAYERS\tTAGS\tSIZE\tPER RUN\tAFTER %d RUNS\n", *runs)
+	var perRunTotal, total int64
+	unknown := false
+	for _, s := range m.StorageEstimate.Workspaces {
+		size, ok := sizes[s.Workspace]
+		if !ok {
+			if size, err = previousExportSize(s); err != nil {
+				log.Printf("Unknown size of workspace %s, set it with -size: %v", s.Workspace, err)
+				unknown = true
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t?\t?\t?\n", s.Workspace, s.Wrapper, s.Exports, s.Exports*s.Layers, len(s.Tags))
+				continue
+			}
+		}
+		// The image appends each export, the tarballs are overwritten
+		perRun := size
+		if s.Wrapper == wrap.OCIWrapper {
+			perRun = size * int64(s.Exports)
+		}
+		// The next runs overwrite the images that aren't retained
+		after := perRun
+		if s.Retained {
+			after = perRun * int64(*runs)
+		}
+		perRunTotal += perRun
+		total += after
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", s.Workspace, s.Wrapper, s.Exports, s.Exports*s.Layers, len(s.Tags), formatSize(size), formatSize(perRun), formatSize(after))
+	}
+	if unknown {
+		fmt.Fprintf(w, "TOTAL\t\t\t\t\t\t>= %s\t>= %s\n", formatSize(perRunTotal), formatSize(total))
+	} else {
+		fmt.Fprintf(w, "TOTAL\t\t\t\t\t\t%s\t%s\n", formatSize(perRunTotal), formatSize(total))
+	}
+	return w.Flush()
+}
+
+// previousExportSize returns the compressed size of the last export of the
+// workspace by the previous runs: the last layers of the image of its
+// target.
+func previousExportSize(s wrap.WorkspaceStorage) (int64, error) {
+	if s.Wrapper != wrap.OCIWrapper {
+		return 0, fmt.Errorf("only the sizes of the %s wrapper are read from the previous runs", wrap.OCIWrapper)
+	}
+	if strings.Contains(s.Target, "$(") {
+		return 0, fmt.Errorf("the target %s is run-scoped", s.Target)
+	}
+	ref, err := name.ParseReference(s.Target)
+	if err != nil {
+		return 0, err
+	}
+	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return 0, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return 0, err
+	}
+	n := s.Layers
+	if n > len(layers) {
+		n = len(layers)
+	}
+	var size int64
+	for _, l := range layers[len(layers)-n:] {
+		layerSize, err := l.Size()
+		if err != nil {
+			return 0, err
+		}
+		size += layerSize
+	}
+	return size, nil
+}
+
+// formatSize formats the bytes with a binary suffix, e.g. 1.5Gi.
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
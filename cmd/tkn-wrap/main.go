@@ -0,0 +1,167 @@
+// tkn-wrap helps adopting wrapped Pipelines. Installed in the PATH, it is
+// available as a tkn plugin: tkn wrap <command>.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/resolver/wrap"
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const usage = `Usage: tkn-wrap <command> [flags]
+
+Commands:
+  lint        report the constructs of a Pipeline incompatible or risky to wrap
+  base-image  build or push the base image of the workspace images
+  migrate     propose wrapped replacements of the PipelineRuns using PVCs
+  estimate    estimate the registry storage the runs of a wrapped Pipeline take
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "lint":
+		os.Exit(runLint(args))
+	case "base-image":
+		if err := runBaseImage(args); err != nil {
+			log.Fatal(err)
+		}
+	case "migrate":
+		if err := runMigrate(args); err != nil {
+			log.Fatal(err)
+		}
+	case "estimate":
+		if err := runEstimate(args); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+// runLint prints the findings of the Pipeline and returns 1 if any is an
+// error.
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	file := fs.String("f", "", "file of the Pipeline to lint, - for stdin")
+	workspaces := fs.String("workspaces", "", "comma separated workspaces to wrap, all of them if empty")
+	fs.Parse(args)
+
+	var b []byte
+	var err error
+	switch *file {
+	case "":
+		log.Fatal("-f is required")
+	case "-":
+		b, err = io.ReadAll(os.Stdin)
+	default:
+		b, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var p v1beta1.Pipeline
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		log.Fatalf("invalid Pipeline in %s: %v", *file, err)
+	}
+
+	var ws []string
+	if *workspaces != "" {
+		ws = strings.Split(*workspaces, ",")
+	}
+	status := 0
+	for _, f := range wrap.Lint(&p.Spec, ws) {
+		fmt.Println(f)
+		if f.Severity == wrap.SeverityError {
+			status = 1
+		}
+	}
+	return status
+}
+
+const baseImageUsage = `Usage: tkn-wrap base-image <build|push> [flags]
+
+Commands:
+  build  write the base image in a tarball, e.g. to load it in a registry
+  push   push the base image to a registry, for all the platforms, e.g.
+         to set it as base-image in the wrapresolver-config ConfigMap
+`
+
+func runBaseImage(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, baseImageUsage)
+		os.Exit(2)
+	}
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "build":
+		fs := flag.NewFlagSet("base-image build", flag.ExitOnError)
+		output := fs.String("o", "base.tar", "tarball to write the image in")
+		tag := fs.String("t", wrap.DefaultBaseImage, "tag of the image in the tarball")
+		platform := fs.String("platform", "linux/amd64", "platform of the image, a tarball holds a single one")
+		fs.Parse(args)
+
+		ref, err := name.NewTag(*tag)
+		if err != nil {
+			return fmt.Errorf("invalid tag %s: %v", *tag, err)
+		}
+		p, err := v1.ParsePlatform(*platform)
+		if err != nil {
+			return fmt.Errorf("invalid platform %s: %v", *platform, err)
+		}
+		img, err := wrapstep.PlatformBaseImage(*p)
+		if err != nil {
+			return err
+		}
+		if err := tarball.WriteToFile(*output, ref, img); err != nil {
+			return err
+		}
+		log.Printf("Wrote %s in %s", ref, *output)
+	case "push":
+		fs := flag.NewFlagSet("base-image push", flag.ExitOnError)
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: tkn-wrap base-image push <image>")
+		}
+
+		ref, err := name.ParseReference(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("invalid image %s: %v", fs.Arg(0), err)
+		}
+		// Push the manifest list for all the platforms
+		idx, err := wrapstep.BaseIndex()
+		if err != nil {
+			return err
+		}
+		if err := remote.WriteIndex(ref, idx, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+			return err
+		}
+		digest, err := idx.Digest()
+		if err != nil {
+			return err
+		}
+		fmt.Println(ref.Context().Digest(digest.String()))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", cmd, baseImageUsage)
+		os.Exit(2)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/resolver/wrap"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	clientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// runMigrate scans the PipelineRuns binding volumeClaimTemplate workspaces
+// and prints the wrapped replacement of the latest one of each Pipeline,
+// preceded by the findings of lint as comments. The PipelineRuns that can't
+// be migrated are reported on stderr.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig file, the default loading rules apply if empty")
+	namespace := fs.String("n", "", "namespace to scan, the one of the current context if empty")
+	allNamespaces := fs.Bool("A", false, "scan all the namespaces")
+	target := fs.String("target", "", "target of the wrapped workspaces, where {{pipeline}} is replaced by the name of the Pipeline, e.g. quay.io/me/{{pipeline}}-{{workspace}}:$(context.pipelineRun.name)")
+	fs.Parse(args)
+	if *target == "" {
+		return fmt.Errorf("-target is required")
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+	ns := metav1.NamespaceAll
+	if !*allNamespaces {
+		ns = *namespace
+		if ns == "" {
+			var err error
+			if ns, _, err = config.Namespace(); err != nil {
+				return err
+			}
+		}
+	}
+	cfg, err := config.ClientConfig()
+	if err != nil {
+		return err
+	}
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	runs, err := client.TektonV1beta1().PipelineRuns(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	migrated := 0
+	for _, pr := range wrap.LatestRuns(runs.Items) {
+		pr := pr
+		var p *v1beta1.Pipeline
+		if ref := pr.Spec.PipelineRef; ref != nil && ref.Name != "" && ref.Bundle == "" {
+			if p, err = client.TektonV1beta1().Pipelines(pr.Namespace).Get(ctx, ref.Name, metav1.GetOptions{}); err != nil {
+				log.Printf("Skipping PipelineRun %s/%s: %v", pr.Namespace, pr.Name, err)
+				continue
+			}
+		}
+		m, err := wrap.ProposeMigration(&pr, p, *target)
+		if err != nil {
+			log.Printf("Skipping PipelineRun %s/%s: %v", pr.Namespace, pr.Name, err)
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		if err := printMigration(m); err != nil {
+			return err
+		}
+		migrated++
+	}
+	log.Printf("Proposed %d wrapped PipelineRun(s)", migrated)
+	return nil
+}
+
+func printMigration(m *wrap.Migration) error {
+	b, err := yaml.Marshal(m.Replacement)
+	if err != nil {
+		return err
+	}
+	var comments strings.Builder
+	fmt.Fprintf(&comments, "# Replaces PipelineRun %s/%s of Pipeline %s, wrapping %s\n", m.Replacement.Namespace, m.PipelineRun, m.Pipeline, strings.Join(m.Workspaces, ","))
+	for _, f := range m.Findings {
+		fmt.Fprintf(&comments, "# %s\n", f)
+	}
+	_, err = fmt.Fprintf(os.Stdout, "---\n%s%s", comments.String(), b)
+	return err
+}
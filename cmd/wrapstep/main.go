@@ -0,0 +1,188 @@
+// wrapstep moves the content of a workspace in and out of OCI images. It
+// runs in the steps the wrap resolver injects in wrapped Pipelines.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+)
+
+const usage = `Usage: wrapstep <command> [flags] [-- flags]...
+
+Commands:
+  import  extract the content of an image in a workspace
+  export  append the content of a workspace to an image and push it
+
+The flags of each workspace are separated by --, so that a single step
+moves all the workspaces of a task.
+`
+
+func main() {
+	log.SetFlags(0)
+	// Set by the resolver, to correlate the logs with its resolution
+	if id := os.Getenv("WRAP_TRACE_ID"); id != "" {
+		log.SetPrefix("[" + id + "] ")
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "import":
+		err = runImport(ctx, args, injectFaults(cmd))
+	case "export":
+		err = runExport(ctx, args, injectFaults(cmd))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runImport imports the workspaces, in turn, of each group of flags.
+func runImport(ctx context.Context, args []string, fault error) error {
+	for {
+		rest, err := importWorkspace(ctx, args, fault)
+		if err != nil || len(rest) == 0 {
+			return err
+		}
+		args = rest
+	}
+}
+
+// importWorkspace imports the workspace of the first group of flags of
+// args, and returns the next groups.
+func importWorkspace(ctx context.Context, args []string, fault error) ([]string, error) {
+	var opts wrapstep.ImportOptions
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.StringVar(&opts.Source, "source", "", "image, or docker-archive: tarball, to import the content from")
+	fs.StringVar(&opts.Path, "path", "", "directory to extract the content in")
+	fs.StringVar(&opts.CacheDir, "cache", "", "directory where layers are cached by digest")
+	fs.Int64Var(&opts.MaxRate, "max-rate", 0, "maximum transfer rate with the registry in bytes per second, 0 for unlimited")
+	fs.DurationVar(&opts.Wait, "wait", 0, "how long to wait for the registry to serve the source, 0 for not waiting")
+	fs.BoolVar(&opts.AllowMissing, "allow-missing", false, "leave the workspace as is if the source doesn't exist")
+	bestEffort := fs.Bool("best-effort", false, "only warn if the import fails, leaving the workspace as is")
+	credentials := credentialsFlag(fs)
+	fs.Parse(args)
+	var err error
+	if opts.Keychain, err = wrapstep.Credentials(ctx, *credentials); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Extract workspace content from %s in %s", opts.Source, opts.Path)
+	if fault != nil {
+		return fs.Args(), warnIf(*bestEffort, fault)
+	}
+	return fs.Args(), warnIf(*bestEffort, wrapstep.Import(ctx, opts))
+}
+
+// runExport exports the workspaces, in turn, of each group of flags.
+func runExport(ctx context.Context, args []string, fault error) error {
+	for {
+		rest, err := exportWorkspace(ctx, args, fault)
+		if err != nil || len(rest) == 0 {
+			return err
+		}
+		args = rest
+	}
+}
+
+// exportWorkspace exports the workspace of the first group of flags of
+// args, and returns the next groups.
+func exportWorkspace(ctx context.Context, args []string, fault error) ([]string, error) {
+	var opts wrapstep.ExportOptions
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&opts.Path, "path", "", "directory to export")
+	fs.StringVar(&opts.Base, "base", "", "image, or docker-archive: tarball, to append the content onto")
+	fs.StringVar(&opts.Target, "target", "", "image to push to, or docker-archive: tarball to write")
+	fs.BoolVar(&opts.CompareAndSwap, "cas", false, "append onto the current target and only move the target if nobody pushed to it meanwhile")
+	fs.IntVar(&opts.Retries, "retries", 5, "how many times to retry a compare-and-swap export on conflict")
+	fs.IntVar(&opts.Chunks, "chunks", 1, "number of layers to split the content in, pushed concurrently")
+	fs.Int64Var(&opts.MaxRate, "max-rate", 0, "maximum transfer rate with the registry in bytes per second, 0 for unlimited")
+	fs.StringVar(&opts.ResultPath, "result", "", "file to report the pushed image in, as a JSON object with its uri and digest")
+	fs.StringVar(&opts.ArtifactType, "artifact-type", "", "media type of the config of the pushed image, its artifact type, in an OCI manifest")
+	fs.StringVar(&opts.Compression, "compression", wrapstep.CompressionGzip, fmt.Sprintf("compression of the pushed layers, among %s", strings.Join(wrapstep.Compressions(), ",")))
+	fs.StringVar(&opts.Owner, "owner", "", "<namespace>/<pipeline> owning the pushed image, refusing to push to a target owned by another pipeline")
+	fs.BoolVar(&opts.Force, "force", false, "push to a target owned by another pipeline")
+	exclude := fs.String("exclude", "", fmt.Sprintf("comma separated patterns of the paths left out of the exported content, with the ones of the %s file of the directory", wrapstep.WrapignoreFile))
+	tags := fs.String("tags", "", "comma separated list of other tags of the pushed image, in the repository of the target")
+	bestEffort := fs.Bool("best-effort", false, "only warn if the export fails")
+	credentials := credentialsFlag(fs)
+	fs.Parse(args)
+	var err error
+	if opts.Keychain, err = wrapstep.Credentials(ctx, *credentials); err != nil {
+		return nil, err
+	}
+	if *tags != "" {
+		opts.Tags = strings.Split(*tags, ",")
+	}
+	if *exclude != "" {
+		opts.Exclude = strings.Split(*exclude, ",")
+	}
+
+	log.Printf("Export workspace content from %s to %s", opts.Path, opts.Target)
+	if fault != nil {
+		return fs.Args(), warnIf(*bestEffort, fault)
+	}
+	return fs.Args(), warnIf(*bestEffort, wrapstep.Export(ctx, opts))
+}
+
+// credentialsFlag defines the flag of the credentials spec, see
+// wrapstep.Credentials, defaulting to WRAPSTEP_CREDENTIALS, e.g. set in the
+// image of a build registering its own providers.
+func credentialsFlag(fs *flag.FlagSet) *string {
+	spec := os.Getenv("WRAPSTEP_CREDENTIALS")
+	if spec == "" {
+		spec = wrapstep.DefaultCredentials
+	}
+	return fs.String("credentials", spec, fmt.Sprintf("comma separated credential providers, <provider> or <provider>=<arg>, among %s", strings.Join(wrapstep.CredentialProviders(), ",")))
+}
+
+// injectFaults simulates the faults listed in WRAPSTEP_FAULT_INJECT, set by
+// the fault-inject parameter of the wrap resolver: it sleeps for slow, and
+// returns the error the command has to fail with for registry-error.
+// Partial extracts are only simulated by the crane steps.
+func injectFaults(cmd string) error {
+	v := os.Getenv("WRAPSTEP_FAULT_INJECT")
+	if v == "" {
+		return nil
+	}
+	log.Printf("Simulated faults: %s", v)
+	var fault error
+	for _, f := range strings.Split(v, ",") {
+		switch f {
+		case "slow":
+			log.Printf("fault-inject: slowing down the %s by 30s", cmd)
+			time.Sleep(30 * time.Second)
+		case "registry-error":
+			fault = errors.New("fault-inject: simulated registry error")
+		}
+	}
+	return fault
+}
+
+// warnIf only prints err as a warning when best-effort.
+func warnIf(bestEffort bool, err error) error {
+	if err != nil && bestEffort {
+		log.Printf("Warning: %v, continuing", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+// Package install renders the manifests installing the wrap resolver, from
+// a small configuration API, so that platform teams can install consistent
+// configurations across clusters from code.
+package install
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/resolver/wrap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	partOf = "tekton-experimental-wrap-pipelines"
+
+	controllerName = "tekton-wrap-pipeline-controller"
+	prefetcherName = "tekton-wrap-pipeline-prefetcher"
+)
+
+// Options describes an installation of the wrap resolver.
+type Options struct {
+	// Namespace is the namespace of the Tekton resolvers,
+	// tekton-pipelines-resolvers by default.
+	Namespace string `json:"namespace,omitempty"`
+	// Version labels the installed resources, devel by default.
+	Version string `json:"version,omitempty"`
+	// ControllerImage is the image of the resolver controller.
+	ControllerImage string `json:"controllerImage,omitempty"`
+	// PrefetcherImage is the image of the prefetcher DaemonSet, only
+	// installed when Prefetch is set.
+	PrefetcherImage string `json:"prefetcherImage,omitempty"`
+	// Prefetch installs the prefetcher DaemonSet and enables the prefetch
+	// hints. It requires a LayerCachePath.
+	Prefetch bool `json:"prefetch,omitempty"`
+	// LayerCachePath is the directory of the nodes where layers are
+	// cached, see the layer-cache-path key of the resolver configuration.
+	LayerCachePath string `json:"layerCachePath,omitempty"`
+	// Config holds the other keys of the resolver configuration, e.g. the
+	// default values of the params, the base and wrapstep images, or the
+	// transfer rate limits.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+func (o *Options) setDefaults() {
+	if o.Namespace == "" {
+		o.Namespace = "tekton-pipelines-resolvers"
+	}
+	if o.Version == "" {
+		o.Version = "devel"
+	}
+	if o.ControllerImage == "" {
+		o.ControllerImage = "ghcr.io/openshift-pipelines/tekton-wrap-pipeline/controller:latest"
+	}
+	if o.PrefetcherImage == "" {
+		o.PrefetcherImage = "ghcr.io/openshift-pipelines/tekton-wrap-pipeline/prefetcher:latest"
+	}
+}
+
+// resolverConfig returns the data of the resolver ConfigMap.
+func (o Options) resolverConfig() map[string]string {
+	data := map[string]string{"default-wrapper": "oci"}
+	for k, v := range o.Config {
+		data[k] = v
+	}
+	if o.LayerCachePath != "" {
+		data["layer-cache-path"] = o.LayerCachePath
+	}
+	if o.Prefetch {
+		data["prefetch"] = "true"
+	}
+	return data
+}
+
+// Render returns the resources installing the wrap resolver.
+func Render(o Options) ([]runtime.Object, error) {
+	o.setDefaults()
+	config := o.resolverConfig()
+	if err := wrap.ValidateConfig(config); err != nil {
+		return nil, err
+	}
+	o.LayerCachePath = config["layer-cache-path"]
+	if o.Prefetch && o.LayerCachePath == "" {
+		return nil, fmt.Errorf("prefetch requires a layer cache path")
+	}
+
+	objs := []runtime.Object{
+		&corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      wrap.ConfigName,
+				Namespace: o.Namespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/component": "wrap-resolver",
+					"app.kubernetes.io/instance":  "default",
+					"app.kubernetes.io/part-of":   "wrap-reslovers",
+				},
+			},
+			Data: config,
+		},
+		controller(o),
+	}
+	if o.Prefetch {
+		objs = append(objs, prefetcher(o)...)
+	}
+	return objs, nil
+}
+
+// YAML marshals the resources in a multi-document YAML stream.
+func YAML(objs []runtime.Object) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+func labels(o Options, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":      component,
+		"app.kubernetes.io/component": component,
+		"app.kubernetes.io/instance":  "default",
+		"app.kubernetes.io/version":   o.Version,
+		"app.kubernetes.io/part-of":   partOf,
+	}
+}
+
+func selector(component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":      component,
+		"app.kubernetes.io/component": component,
+		"app.kubernetes.io/instance":  "default",
+		"app.kubernetes.io/part-of":   partOf,
+	}
+}
+
+func controller(o Options) *appsv1.Deployment {
+	replicas := int32(1)
+	noEscalation := false
+	podLabels := labels(o, "controller")
+	podLabels["app"] = controllerName
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      controllerName,
+			Namespace: o.Namespace,
+			Labels:    labels(o, "controller"),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector("controller")},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"cluster-autoscaler.kubernetes.io/safe-to-evict": "false"},
+					Labels:      podLabels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "tekton-pipelines-resolvers",
+					Containers: []corev1.Container{{
+						Name:  controllerName,
+						Image: o.ControllerImage,
+						Env: []corev1.EnvVar{
+							{Name: "SYSTEM_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+							{Name: "CONFIG_LEADERELECTION_NAME", Value: "config-leader-election"},
+							{Name: "CONFIG_LOGGING_NAME", Value: "config-logging"},
+							{Name: "CONFIG_OBSERVABILITY_NAME", Value: "config-observability"},
+							{Name: "METRICS_DOMAIN", Value: "experimental.tekton.dev/wrap-pipelines"},
+							{Name: "SELFTEST_PORT", Value: "8080"},
+						},
+						Ports: []corev1.ContainerPort{{Name: "selftest", ContainerPort: 8080}},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
+								Path: "/readiness",
+								Port: intstr.FromString("selftest"),
+							}},
+							PeriodSeconds: 10,
+						},
+						SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &noEscalation},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func prefetcher(o Options) []runtime.Object {
+	noEscalation := false
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	meta := metav1.ObjectMeta{
+		Name:   prefetcherName,
+		Labels: map[string]string{"app.kubernetes.io/component": "prefetcher", "app.kubernetes.io/part-of": partOf},
+	}
+	namespaced := *meta.DeepCopy()
+	namespaced.Namespace = o.Namespace
+	return []runtime.Object{
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: namespaced,
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: meta,
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"pods"},
+				Verbs:     []string{"get", "list", "watch"},
+			}},
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: meta,
+			Subjects: []rbacv1.Subject{{
+				Kind:      "ServiceAccount",
+				Name:      prefetcherName,
+				Namespace: o.Namespace,
+			}},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     prefetcherName,
+			},
+		},
+		&appsv1.DaemonSet{
+			TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      prefetcherName,
+				Namespace: o.Namespace,
+				Labels:    labels(o, "prefetcher"),
+			},
+			Spec: appsv1.DaemonSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: selector("prefetcher")},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels(o, "prefetcher")},
+					Spec: corev1.PodSpec{
+						ServiceAccountName: prefetcherName,
+						Containers: []corev1.Container{{
+							Name:  "prefetcher",
+							Image: o.PrefetcherImage,
+							Args:  []string{"-cache", "/wrap/layer-cache"},
+							Env: []corev1.EnvVar{{
+								Name:      "NODE_NAME",
+								ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+							}},
+							VolumeMounts:    []corev1.VolumeMount{{Name: "layer-cache", MountPath: "/wrap/layer-cache"}},
+							SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &noEscalation},
+						}},
+						Volumes: []corev1.Volume{{
+							Name: "layer-cache",
+							VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{
+								Path: o.LayerCachePath,
+								Type: &hostPathType,
+							}},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
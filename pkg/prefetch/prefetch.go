@@ -0,0 +1,116 @@
+// Package prefetch pre-pulls the workspace images of wrapped Pipelines in
+// the layer cache of the nodes running their tasks, while the tasks are
+// still running.
+package prefetch
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// Label marks the pods of the tasks with workspace images to prefetch.
+	Label = "wrap.tekton.dev/prefetch"
+	// ImagesAnnotation lists the workspace images to prefetch, comma
+	// separated.
+	ImagesAnnotation = "wrap.tekton.dev/prefetch-images"
+
+	pipelineRunLabel = "tekton.dev/pipelineRun"
+)
+
+// Prefetcher prefetches the images of the pods labeled with Label in the
+// layer cache of its node, when the PipelineRun of the pod runs a pod on
+// the node. The next tasks of the PipelineRun are likely to be scheduled
+// on the nodes it already uses, and their imports only have to pull the
+// layers exported since.
+type Prefetcher struct {
+	NodeName string
+	CacheDir string
+	Pods     corev1listers.PodLister
+	Logger   *zap.SugaredLogger
+
+	mu   sync.Mutex
+	done map[types.UID]bool
+	// sem limits the number of concurrent prefetches.
+	sem chan struct{}
+}
+
+// New returns a Prefetcher running at most jobs prefetches at once.
+func New(nodeName, cacheDir string, pods corev1listers.PodLister, logger *zap.SugaredLogger, jobs int) *Prefetcher {
+	return &Prefetcher{
+		NodeName: nodeName,
+		CacheDir: cacheDir,
+		Pods:     pods,
+		Logger:   logger,
+		done:     map[types.UID]bool{},
+		sem:      make(chan struct{}, jobs),
+	}
+}
+
+// OnPod prefetches the images of pod if it is running and its PipelineRun
+// uses the node.
+func (p *Prefetcher) OnPod(ctx context.Context, pod *corev1.Pod) {
+	if pod.Status.Phase != corev1.PodRunning || pod.Annotations[ImagesAnnotation] == "" {
+		return
+	}
+	if !p.usesNode(pod) {
+		return
+	}
+	p.mu.Lock()
+	if p.done[pod.UID] {
+		p.mu.Unlock()
+		return
+	}
+	p.done[pod.UID] = true
+	p.mu.Unlock()
+
+	for _, image := range strings.Split(pod.Annotations[ImagesAnnotation], ",") {
+		go p.prefetch(ctx, image)
+	}
+}
+
+// Forget drops what is known about a deleted pod.
+func (p *Prefetcher) Forget(pod *corev1.Pod) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.done, pod.UID)
+}
+
+func (p *Prefetcher) usesNode(pod *corev1.Pod) bool {
+	if pod.Spec.NodeName == p.NodeName {
+		return true
+	}
+	pipelineRun, ok := pod.Labels[pipelineRunLabel]
+	if !ok {
+		return false
+	}
+	pods, err := p.Pods.Pods(pod.Namespace).List(labels.SelectorFromSet(labels.Set{pipelineRunLabel: pipelineRun}))
+	if err != nil {
+		p.Logger.Errorf("failed to list the pods of PipelineRun %s/%s: %v", pod.Namespace, pipelineRun, err)
+		return false
+	}
+	for _, other := range pods {
+		if other.Spec.NodeName == p.NodeName {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Prefetcher) prefetch(ctx context.Context, image string) {
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+
+	p.Logger.Infof("prefetching %s", image)
+	if err := wrapstep.Prefetch(ctx, image, p.CacheDir); err != nil {
+		p.Logger.Warnf("failed to prefetch %s: %v", image, err)
+	}
+}
@@ -0,0 +1,112 @@
+package wrap
+
+import (
+	"sort"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// exportReaders returns, for each task of the Pipeline exporting one of the
+// wrapped workspaces, keyed by "<task>/<workspace>", the downstream tasks
+// importing it. Downstream tasks are the ones depending on the task,
+// directly or not, through runAfter or result references. The first task
+// never imports, and finally tasks use the workspaces as bound.
+func exportReaders(p *v1beta1.PipelineSpec, workspaces sets.String) map[string][]string {
+	dependents := taskDependents(p)
+	uses := map[string]sets.String{}
+	for i, t := range p.Tasks {
+		uses[t.Name] = sets.NewString()
+		for _, w := range t.Workspaces {
+			if workspaces.Has(w.Workspace) && i != 0 {
+				uses[t.Name].Insert(w.Workspace)
+			}
+		}
+	}
+
+	readers := map[string][]string{}
+	for _, t := range p.Tasks {
+		downstream := downstreamTasks(t.Name, dependents)
+		for _, w := range t.Workspaces {
+			if !workspaces.Has(w.Workspace) {
+				continue
+			}
+			key := t.Name + "/" + w.Workspace
+			readers[key] = []string{}
+			for _, d := range downstream {
+				if uses[d].Has(w.Workspace) {
+					readers[key] = append(readers[key], d)
+				}
+			}
+		}
+	}
+	return readers
+}
+
+// taskDependents returns the tasks depending directly on each task.
+func taskDependents(p *v1beta1.PipelineSpec) map[string][]string {
+	dependents := map[string][]string{}
+	for _, t := range p.Tasks {
+		for _, dep := range t.Deps() {
+			dependents[dep] = append(dependents[dep], t.Name)
+		}
+	}
+	return dependents
+}
+
+// downstreamTasks returns the tasks depending on name, directly or not.
+func downstreamTasks(name string, dependents map[string][]string) []string {
+	seen := sets.NewString()
+	queue := append([]string{}, dependents[name]...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if seen.Has(n) {
+			continue
+		}
+		seen.Insert(n)
+		queue = append(queue, dependents[n]...)
+	}
+	return seen.List()
+}
+
+// unreadExports returns the keys of the exports no downstream task reads.
+func unreadExports(readers map[string][]string) []string {
+	var unread []string
+	for key, r := range readers {
+		if len(r) == 0 {
+			unread = append(unread, key)
+		}
+	}
+	sort.Strings(unread)
+	return unread
+}
+
+// latestExporter returns the task exporting the workspace that name
+// imports: the upstream task using the workspace that no other such
+// upstream task runs after. It returns "" if there is none, or if
+// several upstream tasks export it concurrently.
+func latestExporter(p *v1beta1.PipelineSpec, name, workspace string) string {
+	dependents := taskDependents(p)
+	exporters := sets.NewString()
+	for _, t := range p.Tasks {
+		if t.Name == name {
+			continue
+		}
+		for _, w := range t.Workspaces {
+			if w.Workspace == workspace && sets.NewString(downstreamTasks(t.Name, dependents)...).Has(name) {
+				exporters.Insert(t.Name)
+			}
+		}
+	}
+	var latest []string
+	for _, e := range exporters.List() {
+		if !exporters.HasAny(downstreamTasks(e, dependents)...) {
+			latest = append(latest, e)
+		}
+	}
+	if len(latest) != 1 {
+		return ""
+	}
+	return latest[0]
+}
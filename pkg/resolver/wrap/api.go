@@ -0,0 +1,261 @@
+package wrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// maxAPIPipelineSize is the maximum size of the Pipelines posted to the
+	// API.
+	maxAPIPipelineSize = 4 << 20
+	// defaultAPITimeout is how long the resolutions of the API can take,
+	// unless the resolution-timeout of the resolver configuration is set,
+	// like with the resolution framework.
+	defaultAPITimeout = time.Minute
+)
+
+// API serves the resolutions of the Resolver over HTTP, for tooling outside
+// of the cluster to wrap Pipelines without creating ResolutionRequests:
+//   - POST /wrap?<param>=<value>&namespace=<namespace> wraps the Pipeline
+//     of the body, in YAML or JSON, with the params of the query and the
+//     current resolver configuration, and answers with the wrapped
+//     Pipeline in YAML. The Tasks it references are fetched from the
+//     namespace, the one of the Pipeline by default.
+//
+// Callers authenticate with a bearer token of the cluster and must be
+// allowed to create ResolutionRequests in the namespace.
+type API struct {
+	resolver *Resolver
+
+	mu         sync.Mutex
+	ctx        context.Context
+	kubeClient kubernetes.Interface
+	conf       map[string]string
+}
+
+// NewAPI returns an API serving the resolutions of r, once its controller
+// is set up with Watch.
+func NewAPI(r *Resolver) *API {
+	return &API{resolver: r}
+}
+
+// Watch wraps the constructor of the resolver controller to serve the
+// resolutions with the current resolver configuration.
+func (a *API) Watch(ctor injection.ControllerConstructor) injection.ControllerConstructor {
+	return func(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+		impl := ctor(ctx, cmw)
+		a.mu.Lock()
+		a.ctx, a.kubeClient = ctx, a.resolver.kubeClientSet
+		a.mu.Unlock()
+		cmw.Watch(ResolverConfigName(), func(cm *corev1.ConfigMap) {
+			conf := cm.Data
+			if conf == nil {
+				conf = map[string]string{}
+			}
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.conf = conf
+		})
+		return impl
+	}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/wrap" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	a.mu.Lock()
+	kubeClient := a.kubeClient
+	a.mu.Unlock()
+	if kubeClient == nil {
+		http.Error(w, errNotLoaded.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	user, code, err := authenticate(req.Context(), kubeClient, req.Header.Get("Authorization"))
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxAPIPipelineSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read the pipeline: %v", err), http.StatusBadRequest)
+		return
+	}
+	query := req.URL.Query()
+	pipeline, err := parsePipeline(body, query.Get("namespace"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if code, err := authorize(req.Context(), kubeClient, user, pipeline.Namespace); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	params := map[string]string{}
+	for k, v := range query {
+		if k != "namespace" {
+			params[k] = v[0]
+		}
+	}
+	data, err := a.wrapPipeline(req.Context(), params, pipeline)
+	var invalid invalidRequestError
+	switch {
+	case errors.Is(err, errNotLoaded):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.As(err, &invalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data)
+}
+
+var errNotLoaded = fmt.Errorf("%s not loaded yet", ResolverConfigName())
+
+// invalidRequestError is an error of the request, as opposed to the
+// failures to wrap its Pipeline.
+type invalidRequestError struct {
+	error
+}
+
+// parsePipeline parses the Pipeline of a request, in YAML or JSON, setting
+// its namespace if not empty.
+func parsePipeline(data []byte, namespace string) (*v1beta1.Pipeline, error) {
+	pipeline := &v1beta1.Pipeline{}
+	if err := yaml.Unmarshal(data, pipeline); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %v", err)
+	}
+	if pipeline.Kind != "" && pipeline.Kind != "Pipeline" {
+		return nil, fmt.Errorf("invalid pipeline: unexpected kind %s", pipeline.Kind)
+	}
+	if pipeline.Name == "" {
+		return nil, fmt.Errorf("invalid pipeline: the name is required")
+	}
+	if err := decodeStrict("Pipeline", pipeline.Name, data, &v1beta1.Pipeline{}); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %v", err)
+	}
+	if namespace != "" {
+		pipeline.Namespace = namespace
+	}
+	if pipeline.Namespace == "" {
+		return nil, fmt.Errorf("the namespace is required, in the request or the pipeline")
+	}
+	return pipeline, nil
+}
+
+// wrapPipeline wraps the Pipeline of a request with the params and the
+// current resolver configuration, like the resolutions of its namespace,
+// and returns it in YAML.
+func (a *API) wrapPipeline(ctx context.Context, params map[string]string, pipeline *v1beta1.Pipeline) ([]byte, error) {
+	a.mu.Lock()
+	base, conf := a.ctx, a.conf
+	a.mu.Unlock()
+	if conf == nil {
+		return nil, errNotLoaded
+	}
+	params[PipelineRefParam] = pipeline.Name
+
+	ctx = logging.WithLogger(ctx, logging.FromContext(base))
+	ctx = common.InjectRequestNamespace(ctx, pipeline.Namespace)
+	ctx = framework.InjectResolverConfigToContext(ctx, conf)
+	ctx, cancel := context.WithTimeout(ctx, a.resolver.GetResolutionTimeout(ctx, defaultAPITimeout))
+	defer cancel()
+
+	if err := a.resolver.ValidateParams(ctx, params); err != nil {
+		return nil, invalidRequestError{err}
+	}
+	ctx, err := a.resolver.withClusterEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params, _ = populateParamsWithDefaults(ctx, params)
+	resolved, err := a.resolver.resolvePipeline(ctx, params, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.Data(), nil
+}
+
+// authenticate returns the user the bearer token of the Authorization
+// header authenticates. It returns the HTTP status code to answer with
+// otherwise.
+func authenticate(ctx context.Context, kubeClient kubernetes.Interface, authorization string) (authenticationv1.UserInfo, int, error) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == "" || token == authorization {
+		return authenticationv1.UserInfo{}, http.StatusUnauthorized, fmt.Errorf("a bearer token is required")
+	}
+	review, err := kubeClient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, http.StatusInternalServerError, fmt.Errorf("failed to review the token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, http.StatusUnauthorized, fmt.Errorf("invalid token: %s", review.Status.Error)
+	}
+	return review.Status.User, 0, nil
+}
+
+// authorize checks that the user is allowed to create ResolutionRequests
+// in the namespace. It returns the HTTP status code to answer with
+// otherwise.
+func authorize(ctx context.Context, kubeClient kubernetes.Interface, user authenticationv1.UserInfo, namespace string) (int, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access, err := kubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "create",
+				Group:     "resolution.tekton.dev",
+				Resource:  "resolutionrequests",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to review the access of %s: %v", user.Username, err)
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("%s is not allowed to create resolutionrequests in namespace %s", user.Username, namespace)
+	}
+	return 0, nil
+}
@@ -0,0 +1,166 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+const (
+	validToken = "valid-token"
+	apiUser    = "alice"
+)
+
+// fakeAuthClient returns a clientset authenticating validToken as apiUser,
+// allowed to create ResolutionRequests in the allowed namespace only. The
+// SubjectAccessReviews it gets are recorded in reviews.
+func fakeAuthClient(allowed string, reviews *[]authorizationv1.SubjectAccessReviewSpec) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case validToken:
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: apiUser, Groups: []string{"dev"}, Extra: map[string]authenticationv1.ExtraValue{"scope": {"ci"}}},
+			}
+		case "broken":
+			return true, nil, fmt.Errorf("apiserver unavailable")
+		default:
+			review.Status = authenticationv1.TokenReviewStatus{Error: "unknown token"}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		if reviews != nil {
+			*reviews = append(*reviews, review.Spec)
+		}
+		a := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == apiUser && a.Namespace == allowed &&
+			a.Verb == "create" && a.Group == "resolution.tekton.dev" && a.Resource == "resolutionrequests"
+		return true, review, nil
+	})
+	return client
+}
+
+func TestAuthenticate(t *testing.T) {
+	client := fakeAuthClient("ci", nil)
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		wantCode      int
+	}{
+		{"valid", "Bearer " + validToken, 0},
+		{"missing", "", http.StatusUnauthorized},
+		{"not bearer", "Basic " + validToken, http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"invalid", "Bearer other", http.StatusUnauthorized},
+		{"review failure", "Bearer broken", http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			user, code, err := authenticate(context.Background(), client, tc.authorization)
+			if tc.wantCode == 0 {
+				if err != nil || user.Username != apiUser {
+					t.Fatalf("authenticate() = %v, %v, want %s", user, err, apiUser)
+				}
+				return
+			}
+			if err == nil || code != tc.wantCode {
+				t.Errorf("authenticate() = %d, %v, want %d", code, err, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var reviews []authorizationv1.SubjectAccessReviewSpec
+	client := fakeAuthClient("ci", &reviews)
+	user := authenticationv1.UserInfo{Username: apiUser, Groups: []string{"dev"}, UID: "1", Extra: map[string]authenticationv1.ExtraValue{"scope": {"ci"}}}
+	if code, err := authorize(context.Background(), client, user, "ci"); err != nil {
+		t.Errorf("authorize() in the allowed namespace = %d, %v", code, err)
+	}
+	if code, err := authorize(context.Background(), client, user, "prod"); err == nil || code != http.StatusForbidden {
+		t.Errorf("authorize() in another namespace = %d, %v, want %d", code, err, http.StatusForbidden)
+	}
+	other := authenticationv1.UserInfo{Username: "mallory"}
+	if code, err := authorize(context.Background(), client, other, "ci"); err == nil || code != http.StatusForbidden {
+		t.Errorf("authorize() of another user = %d, %v, want %d", code, err, http.StatusForbidden)
+	}
+	// The review is of the whole identity of the user
+	got := reviews[0]
+	if got.UID != "1" || len(got.Groups) != 1 || got.Groups[0] != "dev" || len(got.Extra["scope"]) != 1 {
+		t.Errorf("authorize() reviewed %+v, want the groups, uid and extra of the user", got)
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	const pipeline = `apiVersion: tekton.dev/v1beta1
+kind: Pipeline
+metadata:
+  name: build
+  namespace: ci
+spec:
+  tasks: []
+`
+	for _, tc := range []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		namespace     string
+		body          string
+		wantCode      int
+	}{
+		{name: "unknown path", method: http.MethodPost, path: "/other", wantCode: http.StatusNotFound},
+		{name: "get", method: http.MethodGet, path: "/wrap", wantCode: http.StatusMethodNotAllowed},
+		{name: "unauthenticated", method: http.MethodPost, path: "/wrap", wantCode: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodPost, path: "/wrap", authorization: "Bearer other", wantCode: http.StatusUnauthorized},
+		{name: "token review failure", method: http.MethodPost, path: "/wrap", authorization: "Bearer broken", wantCode: http.StatusInternalServerError},
+		{name: "oversized pipeline", method: http.MethodPost, path: "/wrap", authorization: "Bearer " + validToken, body: pipeline + "#" + strings.Repeat("x", maxAPIPipelineSize), wantCode: http.StatusBadRequest},
+		{name: "forbidden namespace", method: http.MethodPost, path: "/wrap", authorization: "Bearer " + validToken, namespace: "prod", wantCode: http.StatusForbidden},
+		// Authorized, the configuration isn't loaded in the test
+		{name: "authorized", method: http.MethodPost, path: "/wrap", authorization: "Bearer " + validToken, wantCode: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &API{resolver: &Resolver{}, kubeClient: fakeAuthClient("ci", nil)}
+			path := tc.path
+			if tc.namespace != "" {
+				path += "?namespace=" + tc.namespace
+			}
+			body := pipeline
+			if tc.body != "" {
+				body = tc.body
+			}
+			req := httptest.NewRequest(tc.method, path, strings.NewReader(body))
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			a.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Errorf("ServeHTTP() = %d %s, want %d", rec.Code, rec.Body, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPINotLoaded(t *testing.T) {
+	a := NewAPI(&Resolver{})
+	req := httptest.NewRequest(http.MethodPost, "/wrap", strings.NewReader(""))
+	req.Header.Set("Authorization", "Bearer "+validToken)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() before Watch = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
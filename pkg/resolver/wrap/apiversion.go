@@ -0,0 +1,55 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/yaml"
+)
+
+// marshalPipeline returns the wrapped Pipeline in YAML, as apiVersion:
+// APIVersionV1beta1, or APIVersionV1 converting it along with its embedded
+// TaskSpecs. The conversion fails rather than drops the fields v1 lacks,
+// e.g. the PipelineResources of tasks.
+func marshalPipeline(ctx context.Context, p *v1beta1.Pipeline, apiVersion string) ([]byte, error) {
+	p.Kind = "Pipeline"
+	p.APIVersion = APIVersionV1beta1
+	if apiVersion != APIVersionV1 {
+		return yaml.Marshal(p)
+	}
+	converted := &v1.Pipeline{}
+	if err := p.ConvertTo(ctx, converted); err != nil {
+		return nil, fmt.Errorf("converting pipeline %s to %s: %v", p.Name, APIVersionV1, err)
+	}
+	back := &v1beta1.Pipeline{}
+	if err := back.ConvertFrom(ctx, converted); err != nil {
+		return nil, fmt.Errorf("converting pipeline %s to %s: %v", p.Name, APIVersionV1, err)
+	}
+	if err := checkConversion(p.Spec, back.Spec); err != nil {
+		return nil, fmt.Errorf("pipeline %s can't be converted to %s without dropping some of its fields: %v", p.Name, APIVersionV1, err)
+	}
+	converted.Kind = "Pipeline"
+	converted.APIVersion = APIVersionV1
+	return yaml.Marshal(converted)
+}
+
+// checkConversion returns an error naming what differs between the spec
+// of the wrapped Pipeline and its conversion back from v1.
+func checkConversion(want, got v1beta1.PipelineSpec) error {
+	if equality.Semantic.DeepEqual(want, got) {
+		return nil
+	}
+	tasks := map[string]v1beta1.PipelineTask{}
+	for _, t := range append(got.Tasks, got.Finally...) {
+		tasks[t.Name] = t
+	}
+	for _, t := range append(want.Tasks, want.Finally...) {
+		if !equality.Semantic.DeepEqual(t, tasks[t.Name]) {
+			return fmt.Errorf("task %s", t.Name)
+		}
+	}
+	return fmt.Errorf("its description, params, workspaces or results")
+}
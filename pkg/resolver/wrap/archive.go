@@ -0,0 +1,116 @@
+package wrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const archiveVolumeName = "wrap-archives"
+
+// isArchive tells whether the target is a docker-archive tarball rather
+// than an image.
+func isArchive(target string) bool {
+	return strings.HasPrefix(target, wrapstep.ArchiveScheme)
+}
+
+// validateArchiveTarget checks that the docker-archive target is a tarball
+// in the archive-path directory of the nodes.
+func validateArchiveTarget(target, archivePath string) error {
+	if archivePath == "" {
+		return fmt.Errorf("%q is a docker-archive tarball but archive-path isn't set in %s", target, ResolverConfigName())
+	}
+	path := filepath.Clean(strings.TrimPrefix(target, wrapstep.ArchiveScheme))
+	if !strings.HasPrefix(path, filepath.Clean(archivePath)+"/") {
+		return fmt.Errorf("%q is not a docker-archive tarball in %s", target, archivePath)
+	}
+	return nil
+}
+
+func validateArchivePath(v string) error {
+	if !filepath.IsAbs(v) {
+		return fmt.Errorf("%q is not an absolute path", v)
+	}
+	return nil
+}
+
+// archiveStep returns a wrapstep step running the command for each group
+// of args, with the archive-path directory of the nodes mounted.
+func archiveStep(o stepOptions, name, command string, groups [][]string) v1beta1.Step {
+	args := []string{command}
+	for i, group := range groups {
+		if i > 0 {
+			args = append(args, "--")
+		}
+		args = append(args, group...)
+		if o.BestEffort {
+			args = append(args, "-best-effort")
+		}
+	}
+	return v1beta1.Step{
+		Name:       name,
+		Image:      o.WrapstepImage,
+		WorkingDir: "/",
+		Command:    []string{"/ko-app/wrapstep"},
+		Args:       args,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      archiveVolumeName,
+			MountPath: o.ArchivePath,
+		}},
+	}
+}
+
+// archiveImportSteps returns the step importing the workspaces from
+// docker-archive tarballs.
+func archiveImportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	groups := make([][]string, 0, len(transfers))
+	for _, t := range transfers {
+		group := []string{"-source", t.Source, "-path", t.MountPath}
+		if o.AllowMissing {
+			group = append(group, "-allow-missing")
+		}
+		groups = append(groups, group)
+	}
+	return []v1beta1.Step{archiveStep(o, "archive-import-workspace", "import", groups)}
+}
+
+// archiveExportSteps returns the step exporting the workspaces to
+// docker-archive tarballs. The exports of the first task append onto the
+// empty base image of wrapstep, as base-image may only be in a registry.
+func archiveExportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	groups := make([][]string, 0, len(transfers))
+	for _, t := range transfers {
+		group := []string{"-path", t.MountPath, "-target", t.Target}
+		if t.Base != "" {
+			group = append(group, "-base", t.Base)
+		}
+		if o.ExportChunks > 1 {
+			group = append(group, "-chunks", strconv.Itoa(o.ExportChunks))
+		}
+		if len(o.Exclude) > 0 {
+			group = append(group, "-exclude", strings.Join(o.Exclude, ","))
+		}
+		groups = append(groups, group)
+	}
+	return []v1beta1.Step{archiveStep(o, "archive-export-workspace", "export", groups)}
+}
+
+// addArchiveVolume adds the hostPath volume of the archive-path directory
+// of the nodes to the TaskSpec.
+func addArchiveVolume(s *v1beta1.TaskSpec, path string) {
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	s.Volumes = append(s.Volumes, corev1.Volume{
+		Name: archiveVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: path,
+				Type: &hostPathType,
+			},
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const archiveTaskName = "wrap-archive"
+
+// archiveCopy copies the final image of a workspace to its archive target,
+// see ArchiveTargetParam.
+type archiveCopy struct {
+	Workspace string
+	// Image is the image the exports of the workspace push to.
+	Image string
+	// Target is the image of the archival registry.
+	Target string
+}
+
+// archiveCopies returns the copies of the final images of the workspaces
+// wrapped with oci to archiveTarget, rendered for each of them. The
+// ephemeral ones are deleted at the end of the run instead.
+func archiveCopies(archiveTarget string, targets map[string]string, w wrappers, ephemeral sets.String) []archiveCopy {
+	var copies []archiveCopy
+	for ws, image := range targets {
+		if w.get(ws) != OCIWrapper || ephemeral.Has(ws) {
+			continue
+		}
+		copies = append(copies, archiveCopy{Workspace: ws, Image: image, Target: strings.ReplaceAll(archiveTarget, "{{workspace}}", ws)})
+	}
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].Workspace < copies[j].Workspace
+	})
+	return copies
+}
+
+// copyOnSuccessTask returns a finally task copying the images to their
+// archive targets, with their manifests and layers, only when all the
+// tasks of the run succeeded or were skipped.
+func copyOnSuccessTask(name string, copies []archiveCopy, image string) v1beta1.PipelineTask {
+	var params []v1beta1.Param
+	var paramSpecs []v1beta1.ParamSpec
+	addParam := func(name, value string) string {
+		params = append(params, v1beta1.Param{Name: name, Value: *v1beta1.NewStructuredValues(value)})
+		paramSpecs = append(paramSpecs, v1beta1.ParamSpec{Name: name, Type: v1beta1.ParamTypeString})
+		return fmt.Sprintf("$(params.%s)", name)
+	}
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh -e\n")
+	for i, c := range copies {
+		image, target := addParam(fmt.Sprintf("image-%d", i), c.Image), addParam(fmt.Sprintf("target-%d", i), c.Target)
+		fmt.Fprintf(&script, `echo "Copy %s to %s"
+crane copy %s %s
+`, image, target, image, target)
+	}
+
+	return v1beta1.PipelineTask{
+		Name:   name,
+		Params: params,
+		WhenExpressions: v1beta1.WhenExpressions{{
+			Input:    "$(" + v1beta1.PipelineTasksAggregateStatus + ")",
+			Operator: selection.In,
+			Values:   []string{"Succeeded", "Completed"},
+		}},
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Params: paramSpecs,
+			Steps: []v1beta1.Step{{
+				Name:       "copy",
+				Image:      image,
+				WorkingDir: "/",
+				Script:     script.String(),
+			}},
+		}},
+	}
+}
+
+// copyUsage returns the usage of the task copying the images: it pulls
+// from the registries of the targets and pushes to the archival ones.
+func copyUsage(name string, copies []archiveCopy) TaskUsage {
+	pull, push := sets.NewString(), sets.NewString()
+	for _, c := range copies {
+		pull.Insert(registry(c.Image))
+		push.Insert(registry(c.Target))
+	}
+	return TaskUsage{Name: name, Pull: pull.List(), Push: push.List()}
+}
@@ -0,0 +1,137 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestArchiveCopies(t *testing.T) {
+	targets := map[string]string{
+		"source": "registry.example.com/source",
+		"cache":  "registry.example.com/cache",
+		"tmp":    "registry.example.com/tmp",
+		"logs":   "s3://bucket/logs",
+	}
+	w := wrappers{"": OCIWrapper, "logs": S3Wrapper}
+	got := archiveCopies("archive.example.com/{{workspace}}:v1", targets, w, sets.NewString("tmp"))
+	want := []archiveCopy{
+		{Workspace: "cache", Image: "registry.example.com/cache", Target: "archive.example.com/cache:v1"},
+		{Workspace: "source", Image: "registry.example.com/source", Target: "archive.example.com/source:v1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("archiveCopies() = %v, want %v", got, want)
+	}
+
+	task := copyOnSuccessTask(archiveTaskName, got, DefaultCraneImage)
+	if len(task.Params) != 4 || !strings.Contains(task.TaskSpec.Steps[0].Script, "crane copy $(params.image-1) $(params.target-1)") {
+		t.Errorf("copyOnSuccessTask() = %+v, want a copy per image", task)
+	}
+	usage := copyUsage(archiveTaskName, got)
+	if !reflect.DeepEqual(usage.Pull, []string{"registry.example.com"}) || !reflect.DeepEqual(usage.Push, []string{"archive.example.com"}) {
+		t.Errorf("copyUsage() = %+v, want to pull from the targets and push to the archive", usage)
+	}
+}
+
+func TestWrapArchiveTarget(t *testing.T) {
+	workspaces := []string{"source", "cache"}
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+		// wantCopies are the params of the copies, the image then the
+		// archive target of each workspace.
+		wantCopies map[string]string
+		wantErr    string
+	}{{
+		name:   "all workspaces",
+		params: map[string]string{},
+		wantCopies: map[string]string{
+			"image-0": "registry.example.com/cache", "target-0": "archive.example.com/cache:v1",
+			"image-1": "registry.example.com/source", "target-1": "archive.example.com/source:v1",
+		},
+	}, {
+		name:       "ephemeral workspace",
+		params:     map[string]string{EphemeralWorkspacesParam: "cache"},
+		wantCopies: map[string]string{"image-0": "registry.example.com/source", "target-0": "archive.example.com/source:v1"},
+	}, {
+		name:    "only ephemeral workspaces",
+		params:  map[string]string{EphemeralWorkspacesParam: "source,cache"},
+		wantErr: "archive-target requires a workspace wrapped with oci",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}, {Name: "cache"}},
+				Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", workspaces), embeddedTask("build", workspaces, "clone")},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params := map[string]string{
+				PipelineRefParam:   "build",
+				TargetParam:        "registry.example.com/{{workspace}}",
+				ArchiveTargetParam: "archive.example.com/{{workspace}}:v1",
+			}
+			for k, v := range tc.params {
+				params[k] = v
+			}
+			params, err := populateParamsWithDefaults(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, _, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("wrap() = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+
+			// The last task exports the final images, nothing downstream
+			// imports them
+			var exports int
+			for _, s := range wrapped.Spec.Tasks[1].TaskSpec.Steps {
+				if s.Name == "export-workspace" {
+					exports++
+				}
+			}
+			if exports == 0 {
+				t.Errorf("build doesn't export the final images of the workspaces")
+			}
+			var archive *v1beta1.PipelineTask
+			for i := range wrapped.Spec.Finally {
+				if wrapped.Spec.Finally[i].Name == archiveTaskName {
+					archive = &wrapped.Spec.Finally[i]
+				}
+			}
+			if archive == nil {
+				t.Fatalf("wrap() added no %s finally task: %+v", archiveTaskName, wrapped.Spec.Finally)
+			}
+			if w := archive.WhenExpressions; len(w) != 1 || w[0].Input != "$(tasks.status)" || !reflect.DeepEqual(w[0].Values, []string{"Succeeded", "Completed"}) {
+				t.Errorf("%s runs when %+v, want only once the run succeeded", archiveTaskName, w)
+			}
+			got := map[string]string{}
+			for _, p := range archive.Params {
+				got[p.Name] = p.Value.StringVal
+			}
+			if !reflect.DeepEqual(got, tc.wantCopies) {
+				t.Errorf("%s params = %v, want %v", archiveTaskName, got, tc.wantCopies)
+			}
+			script := archive.TaskSpec.Steps[0].Script
+			for i := 0; i < len(tc.wantCopies)/2; i++ {
+				if want := fmt.Sprintf("crane copy $(params.image-%d) $(params.target-%d)", i, i); !strings.Contains(script, want) {
+					t.Errorf("%s doesn't run %q:\n%s", archiveTaskName, want, script)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package wrap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mediaTypeRegex matches the type/subtype media types of RFC 6838.
+var mediaTypeRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+// artifactTypes holds the artifact types of the images of the workspaces,
+// see ArtifactTypeParam. The empty key holds the one of the workspaces
+// without one of their own.
+type artifactTypes map[string]string
+
+func (a artifactTypes) get(workspace string) string {
+	if t, ok := a[workspace]; ok {
+		return t
+	}
+	return a[""]
+}
+
+// parseArtifactTypes parses either a single media type, applying to all
+// workspaces, or a comma separated list of workspace=mediatype.
+func parseArtifactTypes(value string) (artifactTypes, error) {
+	types := artifactTypes{}
+	if value == "" {
+		return types, nil
+	}
+	for _, s := range strings.Split(value, ",") {
+		var workspace string
+		if i := strings.Index(s, "="); i >= 0 {
+			workspace, s = strings.TrimSpace(s[:i]), s[i+1:]
+			if workspace == "" {
+				return nil, fmt.Errorf("missing workspace name in %q", value)
+			}
+		}
+		s = strings.TrimSpace(s)
+		if !mediaTypeRegex.MatchString(s) {
+			return nil, fmt.Errorf("%q is not a media type", s)
+		}
+		types[workspace] = s
+	}
+	return types, nil
+}
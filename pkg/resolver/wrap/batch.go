@@ -0,0 +1,127 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// BatchParam wraps the Pipelines of the namespace it lists, comma
+	// separated, with the same params, in a single request, e.g. for GitOps
+	// pipelines pre-rendering their whole catalog. The resolved resource is
+	// a List of the wrapped Pipelines, in the order of the param, which
+	// ResolutionRequests created directly can read, but not the
+	// pipelineRefs of PipelineRuns.
+	BatchParam = "batch"
+	// BatchAnnotation lists the Pipelines of the List resolved for
+	// BatchParam. Each of them holds the annotations of the resource it
+	// would have been resolved to alone.
+	BatchAnnotation = "wrap.tekton.dev/batch"
+)
+
+// resolveBatch wraps the Pipelines named names with the params, populated
+// with their defaults, like resolvePipelineRef for each of them. It fails
+// if any of them fails, listing all the failures.
+func (r *Resolver) resolveBatch(ctx context.Context, params map[string]string, names []string) (framework.ResolvedResource, error) {
+	logger := logging.FromContext(ctx)
+
+	list := &metav1.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+	var failures []string
+	for _, name := range names {
+		pipelineParams := make(map[string]string, len(params))
+		for k, v := range params {
+			pipelineParams[k] = v
+		}
+		delete(pipelineParams, BatchParam)
+		pipelineParams[PipelineRefParam] = name
+		resolved, err := r.resolvePipelineRef(ctx, pipelineParams)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		item, err := batchItem(resolved)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		list.Items = append(list.Items, runtime.RawExtension{Raw: item})
+	}
+	if len(failures) > 0 {
+		err := fmt.Errorf("failed to wrap %d of the %d pipelines of %s: %s", len(failures), len(names), BatchParam, strings.Join(failures, "; "))
+		logger.Info(err)
+		return nil, err
+	}
+	data, err := yaml.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	return &batchResource{content: data, names: names}, nil
+}
+
+// batchItem returns the wrapped Pipeline of the resolved resource in JSON,
+// with the annotations of the resource.
+func batchItem(resolved framework.ResolvedResource) ([]byte, error) {
+	data, err := yaml.YAMLToJSON(resolved.Data())
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for k, v := range resolved.Annotations() {
+		annotations[k] = v
+	}
+	u.SetAnnotations(annotations)
+	return u.MarshalJSON()
+}
+
+func validateBatch(v string) error {
+	names := strings.Split(v, ",")
+	seen := sets.NewString()
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("%q holds an empty pipeline name", v)
+		}
+		if seen.Has(name) {
+			return fmt.Errorf("pipeline %s is listed more than once", name)
+		}
+		seen.Insert(name)
+	}
+	return nil
+}
+
+// batchResource is the List of the Pipelines wrapped for BatchParam.
+type batchResource struct {
+	content []byte
+	names   []string
+}
+
+var _ framework.ResolvedResource = &batchResource{}
+
+// Data returns the List in YAML.
+func (b *batchResource) Data() []byte {
+	return b.content
+}
+
+// Annotations returns the BatchAnnotation and MetadataVersionAnnotation,
+// the other ones being on each Pipeline of the List.
+func (b *batchResource) Annotations() map[string]string {
+	return map[string]string{
+		BatchAnnotation:           strings.Join(b.names, ","),
+		MetadataVersionAnnotation: MetadataVersion,
+	}
+}
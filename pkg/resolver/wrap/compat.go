@@ -0,0 +1,68 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/config"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+const defaultFeatureFlagsNamespace = "tekton-pipelines"
+
+// requiredAPIFields returns the lowest enable-api-fields feature flag, stable
+// or alpha, the wrapped Pipeline is valid with, e.g. alpha for the object
+// results of ArtifactResultsParam. It returns an error if the Pipeline isn't
+// valid even with alpha.
+func requiredAPIFields(ctx context.Context, p *v1beta1.Pipeline) (string, error) {
+	var err *apis.FieldError
+	for _, fields := range []string{config.StableAPIFields, config.AlphaAPIFields} {
+		if err = validateWithAPIFields(ctx, p, fields); err == nil {
+			return fields, nil
+		}
+	}
+	return "", err
+}
+
+func validateWithAPIFields(ctx context.Context, p *v1beta1.Pipeline, fields string) *apis.FieldError {
+	cfg := config.FromContextOrDefaults(ctx)
+	flags := *cfg.FeatureFlags
+	flags.EnableAPIFields = fields
+	ctx = config.ToContext(ctx, &config.Config{
+		Defaults:     cfg.Defaults,
+		FeatureFlags: &flags,
+	})
+	p = p.DeepCopy()
+	p.SetDefaults(ctx)
+	return p.Validate(ctx)
+}
+
+// checkFeatureFlags returns an error if the enable-api-fields feature flag
+// of the cluster, read from the feature-flags ConfigMap of the
+// feature-flags-namespace of the resolver configuration, doesn't allow the
+// required API fields, so that the resolution fails with a clear error
+// rather than the PipelineRun with a validation one. It is only checked when
+// check-feature-flags is set.
+func (r *Resolver) checkFeatureFlags(ctx context.Context, conf map[string]string, required string) error {
+	if conf["check-feature-flags"] != "true" || required == config.StableAPIFields {
+		return nil
+	}
+	namespace := conf["feature-flags-namespace"]
+	if namespace == "" {
+		namespace = defaultFeatureFlagsNamespace
+	}
+	cm, err := r.kubeClientSet.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetFeatureFlagsConfigName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read the feature flags of the cluster: %v", err)
+	}
+	flags, err := config.NewFeatureFlagsFromConfigMap(cm)
+	if err != nil {
+		return fmt.Errorf("failed to read the feature flags of the cluster: %v", err)
+	}
+	if flags.EnableAPIFields != required {
+		return fmt.Errorf("the wrapped pipeline requires enable-api-fields %q but it is %q in %s/%s", required, flags.EnableAPIFields, namespace, cm.Name)
+	}
+	return nil
+}
@@ -0,0 +1,499 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+)
+
+// ConfigName is the default name of the ConfigMap of the wrap resolver.
+const ConfigName = "wrapresolver-config"
+
+// ResolverConfigName returns the name of the ConfigMap of the wrap resolver:
+// ConfigName unless the WRAP_RESOLVER_CONFIG_NAME environment variable is
+// set, to run several variants of the resolver side by side.
+func ResolverConfigName() string {
+	if name := os.Getenv("WRAP_RESOLVER_CONFIG_NAME"); name != "" {
+		return name
+	}
+	return ConfigName
+}
+
+// configValidators validates the value of each known key of the resolver
+// configuration.
+var configValidators = map[string]func(string) error{
+	// The wrappers are checked with the wrapper-templates by ValidateConfig
+	"default-wrapper": func(string) error { return nil },
+	"wrapper-templates": func(v string) error {
+		_, err := parseWrapperTemplates(v)
+		return err
+	},
+	"default-target":             validateDefaultTarget,
+	"default-strip-workspaces":   validateBool,
+	"default-allow-missing-base": validateBool,
+	"default-workspace-size": func(v string) error {
+		_, err := parseWorkspaceSizes(v)
+		return err
+	},
+	"default-export-chunks": func(v string) error {
+		if chunks, err := strconv.Atoi(v); err != nil || chunks < 1 {
+			return fmt.Errorf("%q is not a positive integer", v)
+		}
+		return nil
+	},
+	"default-compression": validateCompression,
+	"default-exclude": func(v string) error {
+		_, err := parseExclude(v)
+		return err
+	},
+	"default-import-wait-timeout": func(v string) error {
+		if wait, err := time.ParseDuration(v); err != nil || wait < 0 {
+			return fmt.Errorf("%q is not a duration", v)
+		}
+		return nil
+	},
+	"default-export-failure": func(v string) error {
+		if v != ExportFailureFail && v != ExportFailureWarn {
+			return fmt.Errorf("%q is neither %q nor %q", v, ExportFailureFail, ExportFailureWarn)
+		}
+		return nil
+	},
+	"default-always-export": validateBool,
+	"default-export-on-failure": func(v string) error {
+		_, err := parseExportOnFailure(v)
+		return err
+	},
+	"default-artifact-results": validateBool,
+	"default-order-tasks":      validateBool,
+	"default-latest-alias":     validateBool,
+	"default-keep-original":    validateBool,
+	"default-transactional":    validateBool,
+	"default-lineage-result":   validateBool,
+	"default-verify":           validateBool,
+	"default-output-apiversion": func(v string) error {
+		if v != APIVersionV1beta1 && v != APIVersionV1 {
+			return fmt.Errorf("%q is neither %q nor %q", v, APIVersionV1beta1, APIVersionV1)
+		}
+		return nil
+	},
+	"pipeline-api-versions": func(v string) error {
+		seen := map[string]bool{}
+		for _, version := range pipelineAPIVersions(map[string]string{"pipeline-api-versions": v}) {
+			if version != APIVersionV1beta1 && version != APIVersionV1 {
+				return fmt.Errorf("%q is neither %q nor %q", version, APIVersionV1beta1, APIVersionV1)
+			}
+			if seen[version] {
+				return fmt.Errorf("%q is listed more than once", version)
+			}
+			seen[version] = true
+		}
+		return nil
+	},
+	"default-artifact-type": func(v string) error {
+		_, err := parseArtifactTypes(v)
+		return err
+	},
+	"base-image":             validateReference,
+	"default-base-image":     validateReference,
+	"crane-image":            validateReference,
+	"wrapstep-image":         validateReference,
+	"wrapstep-transfers":     validateBool,
+	"wrapped-pipeline-cache": validateBool,
+	"wrapstep-credentials": func(v string) error {
+		for _, part := range strings.Split(v, ",") {
+			provider, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if !credentialProviderRegex.MatchString(provider) {
+				return fmt.Errorf("%q is not a credential provider, <provider> or <provider>=<arg>", part)
+			}
+			if role, path, _ := strings.Cut(arg, "@"); provider == "vault" && (role == "" || path == "") {
+				return fmt.Errorf("%q is not a vault credential provider, vault=<role>@<path>", part)
+			}
+		}
+		return nil
+	},
+	"max-pod-containers": func(v string) error {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return fmt.Errorf("%q is not a valid number of containers", v)
+		}
+		return nil
+	},
+	"max-pod-requests": func(v string) error {
+		_, err := parseResourceList(v)
+		return err
+	},
+	"owner-markers": validateBool,
+	"verify-image":  validateReference,
+	"vault-address": validateHTTPURL,
+	"vault-auth-path": func(v string) error {
+		if strings.Trim(v, "/") == "" {
+			return fmt.Errorf("%q is not a mount path", v)
+		}
+		return nil
+	},
+	"vault-namespace": func(v string) error {
+		if strings.Trim(v, "/") == "" {
+			return fmt.Errorf("%q is not a Vault namespace", v)
+		}
+		return nil
+	},
+	"layer-cache-path": func(v string) error {
+		if !filepath.IsAbs(v) {
+			return fmt.Errorf("%q is not an absolute path", v)
+		}
+		return nil
+	},
+	"task-source": func(v string) error {
+		if v != TaskSourceCluster && v != TaskSourceGit {
+			return fmt.Errorf("%q is neither %q nor %q", v, TaskSourceCluster, TaskSourceGit)
+		}
+		return nil
+	},
+	"task-git-url": validateTaskGitURL,
+	"task-git-revision": func(v string) error {
+		if v == "" {
+			return fmt.Errorf("empty revision")
+		}
+		return nil
+	},
+	"step-security-context": func(v string) error {
+		_, err := parseStepSecurityContext(v)
+		return err
+	},
+	"allow-fault-injection": validateBool,
+	"check-feature-flags":   validateBool,
+	"check-tekton-version":  validateBool,
+	"feature-flags-namespace": func(v string) error {
+		if errs := validation.IsDNS1123Label(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid namespace: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"environment-label": func(v string) error {
+		if errs := validation.IsQualifiedName(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid label: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"environment-namespace": func(v string) error {
+		if errs := validation.IsDNS1123Label(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid namespace: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"environment-targets": func(v string) error {
+		_, err := parseEnvironmentTargets(v)
+		return err
+	},
+	"cross-namespace-pipelines": func(v string) error {
+		_, err := parseCrossNamespacePipelines(v, "")
+		return err
+	},
+	"cross-namespace-service-account": func(v string) error {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid ServiceAccount name: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"resolution-timeout": func(v string) error {
+		if timeout, err := time.ParseDuration(v); err != nil || timeout <= 0 {
+			return fmt.Errorf("%q is not a positive duration", v)
+		}
+		return nil
+	},
+	"fetch-timeout": func(v string) error {
+		if timeout, err := time.ParseDuration(v); err != nil || timeout <= 0 {
+			return fmt.Errorf("%q is not a positive duration", v)
+		}
+		return nil
+	},
+	"post-process-command": validatePostProcessCommand,
+	"post-process-url":     validateHTTPURL,
+	"post-process-timeout": func(v string) error {
+		if timeout, err := time.ParseDuration(v); err != nil || timeout <= 0 {
+			return fmt.Errorf("%q is not a positive duration", v)
+		}
+		return nil
+	},
+	"mint-registry-tokens": validateBool,
+	"registry-token-repositories": func(v string) error {
+		// Namespaces are valid path components
+		_, err := parseRegistryTokenRepositories(v, "namespace")
+		return err
+	},
+	"registry-token-ttl": func(v string) error {
+		if ttl, err := time.ParseDuration(v); err != nil || ttl <= 0 {
+			return fmt.Errorf("%q is not a positive duration", v)
+		}
+		return nil
+	},
+	"image-digest-watch-interval": func(v string) error {
+		if interval, err := time.ParseDuration(v); err != nil || interval <= 0 {
+			return fmt.Errorf("%q is not a positive duration", v)
+		}
+		return nil
+	},
+	"pipeline-selector": func(v string) error {
+		_, err := labels.Parse(v)
+		return err
+	},
+	"kube-api-qps": func(v string) error {
+		if qps, err := strconv.ParseFloat(v, 32); err != nil || qps <= 0 {
+			return fmt.Errorf("%q is not a positive number", v)
+		}
+		return nil
+	},
+	"kube-api-burst": func(v string) error {
+		if burst, err := strconv.Atoi(v); err != nil || burst < 1 {
+			return fmt.Errorf("%q is not a positive integer", v)
+		}
+		return nil
+	},
+	"max-upload-rate":   validateRate,
+	"max-download-rate": validateRate,
+	"prefetch":          validateBool,
+	"s3-image":          validateReference,
+	"s3-endpoint-url": func(v string) error {
+		_, err := url.ParseRequestURI(v)
+		return err
+	},
+	"s3-credentials-secret": func(v string) error {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid Secret name: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"gcs-image":   validateReference,
+	"rsync-image": validateReference,
+	"rsync-credentials-secret": func(v string) error {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid Secret name: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"archive-path":    validateArchivePath,
+	"openlineage-url": validateHTTPURL,
+	"openlineage-namespace": func(v string) error {
+		if v == "" {
+			return fmt.Errorf("the namespace is empty")
+		}
+		return nil
+	},
+	"openlineage-image": validateReference,
+	"openlineage-api-key-secret": func(v string) error {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid Secret name: %s", v, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+}
+
+// ValidateConfig returns an error listing the invalid and unknown keys of
+// the resolver configuration. Keys starting with an underscore, like
+// _example, are ignored.
+func ValidateConfig(conf map[string]string) error {
+	var errs []string
+	for key, value := range conf {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		validate, ok := configValidators[key]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown key %s", key))
+			continue
+		}
+		if err := validate(value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %s: %v", key, err))
+		}
+	}
+	if v, ok := conf["default-wrapper"]; ok {
+		// Invalid wrapper-templates are already reported
+		templates, _ := parseWrapperTemplates(conf["wrapper-templates"])
+		if _, err := parseWrappers(v, templates); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid default-wrapper: %v", err))
+		}
+	}
+	if _, err := newGitTaskSource(conf); err != nil {
+		errs = append(errs, err.Error())
+	}
+	// Tokens are only minted for the repositories allowed to each namespace
+	if mint, _ := strconv.ParseBool(conf["mint-registry-tokens"]); mint && strings.TrimSpace(conf["registry-token-repositories"]) == "" {
+		errs = append(errs, "mint-registry-tokens requires registry-token-repositories")
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return fmt.Errorf("invalid %s: %s", ResolverConfigName(), strings.Join(errs, "; "))
+}
+
+func validateBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+// credentialProviderRegex matches the names of the credential providers of
+// the wrapstep helper.
+var credentialProviderRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// variableRegex matches the variables Tekton replaces at run time, e.g.
+// $(context.pipelineRun.name).
+var variableRegex = regexp.MustCompile(`\$\([^)]*\)`)
+
+// validateReference checks that v is a valid image reference, with the
+// precise error of the tag or digest it is parsed as. Variables are
+// replaced by a placeholder, as they are only known at run time.
+func validateReference(v string) error {
+	ref := variableRegex.ReplaceAllString(v, "x")
+	var err error
+	switch i := strings.LastIndex(v, "@"); {
+	case i >= 0 && variableRegex.MatchString(v[i:]):
+		// The digest is a variable
+		_, err = name.NewRepository(ref[:strings.LastIndex(ref, "@")])
+	case i >= 0:
+		_, err = name.NewDigest(ref)
+	default:
+		_, err = name.NewTag(ref)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid image reference: %v", v, err)
+	}
+	return nil
+}
+
+// validateCompression checks that v is one of wrapstep.Compressions.
+func validateCompression(v string) error {
+	for _, c := range wrapstep.Compressions() {
+		if v == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", v, strings.Join(wrapstep.Compressions(), ", "))
+}
+
+func validateRate(v string) error {
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		return err
+	}
+	if q.Sign() < 0 {
+		return fmt.Errorf("%q is negative", v)
+	}
+	return nil
+}
+
+// WithConfigValidation wraps the constructor of the resolver controller to
+// validate its ConfigMap on startup and whenever it changes, so that admins
+// find out about bad settings before the next resolution. Errors are logged
+// and recorded as Warning events on the ConfigMap.
+func WithConfigValidation(ctor injection.ControllerConstructor) injection.ControllerConstructor {
+	return func(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+		impl := ctor(ctx, cmw)
+
+		logger := logging.FromContext(ctx)
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.Get(ctx).CoreV1().Events("")})
+		recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "wrapresolver"})
+		cmw.Watch(ResolverConfigName(), func(cm *corev1.ConfigMap) {
+			if err := ValidateConfig(cm.Data); err != nil {
+				logger.Errorf("%v", err)
+				recorder.Event(cm, corev1.EventTypeWarning, "InvalidConfig", err.Error())
+				return
+			}
+			logger.Infof("Feature flags: %v", featureStates(cm.Data))
+			if image, ok := cm.Data["crane-image"]; ok && !strings.Contains(image, "@") {
+				w := fmt.Sprintf("crane-image %s isn't pinned by digest, its tag can move to another image", image)
+				logger.Warn(w)
+				recorder.Event(cm, corev1.EventTypeWarning, "UnpinnedImage", w)
+			}
+			warnings, err := checkDefaultPodTemplate(ctx, client.Get(ctx), cm.Data)
+			if err != nil {
+				logger.Infof("failed to check the default pod template of the cluster: %v", err)
+				return
+			}
+			for _, w := range warnings {
+				logger.Warn(w)
+				recorder.Event(cm, corev1.EventTypeWarning, "IncompatiblePodTemplate", w)
+			}
+		})
+		return impl
+	}
+}
+
+// ApplyClientSettings sets the QPS and burst of the clients of the
+// controller from the kube-api-qps and kube-api-burst keys of the resolver
+// configuration, unless they are already set (e.g. with the -kube-api-qps
+// and -kube-api-burst flags). The clients are created on startup, so the
+// controller has to be restarted for changes to apply.
+func ApplyClientSettings(ctx context.Context, cfg *rest.Config) error {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	cm, err := kubeClient.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ResolverConfigName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if v, ok := cm.Data["kube-api-qps"]; ok && cfg.QPS == 0 {
+		qps, err := strconv.ParseFloat(v, 32)
+		if err != nil || qps <= 0 {
+			return fmt.Errorf("invalid kube-api-qps: %q is not a positive number", v)
+		}
+		cfg.QPS = float32(qps)
+	}
+	if v, ok := cm.Data["kube-api-burst"]; ok && cfg.Burst == 0 {
+		burst, err := strconv.Atoi(v)
+		if err != nil || burst < 1 {
+			return fmt.Errorf("invalid kube-api-burst: %q is not a positive integer", v)
+		}
+		cfg.Burst = burst
+	}
+	return nil
+}
+
+// checkPipelineSelector returns an error if the pipeline-selector of the
+// resolver configuration, if any, doesn't match the labels of the Pipeline,
+// so that admins can limit wrapping to the Pipelines that opted in.
+func checkPipelineSelector(conf map[string]string, p *v1beta1.Pipeline) error {
+	v, ok := conf["pipeline-selector"]
+	if !ok {
+		return nil
+	}
+	selector, err := labels.Parse(v)
+	if err != nil {
+		return fmt.Errorf("invalid pipeline-selector: %w", err)
+	}
+	if !selector.Matches(labels.Set(p.Labels)) {
+		return fmt.Errorf("pipeline %s doesn't match the pipeline-selector %q of %s", p.Name, selector.String(), ResolverConfigName())
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package wrap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		conf     map[string]string
+		wantErrs []string
+	}{{
+		name: "empty",
+		conf: map[string]string{},
+	}, {
+		name: "valid keys",
+		conf: map[string]string{
+			"default-export-chunks":    "4",
+			"default-export-failure":   ExportFailureWarn,
+			"wrapstep-image":           "registry.example.com/wrapstep:latest",
+			"layer-cache-path":         "/var/cache/wrap",
+			"max-upload-rate":          "10Mi",
+			"default-strip-workspaces": "false",
+			"default-target":           "registry.example.com/wrap",
+		},
+	}, {
+		name: "examples ignored",
+		conf: map[string]string{"_example": "anything goes", "_other-key": ""},
+	}, {
+		name:     "unknown key",
+		conf:     map[string]string{"default-exports": "4"},
+		wantErrs: []string{"unknown key default-exports"},
+	}, {
+		name: "invalid values",
+		conf: map[string]string{
+			"default-export-chunks": "0",
+			"prefetch":              "maybe",
+			"layer-cache-path":      "cache",
+			"default-wrapper":       "zip",
+			"crane-image":           "mirror.example.com/crane@sha256:0",
+			"default-compression":   "lz4",
+			"default-exclude":       "../cache",
+		},
+		wantErrs: []string{"invalid default-export-chunks", "invalid prefetch", "invalid layer-cache-path", "invalid default-wrapper", "invalid crane-image", "invalid default-compression", "invalid default-exclude"},
+	}, {
+		name:     "default target without a registry",
+		conf:     map[string]string{"default-target": "wrap"},
+		wantErrs: []string{"invalid default-target"},
+	}, {
+		name: "default wrapper template",
+		conf: map[string]string{
+			"default-wrapper":   "nfs",
+			"wrapper-templates": "nfs:\n  image: registry.example.com/nfs-copy\n  import: cp -r /nfs/x .\n  export: cp -r . /nfs/x\n",
+		},
+	}, {
+		name:     "unknown default wrapper",
+		conf:     map[string]string{"default-wrapper": "nfs"},
+		wantErrs: []string{"invalid default-wrapper"},
+	}, {
+		name:     "unknown and invalid",
+		conf:     map[string]string{"other": "x", "max-download-rate": "fast"},
+		wantErrs: []string{"unknown key other", "invalid max-download-rate"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateConfig(tc.conf)
+			if len(tc.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("ValidateConfig() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ValidateConfig() = nil, want %v", tc.wantErrs)
+			}
+			for _, want := range tc.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("ValidateConfig() = %v, want an error containing %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateConfigVault(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		conf    map[string]string
+		wantErr string
+	}{{
+		name: "documented keys",
+		conf: map[string]string{
+			"vault-address":   "https://vault.vault:8200",
+			"vault-auth-path": "kubernetes",
+			"vault-namespace": "ci",
+		},
+	}, {
+		name: "nested namespace",
+		conf: map[string]string{"vault-namespace": "admin/ci"},
+	}, {
+		name:    "empty namespace",
+		conf:    map[string]string{"vault-namespace": "/"},
+		wantErr: "invalid vault-namespace",
+	}, {
+		name:    "invalid address",
+		conf:    map[string]string{"vault-address": "vault:8200"},
+		wantErr: "invalid vault-address",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateConfig(tc.conf)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateConfig() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ValidateConfig() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package wrap
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// ContractAnnotationPrefix prefixes the annotations of Tasks declaring the
+// content they expect in their workspaces, one per workspace of the Task,
+// e.g. wrap.tekton.dev/expects-source: go.mod,vendor/. The value is a comma
+// separated list of paths relative to the workspace, the ones ending with
+// a slash being directories. Imports of these workspaces are followed by a
+// step checking that the paths exist, failing the task with a message
+// naming the upstream exporter otherwise.
+const ContractAnnotationPrefix = "wrap.tekton.dev/expects-"
+
+// contractPathRegex matches the paths a contract can expect, quoted as is
+// in the script of the check step.
+var contractPathRegex = regexp.MustCompile(`^[A-Za-z0-9._@+=/-]+$`)
+
+// contract maps the workspaces of a Task to the paths it expects in them.
+type contract map[string][]string
+
+// parseContract returns the contract declared by the annotations of a
+// Task.
+func parseContract(annotations map[string]string) (contract, error) {
+	c := contract{}
+	for k, v := range annotations {
+		workspace := strings.TrimPrefix(k, ContractAnnotationPrefix)
+		if workspace == k {
+			continue
+		}
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if !contractPathRegex.MatchString(p) || path.IsAbs(p) || strings.HasPrefix(path.Clean(p), "..") {
+				return nil, fmt.Errorf("%s: %q is not a path relative to the workspace", k, p)
+			}
+			c[workspace] = append(c[workspace], p)
+		}
+	}
+	return c, nil
+}
+
+// contractCheck is the content expected in an imported workspace.
+type contractCheck struct {
+	// Workspace is the workspace of the Task.
+	Workspace string
+	MountPath string
+	// Source is the image the workspace is imported from.
+	Source string
+	// Exporter is the upstream task exporting the workspace, if any and
+	// the import isn't overridden.
+	Exporter string
+	Paths    []string
+}
+
+// checkContractStep returns a step checking that the imported workspaces
+// hold the expected paths, reporting all the missing ones before failing.
+func checkContractStep(checks []contractCheck, image string) v1beta1.Step {
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Workspace < checks[j].Workspace
+	})
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh\nfailed=0\n")
+	for _, c := range checks {
+		producer := "the image doesn't hold them"
+		if c.Exporter != "" {
+			producer = fmt.Sprintf("task %s didn't produce them", c.Exporter)
+		}
+		fmt.Fprintf(&script, "missing=\"\"\n")
+		for _, p := range c.Paths {
+			test := "-e"
+			if strings.HasSuffix(p, "/") {
+				test = "-d"
+			}
+			fmt.Fprintf(&script, "[ %s \"%s/%s\" ] || missing=\"${missing} %s\"\n", test, c.MountPath, strings.TrimSuffix(p, "/"), p)
+		}
+		fmt.Fprintf(&script, `if [ -n "${missing}" ]; then
+  echo "Workspace %s lacks${missing}, expected by the task: %s (imported from %s)"
+  failed=1
+fi
+`, c.Workspace, producer, c.Source)
+	}
+	fmt.Fprintf(&script, "exit \"${failed}\"\n")
+	return v1beta1.Step{
+		Name:       "check-workspace-contract",
+		Image:      image,
+		WorkingDir: "/",
+		Script:     script.String(),
+	}
+}
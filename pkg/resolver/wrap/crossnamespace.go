@@ -0,0 +1,112 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceParam fetches pipelineref, and the Tasks it references, from
+// this namespace instead of the one of the request, if the resolver
+// configuration allows it, see authorizeNamespace.
+const NamespaceParam = "namespace"
+
+type taskNamespaceKey struct{}
+
+// withTaskNamespace returns a context fetching the Tasks from the
+// namespace instead of the one of the request.
+func withTaskNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, taskNamespaceKey{}, namespace)
+}
+
+// taskNamespace returns the namespace the Tasks are fetched from, the one
+// of the request by default.
+func taskNamespace(ctx context.Context) string {
+	if namespace, ok := ctx.Value(taskNamespaceKey{}).(string); ok {
+		return namespace
+	}
+	return common.RequestNamespace(ctx)
+}
+
+// parseCrossNamespacePipelines parses the cross-namespace-pipelines of the
+// resolver configuration: a line per namespace of the requests (* for
+// all) of <namespace>=<namespace>[,<namespace>...], the namespaces they
+// can wrap the Pipelines of. It returns the ones of the namespace.
+func parseCrossNamespacePipelines(v, namespace string) (sets.String, error) {
+	allowed := sets.NewString()
+	for _, line := range strings.Split(v, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ns, sources, ok := strings.Cut(line, "=")
+		ns = strings.TrimSpace(ns)
+		if !ok || ns == "" || strings.TrimSpace(sources) == "" {
+			return nil, fmt.Errorf("%q is not <namespace>=<namespace>[,<namespace>...]", line)
+		}
+		if ns != "*" {
+			if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(errs, ", "))
+			}
+		}
+		for _, source := range strings.Split(sources, ",") {
+			source = strings.TrimSpace(source)
+			if errs := validation.IsDNS1123Label(source); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid namespace %q: %s", source, strings.Join(errs, ", "))
+			}
+			if ns == "*" || ns == namespace {
+				allowed.Insert(source)
+			}
+		}
+	}
+	return allowed, nil
+}
+
+// authorizeNamespace checks that the requests of the namespace can wrap
+// the Pipeline name of the source namespace, so that NamespaceParam
+// doesn't expose the Pipelines the namespace can't read. Either
+// cross-namespace-pipelines allows the source namespace to the namespace,
+// or the cross-namespace-service-account of the namespace (e.g. the one
+// running its PipelineRuns) is allowed to get the Pipeline, as reviewed
+// by a SubjectAccessReview. Requests can't know who created them. Nothing
+// is allowed by default.
+func (r *Resolver) authorizeNamespace(ctx context.Context, conf map[string]string, namespace, source, name string) error {
+	allowed, err := parseCrossNamespacePipelines(conf["cross-namespace-pipelines"], namespace)
+	if err != nil {
+		return fmt.Errorf("invalid cross-namespace-pipelines: %v", err)
+	}
+	if allowed.Has(source) {
+		return nil
+	}
+	sa, ok := conf["cross-namespace-service-account"]
+	if !ok {
+		return fmt.Errorf("namespace %s is not allowed to wrap the pipelines of namespace %s", namespace, source)
+	}
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", namespace, sa)
+	access, err := r.kubeClientSet.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user,
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: source,
+				Verb:      "get",
+				Group:     "tekton.dev",
+				Resource:  "pipelines",
+				Name:      name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to review the access of %s: %v", user, err)
+	}
+	if !access.Status.Allowed {
+		return fmt.Errorf("%s is not allowed to get pipeline %s in namespace %s", user, name, source)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestParseCrossNamespacePipelines(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		v         string
+		namespace string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "empty", v: "", namespace: "dev"},
+		{name: "namespace", v: "dev=shared, catalog\nprod=release", namespace: "dev", want: []string{"catalog", "shared"}},
+		{name: "other namespace", v: "prod=release", namespace: "dev"},
+		{name: "all namespaces", v: "*=catalog\ndev=shared", namespace: "dev", want: []string{"catalog", "shared"}},
+		{name: "not an assignment", v: "dev", wantErr: true},
+		{name: "no sources", v: "dev=", wantErr: true},
+		{name: "invalid namespace", v: "Dev=shared", wantErr: true},
+		{name: "invalid source", v: "dev=shared,", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCrossNamespacePipelines(tc.v, tc.namespace)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseCrossNamespacePipelines() = %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Len() != len(tc.want) || !got.HasAll(tc.want...) {
+				t.Errorf("parseCrossNamespacePipelines() = %v, want %v", got.List(), tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		conf        map[string]string
+		wantReviews int
+		wantErr     bool
+	}{
+		{name: "nothing configured", conf: map[string]string{}, wantErr: true},
+		{name: "allowed namespace", conf: map[string]string{"cross-namespace-pipelines": "dev=shared"}},
+		{name: "other namespace", conf: map[string]string{"cross-namespace-pipelines": "dev=catalog"}, wantErr: true},
+		{name: "allowed service account", conf: map[string]string{"cross-namespace-service-account": "pipeline"}, wantReviews: 1},
+		{name: "denied service account", conf: map[string]string{"cross-namespace-service-account": "default"}, wantReviews: 1, wantErr: true},
+		{name: "invalid configuration", conf: map[string]string{"cross-namespace-pipelines": "dev"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var reviews []authorizationv1.SubjectAccessReviewSpec
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "subjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+				review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+				reviews = append(reviews, review.Spec)
+				a := review.Spec.ResourceAttributes
+				review.Status.Allowed = review.Spec.User == "system:serviceaccount:dev:pipeline" &&
+					a.Namespace == "shared" && a.Name == "build" && a.Verb == "get" && a.Group == "tekton.dev" && a.Resource == "pipelines"
+				return true, review, nil
+			})
+			r := &Resolver{kubeClientSet: client}
+			err := r.authorizeNamespace(context.Background(), tc.conf, "dev", "shared", "build")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("authorizeNamespace() = %v, want error %t", err, tc.wantErr)
+			}
+			if len(reviews) != tc.wantReviews {
+				t.Errorf("authorizeNamespace() reviewed %d times, want %d", len(reviews), tc.wantReviews)
+			}
+		})
+	}
+}
+
+func TestTaskNamespace(t *testing.T) {
+	ctx := common.InjectRequestNamespace(context.Background(), "dev")
+	if got := taskNamespace(ctx); got != "dev" {
+		t.Errorf("taskNamespace() = %q, want the namespace of the request", got)
+	}
+	if got := taskNamespace(withTaskNamespace(ctx, "shared")); got != "shared" {
+		t.Errorf("taskNamespace() = %q, want %q", got, "shared")
+	}
+}
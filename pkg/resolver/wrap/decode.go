@@ -0,0 +1,155 @@
+package wrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// The typed clients and yaml.Unmarshal silently drop the fields the
+// vendored Tekton API doesn't know, e.g. the ones of a newer Tekton in the
+// cluster, which the wrapped Pipeline would then lack. The Pipelines and
+// Tasks are decoded strictly instead, so that wrapping fails rather than
+// strips task features. The alpha fields the vendored API knows, like
+// stdoutConfig, are kept as is.
+
+// decodeStrict decodes the YAML or JSON data into obj, failing on unknown
+// fields.
+func decodeStrict(kind, name string, data []byte, obj interface{}) error {
+	if err := yaml.UnmarshalStrict(data, obj); err != nil {
+		return fmt.Errorf("%s %s can't be wrapped without dropping some of its fields, unknown to the resolver: %v", kind, name, err)
+	}
+	return nil
+}
+
+// restClient returns the REST client of the Tekton clientset, nil for the
+// fake clientset of the self-test.
+func (r *Resolver) restClient() *rest.RESTClient {
+	rc, _ := r.pipelineClientSet.TektonV1beta1().RESTClient().(*rest.RESTClient)
+	return rc
+}
+
+// defaultPipelineAPIVersions are the API versions the Pipelines are
+// looked up as, in order, unless pipeline-api-versions is set. v1beta1
+// comes first: served as v1, the fields v1 lacks, e.g. the
+// PipelineResources, wouldn't be wrapped.
+var defaultPipelineAPIVersions = []string{APIVersionV1beta1, APIVersionV1}
+
+// pipelineAPIVersions returns the API versions the Pipelines are looked up
+// as, in order, from the resolver configuration.
+func pipelineAPIVersions(conf map[string]string) []string {
+	v, ok := conf["pipeline-api-versions"]
+	if !ok {
+		return defaultPipelineAPIVersions
+	}
+	var versions []string
+	for _, version := range strings.Split(v, ",") {
+		versions = append(versions, strings.TrimSpace(version))
+	}
+	return versions
+}
+
+// getPipeline fetches the Pipeline from the namespace, returning it with
+// its content as served, in JSON. It is looked up as each of the
+// pipeline-api-versions in turn, until found, so that it can be written in
+// either: the v1 Pipelines are converted to v1beta1, which the resolver
+// wraps.
+func (r *Resolver) getPipeline(ctx context.Context, namespace, name string) (*v1beta1.Pipeline, []byte, error) {
+	var err error
+	for _, version := range pipelineAPIVersions(framework.GetResolverConfigFromContext(ctx)) {
+		var p *v1beta1.Pipeline
+		var data []byte
+		if version == APIVersionV1 {
+			p, data, err = r.getV1Pipeline(ctx, namespace, name)
+		} else {
+			p, data, err = r.getV1beta1Pipeline(ctx, namespace, name)
+		}
+		// Not found, or the API version isn't served
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		return p, data, err
+	}
+	return nil, nil, err
+}
+
+// getV1beta1Pipeline fetches the Pipeline from the namespace as
+// tekton.dev/v1beta1.
+func (r *Resolver) getV1beta1Pipeline(ctx context.Context, namespace, name string) (*v1beta1.Pipeline, []byte, error) {
+	rc := r.restClient()
+	if rc == nil {
+		p, err := r.pipelineClientSet.TektonV1beta1().Pipelines(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := json.Marshal(p)
+		return p, data, err
+	}
+	data, err := rc.Get().Namespace(namespace).Resource("pipelines").Name(name).DoRaw(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &v1beta1.Pipeline{}
+	if err := decodeStrict("Pipeline", name, data, p); err != nil {
+		return nil, nil, err
+	}
+	return p, data, nil
+}
+
+// getV1Pipeline fetches the Pipeline from the namespace as tekton.dev/v1,
+// converted to v1beta1.
+func (r *Resolver) getV1Pipeline(ctx context.Context, namespace, name string) (*v1beta1.Pipeline, []byte, error) {
+	source := &v1.Pipeline{}
+	var data []byte
+	rc, _ := r.pipelineClientSet.TektonV1().RESTClient().(*rest.RESTClient)
+	if rc == nil {
+		var err error
+		source, err = r.pipelineClientSet.TektonV1().Pipelines(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if data, err = json.Marshal(source); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var err error
+		data, err = rc.Get().Namespace(namespace).Resource("pipelines").Name(name).DoRaw(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := decodeStrict("Pipeline", name, data, source); err != nil {
+			return nil, nil, err
+		}
+	}
+	p := &v1beta1.Pipeline{}
+	if err := p.ConvertFrom(ctx, source); err != nil {
+		return nil, nil, fmt.Errorf("converting pipeline %s from %s: %v", name, APIVersionV1, err)
+	}
+	return p, data, nil
+}
+
+// getClusterTask fetches the Task from the namespace.
+func (r *Resolver) getClusterTask(ctx context.Context, namespace, name string) (*v1beta1.Task, error) {
+	rc := r.restClient()
+	if rc == nil {
+		return r.pipelineClientSet.TektonV1beta1().Tasks(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	data, err := rc.Get().Namespace(namespace).Resource("tasks").Name(name).DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := &v1beta1.Task{}
+	if err := decodeStrict("Task", name, data, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
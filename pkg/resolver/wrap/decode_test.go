@@ -0,0 +1,86 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPipeline(t *testing.T) {
+	// The v1beta1 Pipeline declares a PipelineResource, which v1 lacks
+	v1beta1Pipeline := &v1beta1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "dev"},
+		Spec: v1beta1.PipelineSpec{
+			Description: "v1beta1",
+			Resources:   []v1beta1.PipelineDeclaredResource{{Name: "source", Type: "git"}},
+			Tasks:       []v1beta1.PipelineTask{{Name: "clone", TaskRef: &v1beta1.TaskRef{Name: "git-clone"}}},
+		},
+	}
+	v1Pipeline := &v1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "dev"},
+		Spec: v1.PipelineSpec{
+			Description: "v1",
+			Tasks:       []v1.PipelineTask{{Name: "clone", TaskRef: &v1.TaskRef{Name: "git-clone"}}},
+		},
+	}
+	for _, tc := range []struct {
+		name     string
+		conf     map[string]string
+		v1beta1  bool
+		v1       bool
+		want     string
+		notFound bool
+	}{
+		{name: "both, v1beta1 first by default", v1beta1: true, v1: true, want: "v1beta1"},
+		{name: "v1 only", v1: true, want: "v1"},
+		{name: "v1beta1 only", v1beta1: true, want: "v1beta1"},
+		{name: "both, v1 first", conf: map[string]string{"pipeline-api-versions": APIVersionV1 + "," + APIVersionV1beta1}, v1beta1: true, v1: true, want: "v1"},
+		{name: "v1beta1 not looked up", conf: map[string]string{"pipeline-api-versions": APIVersionV1}, v1beta1: true, notFound: true},
+		{name: "none", notFound: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			ctx := framework.InjectResolverConfigToContext(context.Background(), tc.conf)
+			if tc.v1beta1 {
+				if _, err := client.TektonV1beta1().Pipelines("dev").Create(ctx, v1beta1Pipeline, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.v1 {
+				if _, err := client.TektonV1().Pipelines("dev").Create(ctx, v1Pipeline, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &Resolver{pipelineClientSet: client}
+
+			p, data, err := r.getPipeline(ctx, "dev", "build")
+			if tc.notFound {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("getPipeline() = %v, want not found", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Spec.Description != tc.want {
+				t.Errorf("getPipeline() returned the %s Pipeline, want the %s one", p.Spec.Description, tc.want)
+			}
+			if len(data) == 0 {
+				t.Error("getPipeline() returned no content")
+			}
+			if len(p.Spec.Tasks) != 1 || p.Spec.Tasks[0].TaskRef == nil || p.Spec.Tasks[0].TaskRef.Name != "git-clone" {
+				t.Errorf("getPipeline() returned the tasks %+v, want clone", p.Spec.Tasks)
+			}
+			if tc.want == "v1beta1" && (len(p.Spec.Resources) != 1 || p.Spec.Resources[0].Name != "source") {
+				t.Errorf("getPipeline() returned the resources %+v, want source", p.Spec.Resources)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package wrap
+
+import (
+	"sort"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// DryRunParam, when "true", wraps the Pipeline as usual but for
+	// inspection, e.g. by tooling creating ResolutionRequests to review the
+	// injected steps before running them: the resolved resource gets the
+	// DryRunSummaryAnnotation, and the wrapped-pipeline-cache is neither
+	// read nor written, so that the summary describes a fresh wrapping. The
+	// wrapped Pipeline is the same as without it.
+	DryRunParam = "dry-run"
+	// DryRunSummaryAnnotation is the annotation of the resolved resource
+	// describing what wrapping changed in the Pipeline, as a WrapSummary in
+	// JSON, with DryRunParam.
+	DryRunSummaryAnnotation = "wrap.tekton.dev/dry-run-summary"
+)
+
+// WrapSummary describes what wrapping changed in a Pipeline.
+type WrapSummary struct {
+	// InjectedSteps maps the wrapped tasks to the names of the steps
+	// injected in them.
+	InjectedSteps map[string][]string `json:"injectedSteps,omitempty"`
+	// Embedded lists the tasks whose taskRef was replaced with the
+	// taskSpec of the Task.
+	Embedded []string `json:"embedded,omitempty"`
+	// Finally lists the finally tasks added to the Pipeline.
+	Finally []string `json:"finally,omitempty"`
+	// Params lists the params added to the Pipeline.
+	Params []string `json:"params,omitempty"`
+	// StrippedWorkspaces lists the workspaces no task binds anymore.
+	StrippedWorkspaces []string `json:"strippedWorkspaces,omitempty"`
+	// Targets are the images, or tarballs for s3, of each wrapped
+	// workspace.
+	Targets map[string]string `json:"targets,omitempty"`
+}
+
+// summarize returns what wrapping the Pipeline, as fetched, changed in the
+// wrapped one, stamped with the annotations of the resolver.
+func summarize(original, wrapped *v1beta1.Pipeline, effective *EffectiveSettings) *WrapSummary {
+	s := &WrapSummary{InjectedSteps: map[string][]string{}}
+	if m, err := ParseMetadata(wrapped.Annotations); err == nil {
+		for task, steps := range m.InjectedSteps {
+			for _, step := range steps {
+				s.InjectedSteps[task] = append(s.InjectedSteps[task], step.Name)
+			}
+		}
+		s.StrippedWorkspaces = m.StrippedWorkspaces
+	}
+	for _, t := range original.Spec.Tasks {
+		if t.TaskRef != nil {
+			s.Embedded = append(s.Embedded, t.Name)
+		}
+	}
+	finally := sets.NewString()
+	for _, t := range original.Spec.Finally {
+		finally.Insert(t.Name)
+	}
+	for _, t := range wrapped.Spec.Finally {
+		if !finally.Has(t.Name) {
+			s.Finally = append(s.Finally, t.Name)
+		}
+	}
+	params := sets.NewString()
+	for _, p := range original.Spec.Params {
+		params.Insert(p.Name)
+	}
+	for _, p := range wrapped.Spec.Params {
+		if !params.Has(p.Name) {
+			s.Params = append(s.Params, p.Name)
+		}
+	}
+	sort.Strings(s.Embedded)
+	sort.Strings(s.Finally)
+	sort.Strings(s.Params)
+	if effective != nil {
+		s.Targets = effective.Targets
+	}
+	return s
+}
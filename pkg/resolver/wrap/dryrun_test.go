@@ -0,0 +1,72 @@
+package wrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+)
+
+func TestResolvePipelineDryRun(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		dryRun      string
+		wantSummary bool
+	}{
+		{name: "dry run", dryRun: "true", wantSummary: true},
+		{name: "not a dry run", dryRun: "false"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+				Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", []string{"source"}), embeddedTask("build", []string{"source"}, "clone")},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params, err := populateParamsWithDefaults(ctx, map[string]string{
+				PipelineRefParam: "build",
+				WorkspacesParam:  "source",
+				TargetParam:      "registry.example.com/{{workspace}}",
+				VerifyParam:      "true",
+				DryRunParam:      tc.dryRun,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resolved, err := (&Resolver{}).resolvePipeline(ctx, params, pipeline)
+			if err != nil {
+				t.Fatalf("resolvePipeline() = %v", err)
+			}
+			m, err := ParseMetadata(resolved.Annotations())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.wantSummary {
+				if m.DryRunSummary != nil {
+					t.Errorf("resolvePipeline() summary = %+v, want none", m.DryRunSummary)
+				}
+				return
+			}
+			s := m.DryRunSummary
+			if s == nil {
+				t.Fatal("resolvePipeline() has no summary")
+			}
+			if got := strings.Join(s.InjectedSteps["clone"], ","); !strings.Contains(got, "export-workspace") {
+				t.Errorf("summary of clone injected %q, want the export", got)
+			}
+			if got := strings.Join(s.InjectedSteps["build"], ","); !strings.Contains(got, "import-workspace") {
+				t.Errorf("summary of build injected %q, want the import", got)
+			}
+			if got := strings.Join(s.Finally, ","); got != verifyTaskName {
+				t.Errorf("summary added finally tasks %q, want %s", got, verifyTaskName)
+			}
+			if s.Targets["source"] != "registry.example.com/source" {
+				t.Errorf("summary targets = %v, want registry.example.com/source for source", s.Targets)
+			}
+		})
+	}
+}
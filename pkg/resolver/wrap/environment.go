@@ -0,0 +1,116 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// targetPrefixTemplate is replaced in the targets by the prefix the
+// environment-targets of the resolver configuration set for the
+// environment of the cluster, so that the same PipelineRun pushes to the
+// registry of each environment it is promoted to, e.g. dev, stage and
+// prod.
+const targetPrefixTemplate = "{{target-prefix}}"
+
+// defaultEnvironmentNamespace is the namespace whose environment-label
+// names the environment of the cluster, unless environment-namespace is
+// set.
+const defaultEnvironmentNamespace = "kube-system"
+
+// prefixedParams are the params holding targetPrefixTemplate.
+var prefixedParams = []string{TargetParam, TargetsParam, S3TargetParam, GCSTargetParam, RsyncTargetParam, ArchiveTargetParam, ImportSourceParam, SeedImageParam}
+
+type environmentKey struct{}
+
+// withEnvironment returns a context holding the environment of the
+// cluster.
+func withEnvironment(ctx context.Context, environment string) context.Context {
+	return context.WithValue(ctx, environmentKey{}, environment)
+}
+
+// environmentFromContext returns the environment of the cluster, if
+// withEnvironment set it.
+func environmentFromContext(ctx context.Context) (string, bool) {
+	environment, ok := ctx.Value(environmentKey{}).(string)
+	return environment, ok
+}
+
+// withClusterEnvironment returns ctx holding the environment of the
+// cluster: the value of the environment-label of the resolver
+// configuration on the environment-namespace. ctx is returned as is
+// without environment-label.
+func (r *Resolver) withClusterEnvironment(ctx context.Context) (context.Context, error) {
+	conf := framework.GetResolverConfigFromContext(ctx)
+	label, ok := conf["environment-label"]
+	if !ok {
+		return ctx, nil
+	}
+	namespace := defaultEnvironmentNamespace
+	if v, ok := conf["environment-namespace"]; ok {
+		namespace = v
+	}
+	ns, err := r.kubeClientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the environment of the cluster: %v", err)
+	}
+	environment, ok := ns.Labels[label]
+	if !ok {
+		return nil, fmt.Errorf("namespace %s has no %s label naming the environment of the cluster", namespace, label)
+	}
+	return withEnvironment(ctx, environment), nil
+}
+
+// parseEnvironmentTargets parses the environment-targets of the resolver
+// configuration: a line per environment of <environment>=<prefix>.
+func parseEnvironmentTargets(v string) (map[string]string, error) {
+	prefixes := map[string]string{}
+	for _, line := range strings.Split(v, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		environment, prefix, ok := strings.Cut(line, "=")
+		environment, prefix = strings.TrimSpace(environment), strings.TrimSpace(prefix)
+		if !ok || prefix == "" || strings.ContainsAny(prefix, " \t") {
+			return nil, fmt.Errorf("%q is not <environment>=<prefix>", line)
+		}
+		if errs := validation.IsValidLabelValue(environment); environment == "" || len(errs) > 0 {
+			return nil, fmt.Errorf("invalid environment %q: %s", environment, strings.Join(errs, ", "))
+		}
+		if _, ok := prefixes[environment]; ok {
+			return nil, fmt.Errorf("environment %s is listed twice", environment)
+		}
+		prefixes[environment] = prefix
+	}
+	return prefixes, nil
+}
+
+// renderTargetPrefix replaces targetPrefixTemplate in the prefixedParams
+// with the prefix of the environment of the cluster.
+func renderTargetPrefix(ctx context.Context, conf, params map[string]string) error {
+	for _, key := range prefixedParams {
+		v, ok := params[key]
+		if !ok || !strings.Contains(v, targetPrefixTemplate) {
+			continue
+		}
+		environment, ok := environmentFromContext(ctx)
+		if !ok {
+			return fmt.Errorf("invalid value for %s: %s requires environment-label in %s", key, targetPrefixTemplate, ResolverConfigName())
+		}
+		prefixes, err := parseEnvironmentTargets(conf["environment-targets"])
+		if err != nil {
+			return fmt.Errorf("invalid environment-targets in %s: %v", ResolverConfigName(), err)
+		}
+		prefix, ok := prefixes[environment]
+		if !ok {
+			return fmt.Errorf("invalid value for %s: environment-targets has no prefix for the %s environment of the cluster", key, environment)
+		}
+		params[key] = strings.ReplaceAll(v, targetPrefixTemplate, prefix)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWithClusterEnvironment(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "kube-system",
+		Labels: map[string]string{"example.com/environment": "prod"},
+	}})
+	r := &Resolver{kubeClientSet: client}
+	for _, tc := range []struct {
+		name    string
+		conf    map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "not configured", conf: map[string]string{}},
+		{name: "labelled", conf: map[string]string{"environment-label": "example.com/environment"}, want: "prod"},
+		{name: "not labelled", conf: map[string]string{"environment-label": "example.com/stage"}, wantErr: true},
+		{name: "missing namespace", conf: map[string]string{"environment-label": "example.com/environment", "environment-namespace": "other"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, err := r.withClusterEnvironment(framework.InjectResolverConfigToContext(context.Background(), tc.conf))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("withClusterEnvironment() = %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, _ := environmentFromContext(ctx); got != tc.want {
+				t.Errorf("withClusterEnvironment() environment %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderTargetPrefix(t *testing.T) {
+	conf := map[string]string{"environment-targets": "dev=dev.registry.example.com/team\nprod=registry.example.com/team"}
+	for _, tc := range []struct {
+		name        string
+		environment string
+		conf        map[string]string
+		target      string
+		want        string
+		wantErr     bool
+	}{
+		{name: "prod", environment: "prod", conf: conf, target: "{{target-prefix}}/{{workspace}}", want: "registry.example.com/team/{{workspace}}"},
+		{name: "dev", environment: "dev", conf: conf, target: "{{target-prefix}}/{{workspace}}", want: "dev.registry.example.com/team/{{workspace}}"},
+		{name: "without template", conf: map[string]string{}, target: "quay.io/me/{{workspace}}", want: "quay.io/me/{{workspace}}"},
+		{name: "unknown environment", environment: "stage", conf: conf, target: "{{target-prefix}}/{{workspace}}", wantErr: true},
+		{name: "no environment", conf: conf, target: "{{target-prefix}}/{{workspace}}", wantErr: true},
+		{name: "invalid configuration", environment: "prod", conf: map[string]string{"environment-targets": "prod"}, target: "{{target-prefix}}/{{workspace}}", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.environment != "" {
+				ctx = withEnvironment(ctx, tc.environment)
+			}
+			params := map[string]string{TargetParam: tc.target}
+			err := renderTargetPrefix(ctx, tc.conf, params)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("renderTargetPrefix() = %v, want error %t", err, tc.wantErr)
+			}
+			if err == nil && params[TargetParam] != tc.want {
+				t.Errorf("renderTargetPrefix() target %q, want %q", params[TargetParam], tc.want)
+			}
+		})
+	}
+}
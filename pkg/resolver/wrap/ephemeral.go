@@ -0,0 +1,77 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const cleanupTaskName = "wrap-cleanup"
+
+// ephemeralTag returns the run-scoped tag the export of the workspace by the
+// task is also pushed to with EphemeralWorkspacesParam, so that the cleanup
+// finds the images of intermediate exports the next ones moved the target
+// away from.
+func ephemeralTag(workspace, task string) string {
+	return "wrap-$(context.pipelineRun.uid)-" + workspace + "-" + task
+}
+
+// checkEphemeralTag returns an error if the tags of the exports of the
+// workspace by the task exceed the 128 characters of a tag, once the uid of
+// the PipelineRun is known.
+func checkEphemeralTag(workspace, task string) error {
+	tag := strings.ReplaceAll(ephemeralTag(workspace, task), "$(context.pipelineRun.uid)", strings.Repeat("x", 36))
+	if len(tag) > 128 {
+		return fmt.Errorf("the names of workspace %s and task %s are too long for the run-scoped tag %s", workspace, task, ephemeralTag(workspace, task))
+	}
+	return nil
+}
+
+// cleanupTask returns a finally task deleting the images, whatever the
+// outcome of the run. Images are deleted by digest, so that registries
+// deleting manifests but not tags do too, and the ones already deleted
+// through another tag are skipped.
+func cleanupTask(images []string, image string) v1beta1.PipelineTask {
+	var params []v1beta1.Param
+	var paramSpecs []v1beta1.ParamSpec
+	var script strings.Builder
+	fmt.Fprintf(&script, `#!/busybox/sh -e
+delete() {
+  if digest=$(crane digest "$1" 2>/dev/null); then
+    echo "Delete $1"
+    crane delete "${1%%:*}@${digest}"
+  fi
+}
+`)
+	for i, image := range images {
+		name := fmt.Sprintf("image-%d", i)
+		params = append(params, v1beta1.Param{Name: name, Value: *v1beta1.NewStructuredValues(image)})
+		paramSpecs = append(paramSpecs, v1beta1.ParamSpec{Name: name, Type: v1beta1.ParamTypeString})
+		fmt.Fprintf(&script, "delete $(params.%s)\n", name)
+	}
+
+	return v1beta1.PipelineTask{
+		Name:   cleanupTaskName,
+		Params: params,
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Params: paramSpecs,
+			Steps: []v1beta1.Step{{
+				Name:       "cleanup",
+				Image:      image,
+				WorkingDir: "/",
+				Script:     script.String(),
+			}},
+		}},
+	}
+}
+
+// cleanupUsage returns the usage of the task deleting the images.
+func cleanupUsage(images []string) TaskUsage {
+	registries := sets.NewString()
+	for _, image := range images {
+		registries.Insert(registry(image))
+	}
+	return TaskUsage{Name: cleanupTaskName, Pull: registries.List(), Push: registries.List()}
+}
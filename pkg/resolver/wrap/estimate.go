@@ -0,0 +1,69 @@
+package wrap
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// StorageEstimate describes what each run of the wrapped Pipeline stores
+// in the registries and buckets, for admins to evaluate their capacity
+// before wrapping Pipelines, see StorageEstimateAnnotation. The sizes are
+// left to the consumers, e.g. tkn wrap estimate, which knows the ones of
+// the previous runs.
+type StorageEstimate struct {
+	Workspaces []WorkspaceStorage `json:"workspaces"`
+}
+
+// WorkspaceStorage describes what each run stores for a wrapped
+// workspace.
+type WorkspaceStorage struct {
+	Workspace string `json:"workspace"`
+	Wrapper   string `json:"wrapper"`
+	// Target is the image, or the tarball, the workspace is exported to.
+	Target string `json:"target"`
+	// Exports is the number of exports of the workspace by a run, each
+	// holding its whole content: the image of the oci wrapper keeps them
+	// all as layers, the tarballs of the other wrappers only the last.
+	Exports int `json:"exports"`
+	// Layers is the number of layers each export appends to the image of
+	// the oci wrapper.
+	Layers int `json:"layers,omitempty"`
+	// Tags are the other tags each run pushes the image under, e.g. the
+	// run-scoped tags of the ephemeral workspaces or the latest alias.
+	Tags []string `json:"tags,omitempty"`
+	// Retained tells whether the images of each run are kept after the
+	// next runs: they aren't when the next runs overwrite the target, nor
+	// when the finally task of the ephemeral workspaces deletes them.
+	Retained bool `json:"retained"`
+}
+
+// storageEstimate returns the StorageEstimate of the exports of each
+// workspace, given the other tags they are pushed under.
+func storageEstimate(targets map[string]string, w wrappers, exports map[string]int, tags map[string][]string, ephemeral sets.String, chunks int) StorageEstimate {
+	var e StorageEstimate
+	for ws, target := range targets {
+		s := WorkspaceStorage{
+			Workspace: ws,
+			Wrapper:   w.get(ws),
+			Target:    target,
+			Exports:   exports[ws],
+			Tags:      tags[ws],
+			// Run-scoped targets, e.g. the ones of transactional
+			Retained: strings.Contains(target, "$(") && !ephemeral.Has(ws),
+		}
+		if s.Wrapper == OCIWrapper && s.Exports > 0 {
+			s.Layers = 1
+			if chunks > 1 {
+				s.Layers = chunks
+			}
+		}
+		sort.Strings(s.Tags)
+		e.Workspaces = append(e.Workspaces, s)
+	}
+	sort.Slice(e.Workspaces, func(i, j int) bool {
+		return e.Workspaces[i].Workspace < e.Workspaces[j].Workspace
+	})
+	return e
+}
@@ -0,0 +1,66 @@
+package wrap
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+)
+
+// ExcludeParam leaves paths out of the exported content of the workspaces,
+// e.g. node_modules,.git: comma separated patterns, relative to the root of
+// the workspaces. Like tar --exclude, a pattern without a slash matches
+// the files and directories of that name at any depth. The exports also
+// leave out the patterns of the wrapstep.WrapignoreFile of the workspaces.
+const ExcludeParam = "exclude"
+
+// parseExclude parses the patterns of ExcludeParam. They are quoted in the
+// scripts of the steps, so they can't hold quotes nor newlines.
+func parseExclude(v string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "/") || p == ".." || strings.HasPrefix(p, "../") || strings.Contains(p, "/../") {
+			return nil, fmt.Errorf("%q is not relative to the root of the workspace", p)
+		}
+		if strings.ContainsAny(p, "'\"\n") {
+			return nil, fmt.Errorf("%q holds quotes or newlines", p)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%q is not a valid pattern: %v", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// tarExcludeArgs returns the arguments of tar leaving out the patterns
+// and the ones of the wrapignore file, for a tar run in the root of the
+// workspace.
+func tarExcludeArgs(patterns []string) string {
+	var args strings.Builder
+	for _, p := range patterns {
+		fmt.Fprintf(&args, " --exclude='%s'", p)
+	}
+	fmt.Fprintf(&args, " $(test -f %[1]s && echo -X %[1]s)", wrapstep.WrapignoreFile)
+	return args.String()
+}
+
+// rsyncExcludeArgs returns the arguments of rsync leaving out the patterns
+// and the ones of the wrapignore file of the workspace at mountPath. The
+// excluded paths are deleted from the target too, so that the next imports
+// don't get them from a previous export.
+func rsyncExcludeArgs(mountPath string, patterns []string) string {
+	var args strings.Builder
+	args.WriteString(" --delete-excluded")
+	for _, p := range patterns {
+		fmt.Fprintf(&args, " --exclude='%s'", p)
+	}
+	wrapignore := path.Join(mountPath, wrapstep.WrapignoreFile)
+	fmt.Fprintf(&args, " $(test -f %[1]s && echo --exclude-from=%[1]s)", wrapignore)
+	return args.String()
+}
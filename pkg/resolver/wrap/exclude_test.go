@@ -0,0 +1,96 @@
+package wrap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExclude(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    []string
+		wantErr string
+	}{
+		{value: ""},
+		{value: "node_modules, .git,,build/*.o", want: []string{"node_modules", ".git", "build/*.o"}},
+		{value: "/etc", wantErr: "not relative"},
+		{value: "../cache", wantErr: "not relative"},
+		{value: "a/../../b", wantErr: "not relative"},
+		{value: "it's", wantErr: "quotes"},
+		{value: "[a", wantErr: "not a valid pattern"},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseExclude(tc.value)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("parseExclude() = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseExclude() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExportStepsExclude(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		wrapper string
+		params  map[string]string
+		want    []string
+	}{{
+		name:    "crane",
+		wrapper: OCIWrapper,
+		want:    []string{"tar -f - -c --exclude='node_modules' --exclude='.git' $(test -f .wrapignore && echo -X .wrapignore) ."},
+	}, {
+		name:    "wrapstep",
+		wrapper: OCIWrapper,
+		params:  map[string]string{ExportChunksParam: "2"},
+		want:    []string{"-exclude node_modules,.git"},
+	}, {
+		name:    "docker-archive",
+		wrapper: ArchiveWrapper,
+		want:    []string{"-exclude node_modules,.git"},
+	}, {
+		name:    "s3",
+		wrapper: S3Wrapper,
+		want:    []string{"tar -f - -c --exclude='node_modules' --exclude='.git' $(test -f .wrapignore && echo -X .wrapignore) ."},
+	}, {
+		name:    "gcs",
+		wrapper: GCSWrapper,
+		want:    []string{"tar -f - -c --exclude='node_modules' --exclude='.git' $(test -f .wrapignore && echo -X .wrapignore) ."},
+	}, {
+		name:    "rsync",
+		wrapper: RsyncWrapper,
+		want:    []string{"rsync -a --delete --delete-excluded --exclude='node_modules' --exclude='.git' $(test -f /workspace/source/.wrapignore && echo --exclude-from=/workspace/source/.wrapignore) /workspace/source/"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := map[string]string{ExcludeParam: "node_modules,.git"}
+			for k, v := range tc.params {
+				params[k] = v
+			}
+			o, err := newStepOptions(map[string]string{}, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, ok := strategy(tc.wrapper)
+			if !ok {
+				t.Fatalf("no wrapper %s", tc.wrapper)
+			}
+			transfers := []workspaceTransfer{{Workspace: "source", Wrapper: tc.wrapper, MountPath: "/workspace/source", Base: "registry.example.com/source", Target: "registry.example.com/source"}}
+			step := s.exportSteps(o, transfers)[0]
+			got := step.Script + strings.Join(step.Args, " ")
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("export step %s doesn't hold %q", got, want)
+				}
+			}
+		})
+	}
+}
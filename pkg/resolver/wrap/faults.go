@@ -0,0 +1,115 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Faults the injected steps can simulate, see FaultInjectParam.
+const (
+	// FaultRegistryError fails every registry (or S3) call.
+	FaultRegistryError = "registry-error"
+	// FaultSlow delays every registry (or S3) call by faultSlowDelay
+	// seconds.
+	FaultSlow = "slow"
+	// FaultPartialExtract truncates the content imports extract. The
+	// wrapstep helper doesn't simulate it.
+	FaultPartialExtract = "partial-extract"
+
+	faultSlowDelay = 30
+	// faultPartialBytes is how many bytes of the content partial extracts
+	// read.
+	faultPartialBytes = 1024
+	// faultInjectEnv is the environment variable the wrapstep helper reads
+	// the faults to simulate from.
+	faultInjectEnv = "WRAPSTEP_FAULT_INJECT"
+)
+
+var allFaults = []string{FaultRegistryError, FaultSlow, FaultPartialExtract}
+
+// faults are the faults the injected steps simulate.
+type faults []string
+
+// parseFaults parses the value of FaultInjectParam, a comma separated list
+// of faults.
+func parseFaults(v string) (faults, error) {
+	var f faults
+	for _, fault := range strings.Split(v, ",") {
+		if fault == "" {
+			continue
+		}
+		known := false
+		for _, k := range allFaults {
+			known = known || fault == k
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown fault %q, expected some of %s", fault, strings.Join(allFaults, ", "))
+		}
+		f = append(f, fault)
+	}
+	sort.Strings(f)
+	return f, nil
+}
+
+func (f faults) has(fault string) bool {
+	for _, v := range f {
+		if v == fault {
+			return true
+		}
+	}
+	return false
+}
+
+// inject makes the steps simulate the faults. Scripts get crane and aws
+// functions wrapping the commands, the wrapstep helper gets the faults in
+// its environment.
+func (f faults) inject(steps []v1beta1.Step) []v1beta1.Step {
+	if len(f) == 0 {
+		return steps
+	}
+	for i := range steps {
+		if steps[i].Script == "" {
+			steps[i].Env = append(steps[i].Env, corev1.EnvVar{Name: faultInjectEnv, Value: strings.Join(f, ",")})
+			continue
+		}
+		shebang, script, _ := strings.Cut(steps[i].Script, "\n")
+		steps[i].Script = shebang + "\n" + f.prelude() + script
+	}
+	return steps
+}
+
+// prelude returns the shell functions simulating the faults.
+func (f faults) prelude() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Simulated faults: %s\nfault_inject() {\n", strings.Join(f, ","))
+	if f.has(FaultSlow) {
+		fmt.Fprintf(&b, "  echo \"fault-inject: slowing down the transfer by %ds\" >&2\n  sleep %d\n", faultSlowDelay, faultSlowDelay)
+	}
+	if f.has(FaultRegistryError) {
+		b.WriteString("  echo \"fault-inject: simulated registry error\" >&2\n  return 1\n")
+	} else {
+		b.WriteString("  return 0\n")
+	}
+	b.WriteString("}\n")
+	// The streams extracted are crane export and aws s3 cp to stdout
+	for _, c := range []struct{ cmd, extract string }{{"crane", `export\ *`}, {"aws", `*\ -`}} {
+		cmd, extract := c.cmd, c.extract
+		if !f.has(FaultPartialExtract) {
+			fmt.Fprintf(&b, "%s() {\n  fault_inject || return\n  command %s \"$@\"\n}\n", cmd, cmd)
+			continue
+		}
+		fmt.Fprintf(&b, `%s() {
+  fault_inject || return
+  case "$*" in
+  %s) command %s "$@" | head -c %d ;;
+  *) command %s "$@" ;;
+  esac
+}
+`, cmd, extract, cmd, faultPartialBytes, cmd)
+	}
+	return b.String()
+}
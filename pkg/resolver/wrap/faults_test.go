@@ -0,0 +1,102 @@
+package wrap
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+func TestNewStepOptionsFaultInject(t *testing.T) {
+	allowed := map[string]string{"allow-fault-injection": "true"}
+	for _, tc := range []struct {
+		name    string
+		conf    map[string]string
+		value   string
+		want    faults
+		wantErr string
+	}{
+		{name: "not allowed", conf: map[string]string{}, value: FaultSlow, wantErr: "allow-fault-injection isn't set"},
+		{name: "disallowed", conf: map[string]string{"allow-fault-injection": "false"}, value: FaultSlow, wantErr: "allow-fault-injection isn't set"},
+		{name: "unknown", conf: allowed, value: "slow,disk-full", wantErr: `unknown fault "disk-full"`},
+		{name: "sorted", conf: allowed, value: "slow,,registry-error", want: faults{FaultRegistryError, FaultSlow}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := newStepOptions(tc.conf, map[string]string{FaultInjectParam: tc.value})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("newStepOptions() = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(o.Faults, tc.want) {
+				t.Errorf("Faults = %v, want %v", o.Faults, tc.want)
+			}
+		})
+	}
+}
+
+func TestFaultsInject(t *testing.T) {
+	steps := faults{FaultSlow}.inject([]v1beta1.Step{
+		{Name: "helper", Args: []string{"import"}},
+		{Name: "script", Script: "#!/bin/sh\ncrane export image -"},
+	})
+	if env := steps[0].Env; len(env) != 1 || env[0].Name != faultInjectEnv || env[0].Value != FaultSlow {
+		t.Errorf("helper step env = %v, want %s=%s", env, faultInjectEnv, FaultSlow)
+	}
+	script := steps[1].Script
+	if !strings.HasPrefix(script, "#!/bin/sh\n# Simulated faults: slow\n") || !strings.HasSuffix(script, "\ncrane export image -") {
+		t.Errorf("script step doesn't run the prelude after its shebang:\n%s", script)
+	}
+	if !strings.Contains(script, "sleep 30") {
+		t.Errorf("script step doesn't slow down the transfers:\n%s", script)
+	}
+	if got := faults(nil).inject([]v1beta1.Step{{Name: "script", Script: "#!/bin/sh\ntrue"}}); got[0].Script != "#!/bin/sh\ntrue" {
+		t.Errorf("inject() without faults changed the script to:\n%s", got[0].Script)
+	}
+}
+
+func TestFaultsPrelude(t *testing.T) {
+	// The fake crane prints 4096 bytes
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "crane"), []byte("#!/bin/sh\nprintf '%04096d' 0\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		faults   faults
+		command  string
+		wantLen  int
+		wantFail bool
+	}{
+		{name: "none", faults: faults{}, command: "crane export image -", wantLen: 4096},
+		{name: "registry error", faults: faults{FaultRegistryError}, command: "crane digest image", wantFail: true},
+		{name: "partial extract", faults: faults{FaultPartialExtract}, command: "crane export image -", wantLen: faultPartialBytes},
+		{name: "partial extract of another command", faults: faults{FaultPartialExtract}, command: "crane digest image", wantLen: 4096},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := exec.Command("/bin/sh", "-c", tc.faults.prelude()+tc.command)
+			cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+			out, err := cmd.Output()
+			if tc.wantFail {
+				if err == nil {
+					t.Errorf("%s succeeded, want the simulated registry error", tc.command)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s failed: %v", tc.command, err)
+			}
+			if len(out) != tc.wantLen {
+				t.Errorf("%s printed %d bytes, want %d", tc.command, len(out), tc.wantLen)
+			}
+		})
+	}
+}
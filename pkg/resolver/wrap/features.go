@@ -0,0 +1,138 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"knative.dev/pkg/metrics"
+)
+
+// Feature is a behavior of the resolver rolled out behind a flag of the
+// resolver configuration, FeatureKeyPrefix followed by its name, e.g.
+// feature-import-source: "false".
+type Feature string
+
+const (
+	// FeatureImportSource allows ImportSourceParam.
+	FeatureImportSource Feature = "import-source"
+	// FeatureWorkspaceContracts checks the content Tasks expect in their
+	// workspaces after their imports, see ContractAnnotationPrefix. The
+	// annotations are ignored when disabled.
+	FeatureWorkspaceContracts Feature = "workspace-contracts"
+	// FeatureArchiveTargets allows docker-archive targets, see
+	// ArchiveWrapper.
+	FeatureArchiveTargets Feature = "archive-targets"
+
+	// FeatureKeyPrefix prefixes the keys of the resolver configuration
+	// enabling or disabling features.
+	FeatureKeyPrefix = "feature-"
+)
+
+const (
+	// StageAlpha features are disabled by default.
+	StageAlpha = "alpha"
+	// StageBeta features are enabled by default.
+	StageBeta = "beta"
+)
+
+// featureFlag describes a Feature.
+type featureFlag struct {
+	Stage       string
+	Description string
+}
+
+// featureFlags are the known features.
+var featureFlags = map[Feature]featureFlag{
+	FeatureImportSource: {
+		Stage:       StageBeta,
+		Description: "the import-source param",
+	},
+	FeatureWorkspaceContracts: {
+		Stage:       StageBeta,
+		Description: "the checks of the wrap.tekton.dev/expects-* annotations of Tasks",
+	},
+	FeatureArchiveTargets: {
+		Stage:       StageAlpha,
+		Description: "the docker-archive: targets",
+	},
+}
+
+func init() {
+	for f := range featureFlags {
+		configValidators[FeatureKeyPrefix+string(f)] = validateBool
+	}
+}
+
+// enabled tells whether the feature is enabled by the resolver
+// configuration, or by default at its stage.
+func (f Feature) enabled(conf map[string]string) bool {
+	if v, ok := conf[FeatureKeyPrefix+string(f)]; ok {
+		enabled, _ := strconv.ParseBool(v)
+		return enabled
+	}
+	return featureFlags[f].Stage == StageBeta
+}
+
+// check returns an error if the feature is disabled.
+func (f Feature) check(conf map[string]string) error {
+	if f.enabled(conf) {
+		return nil
+	}
+	return fmt.Errorf("feature %s (%s, %s) is disabled, set %s%s to true in %s to enable it", f, featureFlags[f].Description, featureFlags[f].Stage, FeatureKeyPrefix, f, ResolverConfigName())
+}
+
+// featureStates returns the state of each feature with the resolver
+// configuration.
+func featureStates(conf map[string]string) map[Feature]bool {
+	flags := map[Feature]bool{}
+	for f := range featureFlags {
+		flags[f] = f.enabled(conf)
+	}
+	return flags
+}
+
+// usedFeatures collects the features a resolution uses, recorded once it
+// succeeds.
+type usedFeatures map[Feature]bool
+
+func (u usedFeatures) list() []string {
+	var list []string
+	for f := range u {
+		list = append(list, string(f))
+	}
+	sort.Strings(list)
+	return list
+}
+
+var (
+	featureUsageCount = stats.Int64("wrap_feature_usage_count", "Number of resolutions using a feature of the resolver", stats.UnitDimensionless)
+	featureTagKey     = tag.MustNewKey("feature")
+	stageTagKey       = tag.MustNewKey("stage")
+)
+
+func init() {
+	if err := metrics.RegisterResourceView(&view.View{
+		Description: featureUsageCount.Description(),
+		Measure:     featureUsageCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{featureTagKey, stageTagKey},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// record records the usage of the features.
+func (u usedFeatures) record(ctx context.Context) {
+	for f := range u {
+		ctx, err := tag.New(ctx, tag.Insert(featureTagKey, string(f)), tag.Insert(stageTagKey, featureFlags[f].Stage))
+		if err != nil {
+			continue
+		}
+		metrics.Record(ctx, featureUsageCount.M(1))
+	}
+}
@@ -0,0 +1,75 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+const defaultGCSImage = "gcr.io/google.com/cloudsdktool/google-cloud-cli:alpine"
+
+// gcsOptions holds the settings of the steps of the gcs wrapper. They
+// authenticate as the service account of the TaskRuns, e.g. with Workload
+// Identity.
+type gcsOptions struct {
+	// Image is the image of the gcloud CLI.
+	Image string
+}
+
+func newGCSOptions(conf map[string]string) gcsOptions {
+	o := gcsOptions{Image: defaultGCSImage}
+	if image, ok := conf["gcs-image"]; ok {
+		o.Image = image
+	}
+	return o
+}
+
+func (o gcsOptions) step(name, script string) v1beta1.Step {
+	return v1beta1.Step{
+		Name:       name,
+		Image:      o.Image,
+		WorkingDir: "/",
+		Script:     script,
+	}
+}
+
+// gcsImportSteps returns a step extracting the tarballs of the workspaces,
+// like s3ImportSteps.
+func gcsImportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Extract workspace content from %s in %s\"\n", t.Source, t.MountPath)
+		extract := fmt.Sprintf("gcloud storage cat %s | tar -x -C %s", t.Source, t.MountPath)
+		if !o.BestEffort {
+			fmt.Fprintf(&script, "%s\n", extract)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to get %s, continuing with the workspace as is"
+fi
+`, extract, t.Source)
+	}
+	return []v1beta1.Step{o.GCS.step("gcs-import-workspace", script.String())}
+}
+
+// gcsExportSteps returns a step uploading the content of the workspaces in
+// tarballs.
+func gcsExportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Export workspace content from %s to %s\"\n", t.MountPath, t.Target)
+		export := fmt.Sprintf("(cd %s && tar -f - -c%s . | gcloud storage cp - %s)", t.MountPath, tarExcludeArgs(o.Exclude), t.Target)
+		if !o.BestEffort {
+			fmt.Fprintf(&script, "%s\n", export)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to export workspace content to %s, continuing"
+fi
+`, export, t.Target)
+	}
+	return []v1beta1.Step{o.GCS.step("gcs-export-workspace", script.String())}
+}
@@ -0,0 +1,107 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// TaskSourceCluster fetches the referenced Tasks from the namespace of
+	// the request, the default.
+	TaskSourceCluster = "cluster"
+	// TaskSourceGit fetches the referenced Tasks from a git repository.
+	TaskSourceGit = "git"
+
+	defaultTaskGitRevision = "main"
+)
+
+// gitTaskSource fetches the referenced Tasks from the raw files of a git
+// repository at a given revision (e.g. the raw endpoint of GitHub or
+// GitLab), so that the wrapped Pipeline matches what's in git even if the
+// Tasks in the cluster have drifted. The URL template holds the path
+// convention of the repository, with {{revision}} and {{task}}
+// placeholders, e.g.
+// https://raw.githubusercontent.com/org/repo/{{revision}}/tasks/{{task}}.yaml
+type gitTaskSource struct {
+	urlTemplate string
+	revision    string
+	client      *http.Client
+}
+
+// newGitTaskSource returns the git source of the resolver configuration, or
+// nil if the Tasks are fetched from the cluster.
+func newGitTaskSource(conf map[string]string) (*gitTaskSource, error) {
+	if conf["task-source"] != TaskSourceGit {
+		return nil, nil
+	}
+	urlTemplate, ok := conf["task-git-url"]
+	if !ok {
+		return nil, fmt.Errorf("task-git-url is required with task-source %s", TaskSourceGit)
+	}
+	revision := conf["task-git-revision"]
+	if revision == "" {
+		revision = defaultTaskGitRevision
+	}
+	return &gitTaskSource{
+		urlTemplate: urlTemplate,
+		revision:    revision,
+		client:      http.DefaultClient,
+	}, nil
+}
+
+func (g *gitTaskSource) url(task string) string {
+	return strings.NewReplacer("{{revision}}", g.revision, "{{task}}", task).Replace(g.urlTemplate)
+}
+
+// task fetches the Task named name at the revision.
+func (g *gitTaskSource) task(ctx context.Context, name string) (*v1beta1.Task, error) {
+	u := g.url(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", u, err)
+	}
+	t := &v1beta1.Task{}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("%s is not a Task: %v", u, err)
+	}
+	if err := decodeStrict("Task", name, data, &v1beta1.Task{}); err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	if t.Kind != "Task" || t.Name != name {
+		return nil, fmt.Errorf("%s is not the Task %s but the %s %s", u, name, t.Kind, t.Name)
+	}
+	return t, nil
+}
+
+func validateTaskGitURL(v string) error {
+	if !strings.Contains(v, "{{task}}") {
+		return fmt.Errorf("%q has no {{task}} placeholder", v)
+	}
+	u, err := url.ParseRequestURI(strings.NewReplacer("{{revision}}", "rev", "{{task}}", "task").Replace(v))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an http(s) URL", v)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package wrap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Authorizer decides whether the caller of a gRPC call, e.g. identified by
+// the metadata of ctx, can wrap Pipelines for the namespace. It returns a
+// status error otherwise.
+type Authorizer func(ctx context.Context, namespace string) error
+
+// GRPCService serves the resolutions of the API over gRPC, see wrapapi.
+type GRPCService struct {
+	api *API
+	// Authorize authorizes the calls, KubernetesAuthorizer by default.
+	Authorize Authorizer
+}
+
+var _ wrapapi.WrapperServer = &GRPCService{}
+
+// NewGRPCService returns a GRPCService serving the resolutions of the API,
+// with its resolver configuration.
+func NewGRPCService(a *API) *GRPCService {
+	return &GRPCService{api: a, Authorize: a.KubernetesAuthorizer}
+}
+
+// KubernetesAuthorizer authorizes the callers like the HTTP API: their
+// bearer token of the cluster, in the authorization metadata, must
+// authenticate a user allowed to create ResolutionRequests in the namespace.
+func (a *API) KubernetesAuthorizer(ctx context.Context, namespace string) error {
+	a.mu.Lock()
+	kubeClient := a.kubeClient
+	a.mu.Unlock()
+	if kubeClient == nil {
+		return status.Error(codes.Unavailable, errNotLoaded.Error())
+	}
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(wrapapi.AuthorizationKey); len(v) > 0 {
+			authorization = v[0]
+		}
+	}
+	user, code, err := authenticate(ctx, kubeClient, authorization)
+	if err == nil {
+		code, err = authorize(ctx, kubeClient, user, namespace)
+	}
+	if err != nil {
+		return status.Error(grpcCode(code), err.Error())
+	}
+	return nil
+}
+
+// Wrap wraps the Pipeline of the request.
+func (s *GRPCService) Wrap(ctx context.Context, req *wrapapi.WrapRequest) (*wrapapi.WrapResponse, error) {
+	data, err := s.wrap(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &wrapapi.WrapResponse{ID: req.ID, Pipeline: data}, nil
+}
+
+// WrapStream wraps the Pipelines of the requests of the stream, in order.
+// The failures to wrap a Pipeline are reported in the Error of its
+// response, while authorization failures end the stream.
+func (s *GRPCService) WrapStream(stream wrapapi.Wrapper_WrapStreamServer) error {
+	// The callers are authorized once per namespace
+	authorized := sets.NewString()
+	var current *wrapapi.WrapRequest
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			current = req
+		} else if req.ID != current.ID {
+			return status.Errorf(codes.InvalidArgument, "request %s received while %s is incomplete", req.ID, current.ID)
+		} else {
+			current.Pipeline = append(current.Pipeline, req.Pipeline...)
+		}
+		if len(current.Pipeline) > maxAPIPipelineSize {
+			return status.Errorf(codes.ResourceExhausted, "pipeline %s exceeds %d bytes", current.ID, maxAPIPipelineSize)
+		}
+		if req.More {
+			continue
+		}
+
+		data, err := s.wrap(stream.Context(), current, authorized)
+		resp := &wrapapi.WrapResponse{ID: current.ID}
+		if err != nil {
+			if code := status.Code(err); code == codes.Unauthenticated || code == codes.PermissionDenied || code == codes.Unavailable {
+				return err
+			}
+			resp.Error = status.Convert(err).Message()
+		}
+		chunks := wrapapi.Chunks(data)
+		for i, chunk := range chunks {
+			resp.Pipeline, resp.More = chunk, i < len(chunks)-1
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+		current = nil
+	}
+	if current != nil {
+		return status.Errorf(codes.InvalidArgument, "request %s is incomplete", current.ID)
+	}
+	return nil
+}
+
+// wrap authorizes and wraps the Pipeline of the request, returning status
+// errors. The namespaces in authorized, if any, aren't authorized again.
+func (s *GRPCService) wrap(ctx context.Context, req *wrapapi.WrapRequest, authorized sets.String) ([]byte, error) {
+	pipeline, err := parsePipeline(req.Pipeline, req.Namespace)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !authorized.Has(pipeline.Namespace) {
+		if err := s.Authorize(ctx, pipeline.Namespace); err != nil {
+			return nil, err
+		}
+		if authorized != nil {
+			authorized.Insert(pipeline.Namespace)
+		}
+	}
+	params := make(map[string]string, len(req.Params))
+	for k, v := range req.Params {
+		params[k] = v
+	}
+	data, err := s.api.wrapPipeline(ctx, params, pipeline)
+	var invalid invalidRequestError
+	switch {
+	case errors.Is(err, errNotLoaded):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case errors.As(err, &invalid):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return data, nil
+}
+
+// grpcCode returns the gRPC code of an HTTP status code of the API.
+func grpcCode(code int) codes.Code {
+	switch code {
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,146 @@
+package wrap
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestKubernetesAuthorizer(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		namespace     string
+		notLoaded     bool
+		want          codes.Code
+	}{
+		{name: "authorized", authorization: "Bearer " + validToken, namespace: "ci", want: codes.OK},
+		{name: "missing token", namespace: "ci", want: codes.Unauthenticated},
+		{name: "invalid token", authorization: "Bearer other", namespace: "ci", want: codes.Unauthenticated},
+		{name: "forbidden namespace", authorization: "Bearer " + validToken, namespace: "prod", want: codes.PermissionDenied},
+		{name: "not loaded", authorization: "Bearer " + validToken, namespace: "ci", notLoaded: true, want: codes.Unavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &API{resolver: &Resolver{}}
+			if !tc.notLoaded {
+				a.kubeClient = fakeAuthClient("ci", nil)
+			}
+			ctx := context.Background()
+			if tc.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(wrapapi.AuthorizationKey, tc.authorization))
+			}
+			if got := status.Code(a.KubernetesAuthorizer(ctx, tc.namespace)); got != tc.want {
+				t.Errorf("KubernetesAuthorizer() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// fakeWrapStream is a stream of the requests, recording the responses.
+type fakeWrapStream struct {
+	grpc.ServerStream
+	requests  []*wrapapi.WrapRequest
+	responses []*wrapapi.WrapResponse
+}
+
+func (s *fakeWrapStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeWrapStream) Recv() (*wrapapi.WrapRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *fakeWrapStream) Send(resp *wrapapi.WrapResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func TestWrapStreamAuthorization(t *testing.T) {
+	pipeline := func(namespace string) []byte {
+		return []byte("metadata:\n  name: build\n  namespace: " + namespace + "\n")
+	}
+	invalid := map[string]string{ExportChunksParam: "0"}
+	for _, tc := range []struct {
+		name           string
+		requests       []*wrapapi.WrapRequest
+		allowed        string
+		want           codes.Code
+		wantAuthorized []string
+		wantResponses  int
+	}{{
+		name: "authorized once per namespace",
+		requests: []*wrapapi.WrapRequest{
+			{ID: "1", Params: invalid, Pipeline: pipeline("ci")},
+			{ID: "2", Params: invalid, Pipeline: pipeline("ci")},
+			{ID: "3", Params: invalid, Pipeline: pipeline("dev")},
+		},
+		allowed:        "*",
+		want:           codes.OK,
+		wantAuthorized: []string{"ci", "dev"},
+		wantResponses:  3,
+	}, {
+		name: "denied namespace ends the stream",
+		requests: []*wrapapi.WrapRequest{
+			{ID: "1", Params: invalid, Pipeline: pipeline("ci")},
+			{ID: "2", Params: invalid, Pipeline: pipeline("prod")},
+			{ID: "3", Params: invalid, Pipeline: pipeline("ci")},
+		},
+		allowed:        "ci",
+		want:           codes.PermissionDenied,
+		wantAuthorized: []string{"ci", "prod"},
+		wantResponses:  1,
+	}, {
+		name: "namespace of the request",
+		requests: []*wrapapi.WrapRequest{
+			{ID: "1", Namespace: "prod", Params: invalid, Pipeline: pipeline("ci")},
+		},
+		allowed:        "ci",
+		want:           codes.PermissionDenied,
+		wantAuthorized: []string{"prod"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The params are invalid, each Pipeline fails once authorized
+			a := &API{resolver: &Resolver{}, ctx: context.Background(), conf: map[string]string{}}
+			var authorized []string
+			s := &GRPCService{api: a, Authorize: func(ctx context.Context, namespace string) error {
+				authorized = append(authorized, namespace)
+				if tc.allowed != "*" && namespace != tc.allowed {
+					return status.Error(codes.PermissionDenied, "denied")
+				}
+				return nil
+			}}
+			stream := &fakeWrapStream{requests: tc.requests}
+			if got := status.Code(s.WrapStream(stream)); got != tc.want {
+				t.Errorf("WrapStream() = %v, want %v", got, tc.want)
+			}
+			if len(authorized) != len(tc.wantAuthorized) {
+				t.Fatalf("authorized %v, want %v", authorized, tc.wantAuthorized)
+			}
+			for i := range authorized {
+				if authorized[i] != tc.wantAuthorized[i] {
+					t.Errorf("authorized %v, want %v", authorized, tc.wantAuthorized)
+				}
+			}
+			if len(stream.responses) != tc.wantResponses {
+				t.Errorf("WrapStream() sent %d responses, want %d", len(stream.responses), tc.wantResponses)
+			}
+			for _, resp := range stream.responses {
+				if resp.Error == "" {
+					t.Errorf("response %s has no error, want the invalid params", resp.ID)
+				}
+			}
+		})
+	}
+}
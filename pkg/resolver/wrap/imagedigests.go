@@ -0,0 +1,237 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+)
+
+// ImageDriftAnnotation is the annotation of the resolved resource listing
+// the images of the injected steps whose tag moved to another digest since
+// the resolver first resolved it, as ImageDrifts in JSON, with the
+// image-digest-watch-interval of the resolver configuration.
+const ImageDriftAnnotation = "wrap.tekton.dev/image-drift"
+
+// imageDigestPoll is how often the ImageDigestWatcher looks for an
+// image-digest-watch-interval elapsed, the interval possibly changing.
+const imageDigestPoll = time.Minute
+
+// ImageDrift is an image whose tag now points to another digest than the
+// one first resolved.
+type ImageDrift struct {
+	// Image is the image reference, by tag.
+	Image string `json:"image"`
+	// Previous is the digest the tag was first resolved to.
+	Previous string `json:"previous"`
+	// Current is the digest the tag was last resolved to.
+	Current string `json:"current"`
+	// Detected is when the change was noticed.
+	Detected time.Time `json:"detected"`
+}
+
+var (
+	imageDriftCount = stats.Int64("wrap_image_digest_drift_count", "Number of changes of the digests of the images of the injected steps", stats.UnitDimensionless)
+	imageTagKey     = tag.MustNewKey("image")
+)
+
+func init() {
+	if err := metrics.RegisterResourceView(&view.View{
+		Description: imageDriftCount.Description(),
+		Measure:     imageDriftCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{imageTagKey},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// imageDigests records the digests the image tags were resolved to, and
+// their drifts. The zero value is ready to use.
+type imageDigests struct {
+	mu      sync.Mutex
+	digests map[string]string
+	drifts  map[string]ImageDrift
+}
+
+// observe records the digest the image was resolved to, returning the
+// drift if it is another one than the one first resolved.
+func (d *imageDigests) observe(image, digest string, now time.Time) (ImageDrift, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.digests == nil {
+		d.digests, d.drifts = map[string]string{}, map[string]ImageDrift{}
+	}
+	first, ok := d.digests[image]
+	if !ok {
+		d.digests[image] = digest
+		return ImageDrift{}, false
+	}
+	if digest == first {
+		// Moved back
+		delete(d.drifts, image)
+		return ImageDrift{}, false
+	}
+	if drift, ok := d.drifts[image]; ok && drift.Current == digest {
+		return drift, false
+	}
+	drift := ImageDrift{Image: image, Previous: first, Current: digest, Detected: now}
+	d.drifts[image] = drift
+	return drift, true
+}
+
+// of returns the drifts of the images, sorted by image.
+func (d *imageDigests) of(images map[string]string) []ImageDrift {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var drifts []ImageDrift
+	for _, image := range images {
+		if drift, ok := d.drifts[image]; ok {
+			drifts = append(drifts, drift)
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Image < drifts[j].Image })
+	return drifts
+}
+
+// watchedImages returns the images of the injected steps which are
+// references by tag: crane, the base and wrapstep images, and the other
+// images the resolver configuration sets, the ones of the wrapper templates
+// included. The ones by digest can't drift, and the ones holding variables
+// are only known at run time.
+func watchedImages(conf map[string]string) []string {
+	o, err := newStepOptions(conf, map[string]string{})
+	if err != nil {
+		return nil
+	}
+	images := sets.NewString(o.CraneImage, o.BaseImage, o.WrapstepImage)
+	for _, t := range o.Templates {
+		images.Insert(t.Image)
+	}
+	for _, key := range []string{"default-base-image", "s3-image", "gcs-image", "rsync-image", "openlineage-image", "verify-image"} {
+		if image, ok := conf[key]; ok {
+			images.Insert(image)
+		}
+	}
+	var watched []string
+	for _, image := range images.List() {
+		if strings.Contains(image, "@") || variableRegex.MatchString(image) {
+			continue
+		}
+		watched = append(watched, image)
+	}
+	return watched
+}
+
+// ImageDigestWatcher resolves the tags of the images of the injected steps
+// to their digests every image-digest-watch-interval of the resolver
+// configuration, and warns when one of them moves to another digest, e.g.
+// a silent upstream update of crane:debug: in the logs, with the
+// wrap_image_digest_drift_count metric, and in the ImageDriftAnnotation of
+// the resolutions using the image. It doesn't run without the interval.
+type ImageDigestWatcher struct {
+	resolver *Resolver
+	// resolve returns the digest of the image reference.
+	resolve func(ctx context.Context, image string) (string, error)
+
+	mu   sync.Mutex
+	conf map[string]string
+}
+
+// NewImageDigestWatcher returns an ImageDigestWatcher recording the drifts
+// in r, once its controller is set up with Watch.
+func NewImageDigestWatcher(r *Resolver) *ImageDigestWatcher {
+	return &ImageDigestWatcher{resolver: r, resolve: resolveImageDigest}
+}
+
+// Watch wraps the constructor of the resolver controller to watch the
+// images of the current resolver configuration until the context of the
+// controller is done.
+func (w *ImageDigestWatcher) Watch(ctor injection.ControllerConstructor) injection.ControllerConstructor {
+	return func(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+		impl := ctor(ctx, cmw)
+		cmw.Watch(ResolverConfigName(), func(cm *corev1.ConfigMap) {
+			conf := cm.Data
+			if conf == nil {
+				conf = map[string]string{}
+			}
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.conf = conf
+		})
+		go w.run(ctx)
+		return impl
+	}
+}
+
+func (w *ImageDigestWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(imageDigestPoll)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		w.mu.Lock()
+		conf := w.conf
+		w.mu.Unlock()
+		if interval, err := time.ParseDuration(conf["image-digest-watch-interval"]); err == nil && interval > 0 && time.Since(last) >= interval {
+			w.check(ctx, conf)
+			last = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check resolves the watched images of the configuration, recording their
+// digests and warning about their drifts. The images failing to resolve
+// are only logged, and checked again the next time.
+func (w *ImageDigestWatcher) check(ctx context.Context, conf map[string]string) {
+	logger := logging.FromContext(ctx)
+	for _, image := range watchedImages(conf) {
+		digest, err := w.resolve(ctx, image)
+		if err != nil {
+			logger.Infof("failed to resolve the digest of image %s: %v", image, err)
+			continue
+		}
+		drift, changed := w.resolver.imageDigests.observe(image, digest, time.Now())
+		if !changed {
+			continue
+		}
+		logger.Warnf("image %s moved from digest %s to %s: pin the digest in %s to keep the previous one", image, drift.Previous, drift.Current, ResolverConfigName())
+		if ctx, err := tag.New(ctx, tag.Insert(imageTagKey, image)); err == nil {
+			metrics.Record(ctx, imageDriftCount.M(1))
+		}
+	}
+}
+
+// resolveImageDigest returns the digest the registry serves for the image
+// reference, with the credentials of the resolver.
+func resolveImageDigest(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %v", image, err)
+	}
+	return desc.Digest.String(), nil
+}
@@ -0,0 +1,70 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWatchedImages(t *testing.T) {
+	got := watchedImages(map[string]string{
+		"base-image":         "registry.example.com/base@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"wrapstep-image":     "registry.example.com/wrapstep:$(context.pipelineRun.name)",
+		"verify-image":       "docker.io/library/busybox:1.36",
+		"default-base-image": "registry.example.com/base:v2",
+	})
+	want := []string{"docker.io/library/busybox:1.36", DefaultCraneImage, "registry.example.com/base:v2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("watchedImages() = %v, want %v", got, want)
+	}
+}
+
+func TestImageDigestWatcher(t *testing.T) {
+	r := &Resolver{}
+	digest := "sha256:1"
+	w := &ImageDigestWatcher{resolver: r, resolve: func(ctx context.Context, image string) (string, error) {
+		if image != DefaultCraneImage {
+			return "", fmt.Errorf("not found")
+		}
+		return digest, nil
+	}}
+	images := map[string]string{"crane": DefaultCraneImage}
+	check := func() []ImageDrift {
+		t.Helper()
+		w.check(context.Background(), map[string]string{})
+		return r.imageDigests.of(images)
+	}
+
+	if drifts := check(); len(drifts) != 0 {
+		t.Errorf("drifts on the first check = %v", drifts)
+	}
+	digest = "sha256:2"
+	drifts := check()
+	if len(drifts) != 1 || drifts[0].Image != DefaultCraneImage || drifts[0].Previous != "sha256:1" || drifts[0].Current != "sha256:2" {
+		t.Fatalf("drifts = %v, want %s from sha256:1 to sha256:2", drifts, DefaultCraneImage)
+	}
+	detected := drifts[0].Detected
+	if drifts := check(); len(drifts) != 1 || !drifts[0].Detected.Equal(detected) {
+		t.Errorf("drifts = %v, want the drift detected at %v", drifts, detected)
+	}
+	if drifts := r.imageDigests.of(map[string]string{"base": DefaultBaseImage}); len(drifts) != 0 {
+		t.Errorf("drifts of other images = %v", drifts)
+	}
+	digest = "sha256:1"
+	if drifts := check(); len(drifts) != 0 {
+		t.Errorf("drifts once moved back = %v", drifts)
+	}
+}
+
+func TestImageDriftAnnotation(t *testing.T) {
+	drift := ImageDrift{Image: DefaultCraneImage, Previous: "sha256:1", Current: "sha256:2", Detected: time.Unix(0, 0).UTC()}
+	resolved := &ResolvedWrapperResource{PipelineRef: "build", ImageDrifts: []ImageDrift{drift}}
+	m, err := ParseMetadata(resolved.Annotations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.ImageDrifts) != 1 || m.ImageDrifts[0] != drift {
+		t.Errorf("ParseMetadata() drifts = %v, want %v", m.ImageDrifts, drift)
+	}
+}
@@ -0,0 +1,84 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// importSources maps tasks, as "<task>", and workspaces of tasks, as
+// "<task>/<workspace>", to the image their import extracts instead of the
+// one exported upstream. The images of tasks may hold {{workspace}}.
+type importSources map[string]string
+
+// parseImportSources parses the value of ImportSourceParam: a comma
+// separated list of <task>=<image> and <task>/<workspace>=<image> pairs.
+func parseImportSources(value string) (importSources, error) {
+	s := importSources{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, image, ok := strings.Cut(part, "=")
+		if !ok || key == "" || image == "" || strings.Count(key, "/") > 1 {
+			return nil, fmt.Errorf("%q is neither <task>=<image> nor <task>/<workspace>=<image>", part)
+		}
+		if _, ok := s[key]; ok {
+			return nil, fmt.Errorf("%s is overridden twice", key)
+		}
+		s[key] = image
+	}
+	return s, nil
+}
+
+// get returns the image the import of the workspace by the task extracts,
+// "" if it isn't overridden.
+func (s importSources) get(task, workspace string) string {
+	if image, ok := s[task+"/"+workspace]; ok {
+		return image
+	}
+	return strings.ReplaceAll(s[task], "{{workspace}}", workspace)
+}
+
+// validate checks that the images are valid references and that the
+// overridden tasks exist and import workspaces wrapped with oci.
+func (s importSources) validate(p *v1beta1.PipelineSpec, workspaces []string, w wrappers) error {
+	wrapped := map[string]bool{}
+	for _, ws := range workspaces {
+		wrapped[ws] = true
+	}
+	for key := range s {
+		task, workspace, _ := strings.Cut(key, "/")
+		var pt *v1beta1.PipelineTask
+		for i := range p.Tasks {
+			if p.Tasks[i].Name == task {
+				pt = &p.Tasks[i]
+			}
+		}
+		if pt == nil {
+			return fmt.Errorf("%s isn't a task of the Pipeline", task)
+		}
+		uses := false
+		for _, pw := range pt.Workspaces {
+			if !wrapped[pw.Workspace] || workspace != "" && pw.Workspace != workspace {
+				continue
+			}
+			uses = true
+			if w.get(pw.Workspace) != OCIWrapper {
+				return fmt.Errorf("workspace %s isn't wrapped with %s, its import can't be overridden", pw.Workspace, OCIWrapper)
+			}
+			if err := validateReference(s.get(task, pw.Workspace)); err != nil {
+				return fmt.Errorf("invalid image for %s: %v", key, err)
+			}
+		}
+		if !uses && workspace != "" {
+			return fmt.Errorf("task %s doesn't use the wrapped workspace %s", task, workspace)
+		}
+		if !uses {
+			return fmt.Errorf("task %s doesn't use any wrapped workspace", task)
+		}
+	}
+	return nil
+}
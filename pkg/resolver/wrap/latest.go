@@ -0,0 +1,117 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const latestTaskName = "wrap-latest"
+
+// latestAlias is the alias tag of the final image of a workspace, see
+// LatestAliasParam.
+type latestAlias struct {
+	Workspace string
+	// Target is the image the exports of the workspace push to.
+	Target string
+	// Tag is the alias, in the repository of Target.
+	Tag string
+}
+
+// latestAliases returns the alias of the final image of each workspace
+// wrapped with oci: <pipeline>-latest, or <pipeline>-<workspace>-latest
+// when other wrapped workspaces share the repository of its target.
+func latestAliases(pipeline string, targets map[string]string, w wrappers) ([]latestAlias, error) {
+	shared := map[string]int{}
+	for ws, target := range targets {
+		if w.get(ws) == OCIWrapper {
+			shared[repository(target)]++
+		}
+	}
+	var aliases []latestAlias
+	for ws, target := range targets {
+		if w.get(ws) != OCIWrapper {
+			continue
+		}
+		tag := pipeline + "-latest"
+		if shared[repository(target)] > 1 {
+			tag = pipeline + "-" + ws + "-latest"
+		}
+		if err := validateReference(repository(target) + ":" + tag); err != nil {
+			return nil, fmt.Errorf("invalid alias for workspace %s: %v", ws, err)
+		}
+		aliases = append(aliases, latestAlias{Workspace: ws, Target: target, Tag: tag})
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Workspace < aliases[j].Workspace
+	})
+	return aliases, nil
+}
+
+// latestTags returns the tags moving the aliases.
+func latestTags(aliases []latestAlias) []imageTag {
+	var tags []imageTag
+	for _, a := range aliases {
+		tags = append(tags, imageTag{Image: a.Target, Tag: a.Tag})
+	}
+	return tags
+}
+
+// imageTag tags Image as Tag, in its repository.
+type imageTag struct {
+	Image string
+	Tag   string
+}
+
+// tagOnSuccessTask returns a finally task tagging the images, only when all
+// the tasks of the run succeeded or were skipped. Each tag is moved at once
+// by the registry, so it always points to a complete image.
+func tagOnSuccessTask(name string, tags []imageTag, image string) v1beta1.PipelineTask {
+	var params []v1beta1.Param
+	var paramSpecs []v1beta1.ParamSpec
+	addParam := func(name, value string) string {
+		params = append(params, v1beta1.Param{Name: name, Value: *v1beta1.NewStructuredValues(value)})
+		paramSpecs = append(paramSpecs, v1beta1.ParamSpec{Name: name, Type: v1beta1.ParamTypeString})
+		return fmt.Sprintf("$(params.%s)", name)
+	}
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh -e\n")
+	for i, t := range tags {
+		image, tag := addParam(fmt.Sprintf("image-%d", i), t.Image), addParam(fmt.Sprintf("tag-%d", i), t.Tag)
+		fmt.Fprintf(&script, `echo "Tag %s as %s"
+crane tag %s %s
+`, image, tag, image, tag)
+	}
+
+	return v1beta1.PipelineTask{
+		Name:   name,
+		Params: params,
+		WhenExpressions: v1beta1.WhenExpressions{{
+			Input:    "$(" + v1beta1.PipelineTasksAggregateStatus + ")",
+			Operator: selection.In,
+			Values:   []string{"Succeeded", "Completed"},
+		}},
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Params: paramSpecs,
+			Steps: []v1beta1.Step{{
+				Name:       "tag",
+				Image:      image,
+				WorkingDir: "/",
+				Script:     script.String(),
+			}},
+		}},
+	}
+}
+
+// tagUsage returns the usage of the task tagging the images.
+func tagUsage(name string, tags []imageTag) TaskUsage {
+	registries := sets.NewString()
+	for _, t := range tags {
+		registries.Insert(registry(t.Image))
+	}
+	return TaskUsage{Name: name, Pull: registries.List(), Push: registries.List()}
+}
@@ -0,0 +1,139 @@
+package wrap
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	lineageTaskName     = "wrap-lineage"
+	defaultLineageImage = "docker.io/curlimages/curl:8.4.0"
+	lineageProducer     = "https://github.com/openshift-pipelines/tekton-wrap-pipeline"
+)
+
+// lineageOptions holds the settings of the OpenLineage events of the
+// workspace lineage.
+type lineageOptions struct {
+	// URL is the OpenLineage HTTP endpoint, e.g. the one of Marquez. No
+	// event is emitted if empty.
+	URL string
+	// Namespace is the OpenLineage namespace of the jobs, the one of the
+	// PipelineRun by default.
+	Namespace string
+	// Image is the image with curl emitting the events.
+	Image string
+	// APIKeySecret is a Secret holding an OPENLINEAGE_API_KEY, if any.
+	APIKeySecret string
+}
+
+func newLineageOptions(conf map[string]string) lineageOptions {
+	o := lineageOptions{
+		URL:          conf["openlineage-url"],
+		Namespace:    "$(context.pipelineRun.namespace)",
+		Image:        defaultLineageImage,
+		APIKeySecret: conf["openlineage-api-key-secret"],
+	}
+	if namespace, ok := conf["openlineage-namespace"]; ok {
+		o.Namespace = namespace
+	}
+	if image, ok := conf["openlineage-image"]; ok {
+		o.Image = image
+	}
+	return o
+}
+
+func validateHTTPURL(v string) error {
+	u, err := url.ParseRequestURI(v)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an http(s) URL", v)
+	}
+	return nil
+}
+
+// lineageTask returns a finally task emitting an OpenLineage run event per
+// task of the Pipeline importing or exporting reported exports: the inputs
+// are the images its imports extracted, the exports of the latest upstream
+// exporters, and the outputs the images its exports pushed. Datasets are
+// named after the repositories of the images, with their digest as version.
+// The tasks that didn't run are skipped.
+func lineageTask(p *v1beta1.PipelineSpec, exports []reportedExport, o lineageOptions) v1beta1.PipelineTask {
+	var params []v1beta1.Param
+	var paramSpecs []v1beta1.ParamSpec
+	addParam := func(name, value string) string {
+		params = append(params, v1beta1.Param{Name: name, Value: *v1beta1.NewStructuredValues(value)})
+		paramSpecs = append(paramSpecs, v1beta1.ParamSpec{Name: name, Type: v1beta1.ParamTypeString})
+		return fmt.Sprintf("$(params.%s)", name)
+	}
+
+	images := map[string]string{}
+	for i, e := range exports {
+		result := fmt.Sprintf("tasks.%s.results.%s", e.Task, e.Result)
+		images[e.Task+"/"+e.Workspace] = addParam(fmt.Sprintf("export-%d", i), fmt.Sprintf("$(%s.uri)@$(%s.digest)", result, result))
+	}
+
+	var events strings.Builder
+	for i, t := range p.Tasks {
+		var inputs, outputs []string
+		for _, w := range t.Workspaces {
+			if i != 0 {
+				if image, ok := images[latestExporter(p, t.Name, w.Workspace)+"/"+w.Workspace]; ok {
+					inputs = append(inputs, fmt.Sprintf(`$(dataset "%s")`, image))
+				}
+			}
+			if image, ok := images[t.Name+"/"+w.Workspace]; ok {
+				outputs = append(outputs, fmt.Sprintf(`$(dataset "%s")`, image))
+			}
+		}
+		if len(inputs) == 0 && len(outputs) == 0 {
+			continue
+		}
+		status := addParam(fmt.Sprintf("status-%d", i), fmt.Sprintf("$(tasks.%s.status)", t.Name))
+		fmt.Fprintf(&events, "emit %s \"%s\" \"%s\" \"%s\"\n", t.Name, status, strings.Join(inputs, ","), strings.Join(outputs, ","))
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, `#!/bin/sh -e
+dataset() {
+  uri="${1%%@*}"
+  printf '{"namespace":"oci://%%s","name":"%%s","facets":{"version":{"_producer":"%s","_schemaURL":"https://openlineage.io/spec/facets/1-0-0/DatasetVersionDatasetFacet.json","datasetVersion":"%%s"}}}' "${uri%%%%/*}" "${uri#*/}" "${1#*@}"
+}
+emit() {
+  case "$2" in
+  Succeeded) type=COMPLETE ;;
+  Failed) type=FAIL ;;
+  *) return 0 ;;
+  esac
+  echo "Emit the $type event of $1"
+  printf '{"eventType":"%%s","eventTime":"%%s","run":{"runId":"%%s"},"job":{"namespace":"%s","name":"$(context.pipeline.name).%%s"},"inputs":[%%s],"outputs":[%%s],"producer":"%s","schemaURL":"https://openlineage.io/spec/1-0-5/OpenLineage.json#/definitions/RunEvent"}' \
+    "$type" "$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)" "$(cat /proc/sys/kernel/random/uuid)" "$1" "$3" "$4" > /tmp/event.json
+  curl -sSf -X POST -H "Content-Type: application/json" ${OPENLINEAGE_API_KEY:+-H "Authorization: Bearer $OPENLINEAGE_API_KEY"} \
+    --data @/tmp/event.json %s/api/v1/lineage
+}
+%s`, lineageProducer, o.Namespace, lineageProducer, strings.TrimSuffix(o.URL, "/"), events.String())
+
+	step := v1beta1.Step{
+		Name:   "lineage",
+		Image:  o.Image,
+		Script: script.String(),
+	}
+	if o.APIKeySecret != "" {
+		step.EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: o.APIKeySecret}},
+		}}
+	}
+	return v1beta1.PipelineTask{
+		Name:   lineageTaskName,
+		Params: params,
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Params: paramSpecs,
+			Steps:  []v1beta1.Step{step},
+		}},
+	}
+}
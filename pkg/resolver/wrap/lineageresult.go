@@ -0,0 +1,77 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+const (
+	lineageResultTaskName = "wrap-lineage-result"
+	lineageResultName     = "lineage"
+	// lineagePipelineResult is the result of the PipelineRun holding the
+	// lineage, see LineageResultParam.
+	lineagePipelineResult = "wrap-lineage"
+)
+
+// lineageResultTask returns a finally task aggregating the digests the
+// exports of each workspace pushed in a JSON result: per workspace, the
+// exports in the order of the Pipeline, each with the task, the image and
+// its digest, and the parent task whose export it imported, if any. The
+// last entries without children are the final states of the workspace.
+func lineageResultTask(p *v1beta1.PipelineSpec, exports []reportedExport, image string) v1beta1.PipelineTask {
+	var params []v1beta1.Param
+	var paramSpecs []v1beta1.ParamSpec
+	addParam := func(name, value string) string {
+		params = append(params, v1beta1.Param{Name: name, Value: *v1beta1.NewStructuredValues(value)})
+		paramSpecs = append(paramSpecs, v1beta1.ParamSpec{Name: name, Type: v1beta1.ParamTypeString})
+		return fmt.Sprintf("$(params.%s)", name)
+	}
+
+	reported := map[string]bool{}
+	for _, e := range exports {
+		reported[e.Task+"/"+e.Workspace] = true
+	}
+	chains := map[string][]string{}
+	for i, e := range exports {
+		result := fmt.Sprintf("tasks.%s.results.%s", e.Task, e.Result)
+		uri := addParam(fmt.Sprintf("uri-%d", i), fmt.Sprintf("$(%s.uri)", result))
+		digest := addParam(fmt.Sprintf("digest-%d", i), fmt.Sprintf("$(%s.digest)", result))
+		entry := fmt.Sprintf(`{"task":"%s","image":"%s@%s","digest":"%s"`, e.Task, uri, digest, digest)
+		if parent := latestExporter(p, e.Task, e.Workspace); reported[parent+"/"+e.Workspace] {
+			entry += fmt.Sprintf(`,"parent":"%s"`, parent)
+		}
+		chains[e.Workspace] = append(chains[e.Workspace], entry+"}")
+	}
+	workspaces := make([]string, 0, len(chains))
+	for ws := range chains {
+		workspaces = append(workspaces, ws)
+	}
+	sort.Strings(workspaces)
+	var entries []string
+	for _, ws := range workspaces {
+		entries = append(entries, fmt.Sprintf(`"%s":[%s]`, ws, strings.Join(chains[ws], ",")))
+	}
+
+	return v1beta1.PipelineTask{
+		Name:   lineageResultTaskName,
+		Params: params,
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Params: paramSpecs,
+			Results: []v1beta1.TaskResult{{
+				Name:        lineageResultName,
+				Description: "The digests each workspace was exported to by each task, in JSON",
+			}},
+			Steps: []v1beta1.Step{{
+				Name:       "lineage",
+				Image:      image,
+				WorkingDir: "/",
+				Script: fmt.Sprintf(`#!/busybox/sh -e
+printf '%%s' '{"pipelineRun":"$(context.pipelineRun.name)","workspaces":{%s}}' > $(results.%s.path)
+`, strings.Join(entries, ","), lineageResultName),
+			}},
+		}},
+	}
+}
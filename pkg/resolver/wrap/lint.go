@@ -0,0 +1,208 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Severity tells how much a Finding gets in the way of wrapping.
+type Severity int
+
+const (
+	// SeverityInfo findings only cost time or registry space.
+	SeverityInfo Severity = iota
+	// SeverityWarning findings may lose or mix up workspace content.
+	SeverityWarning
+	// SeverityError findings can't be wrapped.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// Finding is a construct of a Pipeline that is incompatible or risky to
+// wrap.
+type Finding struct {
+	Severity Severity
+	// Task is the pipeline task the finding is about, if any.
+	Task    string
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Task == "" {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: task %s: %s", f.Severity, f.Task, f.Message)
+}
+
+// maxWrappedTasks is the number of tasks using the wrapped workspaces above
+// which imports and exports are reported as a significant overhead.
+const maxWrappedTasks = 20
+
+// Lint inspects the Pipeline for the constructs that are incompatible or
+// risky when wrapping the given workspaces, all of them if empty. Findings
+// are sorted by decreasing severity.
+func Lint(p *v1beta1.PipelineSpec, workspaces []string) []Finding {
+	wrapped := sets.NewString(workspaces...)
+	if wrapped.Len() == 0 {
+		for _, w := range p.Workspaces {
+			wrapped.Insert(w.Name)
+		}
+	}
+
+	var findings []Finding
+	dependents := taskDependents(p)
+	var wrappedTasks []v1beta1.PipelineTask
+	for _, t := range p.Tasks {
+		uses := usedWorkspaces(t, wrapped)
+		if uses.Len() == 0 {
+			continue
+		}
+		wrappedTasks = append(wrappedTasks, t)
+		findings = append(findings, lintTask(t, uses)...)
+	}
+
+	// Tasks writing the same workspace concurrently export on top of the
+	// same image, the last export wins.
+	for i, a := range wrappedTasks {
+		for _, b := range wrappedTasks[i+1:] {
+			if ordered(a.Name, b.Name, dependents) {
+				continue
+			}
+			shared := writtenWorkspaces(a, wrapped).Intersection(writtenWorkspaces(b, wrapped))
+			if shared.Len() > 0 {
+				findings = append(findings, Finding{
+					Severity: SeverityError,
+					Task:     b.Name,
+					Message:  fmt.Sprintf("writes workspace(s) %s in parallel with task %s, one of the exports would be lost", strings.Join(shared.List(), ","), a.Name),
+				})
+			}
+		}
+	}
+
+	for _, t := range p.Finally {
+		if uses := usedWorkspaces(t, wrapped); uses.Len() > 0 {
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Task:     t.Name,
+				Message:  fmt.Sprintf("finally tasks aren't wrapped, workspace(s) %s must still be bound", strings.Join(uses.List(), ",")),
+			})
+		}
+	}
+
+	if len(wrappedTasks) > maxWrappedTasks {
+		findings = append(findings, Finding{
+			Severity: SeverityInfo,
+			Message:  fmt.Sprintf("%d tasks use the wrapped workspaces, each of them imports and exports their content", len(wrappedTasks)),
+		})
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+	return findings
+}
+
+func lintTask(t v1beta1.PipelineTask, uses sets.String) []Finding {
+	var findings []Finding
+	add := func(severity Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Task: t.Name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case t.TaskRef != nil && t.TaskRef.APIVersion != "", t.TaskSpec != nil && t.TaskSpec.APIVersion != "":
+		add(SeverityError, "is a custom task, no import or export step can be added to it")
+	case t.TaskRef != nil && t.TaskRef.Resolver != "":
+		add(SeverityError, "references a remote Task, only Tasks of the namespace and of bundles can be wrapped")
+	case t.TaskRef != nil && t.TaskRef.Kind == v1beta1.ClusterTaskKind:
+		add(SeverityError, "references a ClusterTask, only Tasks of the namespace and of bundles can be wrapped")
+	}
+	if t.IsMatrixed() {
+		add(SeverityError, "is matrixed, its TaskRuns would export workspace(s) %s concurrently", strings.Join(uses.List(), ","))
+	}
+	for _, w := range t.Workspaces {
+		if uses.Has(w.Workspace) && w.SubPath != "" {
+			add(SeverityWarning, "binds workspace %s with subPath %s, the whole workspace is imported and exported", w.Workspace, w.SubPath)
+		}
+	}
+	if t.TaskSpec == nil {
+		return findings
+	}
+	for _, s := range t.TaskSpec.Sidecars {
+		for _, w := range t.Workspaces {
+			if !uses.Has(w.Workspace) || !sidecarUses(s, w.Name) {
+				continue
+			}
+			add(SeverityWarning, "sidecar %s uses workspace %s, what it writes after the export step is lost", s.Name, w.Workspace)
+		}
+	}
+	return findings
+}
+
+// usedWorkspaces returns the wrapped workspaces the task binds.
+func usedWorkspaces(t v1beta1.PipelineTask, wrapped sets.String) sets.String {
+	uses := sets.NewString()
+	for _, w := range t.Workspaces {
+		if wrapped.Has(w.Workspace) {
+			uses.Insert(w.Workspace)
+		}
+	}
+	return uses
+}
+
+// writtenWorkspaces returns the wrapped workspaces the task may write to,
+// i.e. not declared read-only.
+func writtenWorkspaces(t v1beta1.PipelineTask, wrapped sets.String) sets.String {
+	readOnly := sets.NewString()
+	if t.TaskSpec != nil {
+		for _, d := range t.TaskSpec.Workspaces {
+			if d.ReadOnly {
+				readOnly.Insert(d.Name)
+			}
+		}
+	}
+	written := sets.NewString()
+	for _, w := range t.Workspaces {
+		if wrapped.Has(w.Workspace) && !readOnly.Has(w.Name) {
+			written.Insert(w.Workspace)
+		}
+	}
+	return written
+}
+
+// ordered tells whether one of the tasks runs after the other.
+func ordered(a, b string, dependents map[string][]string) bool {
+	return sets.NewString(downstreamTasks(a, dependents)...).Has(b) ||
+		sets.NewString(downstreamTasks(b, dependents)...).Has(a)
+}
+
+func sidecarUses(s v1beta1.Sidecar, workspace string) bool {
+	for _, w := range s.Workspaces {
+		if w.Name == workspace {
+			return true
+		}
+	}
+	ref := fmt.Sprintf("$(workspaces.%s.", workspace)
+	if strings.Contains(s.Script, ref) {
+		return true
+	}
+	for _, arg := range append(append([]string{}, s.Command...), s.Args...) {
+		if strings.Contains(arg, ref) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+const (
+	manifestTaskName   = "wrap-manifest"
+	manifestResultName = "manifest"
+	// manifestResult is the WorkspaceManifestParam value only emitting
+	// the manifest as a PipelineRun result.
+	manifestResult = "result"
+)
+
+// reportedExport is an export reporting the pushed image in a result of
+// its task.
+type reportedExport struct {
+	Task      string
+	Workspace string
+	Result    string
+}
+
+// manifestTask returns a finally task aggregating the images pushed by
+// the exports in a JSON manifest, emitted as a result and pushed to
+// target unless it is manifestResult.
+func manifestTask(exports []reportedExport, target, image string) v1beta1.PipelineTask {
+	var params []v1beta1.Param
+	var paramSpecs []v1beta1.ParamSpec
+	var entries []string
+	for i, e := range exports {
+		name := fmt.Sprintf("export-%d", i)
+		result := fmt.Sprintf("tasks.%s.results.%s", e.Task, e.Result)
+		params = append(params, v1beta1.Param{
+			Name:  name,
+			Value: *v1beta1.NewStructuredValues(fmt.Sprintf("$(%s.uri)@$(%s.digest)", result, result)),
+		})
+		paramSpecs = append(paramSpecs, v1beta1.ParamSpec{Name: name, Type: v1beta1.ParamTypeString})
+		entries = append(entries, fmt.Sprintf(`{"task":"%s","workspace":"%s","image":"$(params.%s)"}`, e.Task, e.Workspace, name))
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh -e\n")
+	fmt.Fprintf(&script, `printf '%%s' '{"pipelineRun":"$(context.pipelineRun.name)","exports":[%s]}' > $(results.%s.path)
+`, strings.Join(entries, ","), manifestResultName)
+	if target != manifestResult {
+		fmt.Fprintf(&script, `mkdir -p /tmp/manifest
+cp $(results.%s.path) /tmp/manifest/manifest.json
+echo "Push the workspace manifest to %s"
+(cd /tmp/manifest && tar -f - -c manifest.json | crane append -t %s -f -)
+`, manifestResultName, target, target)
+	}
+
+	return v1beta1.PipelineTask{
+		Name:   manifestTaskName,
+		Params: params,
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Params: paramSpecs,
+			Results: []v1beta1.TaskResult{{
+				Name:        manifestResultName,
+				Description: "The images the workspaces were exported to by each task, in JSON",
+			}},
+			Steps: []v1beta1.Step{{
+				Name:       "manifest",
+				Image:      image,
+				WorkingDir: "/",
+				Script:     script.String(),
+			}},
+		}},
+	}
+}
@@ -0,0 +1,114 @@
+package wrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MetadataVersion is the version of the schema of the annotations the
+// resolver stamps on the wrapped Pipeline and the resolved resource, see
+// MetadataVersionAnnotation.
+//
+// Within a version, annotations and fields are only added: none is
+// removed, renamed or given another type, so that consumers keep parsing
+// the output of later releases, ignoring what they don't know. A release
+// breaking this bumps the version.
+const MetadataVersion = "v1"
+
+// Metadata are the annotations of a wrapped Pipeline, or of its resolved
+// resource, decoded. The fields whose annotation isn't set are left empty,
+// e.g. Effective and Original on the wrapped Pipeline, which only the
+// resolved resource holds.
+type Metadata struct {
+	// Version is the MetadataVersion of the annotations.
+	Version string
+	// PipelineRef is the requested Pipeline.
+	PipelineRef string
+	// TaskRef is the requested Task, instead of PipelineRef.
+	TaskRef string
+	// TraceID is the TraceIDAnnotation.
+	TraceID string
+	// APIFields is the APIFieldsAnnotation.
+	APIFields string
+	// TektonVersion is the TektonVersionAnnotation.
+	TektonVersion string
+	// Cached is the CachedAnnotation.
+	Cached string
+	// StrippedWorkspaces is the StrippedWorkspacesAnnotation.
+	StrippedWorkspaces []string
+	// UnreadExports is the UnreadExportsAnnotation.
+	UnreadExports []string
+	// ExportReaders is the ExportReadersAnnotation.
+	ExportReaders map[string][]string
+	// InjectedSteps is the InjectedStepsAnnotation.
+	InjectedSteps map[string][]InjectedStep
+	// Usage is the UsageAnnotation.
+	Usage *Usage
+	// Warnings is the WarningsAnnotation.
+	Warnings []string
+	// StorageEstimate is the StorageEstimateAnnotation.
+	StorageEstimate *StorageEstimate
+	// Effective is the EffectiveParamsAnnotation.
+	Effective *EffectiveSettings
+	// Original is the OriginalAnnotation, with the
+	// OriginalContentAnnotation as its Content.
+	Original *OriginalPipeline
+	// ImageDrifts is the ImageDriftAnnotation.
+	ImageDrifts []ImageDrift
+	// DryRunSummary is the DryRunSummaryAnnotation.
+	DryRunSummary *WrapSummary
+}
+
+// ParseMetadata decodes the annotations of a wrapped Pipeline, or of its
+// resolved resource. The annotations stamped before MetadataVersionAnnotation
+// existed are of the first version; the ones of another version fail, their
+// fields possibly meaning something else.
+func ParseMetadata(annotations map[string]string) (*Metadata, error) {
+	m := &Metadata{
+		Version:       annotations[MetadataVersionAnnotation],
+		PipelineRef:   annotations["PipelineRef"],
+		TaskRef:       annotations["TaskRef"],
+		TraceID:       annotations[TraceIDAnnotation],
+		APIFields:     annotations[APIFieldsAnnotation],
+		TektonVersion: annotations[TektonVersionAnnotation],
+		Cached:        annotations[CachedAnnotation],
+	}
+	if m.Version == "" {
+		m.Version = MetadataVersion
+	}
+	if m.Version != MetadataVersion {
+		return nil, fmt.Errorf("unsupported %s %q, only %q is", MetadataVersionAnnotation, m.Version, MetadataVersion)
+	}
+	if v := annotations[StrippedWorkspacesAnnotation]; v != "" {
+		m.StrippedWorkspaces = strings.Split(v, ",")
+	}
+	if v := annotations[UnreadExportsAnnotation]; v != "" {
+		m.UnreadExports = strings.Split(v, ",")
+	}
+	for annotation, v := range map[string]interface{}{
+		ExportReadersAnnotation:   &m.ExportReaders,
+		InjectedStepsAnnotation:   &m.InjectedSteps,
+		UsageAnnotation:           &m.Usage,
+		WarningsAnnotation:        &m.Warnings,
+		StorageEstimateAnnotation: &m.StorageEstimate,
+		EffectiveParamsAnnotation: &m.Effective,
+		OriginalAnnotation:        &m.Original,
+		ImageDriftAnnotation:      &m.ImageDrifts,
+		DryRunSummaryAnnotation:   &m.DryRunSummary,
+	} {
+		data, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", annotation, err)
+		}
+	}
+	if m.Original != nil {
+		if content, ok := annotations[OriginalContentAnnotation]; ok {
+			m.Original.Content = []byte(content)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,131 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Migration is the proposed replacement of a PipelineRun binding
+// volumeClaimTemplate workspaces, running its Pipeline through the
+// resolver instead.
+type Migration struct {
+	// PipelineRun is the name of the PipelineRun the proposal is made from.
+	PipelineRun string
+	// Pipeline is the name of the Pipeline it runs.
+	Pipeline string
+	// Workspaces are the workspaces to wrap, the ones bound with a
+	// volumeClaimTemplate.
+	Workspaces []string
+	// Findings are the ones of Lint for these workspaces.
+	Findings []Finding
+	// Replacement is the PipelineRun to create instead.
+	Replacement *v1beta1.PipelineRun
+}
+
+// ProposeMigration returns the proposed replacement of the PipelineRun of
+// the Pipeline p it references, wrapping its volumeClaimTemplate workspaces and pushing them
+// to target, in which {{pipeline}} is replaced by the name of the Pipeline.
+// It returns nil if the PipelineRun binds no volumeClaimTemplate workspace.
+// Only PipelineRuns referencing a Pipeline of their namespace by name can
+// be migrated, as the resolver fetches it the same way.
+func ProposeMigration(pr *v1beta1.PipelineRun, p *v1beta1.Pipeline, target string) (*Migration, error) {
+	claimed := sets.NewString()
+	for _, w := range pr.Spec.Workspaces {
+		if w.VolumeClaimTemplate != nil {
+			claimed.Insert(w.Name)
+		}
+	}
+	if claimed.Len() == 0 {
+		return nil, nil
+	}
+	ref := pr.Spec.PipelineRef
+	if ref == nil || ref.Name == "" || ref.Bundle != "" {
+		return nil, fmt.Errorf("PipelineRun %s doesn't reference a Pipeline of its namespace by name", pr.Name)
+	}
+
+	m := &Migration{
+		PipelineRun: pr.Name,
+		Pipeline:    p.Name,
+		Workspaces:  claimed.List(),
+	}
+	m.Findings = Lint(&p.Spec, m.Workspaces)
+
+	newRun := &v1beta1.PipelineRun{
+		TypeMeta: metav1.TypeMeta{APIVersion: "tekton.dev/v1beta1", Kind: "PipelineRun"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: p.Name + "-",
+			Namespace:    pr.Namespace,
+			Labels:       unmanaged(pr.Labels),
+			Annotations:  unmanaged(pr.Annotations),
+		},
+		Spec: *pr.Spec.DeepCopy(),
+	}
+	newRun.Spec.Status = ""
+	newRun.Spec.PipelineRef = &v1beta1.PipelineRef{
+		ResolverRef: v1beta1.ResolverRef{
+			Resolver: "wrap",
+			Params: []v1beta1.Param{
+				{Name: PipelineRefParam, Value: *v1beta1.NewStructuredValues(p.Name)},
+				{Name: WorkspacesParam, Value: *v1beta1.NewStructuredValues(strings.Join(m.Workspaces, ","))},
+				{Name: TargetParam, Value: *v1beta1.NewStructuredValues(strings.ReplaceAll(target, "{{pipeline}}", p.Name))},
+			},
+		},
+	}
+	for i, w := range newRun.Spec.Workspaces {
+		if claimed.Has(w.Name) {
+			newRun.Spec.Workspaces[i] = v1beta1.WorkspaceBinding{Name: w.Name, EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		}
+	}
+	m.Replacement = newRun
+	return m, nil
+}
+
+// unmanaged returns the labels or annotations of a PipelineRun without the
+// ones set by Tekton and kubectl.
+func unmanaged(labels map[string]string) map[string]string {
+	var kept map[string]string
+	for k, v := range labels {
+		if strings.HasPrefix(k, "tekton.dev/") || strings.HasPrefix(k, "kubectl.kubernetes.io/") {
+			continue
+		}
+		if kept == nil {
+			kept = map[string]string{}
+		}
+		kept[k] = v
+	}
+	return kept
+}
+
+// LatestRuns returns the most recent PipelineRun of each Pipeline, sorted by
+// namespace and Pipeline name. PipelineRuns without a Pipeline name (e.g.
+// embedding their Pipeline) are kept apart, each one as is.
+func LatestRuns(runs []v1beta1.PipelineRun) []v1beta1.PipelineRun {
+	latest := map[string]v1beta1.PipelineRun{}
+	var others []v1beta1.PipelineRun
+	for _, pr := range runs {
+		if pr.Spec.PipelineRef == nil || pr.Spec.PipelineRef.Name == "" {
+			others = append(others, pr)
+			continue
+		}
+		key := pr.Namespace + "/" + pr.Spec.PipelineRef.Name
+		if current, ok := latest[key]; !ok || current.CreationTimestamp.Before(&pr.CreationTimestamp) {
+			latest[key] = pr
+		}
+	}
+	keys := make([]string, 0, len(latest))
+	for k := range latest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var result []v1beta1.PipelineRun
+	for _, k := range keys {
+		result = append(result, latest[k])
+	}
+	return append(result, others...)
+}
@@ -0,0 +1,64 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// PipelineMutator mutates a Pipeline in place, e.g. the wrap mutation, or
+// one injecting security sidecars. Mutators compose in a Chain, so tools
+// don't each re-implement the traversal of the Pipeline.
+type PipelineMutator interface {
+	Mutate(ctx context.Context, p *v1beta1.Pipeline) error
+}
+
+// PipelineMutatorFunc is a function implementing PipelineMutator.
+type PipelineMutatorFunc func(ctx context.Context, p *v1beta1.Pipeline) error
+
+// Mutate calls f.
+func (f PipelineMutatorFunc) Mutate(ctx context.Context, p *v1beta1.Pipeline) error {
+	return f(ctx, p)
+}
+
+// Chain is a PipelineMutator applying its mutators in order. It stops at
+// the first error.
+type Chain []PipelineMutator
+
+// Mutate applies the mutators of the chain in order.
+func (c Chain) Mutate(ctx context.Context, p *v1beta1.Pipeline) error {
+	for i, m := range c {
+		if err := m.Mutate(ctx, p); err != nil {
+			return fmt.Errorf("mutator %d (%T) failed: %w", i, m, err)
+		}
+	}
+	return nil
+}
+
+// Mutator returns the wrap mutation with the params, completed with the
+// defaults of the resolver configuration of ctx, to compose it with other
+// mutators. Referenced Tasks are fetched with the clients of the resolver,
+// from the namespace of ctx (see common.InjectRequestNamespace).
+func (r *Resolver) Mutator(params map[string]string) PipelineMutator {
+	return PipelineMutatorFunc(func(ctx context.Context, p *v1beta1.Pipeline) error {
+		if _, ok := params[PipelineRefParam]; !ok {
+			// The Pipeline is given rather than referenced
+			params[PipelineRefParam] = p.Name
+		}
+		ctx, err := r.withClusterEnvironment(ctx)
+		if err != nil {
+			return err
+		}
+		params, err := populateParamsWithDefaults(ctx, params)
+		if err != nil {
+			return err
+		}
+		wrapped, _, err := r.wrap(ctx, params, p)
+		if err != nil {
+			return err
+		}
+		*p = *wrapped
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+package wrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// exportOnFailure holds the tasks, as "<task>", and the workspaces of
+// tasks, as "<task>/<workspace>", exported even when a step of the task
+// failed. "*" matches every task.
+type exportOnFailure sets.String
+
+// parseExportOnFailure parses the value of ExportOnFailureParam: a boolean
+// for all tasks, or a comma separated list of tasks and task workspaces.
+func parseExportOnFailure(value string) (exportOnFailure, error) {
+	if value == "" {
+		return exportOnFailure(sets.NewString()), nil
+	}
+	if all, err := strconv.ParseBool(value); err == nil {
+		if all {
+			return exportOnFailure(sets.NewString("*")), nil
+		}
+		return exportOnFailure(sets.NewString()), nil
+	}
+	e := sets.NewString()
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.Count(part, "/") > 1 {
+			return nil, fmt.Errorf("%q is neither a task nor a task/workspace", part)
+		}
+		e.Insert(part)
+	}
+	return exportOnFailure(e), nil
+}
+
+func (e exportOnFailure) matches(task, workspace string) bool {
+	return sets.String(e).HasAny("*", task, task+"/"+workspace)
+}
+
+// continueOnError lets the steps of the TaskSpec continue on error, so
+// that the export steps run after a failed step, and returns the names of
+// the steps that stopped on error, to check their exit code afterwards
+// with checkStepsStep. Unnamed steps are named.
+func continueOnError(s *v1beta1.TaskSpec) []string {
+	var names []string
+	for i := range s.Steps {
+		if s.Steps[i].OnError == v1beta1.Continue {
+			continue
+		}
+		if s.Steps[i].Name == "" {
+			s.Steps[i].Name = fmt.Sprintf("unnamed-%d", i)
+		}
+		s.Steps[i].OnError = v1beta1.Continue
+		names = append(names, s.Steps[i].Name)
+	}
+	return names
+}
+
+// checkStepsStep returns a step failing with the exit code of the first
+// failed step among steps, so that the task still fails once the
+// workspaces are exported.
+func checkStepsStep(steps []string, image string) v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh -e\n")
+	for _, name := range steps {
+		fmt.Fprintf(&script, `code=$(cat $(steps.step-%s.exitCode.path))
+if [ "${code}" != 0 ]; then
+  echo "Step %s failed with exit code ${code}"
+  exit "${code}"
+fi
+`, name, name)
+	}
+	return v1beta1.Step{
+		Name:       "check-steps",
+		Image:      image,
+		WorkingDir: "/",
+		Script:     script.String(),
+	}
+}
+
+// snapshotSteps renames the export steps running whatever the outcome of
+// the task, to tell them apart from the ones running on success.
+func snapshotSteps(steps []v1beta1.Step) []v1beta1.Step {
+	for i := range steps {
+		steps[i].Name = "snapshot-" + steps[i].Name
+	}
+	return steps
+}
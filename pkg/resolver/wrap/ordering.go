@@ -0,0 +1,78 @@
+package wrap
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// importKey identifies the images the imports of a task pull, "" if none.
+func importKey(transfers []workspaceTransfer) string {
+	var sources []string
+	for _, t := range byWrapper(transfers)[OCIWrapper] {
+		sources = append(sources, t.Source)
+	}
+	sort.Strings(sources)
+	return strings.Join(sources, ",")
+}
+
+// orderTasks reorders the tasks of the Pipeline, given the importKey of
+// each task, so that the tasks importing the same images are listed
+// consecutively where the DAG allows. Tekton creates the TaskRuns of the
+// tasks ready to run in the order of the Pipeline, so their pods are then
+// scheduled one after the other, while the images (or the layer cache) the
+// first one pulled are still on the node. The order is otherwise kept: a
+// task is only moved after tasks it doesn't depend on, and before tasks
+// not depending on it.
+func orderTasks(p *v1beta1.PipelineSpec, imports map[string]string) {
+	names := map[string]bool{}
+	for _, t := range p.Tasks {
+		names[t.Name] = true
+	}
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for _, t := range p.Tasks {
+		for _, dep := range t.Deps() {
+			// Finally tasks and unknown tasks are Pipeline validation errors
+			if !names[dep] {
+				continue
+			}
+			pending[t.Name]++
+			dependents[dep] = append(dependents[dep], t.Name)
+		}
+	}
+
+	ordered := make([]v1beta1.PipelineTask, 0, len(p.Tasks))
+	placed := map[string]bool{}
+	last := ""
+	for len(ordered) < len(p.Tasks) {
+		next := -1
+		for i, t := range p.Tasks {
+			if placed[t.Name] || pending[t.Name] > 0 {
+				continue
+			}
+			if next == -1 {
+				next = i
+			}
+			if last != "" && imports[t.Name] == last {
+				next = i
+				break
+			}
+		}
+		// Cycles are Pipeline validation errors, keep the order as is
+		if next == -1 {
+			return
+		}
+		t := p.Tasks[next]
+		placed[t.Name] = true
+		for _, d := range dependents[t.Name] {
+			pending[d]--
+		}
+		if imports[t.Name] != "" {
+			last = imports[t.Name]
+		}
+		ordered = append(ordered, t)
+	}
+	p.Tasks = ordered
+}
@@ -0,0 +1,48 @@
+package wrap
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// OriginalPipeline locates the Pipeline a resolution wrapped, as fetched,
+// see OriginalAnnotation.
+type OriginalPipeline struct {
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	UID             string `json:"uid,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Digest is the sha256 digest of Content.
+	Digest string `json:"digest"`
+	// Content is the Pipeline as served by the API server.
+	Content []byte `json:"-"`
+}
+
+// newOriginalPipeline returns the location of the Pipeline fetched as data,
+// before any mutation.
+func newOriginalPipeline(p *v1beta1.Pipeline, data []byte) *OriginalPipeline {
+	return &OriginalPipeline{
+		Namespace:       p.Namespace,
+		Name:            p.Name,
+		UID:             string(p.UID),
+		ResourceVersion: p.ResourceVersion,
+		Digest:          fmt.Sprintf("sha256:%x", sha256.Sum256(data)),
+		Content:         data,
+	}
+}
+
+// annotations returns the annotations of the resolved resource recording
+// the original Pipeline, with its content if kept.
+func (o *OriginalPipeline) annotations(keep bool) map[string]string {
+	annotations := map[string]string{}
+	if b, err := json.Marshal(o); err == nil {
+		annotations[OriginalAnnotation] = string(b)
+	}
+	if keep {
+		annotations[OriginalContentAnnotation] = string(o.Content)
+	}
+	return annotations
+}
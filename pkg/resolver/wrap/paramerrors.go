@@ -0,0 +1,48 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParamsError is returned when the params of a request are missing or
+// invalid. It lists all of them, so that the status of the
+// ResolutionRequest tells everything to fix at once.
+type ParamsError struct {
+	// Missing are the names of the required params not set, nor defaulted
+	// by the resolver configuration.
+	Missing []string
+	// Invalid are the errors of the params with invalid values.
+	Invalid []error
+}
+
+var _ error = &ParamsError{}
+
+func (e *ParamsError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, fmt.Sprintf("missing required param(s): %s", strings.Join(e.Missing, ", ")))
+	}
+	for _, err := range e.Invalid {
+		parts = append(parts, err.Error())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// missing records the required param name as missing.
+func (e *ParamsError) missing(name string) {
+	e.Missing = append(e.Missing, name)
+}
+
+// invalid records the error of an invalid param.
+func (e *ParamsError) invalid(err error) {
+	e.Invalid = append(e.Invalid, err)
+}
+
+// orNil returns e if any param is missing or invalid, nil otherwise.
+func (e *ParamsError) orNil() error {
+	if len(e.Missing) == 0 && len(e.Invalid) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,105 @@
+package wrap
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+const (
+	// partialResolutionTTL is how long the tasks resolved by an
+	// unfinished resolution are kept for the next request.
+	partialResolutionTTL = 30 * time.Minute
+	// maxPartialResolutions is how many unfinished resolutions are kept,
+	// the oldest ones are dropped first.
+	maxPartialResolutions = 64
+)
+
+// partialResolutions keeps the tasks resolved for the Pipelines whose
+// resolution didn't finish, e.g. because it timed out, so that the next
+// request for the same Pipeline resumes from there instead of fetching all
+// the tasks again. Entries are keyed by the resourceVersion of the
+// Pipeline, or the digest of its tasks if it has none (e.g. inline), so
+// that a change of the Pipeline starts over. The zero value is ready to
+// use.
+type partialResolutions struct {
+	mu      sync.Mutex
+	entries map[string]*partialResolution
+}
+
+type partialResolution struct {
+	tasks   map[string]*v1beta1.Task
+	updated time.Time
+}
+
+func partialResolutionKey(p *v1beta1.Pipeline) string {
+	if p.ResourceVersion == "" {
+		data, _ := json.Marshal(p.Spec.Tasks)
+		return fmt.Sprintf("%s/%s@sha256:%x", p.Namespace, p.Name, sha256.Sum256(data))
+	}
+	return fmt.Sprintf("%s/%s@%s", p.Namespace, p.Name, p.ResourceVersion)
+}
+
+// get returns a copy of the tasks already resolved for the Pipeline, by
+// pipeline task.
+func (c *partialResolutions) get(p *v1beta1.Pipeline) map[string]*v1beta1.Task {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	tasks := map[string]*v1beta1.Task{}
+	if e, ok := c.entries[partialResolutionKey(p)]; ok {
+		for name, t := range e.tasks {
+			tasks[name] = t.DeepCopy()
+		}
+	}
+	return tasks
+}
+
+// add records a task resolved for the Pipeline.
+func (c *partialResolutions) add(p *v1beta1.Pipeline, task string, t *v1beta1.Task) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*partialResolution{}
+	}
+	key := partialResolutionKey(p)
+	e, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= maxPartialResolutions {
+			c.evictOldest()
+		}
+		e = &partialResolution{tasks: map[string]*v1beta1.Task{}}
+		c.entries[key] = e
+	}
+	e.tasks[task] = t.DeepCopy()
+	e.updated = time.Now()
+}
+
+// done drops the tasks of the Pipeline once its resolution finished.
+func (c *partialResolutions) done(p *v1beta1.Pipeline) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, partialResolutionKey(p))
+}
+
+func (c *partialResolutions) expire() {
+	for key, e := range c.entries {
+		if time.Since(e.updated) > partialResolutionTTL {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *partialResolutions) evictOldest() {
+	var oldest string
+	for key, e := range c.entries {
+		if oldest == "" || e.updated.Before(c.entries[oldest].updated) {
+			oldest = key
+		}
+	}
+	delete(c.entries, oldest)
+}
@@ -0,0 +1,51 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// PipelineYAMLParam is the Pipeline to wrap, in YAML or JSON, instead of
+// fetching it, e.g. for Pipelines generated by CI and stored nowhere:
+// either a PipelineSpec, or a whole tekton.dev/v1 or tekton.dev/v1beta1
+// Pipeline. pipelineref names it if set, else the name of the whole
+// Pipeline, or inlinePipelineName.
+const PipelineYAMLParam = "pipeline-yaml"
+
+// inlinePipelineName is the name of the PipelineSpecs of PipelineYAMLParam
+// without a pipelineref.
+const inlinePipelineName = "inline"
+
+// inlinePipeline decodes the Pipeline of PipelineYAMLParam as the Pipeline
+// name of the namespace, name defaulting as documented for the param. Its
+// tasks are still fetched from the task-source.
+func inlinePipeline(ctx context.Context, namespace, name, content string) (*v1beta1.Pipeline, error) {
+	meta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal([]byte(content), &meta); err != nil {
+		return nil, fmt.Errorf("not a Pipeline nor a PipelineSpec: %v", err)
+	}
+	p := &v1beta1.Pipeline{}
+	if meta.Kind == "" && meta.APIVersion == "" {
+		if err := decodeStrict("PipelineSpec", PipelineYAMLParam, []byte(content), &p.Spec); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if p, err = decodePipeline(ctx, PipelineYAMLParam, []byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	if name == "" {
+		name = p.Name
+	}
+	if name == "" {
+		name = inlinePipelineName
+	}
+	p.TypeMeta = metav1.TypeMeta{APIVersion: APIVersionV1beta1, Kind: "Pipeline"}
+	p.Name, p.Namespace = name, namespace
+	return p, nil
+}
@@ -0,0 +1,61 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInlinePipeline(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		param    string
+		content  string
+		wantName string
+		wantErr  bool
+	}{{
+		name:     "spec",
+		content:  "tasks:\n- name: build\n  taskRef:\n    name: build\n",
+		wantName: inlinePipelineName,
+	}, {
+		name:     "spec named by pipelineref",
+		param:    "generated",
+		content:  "tasks:\n- name: build\n  taskRef:\n    name: build\n",
+		wantName: "generated",
+	}, {
+		name:     "v1beta1 pipeline",
+		content:  "apiVersion: tekton.dev/v1beta1\nkind: Pipeline\nmetadata:\n  name: build\nspec:\n  tasks:\n  - name: build\n    taskRef:\n      name: build\n",
+		wantName: "build",
+	}, {
+		name:     "v1 pipeline",
+		content:  `{"apiVersion": "tekton.dev/v1", "kind": "Pipeline", "metadata": {"name": "build"}, "spec": {"tasks": [{"name": "build", "taskRef": {"name": "build"}}]}}`,
+		wantName: "build",
+	}, {
+		name:    "unknown field",
+		content: "tasks:\n- name: build\n  taskRef:\n    name: build\n  other: true\n",
+		wantErr: true,
+	}, {
+		name:    "task",
+		content: "apiVersion: tekton.dev/v1beta1\nkind: Task\nmetadata:\n  name: build\n",
+		wantErr: true,
+	}, {
+		name:    "invalid",
+		content: "[",
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := inlinePipeline(context.Background(), "ci", tc.param, tc.content)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("inlinePipeline() = %v, want an error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("inlinePipeline() = %v", err)
+			}
+			if p.Name != tc.wantName || p.Namespace != "ci" || len(p.Spec.Tasks) != 1 || p.Spec.Tasks[0].TaskRef.Name != "build" {
+				t.Errorf("inlinePipeline() = %s/%s %+v, want ci/%s with the build task", p.Namespace, p.Name, p.Spec, tc.wantName)
+			}
+		})
+	}
+}
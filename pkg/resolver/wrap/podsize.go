@@ -0,0 +1,109 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/logging"
+)
+
+// defaultMaxPodContainers is the number of containers of a wrapped task
+// above which its pod is reported as oversized, unless max-pod-containers
+// is set in the resolver configuration.
+const defaultMaxPodContainers = 20
+
+// podLimits are the sizes of the pods of the wrapped tasks above which they
+// are reported, as each injected step adds a container to the pod.
+type podLimits struct {
+	// Containers is the maximum number of steps and sidecars, no limit if
+	// 0.
+	Containers int
+	// Requests are the maximum total resource requests of the steps and
+	// sidecars, which Kubernetes sums, e.g. cpu and memory.
+	Requests corev1.ResourceList
+}
+
+func newPodLimits(conf map[string]string) podLimits {
+	l := podLimits{Containers: defaultMaxPodContainers}
+	if v, ok := conf["max-pod-containers"]; ok {
+		l.Containers, _ = strconv.Atoi(v)
+	}
+	l.Requests, _ = parseResourceList(conf["max-pod-requests"])
+	return l
+}
+
+// parseResourceList parses a comma separated list of <resource>=<quantity>,
+// e.g. cpu=4,memory=8Gi.
+func parseResourceList(value string) (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	if value == "" {
+		return list, nil
+	}
+	for _, s := range strings.Split(value, ",") {
+		name, quantity, ok := strings.Cut(strings.TrimSpace(s), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not <resource>=<quantity>", s)
+		}
+		q, err := resource.ParseQuantity(quantity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity of %s: %v", name, err)
+		}
+		list[corev1.ResourceName(name)] = q
+	}
+	return list, nil
+}
+
+// check returns the warnings about the pod of the wrapped task, given the
+// number of injected steps.
+func (l podLimits) check(task string, s *v1beta1.TaskSpec, injected int) []string {
+	var warnings []string
+	if containers := len(s.Steps) + len(s.Sidecars); l.Containers > 0 && containers > l.Containers && injected > 0 {
+		warnings = append(warnings, fmt.Sprintf("task %s runs %d containers, %d of them injected, above the %d of max-pod-containers, its pod may not be scheduled: wrap fewer of its workspaces", task, containers, injected, l.Containers))
+	}
+	if len(l.Requests) == 0 {
+		return warnings
+	}
+	total := corev1.ResourceList{}
+	add := func(requests corev1.ResourceList) {
+		for name, q := range requests {
+			sum := total[name]
+			sum.Add(q)
+			total[name] = sum
+		}
+	}
+	for _, step := range s.Steps {
+		add(step.Resources.Requests)
+	}
+	for _, sidecar := range s.Sidecars {
+		add(sidecar.Resources.Requests)
+	}
+	names := make([]string, 0, len(l.Requests))
+	for name := range l.Requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit, requested := l.Requests[corev1.ResourceName(name)], total[corev1.ResourceName(name)]
+		if requested.Cmp(limit) > 0 {
+			warnings = append(warnings, fmt.Sprintf("task %s requests %s of %s, above the %s of max-pod-requests", task, requested.String(), name, limit.String()))
+		}
+	}
+	return warnings
+}
+
+// warn logs the warnings and, if there is a recorder, records them as
+// events on the Pipeline.
+func (r *Resolver) warn(ctx context.Context, p *v1beta1.Pipeline, reason string, warnings []string) {
+	for _, w := range warnings {
+		logging.FromContext(ctx).Warn(w)
+		if r.recorder != nil {
+			r.recorder.Event(pipelineObjectRef(p), corev1.EventTypeWarning, reason, w)
+		}
+	}
+}
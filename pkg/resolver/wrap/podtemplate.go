@@ -0,0 +1,87 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/config"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// parseStepSecurityContext parses the step-security-context of the resolver
+// configuration, a SecurityContext in YAML, if any.
+func parseStepSecurityContext(v string) (*corev1.SecurityContext, error) {
+	if v == "" {
+		return nil, nil
+	}
+	sc := &corev1.SecurityContext{}
+	if err := yaml.UnmarshalStrict([]byte(v), sc); err != nil {
+		return nil, fmt.Errorf("%q is not a SecurityContext: %v", v, err)
+	}
+	return sc, nil
+}
+
+// withSecurityContext sets the step-security-context on the injected steps,
+// e.g. so that they run as non-root like the pod template requires. The
+// steps of the wrapper templates keep their own securityContext.
+func (o stepOptions) withSecurityContext(steps []v1beta1.Step) []v1beta1.Step {
+	if o.SecurityContext == nil {
+		return steps
+	}
+	for i := range steps {
+		if steps[i].SecurityContext != nil {
+			continue
+		}
+		steps[i].SecurityContext = o.SecurityContext.DeepCopy()
+	}
+	return steps
+}
+
+// checkPodTemplate returns warnings about the constraints of the pod
+// template the injected steps can't run under.
+func checkPodTemplate(tpl *pod.Template, sc *corev1.SecurityContext) []string {
+	if tpl == nil {
+		return nil
+	}
+	var warnings []string
+	if psc := tpl.SecurityContext; psc != nil && psc.RunAsNonRoot != nil && *psc.RunAsNonRoot &&
+		(sc == nil || sc.RunAsUser == nil || *sc.RunAsUser == 0) &&
+		(psc.RunAsUser == nil || *psc.RunAsUser == 0) {
+		warnings = append(warnings, "the pod template requires non-root containers but the crane and aws-cli images of the injected steps run as root, set runAsUser in step-security-context")
+	}
+	if os, ok := tpl.NodeSelector[corev1.LabelOSStable]; ok && os != "linux" {
+		warnings = append(warnings, fmt.Sprintf("the pod template selects %s nodes but the images of the injected steps are only available for linux", os))
+	}
+	if arch, ok := tpl.NodeSelector[corev1.LabelArchStable]; ok && arch != "amd64" && arch != "arm64" {
+		warnings = append(warnings, fmt.Sprintf("the pod template selects %s nodes but the images of the injected steps are only available for amd64 and arm64", arch))
+	}
+	return warnings
+}
+
+// checkDefaultPodTemplate returns warnings about the default pod template of
+// the cluster, read from the config-defaults ConfigMap of the
+// feature-flags-namespace, given the resolver configuration.
+func checkDefaultPodTemplate(ctx context.Context, kubeClient kubernetes.Interface, conf map[string]string) ([]string, error) {
+	namespace := conf["feature-flags-namespace"]
+	if namespace == "" {
+		namespace = defaultFeatureFlagsNamespace
+	}
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetDefaultsConfigName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := config.NewDefaultsFromConfigMap(cm)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := parseStepSecurityContext(conf["step-security-context"])
+	if err != nil {
+		return nil, err
+	}
+	return checkPodTemplate(defaults.DefaultPodTemplate, sc), nil
+}
@@ -0,0 +1,174 @@
+package wrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// PostProcessNamespaceEnv is the variable of the post-process-command,
+	// and PostProcessNamespaceHeader the header of the requests to the
+	// post-process-url, holding the namespace of the request.
+	PostProcessNamespaceEnv    = "WRAP_NAMESPACE"
+	PostProcessNamespaceHeader = "X-Wrap-Namespace"
+	// PostProcessTraceIDHeader holds the trace ID of the resolution, see
+	// TraceIDAnnotation. The post-process-command gets it in TraceIDEnv.
+	PostProcessTraceIDHeader = "X-Wrap-Trace-Id"
+
+	defaultPostProcessTimeout = 10 * time.Second
+)
+
+// postProcess runs the hooks of the resolver configuration on the wrapped
+// Pipeline, see postProcessHooks.
+func postProcess(ctx context.Context, conf map[string]string, p *v1beta1.Pipeline) error {
+	for _, h := range postProcessHooks(conf) {
+		if err := h.Mutate(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postProcessHooks returns the hooks of the resolver configuration,
+// post-processing the wrapped Pipelines after the After chain, e.g. to
+// inject the sidecars an organization mandates, without forking the
+// resolver: the post-process-command, then the post-process-url. Each
+// gets the wrapped Pipeline, as tekton.dev/v1beta1 JSON, and returns it
+// post-processed, in YAML or JSON, or nothing to leave it unchanged.
+func postProcessHooks(conf map[string]string) []PipelineMutator {
+	timeout := defaultPostProcessTimeout
+	if v, ok := conf["post-process-timeout"]; ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			timeout = d
+		}
+	}
+	var hooks []PipelineMutator
+	if v := conf["post-process-command"]; v != "" {
+		hooks = append(hooks, &commandHook{args: strings.Fields(v), timeout: timeout})
+	}
+	if v := conf["post-process-url"]; v != "" {
+		hooks = append(hooks, &webhookHook{url: v, timeout: timeout, client: http.DefaultClient})
+	}
+	return hooks
+}
+
+// commandHook runs a command of the controller image (or of a volume
+// mounted in it) post-processing the wrapped Pipeline, from stdin to
+// stdout. It fails if the command exits with another code than 0,
+// reporting its stderr.
+type commandHook struct {
+	args    []string
+	timeout time.Duration
+}
+
+// Mutate runs the command on p.
+func (h *commandHook) Mutate(ctx context.Context, p *v1beta1.Pipeline) error {
+	in, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cmdCtx, h.args[0], h.args[1:]...)
+	cmd.Env = append(os.Environ(),
+		PostProcessNamespaceEnv+"="+common.RequestNamespace(ctx),
+		TraceIDEnv+"="+traceIDFromContext(ctx),
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(in), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if cmdCtx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", h.timeout)
+		}
+		return fmt.Errorf("post-process-command %s failed: %v: %s", h.args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return decodePostProcessed("post-process-command "+h.args[0], p, stdout.Bytes())
+}
+
+// webhookHook posts the wrapped Pipeline to a URL post-processing it,
+// returning it in the body of a 200 response (or none, e.g. with 204), of
+// at most maxAPIPipelineSize bytes. It fails on the other statuses,
+// reporting the body of the response.
+type webhookHook struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// Mutate posts p to the URL.
+func (h *webhookHook) Mutate(ctx context.Context, p *v1beta1.Pipeline) error {
+	in, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, h.url, bytes.NewReader(in))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(PostProcessNamespaceHeader, common.RequestNamespace(ctx))
+	req.Header.Set(PostProcessTraceIDHeader, traceIDFromContext(ctx))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post-process-url %s failed: %v", h.url, err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIPipelineSize+1))
+	if err != nil {
+		return fmt.Errorf("post-process-url %s failed: %v", h.url, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		if len(out) > maxAPIPipelineSize {
+			out = out[:maxAPIPipelineSize]
+		}
+		return fmt.Errorf("post-process-url %s failed: %s: %s", h.url, resp.Status, strings.TrimSpace(string(out)))
+	}
+	if len(out) > maxAPIPipelineSize {
+		return fmt.Errorf("post-process-url %s returned a Pipeline larger than %d bytes", h.url, maxAPIPipelineSize)
+	}
+	return decodePostProcessed("post-process-url "+h.url, p, out)
+}
+
+// decodePostProcessed replaces p with the Pipeline the hook returned, if
+// any. It is decoded strictly, and must keep the name of p.
+func decodePostProcessed(hook string, p *v1beta1.Pipeline, data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	out := &v1beta1.Pipeline{}
+	if err := yaml.UnmarshalStrict(data, out); err != nil {
+		return fmt.Errorf("%s returned an invalid Pipeline: %v", hook, err)
+	}
+	if out.Kind != "" && out.Kind != "Pipeline" {
+		return fmt.Errorf("%s returned a %s instead of the Pipeline", hook, out.Kind)
+	}
+	if out.Name != p.Name {
+		return fmt.Errorf("%s renamed the Pipeline %s to %q", hook, p.Name, out.Name)
+	}
+	out.TypeMeta = p.TypeMeta
+	*p = *out
+	return nil
+}
+
+func validatePostProcessCommand(v string) error {
+	args := strings.Fields(v)
+	if len(args) == 0 || !filepath.IsAbs(args[0]) {
+		return fmt.Errorf("%q is not an absolute path to a command, with its arguments", v)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// postProcessed is the Pipeline the hooks of the tests return, describing
+// the namespace and trace ID they got.
+const postProcessed = `metadata:
+  name: build
+spec:
+  description: %s/%s
+`
+
+func TestCommandHook(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		script  string
+		want    string
+		wantErr string
+	}{{
+		name:   "post-processed",
+		script: "cat >/dev/null\nprintf '" + postProcessed + "' \"$" + PostProcessNamespaceEnv + "\" \"$" + TraceIDEnv + "\"",
+		want:   "dev/trace",
+	}, {
+		name:   "unchanged",
+		script: "cat >/dev/null",
+		want:   "original",
+	}, {
+		name:    "failing",
+		script:  "echo denied >&2\nexit 1",
+		wantErr: "exit status 1: denied",
+	}, {
+		name:    "renamed",
+		script:  "echo 'metadata: {name: other}'",
+		wantErr: `renamed the Pipeline build to "other"`,
+	}, {
+		name:    "invalid",
+		script:  "echo 'spec: {unknown: true}'",
+		wantErr: "returned an invalid Pipeline",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hook")
+			if err := os.WriteFile(path, []byte("#!/bin/sh\n"+tc.script+"\n"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			p := hookPipeline()
+			err := (&commandHook{args: []string{path}, timeout: 10 * time.Second}).Mutate(hookContext(), p)
+			checkHook(t, p, err, tc.want, tc.wantErr)
+		})
+	}
+}
+
+func TestCommandHookTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hook")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexec sleep 10\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	err := (&commandHook{args: []string{path}, timeout: 100 * time.Millisecond}).Mutate(hookContext(), hookPipeline())
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Mutate() = %v, want a timeout", err)
+	}
+}
+
+func TestWebhookHook(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		wantErr string
+	}{{
+		name: "post-processed",
+		handler: func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			if !strings.Contains(string(body), `"description":"original"`) {
+				http.Error(w, "not the Pipeline", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, postProcessed, req.Header.Get(PostProcessNamespaceHeader), req.Header.Get(PostProcessTraceIDHeader))
+		},
+		want: "dev/trace",
+	}, {
+		name: "no content",
+		handler: func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+		want: "original",
+	}, {
+		name: "failing",
+		handler: func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "denied", http.StatusForbidden)
+		},
+		wantErr: "403 Forbidden: denied",
+	}, {
+		name: "failing with a Pipeline",
+		handler: func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, postProcessed, "other", "trace")
+		},
+		wantErr: "500 Internal Server Error",
+	}, {
+		name: "oversized",
+		handler: func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, postProcessed, "dev", "trace")
+			fmt.Fprintf(w, "#%s\n", strings.Repeat("x", maxAPIPipelineSize))
+		},
+		wantErr: fmt.Sprintf("larger than %d bytes", maxAPIPipelineSize),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+			p := hookPipeline()
+			err := (&webhookHook{url: server.URL, timeout: 10 * time.Second, client: server.Client()}).Mutate(hookContext(), p)
+			checkHook(t, p, err, tc.want, tc.wantErr)
+		})
+	}
+}
+
+func TestPostProcessHooks(t *testing.T) {
+	hooks := postProcessHooks(map[string]string{
+		"post-process-command": "/bin/hook --strict",
+		"post-process-url":     "https://hooks.example.com/pipelines",
+		"post-process-timeout": "3s",
+	})
+	if len(hooks) != 2 {
+		t.Fatalf("postProcessHooks() = %v, want the command then the URL", hooks)
+	}
+	command, ok := hooks[0].(*commandHook)
+	if !ok || strings.Join(command.args, " ") != "/bin/hook --strict" || command.timeout != 3*time.Second {
+		t.Errorf("postProcessHooks()[0] = %+v, want the command", hooks[0])
+	}
+	webhook, ok := hooks[1].(*webhookHook)
+	if !ok || webhook.url != "https://hooks.example.com/pipelines" || webhook.timeout != 3*time.Second {
+		t.Errorf("postProcessHooks()[1] = %+v, want the URL", hooks[1])
+	}
+	if hooks := postProcessHooks(map[string]string{}); len(hooks) != 0 {
+		t.Errorf("postProcessHooks() without configuration = %v, want none", hooks)
+	}
+}
+
+func hookContext() context.Context {
+	return withTraceID(common.InjectRequestNamespace(context.Background(), "dev"), "trace")
+}
+
+func hookPipeline() *v1beta1.Pipeline {
+	return &v1beta1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "build"},
+		Spec:       v1beta1.PipelineSpec{Description: "original"},
+	}
+}
+
+// checkHook checks that the hook failed with wantErr, or post-processed p
+// to the description want.
+func checkHook(t *testing.T, p *v1beta1.Pipeline, err error, want, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Mutate() = %v, want %q", err, wantErr)
+		}
+		if p.Spec.Description != "original" {
+			t.Errorf("Mutate() failing changed the Pipeline to %q", p.Spec.Description)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Mutate() = %v", err)
+	}
+	if p.Spec.Description != want {
+		t.Errorf("Mutate() described the Pipeline as %q, want %q", p.Spec.Description, want)
+	}
+}
@@ -0,0 +1,82 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+)
+
+// progressInterval is how long a resolution runs before it reports its
+// progress, and how often it reports it afterwards.
+const progressInterval = 5 * time.Second
+
+// progress reports how many tasks of a Pipeline have been resolved, so that
+// users can tell a long resolution (e.g. hundreds of remote tasks) from a
+// stuck one. Reports are logged and, if there is a recorder, recorded as
+// events on the Pipeline.
+type progress struct {
+	ctx      context.Context
+	recorder record.EventRecorder
+	pipeline *v1beta1.Pipeline
+	total    int
+	resolved int
+	start    time.Time
+	last     time.Time
+}
+
+func newProgress(ctx context.Context, recorder record.EventRecorder, p *v1beta1.Pipeline) *progress {
+	now := time.Now()
+	return &progress{
+		ctx:      ctx,
+		recorder: recorder,
+		pipeline: p,
+		total:    len(p.Spec.Tasks),
+		start:    now,
+		last:     now,
+	}
+}
+
+// taskResolved counts a resolved task and reports the progress if the last
+// report is older than progressInterval.
+func (p *progress) taskResolved() {
+	p.resolved++
+	if time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+	p.report("ResolutionProgress", fmt.Sprintf("resolved %d/%d tasks of pipeline %s in %s", p.resolved, p.total, p.pipeline.Name, time.Since(p.start).Round(time.Second)))
+}
+
+// done reports the end of the resolution, if its progress was reported.
+func (p *progress) done() {
+	if p.last == p.start {
+		return
+	}
+	p.report("Resolved", fmt.Sprintf("resolved the %d tasks of pipeline %s in %s", p.total, p.pipeline.Name, time.Since(p.start).Round(time.Second)))
+}
+
+func (p *progress) report(reason, message string) {
+	logging.FromContext(p.ctx).Info(message)
+	if p.recorder == nil {
+		return
+	}
+	p.recorder.Event(pipelineObjectRef(p.pipeline), corev1.EventTypeNormal, reason, message)
+}
+
+// pipelineObjectRef returns the reference events are recorded on. The
+// Tekton types aren't registered in the scheme of the recorder, it refers
+// to the Pipeline directly.
+func pipelineObjectRef(p *v1beta1.Pipeline) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: v1beta1.SchemeGroupVersion.String(),
+		Kind:       "Pipeline",
+		Namespace:  p.Namespace,
+		Name:       p.Name,
+		UID:        p.UID,
+	}
+}
@@ -0,0 +1,62 @@
+package wrap
+
+import (
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// readOnlyBindings returns the wrapped workspaces the tasks of the Pipeline
+// declare read-only, as "<task>/<workspace>". The tasks import them but
+// don't export them, their content being unchanged. The workspace bindings
+// of the PipelineTasks have no readOnly field in the Tekton version in use,
+// only the declarations of the Tasks count.
+func readOnlyBindings(p *v1beta1.PipelineSpec, taskSpecs map[string]*v1beta1.TaskSpec, workspaces sets.String) sets.String {
+	readOnly := sets.NewString()
+	for _, t := range p.Tasks {
+		s, ok := taskSpecs[t.Name]
+		if !ok {
+			continue
+		}
+		for _, pw := range t.Workspaces {
+			if !workspaces.Has(pw.Workspace) {
+				continue
+			}
+			for _, d := range s.Workspaces {
+				if d.Name == pw.Name && d.ReadOnly {
+					readOnly.Insert(t.Name + "/" + pw.Workspace)
+				}
+			}
+		}
+	}
+	return readOnly
+}
+
+// withoutBindings returns a copy of p without the workspace bindings of
+// keys, as "<task>/<workspace>", e.g. to find the tasks exporting the
+// workspaces with latestExporter.
+func withoutBindings(p *v1beta1.PipelineSpec, keys sets.String) *v1beta1.PipelineSpec {
+	filtered := p.DeepCopy()
+	for i, t := range filtered.Tasks {
+		var bindings []v1beta1.WorkspacePipelineTaskBinding
+		for _, w := range t.Workspaces {
+			if !keys.Has(t.Name + "/" + w.Workspace) {
+				bindings = append(bindings, w)
+			}
+		}
+		filtered.Tasks[i].Workspaces = bindings
+	}
+	return filtered
+}
+
+// writableDeclaration clears the readOnly of the declaration of the
+// workspace, for the import step to extract its content in the volume
+// Tekton mounts in all the steps. The steps of the task can then write to
+// it too, unlike the declaration promised, but what they write is dropped,
+// the workspace not being exported.
+func writableDeclaration(s *v1beta1.TaskSpec, name string) {
+	for i := range s.Workspaces {
+		if s.Workspaces[i].Name == name {
+			s.Workspaces[i].ReadOnly = false
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package wrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestWrapReadOnlyWorkspaces(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+		// want are the injected steps of each task
+		want map[string][]string
+		// wantSources are the images the import of each task extracts
+		wantSources map[string]string
+	}{{
+		name: "shared target",
+		want: map[string][]string{
+			"clone": {"export-workspace"},
+			"lint":  {"import-workspace"},
+			"build": {"import-workspace"},
+		},
+		wantSources: map[string]string{"lint": "registry.example.com/source", "build": "registry.example.com/source"},
+	}, {
+		name:   "target per task",
+		params: map[string]string{TargetParam: "registry.example.com/{{workspace}}:{{task}}"},
+		want: map[string][]string{
+			"clone": {"export-workspace"},
+			"lint":  {"import-workspace"},
+			"build": {"import-workspace"},
+		},
+		wantSources: map[string]string{"lint": "registry.example.com/source:clone", "build": "registry.example.com/source:clone"},
+	}, {
+		name:   "stripped",
+		params: map[string]string{StripWorkspacesParam: "true"},
+		want: map[string][]string{
+			"clone": {"export-workspace"},
+			"lint":  {"import-workspace"},
+			"build": {"import-workspace"},
+		},
+		wantSources: map[string]string{"lint": "registry.example.com/source", "build": "registry.example.com/source"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := []string{"src=source"}
+			lint := embeddedTask("lint", src, "clone")
+			lint.TaskSpec.Workspaces[0].ReadOnly = true
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+				Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", src), lint, embeddedTask("build", src, "lint")},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params := map[string]string{
+				PipelineRefParam: "build",
+				TargetParam:      "registry.example.com/{{workspace}}",
+			}
+			for k, v := range tc.params {
+				params[k] = v
+			}
+			params, err := populateParamsWithDefaults(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, _, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+			got := map[string][]string{}
+			for _, pt := range wrapped.Spec.Tasks {
+				for _, s := range pt.TaskSpec.Steps {
+					if s.Name == pt.Name {
+						continue
+					}
+					got[pt.Name] = append(got[pt.Name], s.Name)
+					if s.Name == "import-workspace" && tc.wantSources[pt.Name] != "" && !strings.Contains(s.Script, "crane digest "+tc.wantSources[pt.Name]+")") {
+						t.Errorf("import of %s doesn't extract %s:\n%s", pt.Name, tc.wantSources[pt.Name], s.Script)
+					}
+				}
+				checkWritableWorkspace(t, pt, tc.params[StripWorkspacesParam] == "true")
+			}
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("injected steps diff (-want, +got): %s", d)
+			}
+		})
+	}
+}
+
+// checkWritableWorkspace checks that the import of pt can write to the src
+// workspace: its declaration is writable, or it is replaced with a volume
+// mounted writable in all the steps if stripped.
+func checkWritableWorkspace(t *testing.T, pt v1beta1.PipelineTask, stripped bool) {
+	t.Helper()
+	var declared bool
+	for _, d := range pt.TaskSpec.Workspaces {
+		if d.Name != "src" {
+			continue
+		}
+		declared = true
+		if d.ReadOnly {
+			t.Errorf("workspace src of %s is still read-only, the import can't write to it", pt.Name)
+		}
+	}
+	if declared == stripped {
+		t.Errorf("workspace src of %s declared %t, want %t", pt.Name, declared, !stripped)
+	}
+	if !stripped {
+		return
+	}
+	for _, step := range pt.TaskSpec.Steps {
+		var mounted bool
+		for _, m := range step.VolumeMounts {
+			if m.Name == workspaceVolumeName("src") && m.MountPath == "/workspace/src" && !m.ReadOnly {
+				mounted = true
+			}
+		}
+		if !mounted {
+			t.Errorf("step %s of %s doesn't mount the src volume writable: %v", step.Name, pt.Name, step.VolumeMounts)
+		}
+	}
+}
+
+func TestWithoutBindings(t *testing.T) {
+	binding := func(name, workspace string) v1beta1.WorkspacePipelineTaskBinding {
+		return v1beta1.WorkspacePipelineTaskBinding{Name: name, Workspace: workspace}
+	}
+	p := &v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{
+		{Name: "clone", Workspaces: []v1beta1.WorkspacePipelineTaskBinding{binding("src", "source"), binding("cache", "cache")}},
+		{Name: "lint", Workspaces: []v1beta1.WorkspacePipelineTaskBinding{binding("src", "source"), binding("cache", "cache")}},
+	}}
+	got := withoutBindings(p, sets.NewString("lint/source", "clone/other"))
+	want := []v1beta1.PipelineTask{
+		{Name: "clone", Workspaces: []v1beta1.WorkspacePipelineTaskBinding{binding("src", "source"), binding("cache", "cache")}},
+		{Name: "lint", Workspaces: []v1beta1.WorkspacePipelineTaskBinding{binding("cache", "cache")}},
+	}
+	if d := cmp.Diff(want, got.Tasks); d != "" {
+		t.Errorf("withoutBindings() diff (-want, +got): %s", d)
+	}
+	if len(p.Tasks[1].Workspaces) != 2 {
+		t.Errorf("withoutBindings() changed the Pipeline: %v", p.Tasks[1].Workspaces)
+	}
+}
+
+func TestWritableDeclaration(t *testing.T) {
+	s := &v1beta1.TaskSpec{Workspaces: []v1beta1.WorkspaceDeclaration{
+		{Name: "src", ReadOnly: true, MountPath: "/src"},
+		{Name: "config", ReadOnly: true},
+	}}
+	writableDeclaration(s, "src")
+	want := []v1beta1.WorkspaceDeclaration{
+		{Name: "src", MountPath: "/src"},
+		{Name: "config", ReadOnly: true},
+	}
+	if d := cmp.Diff(want, s.Workspaces); d != "" {
+		t.Errorf("writableDeclaration() diff (-want, +got): %s", d)
+	}
+}
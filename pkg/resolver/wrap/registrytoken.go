@@ -0,0 +1,309 @@
+package wrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// RegistryTokenLabel labels the Secrets holding the registry tokens
+	// minted by the resolver, see mint-registry-tokens.
+	RegistryTokenLabel = "wrap.tekton.dev/registry-token"
+
+	registryTokenVolumeName = "wrap-registry-token"
+	registryTokenMountPath  = "/wrap/registry-token"
+
+	// defaultRegistryTokenTTL is how long the Secrets of the minted tokens
+	// are kept, unless registry-token-ttl is set.
+	defaultRegistryTokenTTL = 24 * time.Hour
+)
+
+// repositoryAllowlist holds the repositories the resolver mints tokens for
+// in a namespace, as set by registry-token-repositories: exact
+// repositories, and prefixes for the patterns ending with /*.
+type repositoryAllowlist struct {
+	exact    sets.String
+	prefixes []string
+}
+
+// parseRegistryTokenRepositories parses registry-token-repositories, a line
+// per namespace, <namespace>=<repository>[,<repository>...], * applying to
+// all the namespaces. The repositories can end with /* to allow all the
+// repositories under them, and reference the namespace as {{namespace}},
+// e.g. *=registry.example.com/{{namespace}}/*. It returns the allowlist
+// of the namespace.
+func parseRegistryTokenRepositories(v, namespace string) (repositoryAllowlist, error) {
+	allowlist := repositoryAllowlist{exact: sets.NewString()}
+	for _, line := range strings.Split(v, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ns, repos, ok := strings.Cut(line, "=")
+		ns = strings.TrimSpace(ns)
+		if !ok || ns == "" || strings.TrimSpace(repos) == "" {
+			return repositoryAllowlist{}, fmt.Errorf("%q is not <namespace>=<repository>[,<repository>...]", line)
+		}
+		for _, pattern := range strings.Split(repos, ",") {
+			pattern = strings.ReplaceAll(strings.TrimSpace(pattern), "{{namespace}}", namespace)
+			prefix := strings.HasSuffix(pattern, "/*")
+			repo, err := name.NewRepository(strings.TrimSuffix(pattern, "/*"))
+			if err != nil {
+				return repositoryAllowlist{}, fmt.Errorf("invalid repository %q: %v", pattern, err)
+			}
+			if !validRepositoryPath(repo) {
+				return repositoryAllowlist{}, fmt.Errorf("invalid repository %q: invalid path component", pattern)
+			}
+			if ns != "*" && ns != namespace {
+				continue
+			}
+			if prefix {
+				allowlist.prefixes = append(allowlist.prefixes, repo.Name()+"/")
+			} else {
+				allowlist.exact.Insert(repo.Name())
+			}
+		}
+	}
+	return allowlist, nil
+}
+
+// pathComponentRegex matches the components of the repository paths of
+// the distribution specification, which go-containerregistry doesn't
+// enforce, e.g. rejecting ".." that would escape the prefixes.
+var pathComponentRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+
+func validRepositoryPath(repo name.Repository) bool {
+	for _, c := range strings.Split(repo.RepositoryStr(), "/") {
+		if !pathComponentRegex.MatchString(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// allows returns whether tokens can be minted for the repository.
+func (a repositoryAllowlist) allows(repo name.Repository) bool {
+	if !validRepositoryPath(repo) {
+		return false
+	}
+	if a.exact.Has(repo.Name()) {
+		return true
+	}
+	for _, p := range a.prefixes {
+		if strings.HasPrefix(repo.Name(), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// registryScopes returns the scopes of the repositories the injected steps
+// access, by registry: the targets of the oci workspaces and the manifest
+// reference are pushed to, the overridden import sources pulled from. It
+// fails if the allowlist doesn't allow one of them, as the tokens carry
+// the credentials of the resolver.
+func registryScopes(targets map[string]string, w wrappers, sources importSources, manifest string, allowlist repositoryAllowlist) (map[name.Registry][]string, error) {
+	actions := map[string]string{}
+	add := func(ref, action string) error {
+		repo := repository(ref)
+		if strings.Contains(repo, "$(") {
+			return fmt.Errorf("the repository of %s references variables, the tokens are minted when resolving", ref)
+		}
+		if actions[repo] != transport.PushScope {
+			actions[repo] = action
+		}
+		return nil
+	}
+	for ws, target := range targets {
+		if w.get(ws) != OCIWrapper || isArchive(target) {
+			continue
+		}
+		if err := add(target, transport.PushScope); err != nil {
+			return nil, err
+		}
+	}
+	for key, image := range sources {
+		for ws := range targets {
+			if _, workspace, ok := strings.Cut(key, "/"); !ok || workspace == ws {
+				if err := add(strings.ReplaceAll(image, "{{workspace}}", ws), transport.PullScope); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if manifest != "" && manifest != manifestResult {
+		if err := add(manifest, transport.PushScope); err != nil {
+			return nil, err
+		}
+	}
+	scopes := map[name.Registry][]string{}
+	for repo, action := range actions {
+		r, err := name.NewRepository(repo)
+		if err != nil {
+			return nil, err
+		}
+		if !allowlist.allows(r) {
+			return nil, fmt.Errorf("registry-token-repositories doesn't allow minting tokens for %s in the namespace", r.Name())
+		}
+		scopes[r.Registry] = append(scopes[r.Registry], r.Scope(action))
+	}
+	for _, s := range scopes {
+		sort.Strings(s)
+	}
+	return scopes, nil
+}
+
+// mintRegistryTokens gets a bearer token from each registry, scoped to the
+// repositories the injected steps access, with the credentials of the
+// resolver, and stores them in a Secret of the namespace, whose name it
+// returns. The Secrets older than registry-token-ttl are deleted along.
+func (r *Resolver) mintRegistryTokens(ctx context.Context, conf map[string]string, namespace string, scopes map[name.Registry][]string) (string, error) {
+	auths := map[string]authn.AuthConfig{}
+	for reg, s := range scopes {
+		token, err := mintRegistryToken(ctx, reg, s)
+		if err != nil {
+			return "", fmt.Errorf("minting a token for %s: %v", reg, err)
+		}
+		if token == "" {
+			// Anonymous access
+			continue
+		}
+		key := reg.RegistryStr()
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+		auths[key] = authn.AuthConfig{RegistryToken: token}
+	}
+	config, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		return "", err
+	}
+	r.deleteExpiredRegistryTokens(ctx, conf, namespace)
+	secret, err := r.kubeClientSet.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "wrap-registry-token-",
+			Labels:       map[string]string{RegistryTokenLabel: "true"},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: config},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("creating the secret of the registry tokens: %v", err)
+	}
+	return secret.Name, nil
+}
+
+// mintRegistryToken returns the bearer token the registry issues for the
+// scopes, "" if it allows anonymous access. The token is the one the
+// transport of go-containerregistry exchanges the credentials for.
+func mintRegistryToken(ctx context.Context, reg name.Registry, scopes []string) (string, error) {
+	auth, err := authn.DefaultKeychain.Resolve(reg)
+	if err != nil {
+		return "", err
+	}
+	capture := &authorizationCapture{inner: http.DefaultTransport, host: reg.RegistryStr()}
+	t, err := transport.NewWithContext(ctx, reg, auth, capture, scopes)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr()), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Transport: t}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return "", err
+	}
+	switch {
+	case capture.authorization == "":
+		return "", nil
+	case !strings.HasPrefix(capture.authorization, "Bearer "):
+		// Never hand the long-lived credentials of the resolver out
+		return "", fmt.Errorf("the registry doesn't issue tokens")
+	}
+	return strings.TrimPrefix(capture.authorization, "Bearer "), nil
+}
+
+// authorizationCapture records the Authorization header of the requests
+// to the registry host.
+type authorizationCapture struct {
+	inner         http.RoundTripper
+	host          string
+	authorization string
+}
+
+func (c *authorizationCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == c.host {
+		c.authorization = req.Header.Get("Authorization")
+	}
+	return c.inner.RoundTrip(req)
+}
+
+// deleteExpiredRegistryTokens deletes the Secrets of the tokens minted for
+// the namespace more than registry-token-ttl ago. Failures are only logged.
+func (r *Resolver) deleteExpiredRegistryTokens(ctx context.Context, conf map[string]string, namespace string) {
+	logger := logging.FromContext(ctx)
+	ttl := defaultRegistryTokenTTL
+	if v, ok := conf["registry-token-ttl"]; ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			ttl = d
+		}
+	}
+	secrets, err := r.kubeClientSet.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: RegistryTokenLabel + "=true"})
+	if err != nil {
+		logger.Infof("failed to list the registry tokens of namespace %s: %v", namespace, err)
+		return
+	}
+	for _, s := range secrets.Items {
+		if time.Since(s.CreationTimestamp.Time) < ttl {
+			continue
+		}
+		if err := r.kubeClientSet.CoreV1().Secrets(namespace).Delete(ctx, s.Name, metav1.DeleteOptions{}); err != nil {
+			logger.Infof("failed to delete the registry token %s of namespace %s: %v", s.Name, namespace, err)
+		}
+	}
+}
+
+// mountRegistryToken makes the injected steps of the wrapped Pipeline use
+// the docker config of the Secret, see forInjectedSteps. The own steps of the
+// tasks keep their credentials.
+func mountRegistryToken(p *v1beta1.Pipeline, injected map[string][]InjectedStep, ownFinally int, secret string) {
+	forInjectedSteps(p, injected, ownFinally, func(s *v1beta1.TaskSpec, steps []int) {
+		s.Volumes = append(s.Volumes, corev1.Volume{
+			Name: registryTokenVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secret,
+					Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+				},
+			},
+		})
+		for _, i := range steps {
+			s.Steps[i].Env = append(s.Steps[i].Env, corev1.EnvVar{Name: "DOCKER_CONFIG", Value: registryTokenMountPath})
+			s.Steps[i].VolumeMounts = append(s.Steps[i].VolumeMounts, corev1.VolumeMount{
+				Name:      registryTokenVolumeName,
+				MountPath: registryTokenMountPath,
+				ReadOnly:  true,
+			})
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package wrap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestParseRegistryTokenRepositories(t *testing.T) {
+	const conf = `ci=registry.example.com/ci/cache
+*=registry.example.com/{{namespace}}/*
+dev=index.docker.io/library/alpine`
+	for _, tc := range []struct {
+		name      string
+		namespace string
+		repo      string
+		want      bool
+	}{
+		{"exact", "ci", "registry.example.com/ci/cache", true},
+		{"exact of another namespace", "dev", "registry.example.com/ci/cache", false},
+		{"own prefix", "dev", "registry.example.com/dev/app/cache", true},
+		{"prefix of another namespace", "dev", "registry.example.com/ci/app", false},
+		{"prefix itself", "dev", "registry.example.com/dev", false},
+		{"prefix lookalike", "dev", "registry.example.com/dev-other/app", false},
+		{"normalized", "dev", "alpine", true},
+		{"other registry", "ci", "other.example.com/ci/cache", false},
+		{"escaping prefix", "dev", "registry.example.com/dev/../ci/cache", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			allowlist, err := parseRegistryTokenRepositories(conf, tc.namespace)
+			if err != nil {
+				t.Fatalf("parseRegistryTokenRepositories() = %v", err)
+			}
+			repo, err := name.NewRepository(tc.repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := allowlist.allows(repo); got != tc.want {
+				t.Errorf("allows(%s) = %t, want %t", tc.repo, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRegistryTokenRepositoriesInvalid(t *testing.T) {
+	for _, conf := range []string{
+		"registry.example.com/ci",
+		"ci=",
+		"=registry.example.com/ci",
+		"ci=registry.example.com/Ci",
+		"ci=registry.example.com/../ci",
+	} {
+		if _, err := parseRegistryTokenRepositories(conf, "ci"); err == nil {
+			t.Errorf("parseRegistryTokenRepositories(%q) = nil, want an error", conf)
+		}
+	}
+}
+
+func TestRegistryScopes(t *testing.T) {
+	allowlist, err := parseRegistryTokenRepositories("*=registry.example.com/{{namespace}}/*", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		targets  map[string]string
+		sources  importSources
+		manifest string
+		want     map[string][]string
+		wantErr  string
+	}{{
+		name:    "targets",
+		targets: map[string]string{"sources": "registry.example.com/ci/sources:latest", "cache": "registry.example.com/ci/cache@sha256:" + strings.Repeat("0", 64)},
+		want: map[string][]string{"registry.example.com": {
+			"repository:ci/cache:push,pull",
+			"repository:ci/sources:push,pull",
+		}},
+	}, {
+		name:    "import source pulled",
+		targets: map[string]string{"sources": "registry.example.com/ci/sources"},
+		sources: importSources{"debug/sources": "registry.example.com/ci/snapshot:1"},
+		want: map[string][]string{"registry.example.com": {
+			"repository:ci/snapshot:pull",
+			"repository:ci/sources:push,pull",
+		}},
+	}, {
+		name:     "manifest pushed",
+		targets:  map[string]string{"sources": "registry.example.com/ci/sources"},
+		manifest: "registry.example.com/ci/manifest",
+		want: map[string][]string{"registry.example.com": {
+			"repository:ci/manifest:push,pull",
+			"repository:ci/sources:push,pull",
+		}},
+	}, {
+		name:    "target of another namespace",
+		targets: map[string]string{"sources": "registry.example.com/prod/sources"},
+		wantErr: "doesn't allow minting tokens for registry.example.com/prod/sources",
+	}, {
+		name:    "import source of another registry",
+		targets: map[string]string{"sources": "registry.example.com/ci/sources"},
+		sources: importSources{"debug": "other.example.com/ci/snapshot"},
+		wantErr: "doesn't allow minting tokens for other.example.com/ci/snapshot",
+	}, {
+		name:    "variable",
+		targets: map[string]string{"sources": "registry.example.com/ci/$(params.name)"},
+		wantErr: "references variables",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			scopes, err := registryScopes(tc.targets, wrappers{"": OCIWrapper}, tc.sources, tc.manifest, allowlist)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("registryScopes() = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("registryScopes() = %v", err)
+			}
+			got := map[string][]string{}
+			for reg, s := range scopes {
+				got[reg.RegistryStr()] = s
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("registryScopes() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigRegistryTokens(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		conf    map[string]string
+		wantErr bool
+	}{
+		{"without allowlist", map[string]string{"mint-registry-tokens": "true"}, true},
+		{"with allowlist", map[string]string{"mint-registry-tokens": "true", "registry-token-repositories": "*=registry.example.com/{{namespace}}/*"}, false},
+		{"disabled", map[string]string{"mint-registry-tokens": "false"}, false},
+		{"invalid allowlist", map[string]string{"registry-token-repositories": "registry.example.com"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateConfig(tc.conf); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateConfig() = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
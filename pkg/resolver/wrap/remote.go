@@ -0,0 +1,217 @@
+package wrap
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/apis/resolution/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// gitResolverType is the type of the git resolver of Tekton, see
+	// PipelineURLParam.
+	gitResolverType = "git"
+	// bundlesResolverType is the type of the bundles resolver of Tekton,
+	// see BundleParam.
+	bundlesResolverType = "bundles"
+	// hubResolverType is the type of the hub resolver of Tekton, see
+	// CatalogParam.
+	hubResolverType = "hub"
+
+	resolutionRequestPollInterval = time.Second
+)
+
+// getGitPipeline fetches the Pipeline at path in the git repository at the
+// revision (the default of the git resolver if empty), returning it with
+// its content, with the git resolver of Tekton.
+func (r *Resolver) getGitPipeline(ctx context.Context, namespace, repo, revision, path string) (*v1beta1.Pipeline, []byte, error) {
+	params := map[string]string{"url": repo, "pathInRepo": path}
+	if revision != "" {
+		params["revision"] = revision
+	}
+	data, err := r.request(ctx, namespace, gitResolverType, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching %s from %s: %v", path, repo, err)
+	}
+	p, err := decodePipeline(ctx, path, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s from %s: %v", path, repo, err)
+	}
+	return p, data, nil
+}
+
+// getBundlePipeline fetches the Pipeline named name from the Tekton bundle,
+// returning it with its content, with the bundles resolver of Tekton.
+func (r *Resolver) getBundlePipeline(ctx context.Context, namespace, bundle, name string) (*v1beta1.Pipeline, []byte, error) {
+	data, err := r.request(ctx, namespace, bundlesResolverType, bundleParams(bundle, name, "pipeline"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching pipeline %s from bundle %s: %v", name, bundle, err)
+	}
+	p, err := decodePipeline(ctx, name, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s from bundle %s: %v", name, bundle, err)
+	}
+	return p, data, nil
+}
+
+// getHubPipeline fetches the version of the Pipeline named name from the
+// catalog of the hub the hub resolver of Tekton is configured with (Tekton
+// Hub or Artifact Hub), returning it with its content.
+func (r *Resolver) getHubPipeline(ctx context.Context, namespace, catalog, name, version string) (*v1beta1.Pipeline, []byte, error) {
+	data, err := r.request(ctx, namespace, hubResolverType, map[string]string{
+		"catalog": catalog,
+		"kind":    "pipeline",
+		"name":    name,
+		"version": version,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching pipeline %s %s from catalog %s: %v", name, version, catalog, err)
+	}
+	p, err := decodePipeline(ctx, name, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s from catalog %s: %v", name, version, catalog, err)
+	}
+	return p, data, nil
+}
+
+// getBundleTask fetches the Task named name from the Tekton bundle, with
+// the bundles resolver of Tekton, for the taskRefs of the Pipelines
+// holding a bundle.
+func (r *Resolver) getBundleTask(ctx context.Context, namespace, bundle, name string) (*v1beta1.Task, error) {
+	data, err := r.request(ctx, namespace, bundlesResolverType, bundleParams(bundle, name, "task"))
+	if err != nil {
+		return nil, fmt.Errorf("fetching task %s from bundle %s: %v", name, bundle, err)
+	}
+	t, err := decodeTask(ctx, name, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s from bundle %s: %v", name, bundle, err)
+	}
+	return t, nil
+}
+
+// bundleParams are the params of the bundles resolver fetching the
+// resource of the kind named name from the bundle, with its default
+// service account.
+func bundleParams(bundle, name, kind string) map[string]string {
+	return map[string]string{"bundle": bundle, "name": name, "kind": kind}
+}
+
+// request fetches a resource with the resolver of Tekton of the type,
+// given its params, returning its content. It is fetched with a
+// ResolutionRequest of the namespace the resolver creates, waits for and
+// deletes.
+func (r *Resolver) request(ctx context.Context, namespace, resolverType string, params map[string]string) ([]byte, error) {
+	if r.resolutionClientSet == nil {
+		return nil, fmt.Errorf("the %s resolver is not supported by this resolver", resolverType)
+	}
+	client := r.resolutionClientSet.ResolutionV1alpha1().ResolutionRequests(namespace)
+	rr, err := client.Create(ctx, &v1alpha1.ResolutionRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "wrap-" + resolverType + "-",
+			Labels:       map[string]string{common.LabelKeyResolverType: resolverType},
+		},
+		Spec: v1alpha1.ResolutionRequestSpec{Parameters: params},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating the resolution request: %v", err)
+	}
+	defer func() {
+		// ctx may be done already
+		if err := client.Delete(context.Background(), rr.Name, metav1.DeleteOptions{}); err != nil {
+			logging.FromContext(ctx).Infof("failed to delete resolution request %s from namespace %s: %v", rr.Name, namespace, err)
+		}
+	}()
+
+	var data []byte
+	err = wait.PollImmediateUntil(resolutionRequestPollInterval, func() (bool, error) {
+		rr, err := client.Get(ctx, rr.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		c := rr.Status.GetCondition(apis.ConditionSucceeded)
+		switch {
+		case c.IsTrue():
+			data, err = base64.StdEncoding.DecodeString(rr.Status.Data)
+			return true, err
+		case c.IsFalse():
+			return false, fmt.Errorf("%s: %s", c.Reason, c.Message)
+		}
+		return false, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("with the %s resolver: %v", resolverType, err)
+	}
+	return data, nil
+}
+
+// decodePipeline decodes the Pipeline of the path (or name), as
+// tekton.dev/v1beta1 or tekton.dev/v1 converted to v1beta1.
+func decodePipeline(ctx context.Context, path string, data []byte) (*v1beta1.Pipeline, error) {
+	meta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("%s is not a Pipeline: %v", path, err)
+	}
+	if meta.Kind != "Pipeline" {
+		return nil, fmt.Errorf("%s is not a Pipeline but a %s", path, meta.Kind)
+	}
+	switch meta.APIVersion {
+	case APIVersionV1beta1:
+		p := &v1beta1.Pipeline{}
+		if err := decodeStrict("Pipeline", path, data, p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case APIVersionV1:
+		source := &v1.Pipeline{}
+		if err := decodeStrict("Pipeline", path, data, source); err != nil {
+			return nil, err
+		}
+		p := &v1beta1.Pipeline{}
+		if err := p.ConvertFrom(ctx, source); err != nil {
+			return nil, fmt.Errorf("converting pipeline %s from %s: %v", path, APIVersionV1, err)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("%s is a Pipeline of unsupported apiVersion %q", path, meta.APIVersion)
+}
+
+// decodeTask decodes the Task of the name, as tekton.dev/v1beta1 or
+// tekton.dev/v1 converted to v1beta1.
+func decodeTask(ctx context.Context, name string, data []byte) (*v1beta1.Task, error) {
+	meta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("%s is not a Task: %v", name, err)
+	}
+	if meta.Kind != "Task" {
+		return nil, fmt.Errorf("%s is not a Task but a %s", name, meta.Kind)
+	}
+	switch meta.APIVersion {
+	case APIVersionV1beta1:
+		t := &v1beta1.Task{}
+		if err := decodeStrict("Task", name, data, t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	case APIVersionV1:
+		source := &v1.Task{}
+		if err := decodeStrict("Task", name, data, source); err != nil {
+			return nil, err
+		}
+		t := &v1beta1.Task{}
+		if err := t.ConvertFrom(ctx, source); err != nil {
+			return nil, fmt.Errorf("converting task %s from %s: %v", name, APIVersionV1, err)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("%s is a Task of unsupported apiVersion %q", name, meta.APIVersion)
+}
@@ -2,43 +2,351 @@ package wrap
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	clientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	pipelineclient "github.com/tektoncd/pipeline/pkg/client/injection/client"
+	resolutionclientset "github.com/tektoncd/pipeline/pkg/client/resolution/clientset/versioned"
+	resolutionclient "github.com/tektoncd/pipeline/pkg/client/resolution/injection/client"
 	"github.com/tektoncd/pipeline/pkg/resolution/common"
 	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/injection/clients/dynamicclient"
 	"knative.dev/pkg/logging"
-	"sigs.k8s.io/yaml"
 )
 
 // LabelValueWrapResolverType is the value to use for the
 // resolution.tekton.dev/type label on resource requests
 const LabelValueWrapResolverType string = "wrap"
 
-// TODO(sbwsg): This should be exposed as a configurable option for
-// admins (e.g. via ConfigMap)
-const timeoutDuration = time.Minute
+// ResolverType returns the value of the resolver type label of the requests
+// the resolver handles: LabelValueWrapResolverType unless the
+// WRAP_RESOLVER_TYPE environment variable is set, to run several variants
+// of the resolver side by side (e.g. wrap and wrap-s3, with different
+// defaults).
+func ResolverType() string {
+	if t := os.Getenv("WRAP_RESOLVER_TYPE"); t != "" {
+		return t
+	}
+	return LabelValueWrapResolverType
+}
+
+// defaultFetchTimeout is how long fetching the Pipeline or one of its Tasks
+// can take, unless fetch-timeout is set in the resolver configuration.
+const defaultFetchTimeout = time.Minute
+
+// fetchTimeout returns how long fetching the Pipeline or one of its Tasks
+// can take.
+func fetchTimeout(conf map[string]string) time.Duration {
+	if v, ok := conf["fetch-timeout"]; ok {
+		if timeout, err := time.ParseDuration(v); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return defaultFetchTimeout
+}
 
 const (
 	PipelineRefParam = "pipelineref"
-	WorkspacesParam  = "workspaces"
-	TargetParam      = "target"
-	WrapperParam     = "wrapper"
+	// TaskRefParam wraps the Task instead of a Pipeline, for TaskRuns, see
+	// resolveTask.
+	TaskRefParam = "taskref"
+	// PipelineURLParam fetches the Pipeline from the git repository at
+	// this URL with the git resolver of Tekton, pipelineref being its path
+	// in the repository, at PipelineRevisionParam if set.
+	PipelineURLParam      = "pipeline-url"
+	PipelineRevisionParam = "pipeline-revision"
+	// BundleParam fetches the Pipeline named BundleNameParam from this
+	// Tekton bundle with the bundles resolver of Tekton, instead of
+	// pipelineref.
+	BundleParam     = "bundle"
+	BundleNameParam = "name"
+	// CatalogParam fetches the Pipeline named pipelineref, at
+	// VersionParam, from this catalog of the hub with the hub resolver of
+	// Tekton.
+	CatalogParam    = "catalog"
+	VersionParam    = "version"
+	WorkspacesParam = "workspaces"
+	TargetParam     = "target"
+	// WrapperParam selects the wrapper moving the content of the
+	// workspaces, for all of them (e.g. oci) or per workspace (e.g.
+	// oci,testdata=s3).
+	WrapperParam = "wrapper"
+	// S3TargetParam is the target of the workspaces using the s3 wrapper,
+	// e.g. s3://bucket/{{workspace}}.tar.
+	S3TargetParam = "s3-target"
+	// GCSTargetParam is the target of the workspaces using the gcs
+	// wrapper, e.g. gs://bucket/{{workspace}}.tar.
+	GCSTargetParam = "gcs-target"
+	// RsyncTargetParam is the target of the workspaces using the rsync
+	// wrapper, a directory of an rsync daemon, e.g.
+	// rsync://cache.example.com/wrap/{{workspace}}.
+	RsyncTargetParam = "rsync-target"
+	// StripWorkspacesParam replaces the wrapped workspaces with task-local
+	// emptyDir volumes, so that TaskRuns don't bind them anymore.
+	StripWorkspacesParam = "strip-workspaces"
+	// WorkspaceSizeParam sets the sizeLimit of the emptyDir volumes of
+	// stripped workspaces, either for all of them (e.g. 1Gi) or per
+	// workspace (e.g. sources=1Gi,cache=500Mi).
+	WorkspaceSizeParam = "workspace-size"
+	// BaseImageParam is the image the exports of the first tasks append
+	// onto, DefaultBaseImage if neither it nor the base-image of the
+	// resolver configuration is set.
+	BaseImageParam = "base-image"
+	// CraneImageParam is the image of the injected steps using crane,
+	// e.g. a mirror for air-gapped clusters, over the crane-image of the
+	// resolver configuration and DefaultCraneImage. It needs a shell and
+	// crane, like gcr.io/go-containerregistry/crane:debug.
+	CraneImageParam = "crane-image"
+	// SharedTargetParam declares that the target is shared by concurrent
+	// runs, e.g. as a cache. Exports then append onto the latest image of
+	// the target and retry when another run pushed to it meanwhile.
+	SharedTargetParam = "shared-target"
+	// ImportWaitTimeoutParam is how long the imports wait for the registry
+	// to serve the images exported upstream, e.g. the replicas of a
+	// geo-replicated registry, instead of failing with NotFound right
+	// after the export succeeded. They don't wait if 0 or not set.
+	ImportWaitTimeoutParam = "import-wait-timeout"
+	// AllowMissingBaseParam makes the imports of oci and docker-archive
+	// workspaces, and of the wrapper templates honoring it, continue with
+	// an empty workspace when the image to import doesn't exist, e.g.
+	// because the task exporting it was skipped by its when expressions,
+	// instead of failing the task. Other failures still fail it.
+	AllowMissingBaseParam = "allow-missing-base"
+	// ExportChunksParam splits the content of each workspace in as many
+	// layers, compressed and pushed concurrently.
+	ExportChunksParam = "export-chunks"
+	// CompressionParam is the compression of the layers the exports of
+	// oci workspaces push, one of wrapstep.Compressions: gzip by default,
+	// zstd or none make the transfers use the wrapstep helper.
+	CompressionParam = "compression"
+	// ExportFailureParam decides what happens when the transfer of a
+	// workspace fails: ExportFailureFail fails the task, ExportFailureWarn
+	// only prints a warning and lets the run proceed, the next tasks
+	// starting with an empty workspace if its image doesn't exist.
+	ExportFailureParam = "export-failure"
+
+	// AlwaysExportParam keeps the exports no downstream task imports,
+	// skipped by default, e.g. when the last image of a workspace is used
+	// outside of the Pipeline. See ExportReadersAnnotation.
+	AlwaysExportParam = "always-export"
+
+	// ArtifactResultsParam reports each export in an object result of the
+	// task, with the uri and digest of the pushed image, and pins the
+	// imports to the digest exported upstream through task params. The
+	// data flow then shows in the run status. Object results require the
+	// alpha API fields of Tekton.
+	ArtifactResultsParam = "artifact-results"
+
+	// ExportOnFailureParam exports workspaces even when a step of their
+	// task failed, e.g. to capture debugging snapshots: true for all tasks,
+	// or a comma separated list of tasks and task workspaces (e.g.
+	// build,test/sources). The task still fails once exported.
+	ExportOnFailureParam = "export-on-failure"
 
-	DefaultBaseImage = "ghcr.io/openshift-pipelines/tekton-wrap-pipeline/base:latest"
+	// WorkspaceManifestParam adds a finally task aggregating the images
+	// pushed by the exports of the run in a JSON manifest, the workspace
+	// lineage of the run. The manifest is emitted as the wrap-manifest
+	// result of the PipelineRun, and pushed to the value of the param
+	// unless it is "result". Exports then report their images like with
+	// ArtifactResultsParam.
+	WorkspaceManifestParam = "workspace-manifest"
+
+	// FaultInjectParam makes the injected steps simulate transport faults,
+	// a comma separated list of registry-error, slow and partial-extract,
+	// to test the resilience of a Pipeline before relying on wrapping.
+	// It is only allowed when allow-fault-injection is set in the
+	// resolver configuration.
+	FaultInjectParam = "fault-inject"
+	// OrderTasksParam lists the tasks importing the same images
+	// consecutively where the DAG allows, for the node image caches, see
+	// orderTasks.
+	OrderTasksParam = "order-tasks"
+	// ImportSourceParam makes the imports of tasks extract the given
+	// images instead of the ones exported upstream, e.g. to replay a
+	// production snapshot in a debug task: a comma separated list of
+	// <task>=<image> and <task>/<workspace>=<image> pairs, see
+	// importSources. The exports of these tasks still append onto the
+	// target.
+	ImportSourceParam = "import-source"
+	// LatestAliasParam tags the final image of each oci workspace as
+	// <pipeline>-latest once the whole run succeeded, a stable pointer to
+	// the last good state, see latestAliases. The exports no downstream
+	// task imports are then kept.
+	LatestAliasParam = "latest-alias"
+	// IncludeTasksParam restricts the tasks getting the transfer steps to
+	// the listed ones, comma separated, see taskFilter. The others use the
+	// wrapped workspaces as bound.
+	IncludeTasksParam = "include-tasks"
+	// ExcludeTasksParam lists the tasks not getting the transfer steps,
+	// comma separated, e.g. the ones reading the workspace without
+	// having to push it, see taskFilter.
+	ExcludeTasksParam = "exclude-tasks"
+	// ArchiveTargetParam copies the final image of each oci workspace to
+	// this image once the whole run succeeded, rendered for each of them
+	// like TargetParam, e.g. to a slower registry retaining them longer
+	// while the transfers of the run stay on a nearby one, see
+	// archiveCopies. The exports no downstream task imports are then
+	// kept. Unrelated to the docker-archive targets.
+	ArchiveTargetParam = "archive-target"
+	// KeepOriginalParam exposes the Pipeline as fetched, before wrapping,
+	// in the OriginalContentAnnotation of the resolved resource.
+	KeepOriginalParam = "keep-original"
+	// TransactionalParam makes the exports of oci workspaces push to
+	// run-scoped tags, see transactionTarget, promoted to the target once
+	// the whole run succeeded, so that failed runs never move it.
+	TransactionalParam = "transactional"
+	// ArtifactTypeParam sets the artifact type of the images of oci
+	// workspaces, the media type of their config, so that registries can
+	// apply distinct retention, replication or scanning policies to them:
+	// either for all of them or per workspace (e.g.
+	// cache=application/vnd.example.cache.v1).
+	ArtifactTypeParam = "artifact-type"
+	// LineageResultParam adds a finally task aggregating the digest chain
+	// of each workspace, the image each task exported and the task it
+	// imported from, in the wrap-lineage JSON result of the PipelineRun,
+	// see lineageResultTask. Exports then report their images like with
+	// ArtifactResultsParam.
+	LineageResultParam = "lineage-result"
+	// EphemeralWorkspacesParam keeps the content of the listed oci
+	// workspaces within the run, e.g. sensitive intermediate data: their
+	// exports push to run-scoped tags in the repository of the target,
+	// deleted by a finally task whatever the outcome of the run, see
+	// cleanupTask. They are never promoted nor aliased.
+	EphemeralWorkspacesParam = "ephemeral-workspaces"
+	// ForceParam makes the exports push to targets owned by another
+	// Pipeline when owner-markers is set in the resolver configuration,
+	// see wrapstep.OwnerAnnotation.
+	ForceParam = "force"
+	// VerifyParam adds a finally task importing the final image of each
+	// workspace once the run succeeded, running VerifyScriptParam on
+	// their content, an end-to-end smoke test of the transport, see
+	// verifyTask. The exports no downstream task imports are then kept.
+	VerifyParam = "verify"
+	// VerifyScriptParam is the script verifying the content of the
+	// workspaces, extracted in /wrap/verify/<workspace>, e.g. comparing
+	// their checksums with a manifest written by the last task.
+	VerifyScriptParam = "verify-script"
+	// OutputAPIVersionParam is the apiVersion of the wrapped Pipeline,
+	// APIVersionV1beta1 by default or APIVersionV1, see marshalPipeline.
+	OutputAPIVersionParam = "output-apiversion"
+
+	APIVersionV1beta1 = "tekton.dev/v1beta1"
+	APIVersionV1      = "tekton.dev/v1"
+
+	ExportFailureFail = "fail"
+	ExportFailureWarn = "warn"
+
+	DefaultBaseImage     = "ghcr.io/openshift-pipelines/tekton-wrap-pipeline/base:latest"
+	DefaultWrapstepImage = "ghcr.io/openshift-pipelines/tekton-wrap-pipeline/wrapstep:latest"
+	DefaultCraneImage    = "gcr.io/go-containerregistry/crane:debug"
+
+	// MetadataVersionAnnotation holds the MetadataVersion of the
+	// annotations below, on the wrapped Pipeline and the resolved
+	// resource, see ParseMetadata.
+	MetadataVersionAnnotation = "wrap.tekton.dev/metadata-version"
+	// StrippedWorkspacesAnnotation lists the workspaces that are not bound
+	// by any TaskRun of the wrapped Pipeline anymore. The affinity assistant
+	// doesn't pin those TaskRuns on the node of a PersistentVolumeClaim
+	// bound to these workspaces, and the binding can be omitted altogether.
+	StrippedWorkspacesAnnotation = "wrap.tekton.dev/stripped-workspaces"
+	// ExportReadersAnnotation maps each export of the wrapped Pipeline,
+	// as "<task>/<workspace>", to the downstream tasks importing it, in
+	// JSON.
+	ExportReadersAnnotation = "wrap.tekton.dev/export-readers"
+	// UnreadExportsAnnotation lists the exports no downstream task
+	// imports, as "<task>/<workspace>". They are skipped unless
+	// AlwaysExportParam is set.
+	UnreadExportsAnnotation = "wrap.tekton.dev/unread-exports"
+	// InjectedStepsAnnotation maps each wrapped task of the Pipeline to
+	// the names and indices of the steps injected in it, in JSON, e.g. for
+	// log processors to collapse them.
+	InjectedStepsAnnotation = "wrap.tekton.dev/injected-steps"
+	// UsageAnnotation describes what the wrapped Pipeline needs at run
+	// time, in JSON: the params without a default, the workspaces to bind
+	// and the credentials the injected steps need.
+	UsageAnnotation = "wrap.tekton.dev/usage"
+	// EffectiveParamsAnnotation is the annotation of the resolved resource
+	// holding the EffectiveSettings the Pipeline was wrapped with, in
+	// JSON.
+	EffectiveParamsAnnotation = "wrap.tekton.dev/effective-params"
+	// OriginalAnnotation is the annotation of the resolved resource
+	// locating the Pipeline as fetched, before wrapping, in JSON: its
+	// namespace, name, uid and resourceVersion, and the sha256 digest of
+	// its content as served by the API server, so that audits can prove
+	// which input the wrapped Pipeline was produced from.
+	OriginalAnnotation = "wrap.tekton.dev/original"
+	// OriginalContentAnnotation is the annotation of the resolved resource
+	// holding that content, in JSON, with KeepOriginalParam.
+	OriginalContentAnnotation = "wrap.tekton.dev/original-content"
+	// WarningsAnnotation lists the warnings about the wrapped Pipeline, in
+	// JSON, e.g. tasks whose pods grew too large with the injected steps.
+	// They are also recorded as events on the Pipeline.
+	WarningsAnnotation = "wrap.tekton.dev/warnings"
+	// APIFieldsAnnotation records the enable-api-fields feature flag,
+	// stable or alpha, the wrapped Pipeline requires.
+	APIFieldsAnnotation = "wrap.tekton.dev/api-fields"
+	// CachedAnnotation is the annotation of the resolved resources served
+	// from a WrappedPipeline, holding its name, with the
+	// wrapped-pipeline-cache of the resolver configuration.
+	CachedAnnotation = "wrap.tekton.dev/cached"
+	// StorageEstimateAnnotation describes what each run of the wrapped
+	// Pipeline stores in the registries and buckets, as a StorageEstimate
+	// in JSON.
+	StorageEstimateAnnotation = "wrap.tekton.dev/storage-estimate"
+	// TraceIDAnnotation holds the trace ID of the resolution, on the
+	// wrapped Pipeline and the resolved resource. It is logged along the
+	// resolution and prefixes the logs of the injected steps.
+	TraceIDAnnotation = "wrap.tekton.dev/trace-id"
 )
 
 type ResolvedWrapperResource struct {
 	Content     []byte
 	PipelineRef string
+	// TaskRef is the wrapped Task, instead of PipelineRef.
+	TaskRef string
+	// Effective holds the settings the Pipeline was wrapped with.
+	Effective *EffectiveSettings
+	// Original is the Pipeline as fetched, if any.
+	Original *OriginalPipeline
+	// KeepOriginal exposes the content of Original too.
+	KeepOriginal bool
+	// TraceID is the trace ID of the resolution.
+	TraceID string
+	// ImageDrifts are the drifts of the images of the injected steps, see
+	// ImageDigestWatcher.
+	ImageDrifts []ImageDrift
+	// Summary describes what wrapping changed, with DryRunParam.
+	Summary *WrapSummary
+}
+
+// EffectiveSettings are the settings a Pipeline was wrapped with, once the
+// defaults of the resolver configuration and the templates applied, as
+// exposed in the EffectiveParamsAnnotation of the resolved resource.
+type EffectiveSettings struct {
+	// Params are the parameters of the request, with their defaults.
+	Params map[string]string `json:"params"`
+	// Targets are the images, or tarballs for s3, of each wrapped
+	// workspace.
+	Targets map[string]string `json:"targets"`
+	// Images are the images of the steps and the base image.
+	Images map[string]string `json:"images"`
+	// Features are the features behind a flag the resolution used.
+	Features []string `json:"features,omitempty"`
 }
 
 var _ framework.ResolvedResource = &ResolvedWrapperResource{}
@@ -50,44 +358,124 @@ func (r *ResolvedWrapperResource) Data() []byte {
 
 // Annotations returns the metadata that accompanies the resource fetched from the cluster.
 func (r *ResolvedWrapperResource) Annotations() map[string]string {
-	return map[string]string{
-		"PipelineRef": r.PipelineRef,
+	annotations := map[string]string{
+		MetadataVersionAnnotation: MetadataVersion,
+	}
+	if r.TaskRef != "" {
+		annotations["TaskRef"] = r.TaskRef
+	} else {
+		annotations["PipelineRef"] = r.PipelineRef
 	}
+	if r.Effective != nil {
+		if b, err := json.Marshal(r.Effective); err == nil {
+			annotations[EffectiveParamsAnnotation] = string(b)
+		}
+	}
+	if r.TraceID != "" {
+		annotations[TraceIDAnnotation] = r.TraceID
+	}
+	if len(r.ImageDrifts) > 0 {
+		if b, err := json.Marshal(r.ImageDrifts); err == nil {
+			annotations[ImageDriftAnnotation] = string(b)
+		}
+	}
+	if r.Summary != nil {
+		if b, err := json.Marshal(r.Summary); err == nil {
+			annotations[DryRunSummaryAnnotation] = string(b)
+		}
+	}
+	if r.Original != nil {
+		for k, v := range r.Original.annotations(r.KeepOriginal) {
+			annotations[k] = v
+		}
+	}
+	return annotations
 }
 
 // Resolver implements a framework.Resolver that can "wrap" a Pipeline for not using a PVC for workspaces
 type Resolver struct {
 	kubeClientSet     kubernetes.Interface
 	pipelineClientSet clientset.Interface
+	// dynamicClientSet stores the WrappedPipelines, nil for the fake
+	// clientsets of the self-test.
+	dynamicClientSet dynamic.Interface
+	// resolutionClientSet requests the Pipelines of PipelineURLParam,
+	// BundleParam and CatalogParam, and the Tasks of bundles, from the git,
+	// bundles and hub resolvers, nil for the self-test.
+	resolutionClientSet resolutionclientset.Interface
+	// recorder records the progress of long resolutions as events.
+	recorder record.EventRecorder
+	// partial keeps the tasks resolved by the resolutions that didn't
+	// finish, for the next request to resume from.
+	partial partialResolutions
+	// imageDigests records the drifts of the images of the injected steps,
+	// see ImageDigestWatcher.
+	imageDigests imageDigests
+
+	// Before mutates the requested Pipeline before it is wrapped, as
+	// fetched from the cluster.
+	Before Chain
+	// After mutates the wrapped Pipeline, whose tasks are all embedded,
+	// before it is validated.
+	After Chain
 }
 
+var _ framework.TimedResolution = &Resolver{}
+
 // Initialize sets up any dependencies needed by the Resolver. None atm.
 func (r *Resolver) Initialize(ctx context.Context) error {
 	r.kubeClientSet = client.Get(ctx)
 	r.pipelineClientSet = pipelineclient.Get(ctx)
+	r.dynamicClientSet = dynamicclient.Get(ctx)
+	r.resolutionClientSet = resolutionclient.Get(ctx)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: r.kubeClientSet.CoreV1().Events("")})
+	r.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "wrapresolver"})
 	return nil
 }
 
 // GetName returns a string name to refer to this Resolver by.
 func (r *Resolver) GetName(context.Context) string {
+	if t := ResolverType(); t != LabelValueWrapResolverType {
+		return "wrapresolver-" + t
+	}
 	return "wrapresolver"
 }
 
 // GetConfigName returns the name of the wrap resolver's configmap.
 func (r *Resolver) GetConfigName(context.Context) string {
-	return "wrapresolver-config"
+	return ResolverConfigName()
+}
+
+// GetResolutionTimeout returns the resolution-timeout of the resolver
+// configuration, or the default timeout of the framework if not set.
+func (r *Resolver) GetResolutionTimeout(ctx context.Context, defaultTimeout time.Duration) time.Duration {
+	if v, ok := framework.GetResolverConfigFromContext(ctx)["resolution-timeout"]; ok {
+		if timeout, err := time.ParseDuration(v); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return defaultTimeout
 }
 
 // GetSelector returns a map of labels to match requests to this Resolver.
 func (r *Resolver) GetSelector(context.Context) map[string]string {
 	return map[string]string{
-		common.LabelKeyResolverType: LabelValueWrapResolverType,
+		common.LabelKeyResolverType: ResolverType(),
 	}
 }
 
-// ValidateParams ensures parameters from a request are as expected.
+// ValidateParams ensures parameters from a request are as expected. The
+// missing and invalid params are all listed in a *ParamsError.
 func (r *Resolver) ValidateParams(ctx context.Context, params map[string]string) error {
-	_, err := populateParamsWithDefaults(ctx, params)
+	if err := ValidateConfig(framework.GetResolverConfigFromContext(ctx)); err != nil {
+		return err
+	}
+	ctx, err := r.withClusterEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = populateParamsWithDefaults(ctx, params)
 	return err
 }
 
@@ -95,164 +483,773 @@ func (r *Resolver) ValidateParams(ctx context.Context, params map[string]string)
 func (r *Resolver) Resolve(ctx context.Context, origParams map[string]string) (framework.ResolvedResource, error) {
 	logger := logging.FromContext(ctx)
 
-	baseimage := DefaultBaseImage
 	namespace := common.RequestNamespace(ctx)
+	ctx, err := r.withClusterEnvironment(ctx)
+	if err != nil {
+		logger.Infof("wrap resolver configuration invalid: %v", err)
+		return nil, err
+	}
 	params, err := populateParamsWithDefaults(ctx, origParams)
 	if err != nil {
 		logger.Infof("wrap resolver parameter(s) invalid: %v", err)
 		return nil, err
 	}
 
-	pipeline, err := r.pipelineClientSet.TektonV1beta1().Pipelines(namespace).Get(ctx, params[PipelineRefParam], metav1.GetOptions{})
+	if name, ok := params[TaskRefParam]; ok {
+		task, err := r.getTask(ctx, name)
+		if err != nil {
+			logger.Infof("failed to load task %s from namespace %s: %v", name, namespace, err)
+			return nil, err
+		}
+		return r.resolveTask(ctx, params, task)
+	}
+	if names, ok := params[BatchParam]; ok {
+		return r.resolveBatch(ctx, params, strings.Split(names, ","))
+	}
+	return r.resolvePipelineRef(ctx, params)
+}
+
+// resolvePipelineRef fetches the Pipeline of the params, populated with
+// their defaults, and wraps it, unless the wrapped-pipeline-cache holds it.
+func (r *Resolver) resolvePipelineRef(ctx context.Context, params map[string]string) (framework.ResolvedResource, error) {
+	logger := logging.FromContext(ctx)
+	namespace := common.RequestNamespace(ctx)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout(framework.GetResolverConfigFromContext(ctx)))
+	var pipeline *v1beta1.Pipeline
+	var data []byte
+	var err error
+	if content, ok := params[PipelineYAMLParam]; ok {
+		pipeline, err = inlinePipeline(fetchCtx, namespace, params[PipelineRefParam], content)
+		data = []byte(content)
+	} else if repo, ok := params[PipelineURLParam]; ok {
+		pipeline, data, err = r.getGitPipeline(fetchCtx, namespace, repo, params[PipelineRevisionParam], params[PipelineRefParam])
+	} else if bundle, ok := params[BundleParam]; ok {
+		pipeline, data, err = r.getBundlePipeline(fetchCtx, namespace, bundle, params[BundleNameParam])
+	} else if catalog, ok := params[CatalogParam]; ok {
+		pipeline, data, err = r.getHubPipeline(fetchCtx, namespace, catalog, params[PipelineRefParam], params[VersionParam])
+	} else if source, ok := params[NamespaceParam]; ok && source != namespace {
+		if err = r.authorizeNamespace(fetchCtx, framework.GetResolverConfigFromContext(ctx), namespace, source, params[PipelineRefParam]); err == nil {
+			pipeline, data, err = r.getPipeline(fetchCtx, source, params[PipelineRefParam])
+		}
+		// Its Tasks are the ones of its namespace
+		ctx = withTaskNamespace(ctx, source)
+	} else {
+		pipeline, data, err = r.getPipeline(fetchCtx, namespace, params[PipelineRefParam])
+	}
+	cancel()
 	if err != nil {
 		logger.Infof("failed to load pipeline %s from namespace %s: %v", params[PipelineRefParam], namespace, err)
 		return nil, err
 	}
-
-	workspaces := sets.NewString(strings.Split(params[WorkspacesParam], ",")...)
-
-	// Resolve tasks from Pipeline to embedded and mutate them
-	taskSpecs, err := r.resolveTaskSpecs(ctx, &pipeline.Spec)
+	original := newOriginalPipeline(pipeline, data)
+	var entry *cacheEntry
+	if dryRun, _ := strconv.ParseBool(params[DryRunParam]); !dryRun {
+		entry, err = r.cacheEntry(ctx, params, pipeline, original)
+	}
+	if err != nil {
+		logger.Infof("failed to look up the wrapped pipeline cache for %s from namespace %s: %v", params[PipelineRefParam], namespace, err)
+	} else if entry != nil {
+		if cached := r.cached(ctx, entry); cached != nil {
+			return cached, nil
+		}
+		// Resume from the Tasks fetched for their digests
+		for name, task := range entry.Tasks {
+			r.partial.add(pipeline, name, task)
+		}
+	}
+	resolved, err := r.resolvePipeline(ctx, params, pipeline)
 	if err != nil {
-		logger.Infof("failed to resolve task specs from pipeline %s in namespace %s: %v", params[PipelineRefParam], namespace, err)
 		return nil, err
 	}
+	resolved.Original = original
+	resolved.KeepOriginal, _ = strconv.ParseBool(params[KeepOriginalParam])
+	if entry != nil {
+		r.cache(ctx, entry, pipeline, resolved)
+	}
+	return resolved, nil
+}
 
-	newPipeline := pipeline.DeepCopy()
-	wtargetimages := map[string]string{}
-	for _, w := range workspaces.List() {
-		wtargetimages[w] = strings.ReplaceAll(params[TargetParam], "{{workspace}}", w)
-	}
-
-	for i, t := range newPipeline.Spec.Tasks {
-		taskWorkspaces := make([]string, len(t.Workspaces))
-		for j, w := range t.Workspaces {
-			taskWorkspaces[j] = w.Workspace
-		}
-		// Skip if not using the workspace
-		if !workspaces.HasAny(taskWorkspaces...) {
-			continue
-		}
-
-		s := taskSpecs[t.Name]
-		// Except the first task, add a step to extract workspace content
-		if i != 0 {
-			var script strings.Builder
-			fmt.Fprintf(&script, "#!/busybox/sh -e\n")
-			for _, pw := range t.Workspaces {
-				if workspaces.Has(pw.Workspace) {
-					baseimage = wtargetimages[pw.Workspace]
-					var w v1beta1.WorkspaceDeclaration
-					for _, d := range s.Workspaces {
-						if d.Name == pw.Name {
-							w = d
-						}
-					}
-					fmt.Fprintf(&script, `echo "Extract workspace content from %s in %s"
-crane export %s | tar -x -C %s
-`, baseimage, w.GetMountPath(), baseimage, w.GetMountPath())
-				}
-			}
-			s.Steps = append([]v1beta1.Step{{
-				Name:       "import-workspace",
-				Image:      "gcr.io/go-containerregistry/crane:debug",
-				WorkingDir: "/",
-				Script:     script.String(),
-			}}, s.Steps...)
-		}
-
-		var script strings.Builder
-		fmt.Fprintf(&script, "#!/busybox/sh -e\n")
-		for _, pw := range t.Workspaces {
-			if workspaces.Has(pw.Workspace) {
-				if i != 0 {
-					baseimage = wtargetimages[pw.Workspace]
-				}
-				var w v1beta1.WorkspaceDeclaration
-				for _, d := range s.Workspaces {
-					if d.Name == pw.Name {
-						w = d
-					}
-				}
-				fmt.Fprintf(&script, `echo "Export workspace content from %s to %s"
-(cd %s && tar -f - -c . | crane append -b %s -t %s -f -)
-`, w.GetMountPath(), wtargetimages[pw.Workspace], w.GetMountPath(), baseimage, wtargetimages[pw.Workspace])
-			}
-		}
-		s.Steps = append(s.Steps, v1beta1.Step{
-			Name:       "export-workspace",
-			Image:      "gcr.io/go-containerregistry/crane:debug",
-			WorkingDir: "/",
-			Script:     script.String(),
-		})
-		newPipeline.Spec.Tasks[i].TaskRef = nil
-		newPipeline.Spec.Tasks[i].TaskSpec.TaskSpec = *s
+// resolvePipeline wraps the Pipeline with the params, populated with their
+// defaults.
+func (r *Resolver) resolvePipeline(ctx context.Context, params map[string]string, pipeline *v1beta1.Pipeline) (*ResolvedWrapperResource, error) {
+	ctx = withTraceID(ctx, newTraceID())
+	logger := logging.FromContext(ctx)
+	namespace := common.RequestNamespace(ctx)
+
+	fetched := pipeline.DeepCopy()
+	newPipeline, effective, err := r.wrapPipeline(ctx, params, pipeline)
+	if err != nil {
+		return nil, err
 	}
 
-	newPipeline.Kind = "Pipeline"
-	newPipeline.APIVersion = "tekton.dev/v1beta1"
-	data, err := yaml.Marshal(newPipeline)
+	data, err := marshalPipeline(ctx, newPipeline, params[OutputAPIVersionParam])
 	if err != nil {
 		logger.Infof("failed to marshal pipeline %s from namespace %s: %v", params[PipelineRefParam], namespace, err)
 		return nil, err
 	}
 
-	return &ResolvedWrapperResource{
+	logger.Infof("wrapped pipeline %s from namespace %s", params[PipelineRefParam], namespace)
+	resolved := &ResolvedWrapperResource{
 		Content:     data,
 		PipelineRef: params[PipelineRefParam],
-	}, nil
+		Effective:   effective,
+		TraceID:     traceIDFromContext(ctx),
+		ImageDrifts: r.imageDigests.of(effective.Images),
+	}
+	if dryRun, _ := strconv.ParseBool(params[DryRunParam]); dryRun {
+		resolved.Summary = summarize(fetched, newPipeline, effective)
+	}
+	return resolved, nil
+}
+
+// wrapPipeline checks that the Pipeline is eligible, and wraps it between
+// the Before and After mutators, followed by the post-process hooks of the
+// configuration, annotating the enable-api-fields it then requires.
+func (r *Resolver) wrapPipeline(ctx context.Context, params map[string]string, pipeline *v1beta1.Pipeline) (*v1beta1.Pipeline, *EffectiveSettings, error) {
+	logger := logging.FromContext(ctx)
+	namespace := common.RequestNamespace(ctx)
+
+	if err := checkPipelineSelector(framework.GetResolverConfigFromContext(ctx), pipeline); err != nil {
+		logger.Infof("pipeline %s from namespace %s not eligible for wrapping: %v", pipeline.Name, namespace, err)
+		return nil, nil, err
+	}
+
+	if err := r.Before.Mutate(ctx, pipeline); err != nil {
+		logger.Infof("failed to mutate pipeline %s from namespace %s: %v", pipeline.Name, namespace, err)
+		return nil, nil, err
+	}
+	newPipeline, effective, err := r.wrap(ctx, params, pipeline)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.After.Mutate(ctx, newPipeline); err != nil {
+		logger.Infof("failed to mutate wrapped pipeline %s from namespace %s: %v", pipeline.Name, namespace, err)
+		return nil, nil, err
+	}
+	if err := postProcess(ctx, framework.GetResolverConfigFromContext(ctx), newPipeline); err != nil {
+		logger.Infof("failed to post-process wrapped pipeline %s from namespace %s: %v", pipeline.Name, namespace, err)
+		return nil, nil, err
+	}
+
+	apiFields, err := requiredAPIFields(ctx, newPipeline)
+	if err != nil {
+		// Probably invalid before wrapping, left to the PipelineRun
+		logger.Infof("wrapped pipeline %s from namespace %s is invalid: %v", pipeline.Name, namespace, err)
+	} else {
+		if newPipeline.Annotations == nil {
+			newPipeline.Annotations = map[string]string{}
+		}
+		newPipeline.Annotations[APIFieldsAnnotation] = apiFields
+		if err := r.checkFeatureFlags(ctx, framework.GetResolverConfigFromContext(ctx), apiFields); err != nil {
+			logger.Infof("wrapped pipeline %s from namespace %s not supported: %v", pipeline.Name, namespace, err)
+			return nil, nil, err
+		}
+	}
+	required := requiredTektonVersion(newPipeline, params[OutputAPIVersionParam])
+	if v := required.String(); v != "" {
+		if newPipeline.Annotations == nil {
+			newPipeline.Annotations = map[string]string{}
+		}
+		newPipeline.Annotations[TektonVersionAnnotation] = v
+	}
+	if err := r.checkTektonVersion(ctx, framework.GetResolverConfigFromContext(ctx), required); err != nil {
+		logger.Infof("wrapped pipeline %s from namespace %s not supported: %v", pipeline.Name, namespace, err)
+		return nil, nil, err
+	}
+	return newPipeline, effective, nil
+}
+
+// wrap returns the Pipeline wrapped with the params, and the effective
+// settings it was wrapped with.
+func (r *Resolver) wrap(ctx context.Context, params map[string]string, pipeline *v1beta1.Pipeline) (*v1beta1.Pipeline, *EffectiveSettings, error) {
+	w, err := r.newWrapping(ctx, params, pipeline)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range w.wrapped.Spec.Tasks {
+		if err := w.wrapTask(i); err != nil {
+			return nil, nil, err
+		}
+	}
+	if w.order {
+		orderTasks(&w.wrapped.Spec, w.imports)
+	}
+	if err := w.addFinally(); err != nil {
+		return nil, nil, err
+	}
+	if err := r.finishWrapping(ctx, w); err != nil {
+		return nil, nil, err
+	}
+	settings := effectiveSettings(w.params, w.targets, w.wrappers, w.stepOpts)
+	settings.Features = w.used.list()
+	return w.wrapped, settings, nil
 }
 
-func (r *Resolver) resolveTaskSpecs(ctx context.Context, pipelineSpec *v1beta1.PipelineSpec) (map[string]*v1beta1.TaskSpec, error) {
+// effectiveSettings returns the settings the Pipeline is wrapped with.
+func effectiveSettings(params, targets map[string]string, w wrappers, o stepOptions) *EffectiveSettings {
+	images := map[string]string{}
+	if w.uses(OCIWrapper) {
+		images["crane"] = o.CraneImage
+		images["base"] = o.BaseImage
+		images["wrapstep"] = o.WrapstepImage
+	}
+	if w.uses(ArchiveWrapper) {
+		images["wrapstep"] = o.WrapstepImage
+	}
+	if w.uses(S3Wrapper) {
+		images["s3"] = o.S3.Image
+	}
+	if w.uses(GCSWrapper) {
+		images["gcs"] = o.GCS.Image
+	}
+	if w.uses(RsyncWrapper) {
+		images["rsync"] = o.Rsync.Image
+	}
+	for name, t := range o.Templates {
+		if w.uses(name) {
+			images[name] = t.Image
+		}
+	}
+	if o.Lineage.URL != "" {
+		images["openlineage"] = o.Lineage.Image
+	}
+	return &EffectiveSettings{
+		Params:  params,
+		Targets: targets,
+		Images:  images,
+	}
+}
+
+func (r *Resolver) resolveTaskSpecs(ctx context.Context, pipeline *v1beta1.Pipeline) (map[string]*v1beta1.TaskSpec, map[string]contract, sets.String, error) {
+	// Resume from the tasks resolved by a previous request that didn't
+	// finish, e.g. because it timed out
+	resolved := r.partial.get(pipeline)
 	taskSpecs := map[string]*v1beta1.TaskSpec{}
-	for _, t := range pipelineSpec.Tasks {
-		var taskSpec *v1beta1.TaskSpec
-		if t.TaskRef == nil {
+	contracts := map[string]contract{}
+	skipped := sets.NewString()
+	progress := newProgress(ctx, r.recorder, pipeline)
+	for _, t := range pipeline.Spec.Tasks {
+		var annotations map[string]string
+		if task, ok := resolved[t.Name]; ok {
+			taskSpecs[t.Name], annotations = &task.Spec, task.Annotations
+			progress.taskResolved()
+		} else if t.TaskRef == nil {
 			// Embedded TaskSpec, get it straight
-			taskSpec = &t.TaskSpec.TaskSpec
+			taskSpecs[t.Name], annotations = &t.TaskSpec.TaskSpec, t.TaskSpec.Metadata.Annotations
+			progress.taskResolved()
 		} else {
-			var err error
-			taskSpec, err = r.getTaskSpec(ctx, t.Name)
+			task, err := r.getPipelineTask(ctx, t.TaskRef)
 			if err != nil {
-				return nil, fmt.Errorf("couldn't fetch taskspec for %s: %v", t.Name, err)
+				return nil, nil, nil, fmt.Errorf("couldn't fetch taskspec for %s (%d/%d tasks resolved, kept for the next request): %v", t.Name, len(taskSpecs), len(pipeline.Spec.Tasks), err)
 			}
+			r.partial.add(pipeline, t.Name, task)
+			taskSpecs[t.Name], annotations = &task.Spec, task.Annotations
+			progress.taskResolved()
+		}
+		c, err := parseContract(annotations)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid workspace contract of task %s: %v", t.Name, err)
+		}
+		contracts[t.Name] = c
+		skip, err := skipAnnotated(annotations)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("task %s: %v", t.Name, err)
+		}
+		if skip {
+			skipped.Insert(t.Name)
 		}
-		taskSpecs[t.Name] = taskSpec
 	}
-	return taskSpecs, nil
+	r.partial.done(pipeline)
+	progress.done()
+	return taskSpecs, contracts, skipped, nil
 }
 
-func (r *Resolver) getTaskSpec(ctx context.Context, name string) (*v1beta1.TaskSpec, error) {
-	namespace := common.RequestNamespace(ctx)
-	t, err := r.pipelineClientSet.TektonV1beta1().Tasks(namespace).Get(ctx, name, metav1.GetOptions{})
+func (r *Resolver) getTask(ctx context.Context, name string) (*v1beta1.Task, error) {
+	conf := framework.GetResolverConfigFromContext(ctx)
+	source, err := newGitTaskSource(conf)
 	if err != nil {
 		return nil, err
 	}
-	return &t.Spec, nil
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout(conf))
+	defer cancel()
+	if source != nil {
+		return source.task(ctx, name)
+	}
+	return r.getClusterTask(ctx, taskNamespace(ctx), name)
+}
+
+// getPipelineTask fetches the Task of the taskRef of a pipeline task: from
+// its bundle if it holds one, else like getTask.
+func (r *Resolver) getPipelineTask(ctx context.Context, ref *v1beta1.TaskRef) (*v1beta1.Task, error) {
+	if ref.Bundle == "" {
+		return r.getTask(ctx, ref.Name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout(framework.GetResolverConfigFromContext(ctx)))
+	defer cancel()
+	return r.getBundleTask(ctx, common.RequestNamespace(ctx), ref.Bundle, ref.Name)
 }
 
 func populateParamsWithDefaults(ctx context.Context, params map[string]string) (map[string]string, error) {
 	conf := framework.GetResolverConfigFromContext(ctx)
 
-	var missingParams []string
+	errs := &ParamsError{}
+
+	if err := renderParamTemplates(params); err != nil {
+		// The other params may hold the templates
+		errs.invalid(err)
+		return nil, errs
+	}
+	if err := renderRequestVariables(ctx, params); err != nil {
+		errs.invalid(err)
+		return nil, errs
+	}
+	if err := renderTargetPrefix(ctx, conf, params); err != nil {
+		errs.invalid(err)
+		return nil, errs
+	}
+	renderDefaultTarget(conf, params)
+
+	if _, ok := params[TaskRefParam]; ok {
+		if _, ok := params[PipelineRefParam]; ok {
+			errs.invalid(fmt.Errorf("%s and %s are mutually exclusive", PipelineRefParam, TaskRefParam))
+		}
+		// The finally tasks these add don't fit in a Task, their defaults
+		// don't apply
+		for _, name := range pipelineOnlyParams {
+			if v := params[name]; v != "" && v != "false" {
+				errs.invalid(fmt.Errorf("%s only applies to %s", name, PipelineRefParam))
+			}
+		}
+		for _, name := range []string{LatestAliasParam, TransactionalParam, VerifyParam, LineageResultParam} {
+			params[name] = "false"
+		}
+	}
 
 	if _, ok := params[WrapperParam]; !ok {
 		if wrapperVal, ok := conf["default-wrapper"]; !ok {
-			missingParams = append(missingParams, WrapperParam)
+			errs.missing(WrapperParam)
 		} else {
 			params[WrapperParam] = wrapperVal
 		}
 	}
+	templates, err := parseWrapperTemplates(conf["wrapper-templates"])
+	if err != nil {
+		errs.invalid(fmt.Errorf("invalid wrapper-templates in %s: %v", ResolverConfigName(), err))
+	}
+	wrappers, err := parseWrappers(params[WrapperParam], templates)
+	if err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", WrapperParam, err))
+	}
+	for _, s := range wrapperStrategies {
+		if _, ok := params[s.targetParam]; !ok && s.targetParam != "" && s.targetParam != TargetParam && wrappers.uses(s.name) {
+			errs.invalid(fmt.Errorf("%s is required by the %s wrapper", s.targetParam, s.name))
+		}
+	}
+
+	if _, ok := params[StripWorkspacesParam]; !ok {
+		if stripVal, ok := conf["default-strip-workspaces"]; ok {
+			params[StripWorkspacesParam] = stripVal
+		} else {
+			params[StripWorkspacesParam] = "false"
+		}
+	}
+	if _, err := strconv.ParseBool(params[StripWorkspacesParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", StripWorkspacesParam, err))
+	}
+
+	if _, ok := params[WorkspaceSizeParam]; !ok {
+		if sizeVal, ok := conf["default-workspace-size"]; ok {
+			params[WorkspaceSizeParam] = sizeVal
+		}
+	}
+	if _, err := parseWorkspaceSizes(params[WorkspaceSizeParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", WorkspaceSizeParam, err))
+	}
 
-	if _, ok := params[PipelineRefParam]; !ok {
-		missingParams = append(missingParams, PipelineRefParam)
+	if _, ok := params[BaseImageParam]; !ok {
+		if baseVal, ok := conf["default-base-image"]; ok {
+			params[BaseImageParam] = baseVal
+		}
 	}
-	if _, ok := params[TargetParam]; !ok {
-		missingParams = append(missingParams, TargetParam)
+	if v, ok := params[BaseImageParam]; ok {
+		if err := validateReference(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", BaseImageParam, err))
+		}
+	}
+	if v, ok := params[CraneImageParam]; ok {
+		if err := validateReference(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", CraneImageParam, err))
+		}
+	}
+
+	if _, ok := params[ArtifactTypeParam]; !ok {
+		if artifactTypeVal, ok := conf["default-artifact-type"]; ok {
+			params[ArtifactTypeParam] = artifactTypeVal
+		}
+	}
+	if _, err := parseArtifactTypes(params[ArtifactTypeParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", ArtifactTypeParam, err))
+	}
+
+	if _, ok := params[SharedTargetParam]; !ok {
+		params[SharedTargetParam] = "false"
+	}
+	if _, err := strconv.ParseBool(params[SharedTargetParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", SharedTargetParam, err))
+	}
+
+	if _, ok := params[ExportChunksParam]; !ok {
+		if chunksVal, ok := conf["default-export-chunks"]; ok {
+			params[ExportChunksParam] = chunksVal
+		} else {
+			params[ExportChunksParam] = "1"
+		}
+	}
+	if chunks, err := strconv.Atoi(params[ExportChunksParam]); err != nil || chunks < 1 {
+		errs.invalid(fmt.Errorf("invalid value for %s: %q is not a positive integer", ExportChunksParam, params[ExportChunksParam]))
+	}
+
+	if _, ok := params[CompressionParam]; !ok {
+		if compressionVal, ok := conf["default-compression"]; ok {
+			params[CompressionParam] = compressionVal
+		}
+	}
+	if v, ok := params[CompressionParam]; ok {
+		if err := validateCompression(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", CompressionParam, err))
+		}
+	}
+
+	if _, ok := params[ExcludeParam]; !ok {
+		if excludeVal, ok := conf["default-exclude"]; ok {
+			params[ExcludeParam] = excludeVal
+		}
+	}
+	if _, err := parseExclude(params[ExcludeParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", ExcludeParam, err))
+	}
+
+	if _, ok := params[AllowMissingBaseParam]; !ok {
+		if allowVal, ok := conf["default-allow-missing-base"]; ok {
+			params[AllowMissingBaseParam] = allowVal
+		}
+	}
+	if v, ok := params[AllowMissingBaseParam]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", AllowMissingBaseParam, err))
+		}
+	}
+
+	if _, ok := params[ImportWaitTimeoutParam]; !ok {
+		if waitVal, ok := conf["default-import-wait-timeout"]; ok {
+			params[ImportWaitTimeoutParam] = waitVal
+		}
+	}
+	if v, ok := params[ImportWaitTimeoutParam]; ok {
+		if wait, err := time.ParseDuration(v); err != nil || wait < 0 {
+			errs.invalid(fmt.Errorf("invalid value for %s: %q is not a duration", ImportWaitTimeoutParam, v))
+		}
+	}
+
+	if _, ok := params[ExportFailureParam]; !ok {
+		if failureVal, ok := conf["default-export-failure"]; ok {
+			params[ExportFailureParam] = failureVal
+		} else {
+			params[ExportFailureParam] = ExportFailureFail
+		}
+	}
+	if v := params[ExportFailureParam]; v != ExportFailureFail && v != ExportFailureWarn {
+		errs.invalid(fmt.Errorf("invalid value for %s: %q is neither %q nor %q", ExportFailureParam, v, ExportFailureFail, ExportFailureWarn))
+	}
+
+	if _, ok := params[AlwaysExportParam]; !ok {
+		if alwaysVal, ok := conf["default-always-export"]; ok {
+			params[AlwaysExportParam] = alwaysVal
+		} else {
+			params[AlwaysExportParam] = "false"
+		}
+	}
+	if _, err := strconv.ParseBool(params[AlwaysExportParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", AlwaysExportParam, err))
+	}
+
+	if _, ok := params[ArtifactResultsParam]; !ok {
+		if artifactVal, ok := conf["default-artifact-results"]; ok {
+			params[ArtifactResultsParam] = artifactVal
+		} else {
+			params[ArtifactResultsParam] = "false"
+		}
+	}
+	if _, err := strconv.ParseBool(params[ArtifactResultsParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", ArtifactResultsParam, err))
+	}
+
+	if _, ok := params[ExportOnFailureParam]; !ok {
+		if onFailureVal, ok := conf["default-export-on-failure"]; ok {
+			params[ExportOnFailureParam] = onFailureVal
+		} else {
+			params[ExportOnFailureParam] = "false"
+		}
+	}
+	if _, err := parseExportOnFailure(params[ExportOnFailureParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", ExportOnFailureParam, err))
+	}
+
+	if _, ok := params[OrderTasksParam]; !ok {
+		if orderVal, ok := conf["default-order-tasks"]; ok {
+			params[OrderTasksParam] = orderVal
+		} else {
+			params[OrderTasksParam] = "false"
+		}
+	}
+	if _, err := strconv.ParseBool(params[OrderTasksParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", OrderTasksParam, err))
+	}
+
+	if _, ok := params[LatestAliasParam]; !ok {
+		if latestVal, ok := conf["default-latest-alias"]; ok {
+			params[LatestAliasParam] = latestVal
+		} else {
+			params[LatestAliasParam] = "false"
+		}
+	}
+	latest, err := strconv.ParseBool(params[LatestAliasParam])
+	if err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", LatestAliasParam, err))
+	}
+	// The final image of a shared target may be the one of another run
+	if shared, _ := strconv.ParseBool(params[SharedTargetParam]); latest && shared {
+		errs.invalid(fmt.Errorf("%s is not supported with %s", LatestAliasParam, SharedTargetParam))
+	}
+
+	if _, ok := params[TransactionalParam]; !ok {
+		if transactionalVal, ok := conf["default-transactional"]; ok {
+			params[TransactionalParam] = transactionalVal
+		} else {
+			params[TransactionalParam] = "false"
+		}
+	}
+	transactional, err := strconv.ParseBool(params[TransactionalParam])
+	if err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", TransactionalParam, err))
+	}
+	// Concurrent runs can't append onto run-scoped tags
+	if shared, _ := strconv.ParseBool(params[SharedTargetParam]); transactional && shared {
+		errs.invalid(fmt.Errorf("%s is not supported with %s", TransactionalParam, SharedTargetParam))
+	}
+
+	if _, ok := params[KeepOriginalParam]; !ok {
+		if keepVal, ok := conf["default-keep-original"]; ok {
+			params[KeepOriginalParam] = keepVal
+		} else {
+			params[KeepOriginalParam] = "false"
+		}
+	}
+	if _, err := strconv.ParseBool(params[KeepOriginalParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", KeepOriginalParam, err))
+	}
+
+	if v, ok := params[ForceParam]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", ForceParam, err))
+		}
+	}
+	if v, ok := params[DryRunParam]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", DryRunParam, err))
+		}
+	}
+
+	if _, ok := params[VerifyParam]; !ok {
+		if verifyVal, ok := conf["default-verify"]; ok {
+			params[VerifyParam] = verifyVal
+		} else {
+			params[VerifyParam] = "false"
+		}
+	}
+	verify, err := strconv.ParseBool(params[VerifyParam])
+	if err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", VerifyParam, err))
+	}
+	if _, ok := params[VerifyScriptParam]; ok && !verify {
+		errs.invalid(fmt.Errorf("%s requires %s", VerifyScriptParam, VerifyParam))
+	}
+
+	if _, ok := params[OutputAPIVersionParam]; !ok {
+		if apiVersionVal, ok := conf["default-output-apiversion"]; ok {
+			params[OutputAPIVersionParam] = apiVersionVal
+		} else {
+			params[OutputAPIVersionParam] = APIVersionV1beta1
+		}
+	}
+	if v := params[OutputAPIVersionParam]; v != APIVersionV1beta1 && v != APIVersionV1 {
+		errs.invalid(fmt.Errorf("invalid value for %s: %q is neither %q nor %q", OutputAPIVersionParam, v, APIVersionV1beta1, APIVersionV1))
+	}
+
+	if _, ok := params[LineageResultParam]; !ok {
+		if lineageVal, ok := conf["default-lineage-result"]; ok {
+			params[LineageResultParam] = lineageVal
+		} else {
+			params[LineageResultParam] = "false"
+		}
+	}
+	if _, err := strconv.ParseBool(params[LineageResultParam]); err != nil {
+		errs.invalid(fmt.Errorf("invalid value for %s: %v", LineageResultParam, err))
+	}
+
+	if v := params[EphemeralWorkspacesParam]; v != "" {
+		// Other runs read shared targets
+		if shared, _ := strconv.ParseBool(params[SharedTargetParam]); shared {
+			errs.invalid(fmt.Errorf("%s is not supported with %s", EphemeralWorkspacesParam, SharedTargetParam))
+		}
+	}
+
+	if v, ok := params[WorkspaceManifestParam]; ok && v != manifestResult {
+		if err := validateReference(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: neither %q nor an image reference: %v", WorkspaceManifestParam, manifestResult, err))
+		}
+	}
+
+	if v, ok := params[TargetParam]; ok {
+		if err := validateResultRefs(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", TargetParam, err))
+		}
+	}
+
+	if v, ok := params[ArchiveTargetParam]; ok {
+		if err := validateResultRefs(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", ArchiveTargetParam, err))
+		}
+		// Other runs read shared targets
+		if shared, _ := strconv.ParseBool(params[SharedTargetParam]); shared {
+			errs.invalid(fmt.Errorf("%s is not supported with %s", ArchiveTargetParam, SharedTargetParam))
+		}
+	}
+
+	if _, err := parseTaskFilter(params); err != nil {
+		errs.invalid(err)
+	}
+
+	if v := params[ImportSourceParam]; v != "" {
+		if err := FeatureImportSource.check(conf); err != nil {
+			errs.invalid(err)
+		}
+		if _, err := parseImportSources(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", ImportSourceParam, err))
+		}
+	}
+
+	if bundle, ok := params[BundleParam]; ok {
+		if err := validateReference(bundle); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", BundleParam, err))
+		}
+		name, ok := params[BundleNameParam]
+		if !ok || name == "" {
+			errs.missing(BundleNameParam)
+		} else if ref, ok := params[PipelineRefParam]; ok && ref != name {
+			errs.invalid(fmt.Errorf("%s and %s are mutually exclusive, %s names the Pipeline of the bundle", PipelineRefParam, BundleParam, BundleNameParam))
+		} else {
+			// For the annotations and the templates
+			params[PipelineRefParam] = name
+		}
+		for _, p := range []string{TaskRefParam, PipelineURLParam} {
+			if _, ok := params[p]; ok {
+				errs.invalid(fmt.Errorf("%s and %s are mutually exclusive", p, BundleParam))
+			}
+		}
+	} else if _, ok := params[BundleNameParam]; ok {
+		errs.invalid(fmt.Errorf("%s requires %s", BundleNameParam, BundleParam))
+	}
+	if v, ok := params[CatalogParam]; ok {
+		if v == "" {
+			errs.invalid(fmt.Errorf("invalid value for %s: empty", CatalogParam))
+		}
+		if params[VersionParam] == "" {
+			errs.missing(VersionParam)
+		}
+		for _, p := range []string{TaskRefParam, PipelineURLParam, BundleParam} {
+			if _, ok := params[p]; ok {
+				errs.invalid(fmt.Errorf("%s and %s are mutually exclusive", p, CatalogParam))
+			}
+		}
+	} else if _, ok := params[VersionParam]; ok {
+		errs.invalid(fmt.Errorf("%s requires %s", VersionParam, CatalogParam))
+	}
+	if v, ok := params[BatchParam]; ok {
+		if err := validateBatch(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", BatchParam, err))
+		}
+		for _, p := range []string{PipelineRefParam, TaskRefParam, PipelineURLParam, BundleParam, CatalogParam} {
+			if _, ok := params[p]; ok {
+				errs.invalid(fmt.Errorf("%s and %s are mutually exclusive", p, BatchParam))
+			}
+		}
+	}
+	if v, ok := params[PipelineYAMLParam]; ok {
+		for _, p := range []string{TaskRefParam, PipelineURLParam, BundleParam, CatalogParam, BatchParam} {
+			if _, ok := params[p]; ok {
+				errs.invalid(fmt.Errorf("%s and %s are mutually exclusive", p, PipelineYAMLParam))
+			}
+		}
+		if p, err := inlinePipeline(ctx, "", params[PipelineRefParam], v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", PipelineYAMLParam, err))
+		} else {
+			// For the annotations and the templates
+			params[PipelineRefParam] = p.Name
+		}
+	}
+	if v, ok := params[NamespaceParam]; ok {
+		for _, p := range []string{TaskRefParam, PipelineURLParam, BundleParam, CatalogParam, PipelineYAMLParam} {
+			if _, ok := params[p]; ok {
+				errs.invalid(fmt.Errorf("%s and %s are mutually exclusive", p, NamespaceParam))
+			}
+		}
+		if msgs := validation.IsDNS1123Label(v); len(msgs) > 0 {
+			errs.invalid(fmt.Errorf("invalid value for %s: %s", NamespaceParam, strings.Join(msgs, ", ")))
+		}
+	}
+	_, pipelineRef := params[PipelineRefParam]
+	_, taskRef := params[TaskRefParam]
+	_, bundle := params[BundleParam]
+	_, batch := params[BatchParam]
+	if !taskRef && !pipelineRef && !bundle && !batch {
+		errs.missing(PipelineRefParam)
+	}
+	if v, ok := params[PipelineURLParam]; ok {
+		if v == "" {
+			errs.invalid(fmt.Errorf("invalid value for %s: empty", PipelineURLParam))
+		}
+		if taskRef {
+			errs.invalid(fmt.Errorf("%s only applies to %s", PipelineURLParam, PipelineRefParam))
+		}
+	} else if _, ok := params[PipelineRevisionParam]; ok {
+		errs.invalid(fmt.Errorf("%s requires %s", PipelineRevisionParam, PipelineURLParam))
+	}
+	if v, ok := params[TargetsParam]; ok {
+		if _, err := parseTargets(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", TargetsParam, err))
+		} else if err := validateResultRefs(v); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", TargetsParam, err))
+		}
+	}
+	validateTaskVariable(params, errs)
+	// The targets of the workspaces are checked once they are known
+	if _, ok := params[TargetParam]; !ok && params[TargetsParam] == "" {
+		errs.missing(TargetParam)
 	}
 	if _, ok := params[WorkspacesParam]; !ok {
-		missingParams = append(missingParams, WorkspacesParam)
+		params[WorkspacesParam] = allWorkspaces
+	}
+	// The ones of allWorkspaces are only known once the Pipeline is fetched
+	if v := params[WorkspacesParam]; v != allWorkspaces {
+		validateWorkspaceParams(conf, params, strings.Split(v, ","), wrappers, errs)
+	} else {
+		validateSampleTargets(params, wrappers, errs)
 	}
 
+	if err := errs.orNil(); err != nil {
+		return nil, err
+	}
 	return params, nil
 }
@@ -0,0 +1,414 @@
+package wrap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+)
+
+func TestPopulateParamsWithDefaults(t *testing.T) {
+	valid := func(extra map[string]string) map[string]string {
+		params := map[string]string{
+			PipelineRefParam: "build",
+			WorkspacesParam:  "source",
+			TargetParam:      "registry.example.com/{{workspace}}",
+		}
+		for k, v := range extra {
+			params[k] = v
+		}
+		return params
+	}
+	for _, tc := range []struct {
+		name        string
+		conf        map[string]string
+		params      map[string]string
+		want        map[string]string
+		wantMissing []string
+		wantInvalid []string
+	}{{
+		name:   "defaults",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: valid(nil),
+		want: map[string]string{
+			WrapperParam:          OCIWrapper,
+			ExportChunksParam:     "1",
+			ExportFailureParam:    ExportFailureFail,
+			SharedTargetParam:     "false",
+			OutputAPIVersionParam: APIVersionV1beta1,
+		},
+	}, {
+		name:   "configured defaults",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-export-chunks": "3", "default-output-apiversion": APIVersionV1},
+		params: valid(nil),
+		want:   map[string]string{ExportChunksParam: "3", OutputAPIVersionParam: APIVersionV1},
+	}, {
+		name:   "params over defaults",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-export-chunks": "3"},
+		params: valid(map[string]string{ExportChunksParam: "2"}),
+		want:   map[string]string{ExportChunksParam: "2"},
+	}, {
+		name:        "all missing",
+		conf:        map[string]string{},
+		params:      map[string]string{},
+		wantMissing: []string{WrapperParam, PipelineRefParam, TargetParam},
+	}, {
+		name:        "missing and invalid",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      map[string]string{PipelineRefParam: "build", ExportChunksParam: "0", VerifyParam: "maybe"},
+		wantMissing: []string{TargetParam},
+		wantInvalid: []string{ExportChunksParam, VerifyParam},
+	}, {
+		name:   "configured import wait timeout",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-import-wait-timeout": "2m"},
+		params: valid(nil),
+		want:   map[string]string{ImportWaitTimeoutParam: "2m"},
+	}, {
+		name:        "invalid import wait timeout",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{ImportWaitTimeoutParam: "-1s"}),
+		wantInvalid: []string{"invalid value for import-wait-timeout"},
+	}, {
+		name:   "all workspaces",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{PipelineRefParam: "build", TargetParam: "registry.example.com/{{workspace}}"},
+		want:   map[string]string{WorkspacesParam: allWorkspaces},
+	}, {
+		name:        "invalid target of all workspaces",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      map[string]string{PipelineRefParam: "build", TargetParam: "Registry/{{workspace}}"},
+		wantInvalid: []string{`invalid value for target: "Registry/workspace" is not a valid image reference`},
+	}, {
+		name:        "s3 wrapper without target",
+		conf:        map[string]string{},
+		params:      valid(map[string]string{WrapperParam: "source=" + S3Wrapper}),
+		wantInvalid: []string{S3TargetParam},
+	}, {
+		name:        "pipelineref and taskref",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TaskRefParam: "build"}),
+		wantInvalid: []string{"pipelineref and taskref are mutually exclusive"},
+	}, {
+		name:        "batch and pipelineref",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{BatchParam: "build,test"}),
+		wantInvalid: []string{"pipelineref and batch are mutually exclusive"},
+	}, {
+		name: "batch and taskref",
+		conf: map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{
+			BatchParam:      "build,test",
+			TaskRefParam:    "build",
+			WorkspacesParam: "source",
+			TargetParam:     "registry.example.com/{{workspace}}",
+		},
+		wantInvalid: []string{"taskref and batch are mutually exclusive"},
+	}, {
+		name: "batch",
+		conf: map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{
+			BatchParam:      "build,test",
+			WorkspacesParam: "source",
+			TargetParam:     "registry.example.com/{{workspace}}",
+		},
+		want: map[string]string{BatchParam: "build,test"},
+	}, {
+		name: "invalid batch",
+		conf: map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{
+			BatchParam:      "build,build",
+			WorkspacesParam: "source",
+			TargetParam:     "registry.example.com/{{workspace}}",
+		},
+		wantInvalid: []string{"invalid value for batch"},
+	}, {
+		name: "bundle",
+		conf: map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{
+			BundleParam:     "registry.example.com/bundle:v1",
+			BundleNameParam: "build",
+			WorkspacesParam: "source",
+			TargetParam:     "registry.example.com/{{workspace}}",
+		},
+		want: map[string]string{PipelineRefParam: "build"},
+	}, {
+		name: "bundle without name",
+		conf: map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{
+			BundleParam:     "registry.example.com/bundle:v1",
+			WorkspacesParam: "source",
+			TargetParam:     "registry.example.com/{{workspace}}",
+		},
+		wantMissing: []string{BundleNameParam},
+	}, {
+		name:        "bundle and taskref",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{BundleParam: "registry.example.com/bundle:v1", BundleNameParam: "build", TaskRefParam: "build"}),
+		wantInvalid: []string{"pipelineref and taskref are mutually exclusive", "taskref and bundle are mutually exclusive"},
+	}, {
+		name: "pipeline-yaml",
+		conf: map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{
+			PipelineYAMLParam: "tasks: []",
+			WorkspacesParam:   "source",
+			TargetParam:       "registry.example.com/{{workspace}}",
+		},
+		want: map[string]string{PipelineRefParam: inlinePipelineName},
+	}, {
+		name:        "pipeline-yaml and taskref",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{PipelineYAMLParam: "tasks: []", TaskRefParam: "build"}),
+		wantInvalid: []string{"pipelineref and taskref are mutually exclusive", "taskref and pipeline-yaml are mutually exclusive"},
+	}, {
+		name:        "invalid pipeline-yaml",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{PipelineYAMLParam: "other: true"}),
+		wantInvalid: []string{"invalid value for pipeline-yaml"},
+	}, {
+		name:   "namespace",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: valid(map[string]string{NamespaceParam: "shared"}),
+		want:   map[string]string{NamespaceParam: "shared"},
+	}, {
+		name:        "invalid namespace",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{NamespaceParam: "Shared"}),
+		wantInvalid: []string{"invalid value for namespace"},
+	}, {
+		name:        "namespace and pipeline-yaml",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{NamespaceParam: "shared", PipelineYAMLParam: "tasks: []"}),
+		wantInvalid: []string{"pipeline-yaml and namespace are mutually exclusive"},
+	}, {
+		name:   "archive target",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: valid(map[string]string{ArchiveTargetParam: "archive.example.com/{{workspace}}"}),
+		want:   map[string]string{ArchiveTargetParam: "archive.example.com/{{workspace}}"},
+	}, {
+		name:        "invalid archive target",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{ArchiveTargetParam: "Archive/{{workspace}}"}),
+		wantInvalid: []string{"invalid value for archive-target for workspace source"},
+	}, {
+		name:        "archive target of a shared target",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{ArchiveTargetParam: "archive.example.com/{{workspace}}", SharedTargetParam: "true"}),
+		wantInvalid: []string{"archive-target is not supported with shared-target"},
+	}, {
+		name:   "default target",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-target": "registry.example.com/wrap/"},
+		params: map[string]string{PipelineRefParam: "build"},
+		want:   map[string]string{TargetParam: "registry.example.com/wrap/{{workspace}}"},
+	}, {
+		name:   "target relative to the default target",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-target": "registry.example.com/wrap"},
+		params: valid(map[string]string{TargetParam: "team/{{workspace}}"}),
+		want:   map[string]string{TargetParam: "registry.example.com/wrap/team/{{workspace}}"},
+	}, {
+		name:   "target over the default target",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-target": "registry.example.com/wrap"},
+		params: valid(map[string]string{TargetParam: "localhost:5000/{{workspace}}"}),
+		want:   map[string]string{TargetParam: "localhost:5000/{{workspace}}"},
+	}, {
+		name:   "configured base image",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-base-image": "mirror.example.com/wrap/base:v1"},
+		params: valid(nil),
+		want:   map[string]string{BaseImageParam: "mirror.example.com/wrap/base:v1"},
+	}, {
+		name:        "invalid base image",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{BaseImageParam: "Mirror/Base"}),
+		wantInvalid: []string{"invalid value for base-image"},
+	}, {
+		name:        "invalid crane image",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{CraneImageParam: "mirror.example.com/crane:Debug!"}),
+		wantInvalid: []string{"invalid value for crane-image"},
+	}, {
+		name:        "invalid dry run",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{DryRunParam: "maybe"}),
+		wantInvalid: []string{"invalid value for dry-run"},
+	}, {
+		name:        "task filters",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{IncludeTasksParam: "build", ExcludeTasksParam: "build"}),
+		wantInvalid: []string{"include-tasks and exclude-tasks both list build"},
+	}, {
+		name:        "catalog without version",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{CatalogParam: "tekton"}),
+		wantMissing: []string{VersionParam},
+	}, {
+		name:        "catalog and bundle",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{CatalogParam: "tekton", VersionParam: "0.1", BundleParam: "registry.example.com/bundle:v1", BundleNameParam: "build"}),
+		wantInvalid: []string{"bundle and catalog are mutually exclusive"},
+	}, {
+		name:        "version without catalog",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{VersionParam: "0.1"}),
+		wantInvalid: []string{"version requires catalog"},
+	}, {
+		name:        "latest alias of a shared target",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{LatestAliasParam: "true", SharedTargetParam: "true"}),
+		wantInvalid: []string{"latest-alias is not supported with shared-target"},
+	}, {
+		name:        "verify script without verify",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{VerifyScriptParam: "true"}),
+		wantInvalid: []string{"verify-script requires verify"},
+	}, {
+		name:        "invalid target",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetParam: "Registry/{{workspace}}"}),
+		wantInvalid: []string{"invalid value for target for workspace source"},
+	}, {
+		name:   "targets without target",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: map[string]string{PipelineRefParam: "build", WorkspacesParam: "source,cache", TargetsParam: `{"source": "registry.example.com/source", "cache": "cache.example.com/cache"}`},
+	}, {
+		name:        "workspace without a target",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      map[string]string{PipelineRefParam: "build", WorkspacesParam: "source,cache", TargetsParam: "source=registry.example.com/source"},
+		wantInvalid: []string{"workspace cache has no target"},
+	}, {
+		name:        "invalid targets",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetsParam: "source"}),
+		wantInvalid: []string{`invalid value for targets: "source" is not <workspace>=<image>`},
+	}, {
+		name:        "targets of an unwrapped workspace",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetsParam: "other=registry.example.com/other"}),
+		wantInvalid: []string{"invalid value for targets: other is not a wrapped workspace"},
+	}, {
+		name:        "targets of an s3 workspace",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{WrapperParam: S3Wrapper, S3TargetParam: "s3://bucket/{{workspace}}.tar", TargetsParam: "source=registry.example.com/source"}),
+		wantInvalid: []string{"the s3 wrapper of workspace source doesn't use target"},
+	}, {
+		name:        "invalid target of targets",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetsParam: "source=Registry/Source"}),
+		wantInvalid: []string{"invalid value for target for workspace source"},
+	}, {
+		name:   "targets relative to the default target",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-target": "registry.example.com/wrap"},
+		params: map[string]string{PipelineRefParam: "build", WorkspacesParam: "source,cache", TargetsParam: "source=team/source, cache=cache.example.com/cache"},
+		want:   map[string]string{TargetsParam: "cache=cache.example.com/cache,source=registry.example.com/wrap/team/source"},
+	}, {
+		name:   "run variable",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: valid(map[string]string{TargetParam: "registry.example.com/{{workspace}}:{{context.pipelineRun.name}}"}),
+		want:   map[string]string{TargetParam: "registry.example.com/{{workspace}}:$(context.pipelineRun.name)"},
+	}, {
+		name:   "pipeline and task variables",
+		conf:   map[string]string{"default-wrapper": OCIWrapper},
+		params: valid(map[string]string{TargetParam: "registry.example.com/{{pipeline}}/{{workspace}}:{{task}}"}),
+		want:   map[string]string{TargetParam: "registry.example.com/{{pipeline}}/{{workspace}}:{{task}}"},
+	}, {
+		name:        "namespace variable without a namespace",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetParam: "registry.example.com/{{namespace}}/{{workspace}}"}),
+		wantInvalid: []string{"{{namespace}} requires the namespace of the request"},
+	}, {
+		name:        "task variable in the repository",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetParam: "registry.example.com/{{task}}/{{workspace}}"}),
+		wantInvalid: []string{"holds {{task}} outside of its tag"},
+	}, {
+		name:        "task variable of a latest alias",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{TargetParam: "registry.example.com/{{workspace}}:{{task}}", LatestAliasParam: "true"}),
+		wantInvalid: []string{"latest-alias is not supported with {{task}} in target"},
+	}, {
+		name:   "configured compression",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-compression": "zstd"},
+		params: valid(nil),
+		want:   map[string]string{CompressionParam: "zstd"},
+	}, {
+		name:        "invalid compression",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{CompressionParam: "lz4"}),
+		wantInvalid: []string{"invalid value for compression"},
+	}, {
+		name:   "configured exclude",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-exclude": "node_modules,.git"},
+		params: valid(nil),
+		want:   map[string]string{ExcludeParam: "node_modules,.git"},
+	}, {
+		name:        "invalid exclude",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{ExcludeParam: "/etc"}),
+		wantInvalid: []string{"invalid value for exclude"},
+	}, {
+		name:   "configured allow-missing-base",
+		conf:   map[string]string{"default-wrapper": OCIWrapper, "default-allow-missing-base": "true"},
+		params: valid(nil),
+		want:   map[string]string{AllowMissingBaseParam: "true"},
+	}, {
+		name:        "invalid allow-missing-base",
+		conf:        map[string]string{"default-wrapper": OCIWrapper},
+		params:      valid(map[string]string{AllowMissingBaseParam: "maybe"}),
+		wantInvalid: []string{"invalid value for allow-missing-base"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := framework.InjectResolverConfigToContext(context.Background(), tc.conf)
+			got, err := populateParamsWithDefaults(ctx, tc.params)
+			if len(tc.wantMissing) == 0 && len(tc.wantInvalid) == 0 {
+				if err != nil {
+					t.Fatalf("populateParamsWithDefaults() = %v, want nil", err)
+				}
+				for k, v := range tc.want {
+					if got[k] != v {
+						t.Errorf("populateParamsWithDefaults() %s = %q, want %q", k, got[k], v)
+					}
+				}
+				return
+			}
+			var paramsErr *ParamsError
+			if !errors.As(err, &paramsErr) {
+				t.Fatalf("populateParamsWithDefaults() = %v, want a ParamsError", err)
+			}
+			if strings.Join(paramsErr.Missing, ",") != strings.Join(tc.wantMissing, ",") {
+				t.Errorf("populateParamsWithDefaults() missing %v, want %v", paramsErr.Missing, tc.wantMissing)
+			}
+			if len(paramsErr.Invalid) != len(tc.wantInvalid) {
+				t.Errorf("populateParamsWithDefaults() invalid %v, want %d errors", paramsErr.Invalid, len(tc.wantInvalid))
+			}
+			for _, want := range tc.wantInvalid {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("populateParamsWithDefaults() = %v, want an error containing %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// embeddedTask returns a PipelineTask embedding a Task of a single step,
+// running after runAfter. Each of workspaces is a workspace of the Pipeline
+// the Task declares and binds under the same name, or <name>=<workspace> to
+// bind it under name.
+func embeddedTask(name string, workspaces []string, runAfter ...string) v1beta1.PipelineTask {
+	pt := v1beta1.PipelineTask{
+		Name:     name,
+		RunAfter: runAfter,
+		TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+			Steps: []v1beta1.Step{{Name: name, Image: "busybox", Script: "true"}},
+		}},
+	}
+	for _, w := range workspaces {
+		binding, workspace, ok := strings.Cut(w, "=")
+		if !ok {
+			workspace = binding
+		}
+		pt.Workspaces = append(pt.Workspaces, v1beta1.WorkspacePipelineTaskBinding{Name: binding, Workspace: workspace})
+		pt.TaskSpec.Workspaces = append(pt.TaskSpec.Workspaces, v1beta1.WorkspaceDeclaration{Name: binding})
+	}
+	return pt
+}
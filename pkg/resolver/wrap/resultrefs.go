@@ -0,0 +1,69 @@
+package wrap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// resultRefRegex matches the task result references of a target, e.g.
+// $(tasks.version.results.tag).
+var resultRefRegex = regexp.MustCompile(`\$\(tasks\.([^.)]+)\.results\.[^)]+\)`)
+
+// resultRefTasks returns the tasks whose results the target references.
+func resultRefTasks(target string) []string {
+	tasks := sets.NewString()
+	for _, m := range resultRefRegex.FindAllStringSubmatch(target, -1) {
+		tasks.Insert(m[1])
+	}
+	return tasks.List()
+}
+
+// validateResultRefs checks that the result references of an image target
+// are in its tag, as exports report the repository of their target.
+func validateResultRefs(target string) error {
+	if strings.Contains(repository(target), "$(tasks.") {
+		return fmt.Errorf("%q references task results outside of its tag", target)
+	}
+	return nil
+}
+
+// checkResultRefs checks that the tasks whose results the target references
+// can run before task: neither task itself nor one of its downstream tasks,
+// which would make a cycle.
+func checkResultRefs(p *v1beta1.PipelineSpec, task, workspace string, refs []string) error {
+	downstream := sets.NewString(downstreamTasks(task, taskDependents(p))...)
+	names := sets.NewString()
+	for _, t := range p.Tasks {
+		names.Insert(t.Name)
+	}
+	for _, ref := range refs {
+		switch {
+		case !names.Has(ref):
+			return fmt.Errorf("the target of workspace %s references the results of %s, which isn't a task of the pipeline", workspace, ref)
+		case ref == task || downstream.Has(ref):
+			return fmt.Errorf("the target of workspace %s references the results of %s, which runs after task %s using the workspace", workspace, ref, task)
+		}
+	}
+	return nil
+}
+
+// paramTarget passes the target, referencing task results, as a param of
+// the task so that Tekton resolves the references, and returns the
+// reference to the param.
+func paramTarget(pt *v1beta1.PipelineTask, s *v1beta1.TaskSpec, workspace, target string) string {
+	name := fmt.Sprintf("wrap-%s-target", workspace)
+	s.Params = append(s.Params, v1beta1.ParamSpec{
+		Name:        name,
+		Type:        v1beta1.ParamTypeString,
+		Description: fmt.Sprintf("The target of the %s workspace", workspace),
+	})
+	pt.Params = append(pt.Params, v1beta1.Param{
+		Name:  name,
+		Value: *v1beta1.NewStructuredValues(target),
+	})
+	return fmt.Sprintf("$(params.%s)", name)
+}
@@ -0,0 +1,91 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const defaultRsyncImage = "docker.io/instrumentisto/rsync-ssh:alpine"
+
+// rsyncOptions holds the settings of the steps of the rsync wrapper.
+type rsyncOptions struct {
+	// Image is the image of rsync.
+	Image string
+	// CredentialsSecret is a Secret holding the RSYNC_PASSWORD environment
+	// variable of rsync, and RSYNC_USER if the targets don't name the user,
+	// if the daemon requires authentication.
+	CredentialsSecret string
+}
+
+func newRsyncOptions(conf map[string]string) rsyncOptions {
+	o := rsyncOptions{
+		Image:             defaultRsyncImage,
+		CredentialsSecret: conf["rsync-credentials-secret"],
+	}
+	if image, ok := conf["rsync-image"]; ok {
+		o.Image = image
+	}
+	return o
+}
+
+func (o rsyncOptions) step(name, script string) v1beta1.Step {
+	step := v1beta1.Step{
+		Name:       name,
+		Image:      o.Image,
+		WorkingDir: "/",
+		Script:     script,
+	}
+	if o.CredentialsSecret != "" {
+		step.EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: o.CredentialsSecret},
+			},
+		}}
+	}
+	return step
+}
+
+// rsyncImportSteps returns a step copying the directories of the daemon in
+// the workspaces. Like tarballs, they hold the whole content of the
+// workspace.
+func rsyncImportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Synchronize workspace content from %s in %s\"\n", t.Source, t.MountPath)
+		extract := fmt.Sprintf("rsync -a %s/ %s/", strings.TrimSuffix(t.Source, "/"), t.MountPath)
+		if !o.BestEffort {
+			fmt.Fprintf(&script, "%s\n", extract)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to get %s, continuing with the workspace as is"
+fi
+`, extract, t.Source)
+	}
+	return []v1beta1.Step{o.Rsync.step("rsync-import-workspace", script.String())}
+}
+
+// rsyncExportSteps returns a step synchronizing the directories of the
+// daemon with the content of the workspaces, deleting what the workspaces
+// don't hold anymore.
+func rsyncExportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Synchronize workspace content from %s to %s\"\n", t.MountPath, t.Target)
+		export := fmt.Sprintf("rsync -a --delete%s %s/ %s/", rsyncExcludeArgs(t.MountPath, o.Exclude), t.MountPath, strings.TrimSuffix(t.Target, "/"))
+		if !o.BestEffort {
+			fmt.Fprintf(&script, "%s\n", export)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to export workspace content to %s, continuing"
+fi
+`, export, t.Target)
+	}
+	return []v1beta1.Step{o.Rsync.step("rsync-export-workspace", script.String())}
+}
@@ -0,0 +1,100 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const defaultS3Image = "docker.io/amazon/aws-cli:2.8.0"
+
+// s3Options holds the settings of the steps of the s3 wrapper.
+type s3Options struct {
+	// Image is the image of the aws CLI.
+	Image string
+	// CredentialsSecret is a Secret holding the AWS_* environment
+	// variables of the aws CLI, e.g. AWS_ACCESS_KEY_ID. The credentials of
+	// the service account are used if empty.
+	CredentialsSecret string
+	// EndpointURL is the URL of an S3 compatible storage.
+	EndpointURL string
+}
+
+func newS3Options(conf map[string]string) s3Options {
+	o := s3Options{
+		Image:             defaultS3Image,
+		CredentialsSecret: conf["s3-credentials-secret"],
+		EndpointURL:       conf["s3-endpoint-url"],
+	}
+	if image, ok := conf["s3-image"]; ok {
+		o.Image = image
+	}
+	return o
+}
+
+// cp returns the aws command copying from src to dst.
+func (o s3Options) cp(src, dst string) string {
+	if o.EndpointURL != "" {
+		return fmt.Sprintf("aws --endpoint-url %s s3 cp %s %s", o.EndpointURL, src, dst)
+	}
+	return fmt.Sprintf("aws s3 cp %s %s", src, dst)
+}
+
+func (o s3Options) step(name, script string) v1beta1.Step {
+	step := v1beta1.Step{
+		Name:       name,
+		Image:      o.Image,
+		WorkingDir: "/",
+		Script:     script,
+	}
+	if o.CredentialsSecret != "" {
+		step.EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: o.CredentialsSecret},
+			},
+		}}
+	}
+	return step
+}
+
+// s3ImportSteps returns a step extracting the tarballs of the workspaces.
+// Unlike images, tarballs hold the whole content of the workspace.
+func s3ImportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Extract workspace content from %s in %s\"\n", t.Source, t.MountPath)
+		extract := fmt.Sprintf("%s | tar -x -C %s", o.S3.cp(t.Source, "-"), t.MountPath)
+		if !o.BestEffort {
+			fmt.Fprintf(&script, "%s\n", extract)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to get %s, continuing with the workspace as is"
+fi
+`, extract, t.Source)
+	}
+	return []v1beta1.Step{o.S3.step("s3-import-workspace", script.String())}
+}
+
+// s3ExportSteps returns a step uploading the content of the workspaces in
+// tarballs.
+func s3ExportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Export workspace content from %s to %s\"\n", t.MountPath, t.Target)
+		export := fmt.Sprintf("(cd %s && tar -f - -c%s . | %s)", t.MountPath, tarExcludeArgs(o.Exclude), o.S3.cp("-", t.Target))
+		if !o.BestEffort {
+			fmt.Fprintf(&script, "%s\n", export)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to export workspace content to %s, continuing"
+fi
+`, export, t.Target)
+	}
+	return []v1beta1.Step{o.S3.step("s3-export-workspace", script.String())}
+}
@@ -0,0 +1,52 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// SeedImageParam makes the first task of the Pipeline import the given
+// image in its workspaces, e.g. a pre-populated source or cache image,
+// instead of starting with empty workspaces. It may hold {{workspace}}.
+// The workspaces of the first task must be wrapped with oci, and the
+// imports ImportSourceParam overrides extract its images instead.
+const SeedImageParam = "seed-image"
+
+// seedSources adds the seed image of each wrapped workspace of the first
+// task of p to sources, unless its import is overridden already, so that
+// the first task imports it like the other tasks import the images
+// exported upstream.
+func seedSources(sources importSources, p *v1beta1.PipelineSpec, workspaces []string, w wrappers, seed string) error {
+	if seed == "" || len(p.Tasks) == 0 {
+		return nil
+	}
+	wrapped := map[string]bool{}
+	for _, ws := range workspaces {
+		wrapped[ws] = true
+	}
+	first := p.Tasks[0]
+	seeded := false
+	for _, pw := range first.Workspaces {
+		if !wrapped[pw.Workspace] || w.get(pw.Workspace) == NoneWrapper {
+			continue
+		}
+		seeded = true
+		if sources.get(first.Name, pw.Workspace) != "" {
+			continue
+		}
+		if w.get(pw.Workspace) != OCIWrapper {
+			return fmt.Errorf("workspace %s isn't wrapped with %s, the first task %s can't import it", pw.Workspace, OCIWrapper, first.Name)
+		}
+		image := taskTarget(strings.ReplaceAll(seed, "{{workspace}}", pw.Workspace), first.Name)
+		if err := validateReference(image); err != nil {
+			return fmt.Errorf("invalid image for workspace %s: %v", pw.Workspace, err)
+		}
+		sources[first.Name+"/"+pw.Workspace] = image
+	}
+	if !seeded {
+		return fmt.Errorf("the first task %s doesn't use any wrapped workspace", first.Name)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package wrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+)
+
+func TestWrapSeedImage(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		params  map[string]string
+		first   v1beta1.PipelineTask
+		want    string
+		wantErr string
+	}{{
+		name:  "no seed",
+		first: embeddedTask("clone", []string{"source"}),
+	}, {
+		name:   "seed",
+		params: map[string]string{SeedImageParam: "registry.example.com/seeds/{{workspace}}:{{task}}"},
+		first:  embeddedTask("clone", []string{"source"}),
+		want:   "crane digest registry.example.com/seeds/source:clone",
+	}, {
+		name:    "not wrapped with oci",
+		params:  map[string]string{SeedImageParam: "registry.example.com/seeds/{{workspace}}", WrapperParam: "source=" + S3Wrapper, S3TargetParam: "s3://bucket/{{workspace}}"},
+		first:   embeddedTask("clone", []string{"source"}),
+		wantErr: "workspace source isn't wrapped with oci",
+	}, {
+		name:    "no wrapped workspace",
+		params:  map[string]string{SeedImageParam: "registry.example.com/seeds/{{workspace}}"},
+		first:   embeddedTask("setup", nil),
+		wantErr: "the first task setup doesn't use any wrapped workspace",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			build := embeddedTask("build", []string{"source"}, tc.first.Name)
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+				Tasks:      []v1beta1.PipelineTask{tc.first, build},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params := map[string]string{
+				PipelineRefParam: "build",
+				TargetParam:      "registry.example.com/{{workspace}}",
+			}
+			for k, v := range tc.params {
+				params[k] = v
+			}
+			params, err := populateParamsWithDefaults(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, _, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("wrap() = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+			steps := wrapped.Spec.Tasks[0].TaskSpec.Steps
+			imported := steps[0].Name == "import-workspace"
+			if imported != (tc.want != "") {
+				t.Fatalf("first task imports = %t, want %t", imported, tc.want != "")
+			}
+			if imported && !strings.Contains(steps[0].Script, tc.want) {
+				t.Errorf("import of the first task doesn't hold %q:\n%s", tc.want, steps[0].Script)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+const selfTestNamespace = "selftest"
+
+// selfTestTask is the Task the last task of the sample Pipeline references,
+// using the alpha fields of steps, which wrapping must keep.
+func selfTestTask() *v1beta1.Task {
+	return &v1beta1.Task{
+		ObjectMeta: metav1.ObjectMeta{Name: "selftest-report", Namespace: selfTestNamespace},
+		Spec: v1beta1.TaskSpec{
+			Workspaces: []v1beta1.WorkspaceDeclaration{{Name: "source"}},
+			Steps: []v1beta1.Step{{
+				Name:         "report",
+				Image:        "busybox",
+				Script:       "du -s $(workspaces.source.path)",
+				StdoutConfig: &v1beta1.StepOutputConfig{Path: "$(workspaces.source.path)/report.out"},
+				StderrConfig: &v1beta1.StepOutputConfig{Path: "$(workspaces.source.path)/report.err"},
+			}},
+		},
+	}
+}
+
+// selfTestPipeline is the sample Pipeline wrapped by the self-test: a chain
+// of three tasks sharing a workspace, the last one referencing
+// selfTestTask.
+func selfTestPipeline() *v1beta1.Pipeline {
+	task := func(name string, runAfter ...string) v1beta1.PipelineTask {
+		return v1beta1.PipelineTask{
+			Name:     name,
+			RunAfter: runAfter,
+			Workspaces: []v1beta1.WorkspacePipelineTaskBinding{{
+				Name:      "source",
+				Workspace: "sources",
+			}},
+			TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+				Workspaces: []v1beta1.WorkspaceDeclaration{{Name: "source"}},
+				Steps: []v1beta1.Step{{
+					Name:   name,
+					Image:  "busybox",
+					Script: "ls $(workspaces.source.path)",
+				}},
+			}},
+		}
+	}
+	return &v1beta1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "selftest", Namespace: selfTestNamespace},
+		Spec: v1beta1.PipelineSpec{
+			Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "sources"}},
+			Tasks: []v1beta1.PipelineTask{task("produce"), task("consume", "produce"), {
+				Name:     "report",
+				RunAfter: []string{"consume"},
+				TaskRef:  &v1beta1.TaskRef{Name: "selftest-report"},
+				Workspaces: []v1beta1.WorkspacePipelineTaskBinding{{
+					Name:      "source",
+					Workspace: "sources",
+				}},
+			}},
+		},
+	}
+}
+
+// SelfTest wraps a sample Pipeline with conf as the resolver configuration,
+// using a fake client, and checks that the wrapped tasks import and export
+// the workspace, and keep the alpha fields of their steps.
+func SelfTest(ctx context.Context, conf map[string]string) error {
+	r := &Resolver{pipelineClientSet: fake.NewSimpleClientset(selfTestPipeline(), selfTestTask())}
+	ctx = common.InjectRequestNamespace(ctx, selfTestNamespace)
+	// The sample Pipeline isn't labeled to match the selector and the
+	// fake client has no cluster to read the feature flags from
+	sample := make(map[string]string, len(conf))
+	for k, v := range conf {
+		if k != "pipeline-selector" && k != "check-feature-flags" {
+			sample[k] = v
+		}
+	}
+	conf = sample
+	ctx = framework.InjectResolverConfigToContext(ctx, conf)
+	params := map[string]string{
+		PipelineRefParam:  "selftest",
+		WorkspacesParam:   "sources",
+		TargetParam:       "registry.invalid/selftest:{{workspace}}",
+		AlwaysExportParam: "true",
+	}
+	if err := r.ValidateParams(ctx, params); err != nil {
+		return fmt.Errorf("self-test params rejected: %v", err)
+	}
+	resolved, err := r.Resolve(ctx, params)
+	if err != nil {
+		return fmt.Errorf("self-test pipeline not resolved: %v", err)
+	}
+
+	var p v1beta1.Pipeline
+	if err := yaml.Unmarshal(resolved.Data(), &p); err != nil {
+		return fmt.Errorf("self-test pipeline not parsable: %v", err)
+	}
+	if len(p.Spec.Tasks) != 3 {
+		return fmt.Errorf("self-test pipeline has %d tasks, expected 3", len(p.Spec.Tasks))
+	}
+	for i, t := range p.Spec.Tasks {
+		if t.TaskSpec == nil {
+			return fmt.Errorf("self-test task %s isn't embedded", t.Name)
+		}
+		steps := t.TaskSpec.Steps
+		if i != 0 && !strings.HasPrefix(steps[0].Name, "import-workspace") {
+			return fmt.Errorf("self-test task %s doesn't import the workspace", t.Name)
+		}
+		if !strings.HasPrefix(steps[len(steps)-1].Name, "export-workspace") {
+			return fmt.Errorf("self-test task %s doesn't export the workspace", t.Name)
+		}
+	}
+	for _, step := range p.Spec.Tasks[2].TaskSpec.Steps {
+		if step.Name == "report" && (step.StdoutConfig == nil || step.StderrConfig == nil) {
+			return fmt.Errorf("self-test task report lost the stdoutConfig or stderrConfig of its step")
+		}
+	}
+	return nil
+}
+
+// SelfTester runs the self-test with the current resolver configuration,
+// whenever it changes, and serves its results:
+//   - /readiness answers with the result of the last self-test, and fails
+//     until the configuration is loaded.
+//   - /selftest runs the self-test again and answers with its result.
+type SelfTester struct {
+	mu     sync.Mutex
+	conf   map[string]string
+	result error
+}
+
+// NewSelfTester returns a SelfTester waiting for the configuration.
+func NewSelfTester() *SelfTester {
+	return &SelfTester{result: fmt.Errorf("%s not loaded yet", ResolverConfigName())}
+}
+
+// Watch wraps the constructor of the resolver controller to run the
+// self-test whenever the resolver ConfigMap changes.
+func (st *SelfTester) Watch(ctor injection.ControllerConstructor) injection.ControllerConstructor {
+	return func(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+		impl := ctor(ctx, cmw)
+		logger := logging.FromContext(ctx)
+		cmw.Watch(ResolverConfigName(), func(cm *corev1.ConfigMap) {
+			conf := cm.Data
+			if conf == nil {
+				conf = map[string]string{}
+			}
+			err := SelfTest(ctx, conf)
+			if err != nil {
+				logger.Errorf("self-test failed: %v", err)
+			}
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			st.conf, st.result = conf, err
+		})
+		return impl
+	}
+}
+
+func (st *SelfTester) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	st.mu.Lock()
+	conf, result := st.conf, st.result
+	st.mu.Unlock()
+
+	switch req.URL.Path {
+	case "/readiness":
+	case "/selftest":
+		if conf != nil {
+			result = SelfTest(req.Context(), conf)
+		}
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if result != nil {
+		http.Error(w, result.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
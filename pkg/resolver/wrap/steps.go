@@ -0,0 +1,637 @@
+package wrap
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/prefetch"
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	layerCacheVolumeName = "wrap-layer-cache"
+	layerCacheMountPath  = "/wrap/layer-cache"
+)
+
+// workspaceTransfer describes how the content of a wrapped workspace is
+// moved in and out of a task.
+type workspaceTransfer struct {
+	// Workspace is the name of the workspace in the Pipeline.
+	Workspace string
+	// Wrapper is the wrapper moving the content, e.g. OCIWrapper.
+	Wrapper string
+	// MountPath is where the workspace is mounted in the task.
+	MountPath string
+	// Base is the image the exported content is appended onto.
+	Base string
+	// Target is the image the content is exported to.
+	Target string
+	// Source is the image the content is imported from: Target, unless
+	// pinned to the digest exported upstream, see ArtifactResultsParam.
+	Source string
+	// Result is the name of the task result the export reports the
+	// pushed image in, if any.
+	Result string
+	// Repository is the repository of Target, without its tag, which may
+	// reference task results.
+	Repository string
+	// ArtifactType is the artifact type of the exported image, if any.
+	ArtifactType string
+	// Tags are other tags of the exported image, in the repository of
+	// Target, see ephemeralTag.
+	Tags []string
+}
+
+// pullRef returns the reference the crane import step extracts, given the
+// digest of the source in the script. Pinned sources already hold it.
+func (t workspaceTransfer) pullRef() string {
+	if t.Source != t.Target {
+		return t.Source
+	}
+	return t.Source + "@${digest}"
+}
+
+// importStep returns a crane step importing the workspaces. Like the
+// wrapstep helper, it skips extraction if the marker file of a workspace
+// shows it already holds the content of the image.
+//
+// When best-effort, a workspace whose image can't be found is left empty
+// instead of failing the step, as its upstream export may have failed.
+//
+// When allowMissing, a workspace whose image doesn't exist is left empty,
+// the registry answering MANIFEST_UNKNOWN, NAME_UNKNOWN or 404, while the
+// other failures still fail the step.
+//
+// When wait isn't 0, it first waits up to wait for the registry to serve
+// each image, see ImportWaitTimeoutParam.
+func importStep(transfers []workspaceTransfer, bestEffort, allowMissing bool, wait time.Duration, image string) v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh -e\n")
+	if wait > 0 {
+		fmt.Fprintf(&script, `wait_for() {
+  deadline=$(($(date +%%s) + %d))
+  until crane digest "$1" > /dev/null 2>&1; do
+    if [ "$(date +%%s)" -ge "${deadline}" ]; then
+      echo "$1 is still not visible in the registry after %s"
+      return 1
+    fi
+    echo "Waiting for $1 to be visible in the registry"
+    sleep 2
+  done
+}
+`, int(wait.Round(time.Second).Seconds()), wait)
+	}
+	for _, t := range transfers {
+		marker := path.Join(t.MountPath, wrapstep.MarkerFile)
+		fmt.Fprintf(&script, "echo \"Extract workspace content from %s in %s\"\n", t.Source, t.MountPath)
+		extract := fmt.Sprintf(`if [ "$(cat %s 2>/dev/null)" = "${digest}" ]; then
+  echo "Workspace content of %s is already extracted in %s, skipping"
+else
+  crane export %s | tar -x -C %s
+  echo "${digest}" > %s
+fi
+`, marker, t.Source, t.MountPath, t.pullRef(), t.MountPath, marker)
+		if wait > 0 {
+			fmt.Fprintf(&script, "wait_for %s", t.Source)
+			if bestEffort || allowMissing {
+				// The digest then fails too
+				fmt.Fprintf(&script, " || true")
+			}
+			fmt.Fprintf(&script, "\n")
+		}
+		if allowMissing && !bestEffort {
+			fmt.Fprintf(&script, `if digest=$(crane digest %s); then
+%selse
+  crane digest %s 2>&1 | grep -q -e MANIFEST_UNKNOWN -e NAME_UNKNOWN -e "404 Not Found" || exit 1
+  echo "Warning: %s doesn't exist, continuing with an empty workspace"
+fi
+`, t.Source, indent(extract), t.Source, t.Source)
+			continue
+		}
+		if !bestEffort {
+			fmt.Fprintf(&script, "digest=$(crane digest %s)\n%s", t.Source, extract)
+			continue
+		}
+		fmt.Fprintf(&script, `if digest=$(crane digest %s); then
+%selse
+  echo "Warning: failed to get %s, continuing with an empty workspace"
+fi
+`, t.Source, indent(extract), t.Source)
+	}
+	return v1beta1.Step{
+		Name:       "import-workspace",
+		Image:      image,
+		WorkingDir: "/",
+		Script:     script.String(),
+	}
+}
+
+// stepOptions holds the settings of the injected import and export steps.
+type stepOptions struct {
+	// BaseImage is the image the exports of the first task append onto.
+	BaseImage string
+	// CraneImage is the image of the steps using crane.
+	CraneImage string
+	// WrapstepImage is the image of the wrapstep helper, used by the steps
+	// crane can't implement.
+	WrapstepImage string
+	// SharedTarget exports with compare-and-swap, see SharedTargetParam.
+	SharedTarget bool
+	// LayerCachePath is the directory of the nodes where imports keep the
+	// layers they pull.
+	LayerCachePath string
+	// ArchivePath is the directory of the nodes holding the docker-archive
+	// targets, see ArchiveWrapper.
+	ArchivePath string
+	// MaxUploadRate and MaxDownloadRate limit the transfer rates of each
+	// export and import, in bytes per second.
+	MaxUploadRate, MaxDownloadRate int64
+	// ExportChunks splits the exported content in as many layers, see
+	// ExportChunksParam.
+	ExportChunks int
+	// Compression is the compression of the exported layers, see
+	// CompressionParam.
+	Compression string
+	// Exclude are the patterns of the paths left out of the exports, see
+	// ExcludeParam.
+	Exclude []string
+	// BestEffort doesn't fail the tasks when transfers fail, see
+	// ExportFailureParam.
+	BestEffort bool
+	// S3 holds the settings of the s3 wrapper.
+	S3 s3Options
+	// GCS holds the settings of the gcs wrapper.
+	GCS gcsOptions
+	// Rsync holds the settings of the rsync wrapper.
+	Rsync rsyncOptions
+	// Templates are the wrapper templates of the resolver configuration.
+	Templates wrapperTemplates
+	// Prefetch adds the hints of the prefetcher DaemonSet to the wrapped
+	// tasks. It requires the layer cache.
+	Prefetch bool
+	// Faults are simulated by the steps, see FaultInjectParam.
+	Faults faults
+	// SecurityContext is set on the injected steps, if any.
+	SecurityContext *corev1.SecurityContext
+	// Lineage holds the settings of the OpenLineage events.
+	Lineage lineageOptions
+	// Credentials are the credential providers of the wrapstep helper, see
+	// wrapstep.Credentials. The transfers of oci workspaces then all use
+	// the helper.
+	Credentials string
+	// Vault holds the settings of the vault credential provider.
+	Vault vaultOptions
+	// Owner is the <namespace>/<pipeline> the exports of oci workspaces
+	// stamp their images with, refusing to push to targets owned by
+	// another Pipeline, if owner-markers is set.
+	Owner string
+	// Force makes the exports push to targets owned by another Pipeline,
+	// see ForceParam.
+	Force bool
+	// ImportWait is how long the imports wait for the registry to serve
+	// their images, see ImportWaitTimeoutParam.
+	ImportWait time.Duration
+	// AllowMissing makes the imports continue with an empty workspace
+	// when their image doesn't exist, see AllowMissingBaseParam.
+	AllowMissing bool
+	// WrapstepTransfers makes the transfers of oci workspaces use the
+	// wrapstep helper, even when crane would do.
+	WrapstepTransfers bool
+}
+
+// newStepOptions reads the step options from the resolver configuration
+// and the request params.
+func newStepOptions(conf, params map[string]string) (stepOptions, error) {
+	o := stepOptions{
+		BaseImage:      DefaultBaseImage,
+		CraneImage:     DefaultCraneImage,
+		WrapstepImage:  DefaultWrapstepImage,
+		LayerCachePath: conf["layer-cache-path"],
+		ArchivePath:    conf["archive-path"],
+		Credentials:    conf["wrapstep-credentials"],
+		S3:             newS3Options(conf),
+		GCS:            newGCSOptions(conf),
+		Rsync:          newRsyncOptions(conf),
+		Lineage:        newLineageOptions(conf),
+		Vault:          newVaultOptions(conf),
+	}
+	if image, ok := conf["base-image"]; ok {
+		o.BaseImage = image
+	}
+	if image, ok := params[BaseImageParam]; ok {
+		o.BaseImage = image
+	}
+	if image, ok := conf["crane-image"]; ok {
+		o.CraneImage = image
+	}
+	if image, ok := params[CraneImageParam]; ok {
+		o.CraneImage = image
+	}
+	if image, ok := conf["wrapstep-image"]; ok {
+		o.WrapstepImage = image
+	}
+	o.WrapstepTransfers, _ = strconv.ParseBool(conf["wrapstep-transfers"])
+	if value, ok := conf["prefetch"]; ok {
+		prefetch, err := strconv.ParseBool(value)
+		if err != nil {
+			return o, fmt.Errorf("invalid prefetch %q in resolver config: %v", value, err)
+		}
+		o.Prefetch = prefetch && o.LayerCachePath != ""
+	}
+	sc, err := parseStepSecurityContext(conf["step-security-context"])
+	if err != nil {
+		return o, fmt.Errorf("invalid step-security-context in resolver config: %v", err)
+	}
+	o.SecurityContext = sc
+	templates, err := parseWrapperTemplates(conf["wrapper-templates"])
+	if err != nil {
+		return o, fmt.Errorf("invalid wrapper-templates in resolver config: %v", err)
+	}
+	o.Templates = templates
+	o.SharedTarget, _ = strconv.ParseBool(params[SharedTargetParam])
+	o.Force, _ = strconv.ParseBool(params[ForceParam])
+	o.ExportChunks, _ = strconv.Atoi(params[ExportChunksParam])
+	o.Compression = params[CompressionParam]
+	o.Exclude, _ = parseExclude(params[ExcludeParam])
+	o.BestEffort = params[ExportFailureParam] == ExportFailureWarn
+	o.ImportWait, _ = time.ParseDuration(params[ImportWaitTimeoutParam])
+	o.AllowMissing, _ = strconv.ParseBool(params[AllowMissingBaseParam])
+	if value, ok := params[FaultInjectParam]; ok {
+		allowed, _ := strconv.ParseBool(conf["allow-fault-injection"])
+		if !allowed {
+			return o, fmt.Errorf("%s is disabled, allow-fault-injection isn't set in %s", FaultInjectParam, ResolverConfigName())
+		}
+		f, err := parseFaults(value)
+		if err != nil {
+			return o, fmt.Errorf("invalid value for %s: %v", FaultInjectParam, err)
+		}
+		o.Faults = f
+	}
+	for key, rate := range map[string]*int64{
+		"max-upload-rate":   &o.MaxUploadRate,
+		"max-download-rate": &o.MaxDownloadRate,
+	} {
+		value, ok := conf[key]
+		if !ok {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return o, fmt.Errorf("invalid %s %q in resolver config: %v", key, value, err)
+		}
+		*rate = q.Value()
+	}
+	return o, nil
+}
+
+// ociImportSteps returns the steps importing the workspaces from images.
+// They use crane, unless they need features of the wrapstep helper, which
+// then imports all the workspaces in a single step.
+func ociImportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	// crane may not extract zstd layers
+	if o.LayerCachePath == "" && o.MaxDownloadRate == 0 && o.Credentials == "" && !o.WrapstepTransfers && o.Compression != wrapstep.CompressionZstd {
+		return []v1beta1.Step{importStep(transfers, o.BestEffort, o.AllowMissing, o.ImportWait, o.CraneImage)}
+	}
+	step := v1beta1.Step{
+		Name:       "import-workspace",
+		Image:      o.WrapstepImage,
+		WorkingDir: "/",
+		Command:    []string{"/ko-app/wrapstep"},
+		Args:       []string{"import"},
+	}
+	if o.LayerCachePath != "" {
+		step.VolumeMounts = []corev1.VolumeMount{{
+			Name:      layerCacheVolumeName,
+			MountPath: layerCacheMountPath,
+		}}
+	}
+	if o.Credentials != "" {
+		step.Env = o.Vault.env()
+	}
+	for i, t := range transfers {
+		if i > 0 {
+			step.Args = append(step.Args, "--")
+		}
+		step.Args = append(step.Args,
+			"-source", t.Source,
+			"-path", t.MountPath,
+		)
+		if o.LayerCachePath != "" {
+			step.Args = append(step.Args, "-cache", layerCacheMountPath)
+		}
+		if o.MaxDownloadRate > 0 {
+			step.Args = append(step.Args, "-max-rate", strconv.FormatInt(o.MaxDownloadRate, 10))
+		}
+		if o.ImportWait > 0 {
+			step.Args = append(step.Args, "-wait", o.ImportWait.String())
+		}
+		if o.AllowMissing {
+			step.Args = append(step.Args, "-allow-missing")
+		}
+		if o.Credentials != "" {
+			step.Args = append(step.Args, "-credentials", o.Credentials)
+		}
+		if o.BestEffort {
+			step.Args = append(step.Args, "-best-effort")
+		}
+	}
+	return []v1beta1.Step{step}
+}
+
+// ociExportSteps returns the steps exporting the workspaces to images. They
+// use crane, unless they need features of the wrapstep helper, which then
+// exports all the workspaces in a single step: exports to a shared target
+// append onto its latest image and retry if another run pushed to it
+// concurrently, exports can be split in chunks pushed concurrently, typed
+// with an artifact type, compressed with zstd or not at all, use other
+// credentials, and stamp the images with their owner.
+func ociExportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	typed := false
+	for _, t := range transfers {
+		typed = typed || t.ArtifactType != ""
+	}
+	compressed := o.Compression != "" && o.Compression != wrapstep.CompressionGzip
+	if !o.SharedTarget && o.MaxUploadRate == 0 && o.ExportChunks <= 1 && !typed && o.Credentials == "" && o.Owner == "" && !o.WrapstepTransfers && !compressed {
+		return []v1beta1.Step{exportStep(transfers, o.BestEffort, o.Exclude, o.CraneImage)}
+	}
+	step := v1beta1.Step{
+		Name:       "export-workspace",
+		Image:      o.WrapstepImage,
+		WorkingDir: "/",
+		Command:    []string{"/ko-app/wrapstep"},
+		Args:       []string{"export"},
+	}
+	if o.Credentials != "" {
+		step.Env = o.Vault.env()
+	}
+	for i, t := range transfers {
+		if i > 0 {
+			step.Args = append(step.Args, "--")
+		}
+		step.Args = append(step.Args,
+			"-path", t.MountPath,
+			"-base", t.Base,
+			"-target", t.Target,
+		)
+		if o.SharedTarget {
+			step.Args = append(step.Args, "-cas")
+		}
+		if o.MaxUploadRate > 0 {
+			step.Args = append(step.Args, "-max-rate", strconv.FormatInt(o.MaxUploadRate, 10))
+		}
+		if o.ExportChunks > 1 {
+			step.Args = append(step.Args, "-chunks", strconv.Itoa(o.ExportChunks))
+		}
+		if t.Result != "" {
+			step.Args = append(step.Args, "-result", resultPath(t.Result))
+		}
+		if t.ArtifactType != "" {
+			step.Args = append(step.Args, "-artifact-type", t.ArtifactType)
+		}
+		if compressed {
+			step.Args = append(step.Args, "-compression", o.Compression)
+		}
+		if len(o.Exclude) > 0 {
+			step.Args = append(step.Args, "-exclude", strings.Join(o.Exclude, ","))
+		}
+		if len(t.Tags) > 0 {
+			step.Args = append(step.Args, "-tags", strings.Join(t.Tags, ","))
+		}
+		if o.Owner != "" {
+			step.Args = append(step.Args, "-owner", o.Owner)
+		}
+		if o.Force {
+			step.Args = append(step.Args, "-force")
+		}
+		if o.Credentials != "" {
+			step.Args = append(step.Args, "-credentials", o.Credentials)
+		}
+		if o.BestEffort {
+			step.Args = append(step.Args, "-best-effort")
+		}
+	}
+	return []v1beta1.Step{step}
+}
+
+// addLayerCacheVolume adds the hostPath volume of the node-local layer
+// cache to the TaskSpec.
+func addLayerCacheVolume(s *v1beta1.TaskSpec, path string) {
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	s.Volumes = append(s.Volumes, corev1.Volume{
+		Name: layerCacheVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: path,
+				Type: &hostPathType,
+			},
+		},
+	})
+}
+
+// addPrefetchHints labels the pods of the task for the prefetcher, with the
+// workspace images it exports. While the task runs, the prefetcher of each
+// node used by the PipelineRun pulls the layers of these images in the
+// layer cache, so that the next tasks only pull the layers exported since.
+func addPrefetchHints(t *v1beta1.EmbeddedTask, transfers []workspaceTransfer) {
+	images := make([]string, 0, len(transfers))
+	for _, tr := range transfers {
+		// Targets referencing task results are only known at run time
+		if strings.Contains(tr.Target, "$(") {
+			continue
+		}
+		images = append(images, tr.Target)
+	}
+	if len(images) == 0 {
+		return
+	}
+	if t.Metadata.Labels == nil {
+		t.Metadata.Labels = map[string]string{}
+	}
+	if t.Metadata.Annotations == nil {
+		t.Metadata.Annotations = map[string]string{}
+	}
+	t.Metadata.Labels[prefetch.Label] = "true"
+	t.Metadata.Annotations[prefetch.ImagesAnnotation] = strings.Join(images, ",")
+}
+
+// exportStep returns a crane step exporting the workspaces, without the
+// paths matching the exclude patterns or the ones of their wrapignore file.
+// When best-effort, a failed export only prints a warning.
+func exportStep(transfers []workspaceTransfer, bestEffort bool, exclude []string, image string) v1beta1.Step {
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/busybox/sh -e\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&script, "echo \"Export workspace content from %s to %s\"\n", t.MountPath, t.Target)
+		export := fmt.Sprintf("(cd %s && tar -f - -c%s . | crane append -b %s -t %s -f -)", t.MountPath, tarExcludeArgs(exclude), t.Base, t.Target)
+		if len(t.Tags) > 0 {
+			// crane append prints the digest of the pushed image
+			export = fmt.Sprintf("pushed=$(cd %s && tar -f - -c%s . | crane append -b %s -t %s -f -)", t.MountPath, tarExcludeArgs(exclude), t.Base, t.Target)
+			for _, tag := range t.Tags {
+				export += fmt.Sprintf(" && crane tag \"${pushed}\" %s", tag)
+			}
+		}
+		if !bestEffort {
+			fmt.Fprintf(&script, "%s\n", export)
+			continue
+		}
+		fmt.Fprintf(&script, `if ! %s; then
+  echo "Warning: failed to export workspace content to %s, continuing"
+fi
+`, export, t.Target)
+	}
+	for _, t := range transfers {
+		if t.Result == "" {
+			continue
+		}
+		fmt.Fprintf(&script, `printf '{"uri":"%%s","digest":"%%s"}' %s "$(crane digest %s)" > %s
+`, t.Repository, t.Target, resultPath(t.Result))
+	}
+	return v1beta1.Step{
+		Name:       "export-workspace",
+		Image:      image,
+		WorkingDir: "/",
+		Script:     script.String(),
+	}
+}
+
+// addArtifactResult declares the object result the export of workspace
+// reports the pushed image in, and returns its name.
+func addArtifactResult(s *v1beta1.TaskSpec, workspace string) string {
+	name := "wrap-" + workspace
+	s.Results = append(s.Results, v1beta1.TaskResult{
+		Name: name,
+		Type: v1beta1.ResultsTypeObject,
+		Properties: map[string]v1beta1.PropertySpec{
+			"uri":    {Type: v1beta1.ParamTypeString},
+			"digest": {Type: v1beta1.ParamTypeString},
+		},
+		Description: fmt.Sprintf("The image the content of the %s workspace was exported to", workspace),
+	})
+	return name
+}
+
+// pinImport passes the image exporter pushed the workspace to as a param
+// of the task, and returns the reference to import it from.
+func pinImport(pt *v1beta1.PipelineTask, s *v1beta1.TaskSpec, exporter, workspace string) string {
+	name := fmt.Sprintf("wrap-%s-image", workspace)
+	s.Params = append(s.Params, v1beta1.ParamSpec{
+		Name:        name,
+		Type:        v1beta1.ParamTypeString,
+		Description: fmt.Sprintf("The image to import the content of the %s workspace from", workspace),
+	})
+	result := fmt.Sprintf("tasks.%s.results.wrap-%s", exporter, workspace)
+	pt.Params = append(pt.Params, v1beta1.Param{
+		Name:  name,
+		Value: *v1beta1.NewStructuredValues(fmt.Sprintf("$(%s.uri)@$(%s.digest)", result, result)),
+	})
+	return fmt.Sprintf("$(params.%s)", name)
+}
+
+// resultPath returns the variable of the path of a task result.
+func resultPath(result string) string {
+	return fmt.Sprintf("$(results.%s.path)", result)
+}
+
+// repository returns the repository of an image reference, without its
+// tag or digest.
+func repository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// indent indents each line of script by two spaces.
+func indent(script string) string {
+	lines := strings.SplitAfter(script, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = "  " + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// mountPath returns where the workspace declared as name is mounted.
+func mountPath(s *v1beta1.TaskSpec, name string) string {
+	var w v1beta1.WorkspaceDeclaration
+	for _, d := range s.Workspaces {
+		if d.Name == name {
+			w = d
+		}
+	}
+	return w.GetMountPath()
+}
+
+// InjectedStep is a step injected in a wrapped task, see
+// InjectedStepsAnnotation.
+type InjectedStep struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+// checkStepNames checks that the own steps of a task aren't named like its
+// injected steps: Tekton requires unique step names, and the stepOverrides
+// of the taskRunSpecs of PipelineRuns select steps by name.
+func checkStepNames(steps []v1beta1.Step, injected []InjectedStep) error {
+	names, indexes := map[string]bool{}, map[int]bool{}
+	for _, step := range injected {
+		names[step.Name], indexes[step.Index] = true, true
+	}
+	for i, step := range steps {
+		if names[step.Name] && !indexes[i] {
+			return fmt.Errorf("step %s is named like an injected step, rename it", step.Name)
+		}
+	}
+	return nil
+}
+
+// injectedSteps returns the steps injected around the own steps of a task:
+// the first prepended ones, and the ones after the own steps.
+func injectedSteps(steps []v1beta1.Step, prepended, own int) []InjectedStep {
+	var injected []InjectedStep
+	for i, step := range steps {
+		if i >= prepended && i < prepended+own {
+			continue
+		}
+		injected = append(injected, InjectedStep{Name: step.Name, Index: i})
+	}
+	return injected
+}
+
+// forInjectedSteps calls fn with the indices of the injected steps of each
+// task of the wrapped Pipeline: the injected steps of the wrapped tasks, and
+// all the steps of the finally tasks added after the first own ones.
+func forInjectedSteps(p *v1beta1.Pipeline, injected map[string][]InjectedStep, ownFinally int, fn func(s *v1beta1.TaskSpec, steps []int)) {
+	for i := range p.Spec.Tasks {
+		t := &p.Spec.Tasks[i]
+		if len(injected[t.Name]) == 0 || t.TaskSpec == nil {
+			continue
+		}
+		steps := make([]int, 0, len(injected[t.Name]))
+		for _, step := range injected[t.Name] {
+			steps = append(steps, step.Index)
+		}
+		fn(&t.TaskSpec.TaskSpec, steps)
+	}
+	for i := ownFinally; i < len(p.Spec.Finally); i++ {
+		t := &p.Spec.Finally[i]
+		if t.TaskSpec == nil {
+			continue
+		}
+		steps := make([]int, len(t.TaskSpec.Steps))
+		for j := range steps {
+			steps[j] = j
+		}
+		fn(&t.TaskSpec.TaskSpec, steps)
+	}
+}
@@ -0,0 +1,166 @@
+package wrap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNewStepOptionsCraneImage(t *testing.T) {
+	const pinned = "mirror.example.com/crane@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, tc := range []struct {
+		name   string
+		conf   map[string]string
+		params map[string]string
+		want   string
+	}{
+		{name: "default", conf: map[string]string{}, params: map[string]string{}, want: DefaultCraneImage},
+		{name: "configured", conf: map[string]string{"crane-image": pinned}, params: map[string]string{}, want: pinned},
+		{name: "param over configured", conf: map[string]string{"crane-image": pinned}, params: map[string]string{CraneImageParam: "mirror.example.com/crane:debug"}, want: "mirror.example.com/crane:debug"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := newStepOptions(tc.conf, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.CraneImage != tc.want {
+				t.Errorf("CraneImage = %q, want %q", o.CraneImage, tc.want)
+			}
+			step := importStep([]workspaceTransfer{{Workspace: "source", Source: "registry.example.com/source", MountPath: "/workspace/source"}}, false, false, 0, o.CraneImage)
+			if step.Image != tc.want {
+				t.Errorf("import step image = %q, want %q", step.Image, tc.want)
+			}
+		})
+	}
+}
+
+func TestOCIStepsCompression(t *testing.T) {
+	transfers := []workspaceTransfer{{Workspace: "source", Wrapper: OCIWrapper, MountPath: "/workspace/source", Base: "registry.example.com/source", Source: "registry.example.com/source", Target: "registry.example.com/source"}}
+	for _, tc := range []struct {
+		compression    string
+		wantImportHelp bool
+		wantExportArgs string
+	}{
+		{compression: ""},
+		{compression: "gzip"},
+		{compression: "zstd", wantImportHelp: true, wantExportArgs: "-compression zstd"},
+		{compression: "none", wantExportArgs: "-compression none"},
+	} {
+		t.Run(tc.compression, func(t *testing.T) {
+			params := map[string]string{}
+			if tc.compression != "" {
+				params[CompressionParam] = tc.compression
+			}
+			o, err := newStepOptions(map[string]string{}, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ociImportSteps(o, transfers)[0].Image == o.WrapstepImage; got != tc.wantImportHelp {
+				t.Errorf("import with the wrapstep helper = %t, want %t", got, tc.wantImportHelp)
+			}
+			export := ociExportSteps(o, transfers)[0]
+			if tc.wantExportArgs == "" {
+				if export.Image != o.CraneImage {
+					t.Errorf("export image = %s, want crane", export.Image)
+				}
+				return
+			}
+			if args := strings.Join(export.Args, " "); export.Image != o.WrapstepImage || !strings.Contains(args, tc.wantExportArgs) {
+				t.Errorf("export %s %s, want the wrapstep helper with %s", export.Image, args, tc.wantExportArgs)
+			}
+		})
+	}
+}
+
+func TestImportStepsAllowMissing(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+		want   []string
+	}{{
+		name:   "crane",
+		params: map[string]string{AllowMissingBaseParam: "true"},
+		want: []string{
+			"if digest=$(crane digest registry.example.com/source); then",
+			`crane digest registry.example.com/source 2>&1 | grep -q -e MANIFEST_UNKNOWN -e NAME_UNKNOWN -e "404 Not Found" || exit 1`,
+			"Warning: registry.example.com/source doesn't exist, continuing with an empty workspace",
+		},
+	}, {
+		name:   "crane waiting",
+		params: map[string]string{AllowMissingBaseParam: "true", ImportWaitTimeoutParam: "1m"},
+		want:   []string{"wait_for registry.example.com/source || true"},
+	}, {
+		name:   "wrapstep",
+		params: map[string]string{AllowMissingBaseParam: "true", CompressionParam: "zstd"},
+		want:   []string{"-source registry.example.com/source -path /workspace/source -allow-missing"},
+	}, {
+		name:   "not allowed",
+		params: map[string]string{},
+		want:   []string{"digest=$(crane digest registry.example.com/source)\n"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := newStepOptions(map[string]string{}, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transfers := []workspaceTransfer{{Workspace: "source", Wrapper: OCIWrapper, MountPath: "/workspace/source", Source: "registry.example.com/source", Target: "registry.example.com/source"}}
+			step := ociImportSteps(o, transfers)[0]
+			got := step.Script + strings.Join(step.Args, " ")
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("import step doesn't hold %q:\n%s", want, got)
+				}
+			}
+			if tc.params[AllowMissingBaseParam] == "" && strings.Contains(got, "continuing with an empty workspace") {
+				t.Errorf("import step continues without its image:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestOCIStepsVault(t *testing.T) {
+	conf := map[string]string{
+		"wrapstep-credentials": "vault=wrap@secret/data/registries",
+		"vault-address":        "https://vault.vault:8200",
+		"vault-namespace":      "team",
+	}
+	o, err := newStepOptions(conf, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfers := []workspaceTransfer{{Workspace: "source", Wrapper: OCIWrapper, MountPath: "/workspace/source", Source: "registry.example.com/source", Target: "registry.example.com/source"}}
+	for _, step := range []v1beta1.Step{ociImportSteps(o, transfers)[0], ociExportSteps(o, transfers)[0]} {
+		if step.Image != o.WrapstepImage || !strings.Contains(strings.Join(step.Args, " "), "-credentials vault=wrap@secret/data/registries") {
+			t.Errorf("%s step %s %v, want the wrapstep helper with the vault credentials", step.Name, step.Image, step.Args)
+		}
+		want := []corev1.EnvVar{{Name: wrapstep.VaultAddrEnv, Value: "https://vault.vault:8200"}, {Name: wrapstep.VaultNamespaceEnv, Value: "team"}}
+		if !reflect.DeepEqual(step.Env, want) {
+			t.Errorf("%s step env = %v, want %v", step.Name, step.Env, want)
+		}
+	}
+
+	delete(conf, "wrapstep-credentials")
+	if o, err = newStepOptions(conf, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if step := ociImportSteps(o, transfers)[0]; len(step.Env) != 0 {
+		t.Errorf("import step env without credentials = %v, want none", step.Env)
+	}
+}
+
+func TestOCIExportStepsOwner(t *testing.T) {
+	o, err := newStepOptions(map[string]string{}, map[string]string{ForceParam: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Owner = "ci/build"
+	transfers := []workspaceTransfer{{Workspace: "source", Wrapper: OCIWrapper, MountPath: "/workspace/source", Target: "registry.example.com/source"}}
+	export := ociExportSteps(o, transfers)[0]
+	if args := strings.Join(export.Args, " "); export.Image != o.WrapstepImage || !strings.Contains(args, "-owner ci/build -force") {
+		t.Errorf("export %s %s, want the wrapstep helper stamping ci/build, forced", export.Image, args)
+	}
+}
@@ -0,0 +1,72 @@
+package wrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TargetsParam sets the targets of some workspaces instead of TargetParam,
+// e.g. to push source and cache to different repositories: either comma
+// separated workspace=image pairs, or a JSON map of the workspaces to
+// their images. It only applies to the workspaces whose target is
+// TargetParam, e.g. not the ones of the s3 wrapper.
+const TargetsParam = "targets"
+
+// parseTargets parses the value of TargetsParam.
+func parseTargets(v string) (map[string]string, error) {
+	targets := map[string]string{}
+	if strings.HasPrefix(strings.TrimSpace(v), "{") {
+		if err := json.Unmarshal([]byte(v), &targets); err != nil {
+			return nil, fmt.Errorf("not a JSON map of the workspaces to their targets: %v", err)
+		}
+		for ws, target := range targets {
+			if ws == "" || target == "" {
+				return nil, fmt.Errorf("%q=%q has an empty workspace or target", ws, target)
+			}
+		}
+		return targets, nil
+	}
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		ws, target, ok := strings.Cut(pair, "=")
+		ws, target = strings.TrimSpace(ws), strings.TrimSpace(target)
+		if !ok || ws == "" || target == "" {
+			return nil, fmt.Errorf("%q is not <workspace>=<image>", pair)
+		}
+		if _, ok := targets[ws]; ok {
+			return nil, fmt.Errorf("workspace %s has several targets", ws)
+		}
+		targets[ws] = target
+	}
+	return targets, nil
+}
+
+// formatTargets returns the targets as the sorted workspace=image pairs of
+// TargetsParam.
+func formatTargets(targets map[string]string) string {
+	pairs := make([]string, 0, len(targets))
+	for ws, target := range targets {
+		pairs = append(pairs, ws+"="+target)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// workspaceTarget returns the target of the workspace: the one of
+// TargetsParam, if the param of its wrapper is TargetParam, else the param
+// rendered with the workspace.
+func workspaceTarget(params map[string]string, targetParam, workspace string) string {
+	if targetParam == TargetParam {
+		// TargetsParam is validated with the other params
+		targets, _ := parseTargets(params[TargetsParam])
+		if target, ok := targets[workspace]; ok {
+			return target
+		}
+	}
+	return strings.ReplaceAll(params[targetParam], "{{workspace}}", workspace)
+}
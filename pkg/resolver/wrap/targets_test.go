@@ -0,0 +1,135 @@
+package wrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+)
+
+func TestParseTargets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string]string{}},
+		{name: "pairs", value: "source=registry.example.com/source, cache=cache.example.com/cache:{{workspace}}", want: map[string]string{"source": "registry.example.com/source", "cache": "cache.example.com/cache:{{workspace}}"}},
+		{name: "json", value: `{"source": "registry.example.com/source"}`, want: map[string]string{"source": "registry.example.com/source"}},
+		{name: "missing image", value: "source=", wantErr: true},
+		{name: "missing workspace", value: "=registry.example.com/source", wantErr: true},
+		{name: "duplicate", value: "source=registry.example.com/a,source=registry.example.com/b", wantErr: true},
+		{name: "invalid json", value: `{"source": 1}`, wantErr: true},
+		{name: "empty json target", value: `{"source": ""}`, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTargets(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseTargets(%q) = %v, want error %t", tc.value, err, tc.wantErr)
+			}
+			if !tc.wantErr {
+				if d := cmp.Diff(tc.want, got); d != "" {
+					t.Errorf("parseTargets(%q) diff (-want, +got): %s", tc.value, d)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapTargets(t *testing.T) {
+	workspaces := []string{"source", "cache"}
+	pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+		Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}, {Name: "cache"}},
+		Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", workspaces), embeddedTask("build", workspaces, "clone")},
+	}}
+	pipeline.Name, pipeline.Namespace = "build", "dev"
+	ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+	ctx = common.InjectRequestNamespace(ctx, "dev")
+	params, err := populateParamsWithDefaults(ctx, map[string]string{
+		PipelineRefParam: "build",
+		TargetParam:      "registry.example.com/{{workspace}}",
+		TargetsParam:     "cache=cache.example.com/build-cache",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, effective, err := (&Resolver{}).wrap(ctx, params, pipeline)
+	if err != nil {
+		t.Fatalf("wrap() = %v", err)
+	}
+	want := map[string]string{
+		"source": "registry.example.com/source",
+		"cache":  "cache.example.com/build-cache",
+	}
+	if d := cmp.Diff(want, effective.Targets); d != "" {
+		t.Errorf("targets diff (-want, +got): %s", d)
+	}
+}
+
+func TestWrapTargetVariables(t *testing.T) {
+	source := []string{"source"}
+	for _, tc := range []struct {
+		name    string
+		tasks   []v1beta1.PipelineTask
+		want    map[string][]string
+		wantErr string
+	}{{
+		name:  "sequence",
+		tasks: []v1beta1.PipelineTask{embeddedTask("clone", source), embeddedTask("build", source, "clone"), embeddedTask("test", source, "build")},
+		want: map[string][]string{
+			"clone": {"registry.example.com/dev/build/source:clone"},
+			"build": {"registry.example.com/dev/build/source:clone", "registry.example.com/dev/build/source:build"},
+			"test":  {"registry.example.com/dev/build/source:build"},
+		},
+	}, {
+		name:    "concurrent exporters",
+		tasks:   []v1beta1.PipelineTask{embeddedTask("clone", source), embeddedTask("lint", source, "clone"), embeddedTask("build", source, "clone"), embeddedTask("test", source, "lint", "build")},
+		wantErr: "task test imports workspace source from no single upstream task",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+				Tasks:      tc.tasks,
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params, err := populateParamsWithDefaults(ctx, map[string]string{
+				PipelineRefParam: "build",
+				TargetParam:      "registry.example.com/{{namespace}}/{{pipeline}}/{{workspace}}:{{task}}",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, _, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("wrap() = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+			for _, pt := range wrapped.Spec.Tasks {
+				var scripts strings.Builder
+				for _, s := range pt.TaskSpec.Steps {
+					scripts.WriteString(s.Script)
+				}
+				for _, want := range tc.want[pt.Name] {
+					if !strings.Contains(scripts.String(), want) {
+						t.Errorf("steps of %s don't reference %s:\n%s", pt.Name, want, scripts.String())
+					}
+				}
+				if strings.Contains(scripts.String(), "{{") {
+					t.Errorf("steps of %s hold unrendered variables:\n%s", pt.Name, scripts.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+// pipelineOnlyParams are the params adding finally tasks, which don't apply
+// to the Tasks of TaskRefParam.
+var pipelineOnlyParams = []string{
+	LatestAliasParam,
+	TransactionalParam,
+	VerifyParam,
+	VerifyScriptParam,
+	LineageResultParam,
+	EphemeralWorkspacesParam,
+	WorkspaceManifestParam,
+	ArchiveTargetParam,
+}
+
+// resolveTask wraps the Task with the params, populated with their
+// defaults, for TaskRuns. The Task is wrapped as the single task of a
+// Pipeline, whose workspaces are the ones it declares: like the first task
+// of a Pipeline, it exports its wrapped workspaces, and only imports the
+// ones of ImportSourceParam.
+func (r *Resolver) resolveTask(ctx context.Context, params map[string]string, task *v1beta1.Task) (*ResolvedWrapperResource, error) {
+	ctx = withTraceID(ctx, newTraceID())
+	logger := logging.FromContext(ctx)
+	namespace := common.RequestNamespace(ctx)
+
+	wrapParams := make(map[string]string, len(params))
+	for k, v := range params {
+		wrapParams[k] = v
+	}
+	// No downstream task reads the exports
+	wrapParams[AlwaysExportParam] = "true"
+	pipeline := taskPipeline(task)
+	fetched := pipeline.DeepCopy()
+	newPipeline, effective, err := r.wrapPipeline(ctx, wrapParams, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	embedded := newPipeline.Spec.Tasks[0].TaskSpec
+	newTask := &v1beta1.Task{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        task.Name,
+			Namespace:   task.Namespace,
+			Labels:      task.Labels,
+			Annotations: newPipeline.Annotations,
+		},
+		Spec: embedded.TaskSpec,
+	}
+	// E.g. the prefetch hints, which the TaskRuns propagate to their pods
+	for k, v := range embedded.Metadata.Annotations {
+		newTask.Annotations[k] = v
+	}
+	// Always exported, to no downstream task
+	delete(newTask.Annotations, ExportReadersAnnotation)
+	delete(newTask.Annotations, UnreadExportsAnnotation)
+
+	data, err := marshalTask(ctx, newTask, params[OutputAPIVersionParam])
+	if err != nil {
+		logger.Infof("failed to marshal task %s from namespace %s: %v", task.Name, namespace, err)
+		return nil, err
+	}
+
+	logger.Infof("wrapped task %s from namespace %s", task.Name, namespace)
+	resolved := &ResolvedWrapperResource{
+		Content:     data,
+		TaskRef:     task.Name,
+		Effective:   effective,
+		TraceID:     traceIDFromContext(ctx),
+		ImageDrifts: r.imageDigests.of(effective.Images),
+	}
+	if dryRun, _ := strconv.ParseBool(params[DryRunParam]); dryRun {
+		resolved.Summary = summarize(fetched, newPipeline, effective)
+	}
+	return resolved, nil
+}
+
+// taskPipeline returns the Pipeline running the Task alone, embedded, with
+// its params and workspaces passed through under the same names. The
+// labels and annotations of the Task are the ones of the Pipeline, for
+// pipeline-selector and the mutators.
+func taskPipeline(task *v1beta1.Task) *v1beta1.Pipeline {
+	pt := v1beta1.PipelineTask{
+		Name: task.Name,
+		TaskSpec: &v1beta1.EmbeddedTask{
+			Metadata: v1beta1.PipelineTaskMetadata{Annotations: task.Annotations},
+			TaskSpec: *task.Spec.DeepCopy(),
+		},
+	}
+	p := &v1beta1.Pipeline{ObjectMeta: *task.ObjectMeta.DeepCopy()}
+	for _, param := range task.Spec.Params {
+		p.Spec.Params = append(p.Spec.Params, param)
+		value := fmt.Sprintf("$(params.%s)", param.Name)
+		if param.Type == v1beta1.ParamTypeArray || param.Type == v1beta1.ParamTypeObject {
+			value = fmt.Sprintf("$(params.%s[*])", param.Name)
+		}
+		pt.Params = append(pt.Params, v1beta1.Param{Name: param.Name, Value: *v1beta1.NewStructuredValues(value)})
+	}
+	for _, w := range task.Spec.Workspaces {
+		p.Spec.Workspaces = append(p.Spec.Workspaces, v1beta1.PipelineWorkspaceDeclaration{Name: w.Name, Optional: w.Optional})
+		pt.Workspaces = append(pt.Workspaces, v1beta1.WorkspacePipelineTaskBinding{Name: w.Name, Workspace: w.Name})
+	}
+	p.Spec.Tasks = []v1beta1.PipelineTask{pt}
+	return p
+}
+
+// marshalTask returns the wrapped Task in YAML, as apiVersion:
+// APIVersionV1beta1, or APIVersionV1 converting it, see marshalPipeline.
+func marshalTask(ctx context.Context, t *v1beta1.Task, apiVersion string) ([]byte, error) {
+	t.Kind = "Task"
+	t.APIVersion = APIVersionV1beta1
+	if apiVersion != APIVersionV1 {
+		return yaml.Marshal(t)
+	}
+	converted := &v1.Task{}
+	if err := t.ConvertTo(ctx, converted); err != nil {
+		return nil, fmt.Errorf("converting task %s to %s: %v", t.Name, APIVersionV1, err)
+	}
+	back := &v1beta1.Task{}
+	if err := back.ConvertFrom(ctx, converted); err != nil {
+		return nil, fmt.Errorf("converting task %s to %s: %v", t.Name, APIVersionV1, err)
+	}
+	if !equality.Semantic.DeepEqual(t.Spec, back.Spec) {
+		return nil, fmt.Errorf("task %s can't be converted to %s without dropping some of its fields", t.Name, APIVersionV1)
+	}
+	converted.Kind = "Task"
+	converted.APIVersion = APIVersionV1
+	return yaml.Marshal(converted)
+}
@@ -0,0 +1,104 @@
+package wrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// SkipAnnotation is the annotation of Tasks, or of the embedded taskSpec of
+// PipelineTasks, leaving the task untouched when "true", as if the
+// exclude-tasks of all the requests listed it.
+const SkipAnnotation = "wrap.tekton.dev/skip"
+
+// taskFilter tells which of the tasks binding the wrapped workspaces get
+// the transfer steps, see IncludeTasksParam and ExcludeTasksParam.
+type taskFilter struct {
+	// include are the only tasks wrapped, if not empty.
+	include sets.String
+	exclude sets.String
+	// skipped are the tasks annotated with SkipAnnotation, over include.
+	skipped sets.String
+}
+
+// parseTaskFilter parses IncludeTasksParam and ExcludeTasksParam: comma
+// separated lists of tasks.
+func parseTaskFilter(params map[string]string) (taskFilter, error) {
+	f := taskFilter{include: sets.NewString(), exclude: sets.NewString(), skipped: sets.NewString()}
+	for key, names := range map[string]sets.String{IncludeTasksParam: f.include, ExcludeTasksParam: f.exclude} {
+		v, ok := params[key]
+		if !ok {
+			continue
+		}
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return f, fmt.Errorf("invalid value for %s: %q holds an empty task name", key, v)
+			}
+			if names.Has(name) {
+				return f, fmt.Errorf("invalid value for %s: task %s is listed more than once", key, name)
+			}
+			names.Insert(name)
+		}
+	}
+	if both := f.include.Intersection(f.exclude); both.Len() > 0 {
+		return f, fmt.Errorf("%s and %s both list %s", IncludeTasksParam, ExcludeTasksParam, strings.Join(both.List(), ","))
+	}
+	return f, nil
+}
+
+// wraps tells whether the task gets the transfer steps.
+func (f taskFilter) wraps(name string) bool {
+	return (f.include.Len() == 0 || f.include.Has(name)) && !f.exclude.Has(name) && !f.skipped.Has(name)
+}
+
+// skipAnnotated tells whether the annotations of a Task set SkipAnnotation.
+func skipAnnotated(annotations map[string]string) (bool, error) {
+	v, ok := annotations[SkipAnnotation]
+	if !ok {
+		return false, nil
+	}
+	skip, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %q is not a boolean", SkipAnnotation, v)
+	}
+	return skip, nil
+}
+
+// validate checks that the filtered tasks are tasks of the Pipeline, so
+// that a typo doesn't silently wrap a task.
+func (f taskFilter) validate(p *v1beta1.PipelineSpec) error {
+	tasks := sets.NewString()
+	for _, t := range p.Tasks {
+		tasks.Insert(t.Name)
+	}
+	for key, names := range map[string]sets.String{IncludeTasksParam: f.include, ExcludeTasksParam: f.exclude} {
+		if unknown := names.Difference(tasks); unknown.Len() > 0 {
+			return fmt.Errorf("invalid value for %s: %s not tasks of the pipeline", key, strings.Join(unknown.List(), ","))
+		}
+	}
+	return nil
+}
+
+// spec returns the PipelineSpec as the transfers see it: the tasks the
+// filter doesn't wrap don't bind the wrapped workspaces, neither importing
+// nor exporting them.
+func (f taskFilter) spec(p *v1beta1.PipelineSpec, workspaces sets.String) *v1beta1.PipelineSpec {
+	filtered := p.DeepCopy()
+	for i, t := range filtered.Tasks {
+		if f.wraps(t.Name) {
+			continue
+		}
+		var bindings []v1beta1.WorkspacePipelineTaskBinding
+		for _, w := range t.Workspaces {
+			if !workspaces.Has(w.Workspace) {
+				bindings = append(bindings, w)
+			}
+		}
+		filtered.Tasks[i].Workspaces = bindings
+	}
+	return filtered
+}
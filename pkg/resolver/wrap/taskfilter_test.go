@@ -0,0 +1,159 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestParseTaskFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		params    map[string]string
+		wrapped   []string
+		unwrapped []string
+		wantErr   bool
+	}{
+		{name: "all", params: map[string]string{}, wrapped: []string{"clone", "lint"}},
+		{name: "include", params: map[string]string{IncludeTasksParam: "clone,build"}, wrapped: []string{"clone", "build"}, unwrapped: []string{"lint"}},
+		{name: "exclude", params: map[string]string{ExcludeTasksParam: "lint, unit-tests"}, wrapped: []string{"clone"}, unwrapped: []string{"lint", "unit-tests"}},
+		{name: "both", params: map[string]string{IncludeTasksParam: "clone,lint", ExcludeTasksParam: "lint"}, wantErr: true},
+		{name: "empty name", params: map[string]string{ExcludeTasksParam: "lint,"}, wantErr: true},
+		{name: "listed twice", params: map[string]string{IncludeTasksParam: "lint,lint"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := parseTaskFilter(tc.params)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseTaskFilter() = %v, want error %t", err, tc.wantErr)
+			}
+			for _, name := range tc.wrapped {
+				if !f.wraps(name) {
+					t.Errorf("wraps(%s) = false, want true", name)
+				}
+			}
+			for _, name := range tc.unwrapped {
+				if f.wraps(name) {
+					t.Errorf("wraps(%s) = true, want false", name)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipAnnotated(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+		wantErr     bool
+	}{
+		{name: "not annotated", annotations: map[string]string{"other": "true"}},
+		{name: "skipped", annotations: map[string]string{SkipAnnotation: "true"}, want: true},
+		{name: "not skipped", annotations: map[string]string{SkipAnnotation: "false"}},
+		{name: "invalid", annotations: map[string]string{SkipAnnotation: "yes please"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := skipAnnotated(tc.annotations)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("skipAnnotated() = %v, want error %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("skipAnnotated() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrapExcludedTasks(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		lintAnnotations map[string]string
+		extra           map[string]string
+	}{
+		{name: "exclude-tasks", extra: map[string]string{ExcludeTasksParam: "lint"}},
+		{name: "skip annotation", lintAnnotations: map[string]string{SkipAnnotation: "true"}},
+		{name: "skip annotation over include-tasks", lintAnnotations: map[string]string{SkipAnnotation: "true"}, extra: map[string]string{IncludeTasksParam: "clone,lint,build"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source := []string{"source"}
+			lint := embeddedTask("lint", source, "clone")
+			lint.TaskSpec.Metadata.Annotations = tc.lintAnnotations
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+				Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", source), lint, embeddedTask("build", source, "lint")},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			params := map[string]string{
+				PipelineRefParam: "build",
+				WorkspacesParam:  "source",
+				TargetParam:      "registry.example.com/{{workspace}}",
+			}
+			for k, v := range tc.extra {
+				params[k] = v
+			}
+			ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params, err := populateParamsWithDefaults(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, _, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+			for _, pt := range wrapped.Spec.Tasks {
+				steps := sets.NewString()
+				for _, s := range pt.TaskSpec.Steps {
+					steps.Insert(s.Name)
+				}
+				switch pt.Name {
+				case "lint":
+					if steps.Len() != 1 {
+						t.Errorf("unwrapped task lint has steps %v, want its own only", steps.List())
+					}
+				case "clone":
+					if !steps.Has("export-workspace") {
+						t.Errorf("task clone has steps %v, want it to export for build", steps.List())
+					}
+				case "build":
+					if !steps.Has("import-workspace") {
+						t.Errorf("task build has steps %v, want it to import", steps.List())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWrapUnknownFilteredTask(t *testing.T) {
+	pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+		Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+		Tasks: []v1beta1.PipelineTask{{
+			Name:       "clone",
+			Workspaces: []v1beta1.WorkspacePipelineTaskBinding{{Name: "source", Workspace: "source"}},
+			TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+				Workspaces: []v1beta1.WorkspaceDeclaration{{Name: "source"}},
+				Steps:      []v1beta1.Step{{Name: "clone", Image: "busybox", Script: "true"}},
+			}},
+		}},
+	}}
+	pipeline.Name, pipeline.Namespace = "build", "dev"
+	ctx := framework.InjectResolverConfigToContext(context.Background(), map[string]string{"default-wrapper": OCIWrapper})
+	ctx = common.InjectRequestNamespace(ctx, "dev")
+	params, err := populateParamsWithDefaults(ctx, map[string]string{
+		PipelineRefParam:  "build",
+		WorkspacesParam:   "source",
+		TargetParam:       "registry.example.com/{{workspace}}",
+		IncludeTasksParam: "deploy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := (&Resolver{}).wrap(ctx, params, pipeline); err == nil {
+		t.Error("wrap() = nil, want an error for the unknown task")
+	}
+}
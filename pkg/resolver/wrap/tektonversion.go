@@ -0,0 +1,148 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TektonVersionAnnotation records the lowest Tekton Pipelines release the
+// wrapped Pipeline requires, and why, e.g. "v0.43.0 (tekton.dev/v1)".
+const TektonVersionAnnotation = "wrap.tekton.dev/tekton-version"
+
+// pipelinesInfoName is the ConfigMap of the Tekton Pipelines installation
+// holding its version.
+const pipelinesInfoName = "pipelines-info"
+
+// tektonRequirement is the lowest Tekton Pipelines release serving a field
+// the wrapped Pipelines may use.
+type tektonRequirement struct {
+	version tektonVersion
+	feature string
+}
+
+// tektonVersion is a release of Tekton Pipelines: major, minor and patch.
+type tektonVersion [3]int
+
+func parseTektonVersion(v string) (tektonVersion, error) {
+	parts := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".", 3)
+	var version tektonVersion
+	if len(parts) != 3 {
+		return version, fmt.Errorf("%q is not a version", v)
+	}
+	// Pre-releases are compared as their release
+	parts[2], _, _ = strings.Cut(parts[2], "-")
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version, fmt.Errorf("%q is not a version", v)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+func (v tektonVersion) less(o tektonVersion) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+func (v tektonVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v[0], v[1], v[2])
+}
+
+// requiredTektonVersion returns the lowest release of Tekton Pipelines
+// serving the fields of the wrapped Pipeline, marshalled as apiVersion,
+// the ones older controllers reject. The fields of the enable-api-fields
+// feature flag are checked by checkFeatureFlags.
+func requiredTektonVersion(p *v1beta1.Pipeline, apiVersion string) tektonRequirement {
+	required := tektonRequirement{version: tektonVersion{0, 0, 0}}
+	need := func(version tektonVersion, feature string) {
+		if required.version.less(version) {
+			required = tektonRequirement{version: version, feature: feature}
+		}
+	}
+	if apiVersion == APIVersionV1 {
+		need(tektonVersion{0, 43, 0}, APIVersionV1)
+	}
+	for _, t := range append(append([]v1beta1.PipelineTask{}, p.Spec.Tasks...), p.Spec.Finally...) {
+		for _, w := range t.WhenExpressions {
+			if strings.Contains(w.Input, v1beta1.PipelineTasksAggregateStatus) {
+				need(tektonVersion{0, 30, 0}, "$("+v1beta1.PipelineTasksAggregateStatus+")")
+			}
+		}
+		if t.TaskSpec == nil {
+			continue
+		}
+		for _, r := range t.TaskSpec.Results {
+			if r.Type == v1beta1.ResultsTypeObject {
+				need(tektonVersion{0, 38, 0}, "object results")
+			}
+		}
+		for _, s := range t.TaskSpec.Steps {
+			if s.OnError != "" {
+				need(tektonVersion{0, 27, 0}, "onError")
+			}
+		}
+	}
+	return required
+}
+
+// String formats the requirement as its TektonVersionAnnotation, empty
+// if there's none.
+func (r tektonRequirement) String() string {
+	if r.feature == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s)", r.version, r.feature)
+}
+
+// parseTektonRequirement parses a TektonVersionAnnotation, no requirement
+// if empty or invalid.
+func parseTektonRequirement(v string) tektonRequirement {
+	version, feature, ok := strings.Cut(v, " ")
+	if !ok || !strings.HasPrefix(feature, "(") || !strings.HasSuffix(feature, ")") {
+		return tektonRequirement{}
+	}
+	parsed, err := parseTektonVersion(version)
+	if err != nil {
+		return tektonRequirement{}
+	}
+	return tektonRequirement{version: parsed, feature: strings.TrimSuffix(strings.TrimPrefix(feature, "("), ")")}
+}
+
+// checkTektonVersion returns an error if the Tekton Pipelines release of
+// the cluster, read from the pipelines-info ConfigMap of the
+// feature-flags-namespace of the resolver configuration, is older than
+// the required one, so that the resolution fails with a clear error
+// rather than the admission webhook rejecting the wrapped Pipeline. It is
+// only checked when check-tekton-version is set.
+func (r *Resolver) checkTektonVersion(ctx context.Context, conf map[string]string, required tektonRequirement) error {
+	if conf["check-tekton-version"] != "true" || required.feature == "" {
+		return nil
+	}
+	namespace := conf["feature-flags-namespace"]
+	if namespace == "" {
+		namespace = defaultFeatureFlagsNamespace
+	}
+	cm, err := r.kubeClientSet.CoreV1().ConfigMaps(namespace).Get(ctx, pipelinesInfoName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read the Tekton version of the cluster: %v", err)
+	}
+	installed, err := parseTektonVersion(cm.Data["version"])
+	if err != nil {
+		return fmt.Errorf("failed to read the Tekton version of the cluster from %s/%s: %v", namespace, cm.Name, err)
+	}
+	if installed.less(required.version) {
+		return fmt.Errorf("the wrapped pipeline requires Tekton >= %s for %s but %s/%s is %s", required.version, required.feature, namespace, cm.Name, installed)
+	}
+	return nil
+}
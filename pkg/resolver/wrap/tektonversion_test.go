@@ -0,0 +1,72 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRequiredTektonVersion(t *testing.T) {
+	whenSucceeded := v1beta1.PipelineTask{Name: "tag", WhenExpressions: v1beta1.WhenExpressions{{
+		Input:    "$(" + v1beta1.PipelineTasksAggregateStatus + ")",
+		Operator: selection.In,
+		Values:   []string{"Succeeded"},
+	}}}
+	onError := v1beta1.PipelineTask{Name: "build", TaskSpec: &v1beta1.EmbeddedTask{TaskSpec: v1beta1.TaskSpec{
+		Steps: []v1beta1.Step{{Name: "build", OnError: v1beta1.Continue}},
+	}}}
+	for _, tc := range []struct {
+		name       string
+		spec       v1beta1.PipelineSpec
+		apiVersion string
+		want       string
+	}{
+		{name: "nothing", apiVersion: APIVersionV1beta1, want: ""},
+		{name: "v1", apiVersion: APIVersionV1, want: "v0.43.0 (tekton.dev/v1)"},
+		{name: "onError", spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{onError}}, apiVersion: APIVersionV1beta1, want: "v0.27.0 (onError)"},
+		{name: "highest", spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{onError}, Finally: []v1beta1.PipelineTask{whenSucceeded}}, apiVersion: APIVersionV1beta1, want: "v0.30.0 ($(tasks.status))"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := requiredTektonVersion(&v1beta1.Pipeline{Spec: tc.spec}, tc.apiVersion)
+			if got.String() != tc.want {
+				t.Errorf("requiredTektonVersion() = %q, want %q", got, tc.want)
+			}
+			if parsed := parseTektonRequirement(got.String()); parsed != got {
+				t.Errorf("parseTektonRequirement(%q) = %v, want %v", got, parsed, got)
+			}
+		})
+	}
+}
+
+func TestCheckTektonVersion(t *testing.T) {
+	required := tektonRequirement{version: tektonVersion{0, 43, 0}, feature: APIVersionV1}
+	for _, tc := range []struct {
+		name      string
+		conf      map[string]string
+		installed string
+		required  tektonRequirement
+		wantErr   bool
+	}{
+		{name: "not checked", conf: map[string]string{}, installed: "v0.40.0", required: required},
+		{name: "nothing required", conf: map[string]string{"check-tekton-version": "true"}, installed: "v0.40.0"},
+		{name: "recent enough", conf: map[string]string{"check-tekton-version": "true"}, installed: "v0.44.1", required: required},
+		{name: "release candidate", conf: map[string]string{"check-tekton-version": "true"}, installed: "v0.43.0-rc.1", required: required},
+		{name: "too old", conf: map[string]string{"check-tekton-version": "true"}, installed: "v0.40.2", required: required, wantErr: true},
+		{name: "unknown version", conf: map[string]string{"check-tekton-version": "true"}, installed: "devel", required: required, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Resolver{kubeClientSet: fake.NewSimpleClientset(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: pipelinesInfoName, Namespace: defaultFeatureFlagsNamespace},
+				Data:       map[string]string{"version": tc.installed},
+			})}
+			if err := r.checkTektonVersion(context.Background(), tc.conf, tc.required); (err != nil) != tc.wantErr {
+				t.Errorf("checkTektonVersion() = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package wrap
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+)
+
+// paramTemplateRegex matches the {{param:<name>}} templates, rendered with
+// the value of the param <name> of the request.
+var paramTemplateRegex = regexp.MustCompile(`{{param:([^}]*)}}`)
+
+// unsafeReferenceRegex matches the runs of characters a param value can't
+// hold in an image reference or an S3 key.
+var unsafeReferenceRegex = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// templatedParams are the params holding {{param:<name>}} templates.
+var templatedParams = []string{TargetParam, TargetsParam, S3TargetParam, GCSTargetParam, RsyncTargetParam, ImportSourceParam, SeedImageParam}
+
+// renderParamTemplates replaces the {{param:<name>}} templates of the
+// templatedParams with the value of the param <name> of the request, e.g.
+// a git-branch param for a lineage of images per branch. The value is
+// lowercased and the other characters than letters, digits, '.', '_' and
+// '-' replaced by '-', so that feature/Login gives feature-login.
+func renderParamTemplates(params map[string]string) error {
+	for _, key := range templatedParams {
+		v, ok := params[key]
+		if !ok {
+			continue
+		}
+		var err error
+		params[key] = paramTemplateRegex.ReplaceAllStringFunc(v, func(template string) string {
+			name := paramTemplateRegex.FindStringSubmatch(template)[1]
+			value, ok := params[name]
+			switch {
+			case !ok && err == nil:
+				err = fmt.Errorf("invalid value for %s: %s references the param %s, missing from the request", key, template, name)
+			case ok:
+				value = strings.Trim(unsafeReferenceRegex.ReplaceAllString(strings.ToLower(value), "-"), "-.")
+				if value == "" && err == nil {
+					err = fmt.Errorf("invalid value for %s: %s renders empty", key, template)
+				}
+			}
+			return value
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderDefaultTarget composes TargetParam with the default-target of the
+// resolver configuration, a registry and repository prefix, e.g.
+// registry.example.com/wrap: the target defaults to
+// <default-target>/{{workspace}}, and the targets not starting with a
+// registry host, e.g. team/{{workspace}}, are prefixed with it, the ones
+// of TargetsParam included.
+func renderDefaultTarget(conf, params map[string]string) {
+	prefix, ok := conf["default-target"]
+	if !ok {
+		return
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	v, ok := params[TargetParam]
+	switch {
+	case !ok:
+		params[TargetParam] = prefix + "/{{workspace}}"
+	case !isArchive(v) && !hasRegistry(v):
+		params[TargetParam] = prefix + "/" + strings.TrimPrefix(v, "/")
+	}
+	// Invalid targets are reported with the other params
+	if targets, err := parseTargets(params[TargetsParam]); err == nil && len(targets) > 0 {
+		for ws, target := range targets {
+			if !isArchive(target) && !hasRegistry(target) {
+				targets[ws] = prefix + "/" + strings.TrimPrefix(target, "/")
+			}
+		}
+		params[TargetsParam] = formatTargets(targets)
+	}
+}
+
+// hasRegistry tells whether the image reference starts with a registry
+// host, like name.ParseReference tells it from a docker.io repository: its
+// first component holds a '.' or a ':', or is localhost.
+func hasRegistry(ref string) bool {
+	host, _, ok := strings.Cut(ref, "/")
+	return ok && (strings.ContainsAny(host, ".:") || host == "localhost")
+}
+
+// validateDefaultTarget checks that the default-target is a repository
+// with a registry host, the targets it prefixes being relative to it.
+func validateDefaultTarget(v string) error {
+	if !hasRegistry(v) {
+		return fmt.Errorf("%q doesn't start with a registry host", v)
+	}
+	return validateReference(strings.TrimSuffix(v, "/") + "/workspace")
+}
+
+// The variables of the templatedParams, besides {{workspace}}.
+const (
+	// namespaceVariable is replaced by the namespace of the request.
+	namespaceVariable = "{{namespace}}"
+	// runVariable is replaced by the Tekton variable of the name of the
+	// PipelineRun, which Tekton replaces at run time.
+	runVariable = "{{context.pipelineRun.name}}"
+	// pipelineVariable is replaced by the name of the wrapped Pipeline,
+	// once fetched.
+	pipelineVariable = "{{pipeline}}"
+	// taskVariable is replaced by the name of the task exporting the
+	// workspace, or by the one of its upstream exporter in the imports,
+	// so that each task pushes its own image, see taskTarget.
+	taskVariable = "{{task}}"
+)
+
+// renderRequestVariables replaces namespaceVariable and runVariable in the
+// templatedParams, so that the image names encode where they come from
+// and don't collide across namespaces.
+func renderRequestVariables(ctx context.Context, params map[string]string) error {
+	namespace := common.RequestNamespace(ctx)
+	for _, key := range templatedParams {
+		v, ok := params[key]
+		if !ok {
+			continue
+		}
+		if strings.Contains(v, namespaceVariable) && namespace == "" {
+			return fmt.Errorf("invalid value for %s: %s requires the namespace of the request", key, namespaceVariable)
+		}
+		v = strings.ReplaceAll(v, namespaceVariable, namespace)
+		params[key] = strings.ReplaceAll(v, runVariable, "$(context.pipelineRun.name)")
+	}
+	return nil
+}
+
+// renderPipelineVariable returns a copy of the params whose
+// templatedParams have pipelineVariable replaced with the name of the
+// Pipeline, the params being reused for other Pipelines.
+func renderPipelineVariable(params map[string]string, pipeline string) map[string]string {
+	rendered := make(map[string]string, len(params))
+	for k, v := range params {
+		rendered[k] = v
+	}
+	for _, key := range templatedParams {
+		if v, ok := rendered[key]; ok {
+			rendered[key] = strings.ReplaceAll(v, pipelineVariable, pipeline)
+		}
+	}
+	return rendered
+}
+
+// taskTarget returns the target rendered for the task.
+func taskTarget(target, task string) string {
+	return strings.ReplaceAll(target, taskVariable, task)
+}
+
+// sampleTarget returns the target with sample values for the variables
+// only known when wrapping, to be validated beforehand.
+func sampleTarget(target string) string {
+	return strings.NewReplacer(pipelineVariable, "x", taskVariable, "x").Replace(target)
+}
+
+// validateTaskVariable records in errs the errors of the targets holding
+// taskVariable. Their images are in the tags, the repository of each
+// workspace being the same, and the params expecting a single image per
+// workspace, or a target shared by several runs, are not supported.
+func validateTaskVariable(params map[string]string, errs *ParamsError) {
+	var keys []string
+	for _, key := range templatedParams {
+		if key != ImportSourceParam && key != SeedImageParam && strings.Contains(params[key], taskVariable) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	targets := []string{params[TargetParam]}
+	if m, err := parseTargets(params[TargetsParam]); err == nil {
+		for _, target := range m {
+			targets = append(targets, target)
+		}
+	}
+	for _, target := range targets {
+		if strings.Contains(repository(target), taskVariable) {
+			errs.invalid(fmt.Errorf("invalid value for %s: %q holds %s outside of its tag", TargetParam, target, taskVariable))
+		}
+		if len(resultRefTasks(target)) > 0 && strings.Contains(target, taskVariable) {
+			errs.invalid(fmt.Errorf("invalid value for %s: %q holds both %s and task results", TargetParam, target, taskVariable))
+		}
+	}
+	for _, name := range []string{SharedTargetParam, LatestAliasParam, TransactionalParam, VerifyParam} {
+		if enabled, _ := strconv.ParseBool(params[name]); enabled {
+			errs.invalid(fmt.Errorf("%s is not supported with %s in %s", name, taskVariable, strings.Join(keys, ", ")))
+		}
+	}
+	for _, name := range []string{ArchiveTargetParam, EphemeralWorkspacesParam} {
+		if params[name] != "" {
+			errs.invalid(fmt.Errorf("%s is not supported with %s in %s", name, taskVariable, strings.Join(keys, ", ")))
+		}
+	}
+}
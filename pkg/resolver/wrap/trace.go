@@ -0,0 +1,152 @@
+package wrap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+// TraceIDEnv is the environment variable of the injected steps holding the
+// trace ID of the resolution, prefixing their logs, see TraceIDAnnotation.
+const TraceIDEnv = "WRAP_TRACE_ID"
+
+type traceIDKey struct{}
+
+// newTraceID returns a random trace ID, in the format of the W3C trace
+// context.
+func newTraceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// withTraceID returns ctx carrying the trace ID, along with its logger.
+func withTraceID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, traceIDKey{}, id)
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With("traceID", id))
+}
+
+// traceIDFromContext returns the trace ID of ctx, "" if none.
+func traceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// traceSteps sets the trace ID on the injected steps of the wrapped
+// Pipeline, which prefix their output with it: the scripts run wrapped by
+// traceScript, and wrapstep reads it from TraceIDEnv.
+func traceSteps(p *v1beta1.Pipeline, injected map[string][]InjectedStep, ownFinally int, id string) {
+	forInjectedSteps(p, injected, ownFinally, func(s *v1beta1.TaskSpec, steps []int) {
+		for _, i := range steps {
+			s.Steps[i].Env = append(s.Steps[i].Env, corev1.EnvVar{Name: TraceIDEnv, Value: id})
+			if s.Steps[i].Script != "" {
+				s.Steps[i].Script = traceScript(s.Steps[i].Script)
+			}
+		}
+	})
+}
+
+// traceScript returns the shell script running script, prefixing each line
+// of its output with the trace ID and exiting with its exit code. Scripts
+// of POSIX shells run with -c, the other ones, e.g. #!/usr/bin/env
+// python3, from a temporary file with the interpreter of their shebang.
+// Only shell builtins are used, the images of the steps being minimal.
+func traceScript(script string) string {
+	command := []string{"/bin/sh", "-e"}
+	if strings.HasPrefix(script, "#!") {
+		shebang, _, _ := strings.Cut(strings.TrimPrefix(script, "#!"), "\n")
+		command = strings.Fields(shebang)
+	}
+	quoted := "'" + strings.ReplaceAll(script, "'", `'\''`) + "'"
+	setup, run, cleanup := "", strings.Join(command, " ")+" -c "+quoted, ""
+	if !isShell(command) {
+		setup = fmt.Sprintf("script=\"${TMPDIR:-/tmp}/wrap-trace-$$\"\nprintf '%%s' %s > \"${script}\"\n", quoted)
+		run = strings.Join(command, " ") + ` "${script}"`
+		cleanup = "rm -f \"${script}\" 2>/dev/null || true\n"
+	}
+	return fmt.Sprintf(`#!/bin/sh
+%sexec 3>&1
+code=$({ { %s 2>&1; echo $? >&4; } | while IFS= read -r line || [ -n "${line}" ]; do printf '[%%s] %%s\n' "${%s}" "${line}"; done >&3; } 4>&1)
+%sexit "${code}"
+`, setup, run, TraceIDEnv, cleanup)
+}
+
+// isShell returns whether the interpreter of a shebang is a POSIX shell,
+// running its script with -c.
+func isShell(command []string) bool {
+	if len(command) == 0 {
+		return true
+	}
+	interpreter := command[0]
+	if path.Base(interpreter) == "env" {
+		interpreter = ""
+		for _, arg := range command[1:] {
+			if !strings.HasPrefix(arg, "-") {
+				interpreter = arg
+				break
+			}
+		}
+	}
+	switch path.Base(interpreter) {
+	case "sh", "bash", "ash", "dash", "ksh", "zsh", "busybox":
+		return true
+	}
+	return false
+}
+
+// traceIDPlaceholder stands for the trace ID in the resolutions stored in
+// WrappedPipelines, each resolution served from them getting its own, see
+// setTraceID.
+const traceIDPlaceholder = "{{wrap.trace-id}}"
+
+// setTraceID returns the wrapped Pipeline of content, in YAML, and the
+// annotations of its resolution with the trace ID set to id: in the
+// TraceIDEnv of the injected steps and the TraceIDAnnotation.
+func setTraceID(content []byte, annotations map[string]string, id string) ([]byte, map[string]string, error) {
+	p := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &p); err != nil {
+		return nil, nil, err
+	}
+	var set func(v interface{})
+	set = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if _, ok := v["value"]; ok && v["name"] == TraceIDEnv {
+				v["value"] = id
+			}
+			for _, child := range v {
+				set(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				set(child)
+			}
+		}
+	}
+	set(p["spec"])
+	if metadata, ok := p["metadata"].(map[string]interface{}); ok {
+		if a, ok := metadata["annotations"].(map[string]interface{}); ok {
+			a[TraceIDAnnotation] = id
+		}
+	}
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	replaced := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		replaced[k] = v
+	}
+	replaced[TraceIDAnnotation] = id
+	return data, replaced, nil
+}
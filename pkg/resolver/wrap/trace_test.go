@@ -0,0 +1,138 @@
+package wrap
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestTraceScript(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		script      string
+		interpreter string
+		wantOutput  string
+		wantCode    int
+	}{{
+		name:       "default shell",
+		script:     "echo one\necho two >&2",
+		wantOutput: "[trace] one\n[trace] two\n",
+	}, {
+		name:       "shell shebang",
+		script:     "#!/bin/sh\necho 'quoted'\nexit 3",
+		wantOutput: "[trace] quoted\n",
+		wantCode:   3,
+	}, {
+		name:       "failing default shell",
+		script:     "false\necho unreachable",
+		wantOutput: "",
+		wantCode:   1,
+	}, {
+		name:        "python",
+		script:      "#!/usr/bin/env python3\nimport sys\nprint('it\\'s python')\nsys.exit(2)",
+		interpreter: "python3",
+		wantOutput:  "[trace] it's python\n",
+		wantCode:    2,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.interpreter != "" {
+				if _, err := exec.LookPath(tc.interpreter); err != nil {
+					t.Skipf("%s not found", tc.interpreter)
+				}
+			}
+			script := traceScript(tc.script)
+			if !strings.HasPrefix(script, "#!/bin/sh\n") {
+				t.Errorf("traceScript() doesn't run with /bin/sh:\n%s", script)
+			}
+			tmp := t.TempDir()
+			path := filepath.Join(tmp, "script")
+			if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(path)
+			cmd.Env = append(os.Environ(), TraceIDEnv+"=trace", "TMPDIR="+tmp)
+			out, err := cmd.Output()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.wantOutput || code != tc.wantCode {
+				t.Errorf("traceScript() output %q with code %d, want %q with code %d", out, code, tc.wantOutput, tc.wantCode)
+			}
+			if entries, _ := os.ReadDir(tmp); len(entries) != 1 {
+				t.Errorf("traceScript() left temporary files: %v", entries)
+			}
+		})
+	}
+}
+
+func TestSetTraceID(t *testing.T) {
+	content := []byte(`apiVersion: tekton.dev/v1beta1
+kind: Pipeline
+metadata:
+  annotations:
+    wrap.tekton.dev/trace-id: 0123456789abcdef0123456789abcdef
+  name: build
+spec:
+  tasks:
+  - name: build
+    taskSpec:
+      steps:
+      - env:
+        - name: WRAP_TRACE_ID
+          value: 0123456789abcdef0123456789abcdef
+        - name: OTHER
+          value: 0123456789abcdef0123456789abcdef
+        name: wrap-export
+`)
+	annotations := map[string]string{TraceIDAnnotation: "0123456789abcdef0123456789abcdef", "PipelineRef": "build"}
+
+	stored, storedAnnotations, err := setTraceID(content, annotations, traceIDPlaceholder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the unrelated variable keeps it
+	if strings.Count(string(stored), "0123456789abcdef0123456789abcdef") != 1 || storedAnnotations[TraceIDAnnotation] != traceIDPlaceholder {
+		t.Errorf("setTraceID() kept the trace ID:\n%s", stored)
+	}
+	// Served IDs made of digits only must stay strings
+	served, servedAnnotations, err := setTraceID(stored, storedAnnotations, "12345678901234567890123456789012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec struct {
+			Tasks []struct {
+				TaskSpec struct {
+					Steps []struct {
+						Env []struct {
+							Name  string `json:"name"`
+							Value string `json:"value"`
+						} `json:"env"`
+					} `json:"steps"`
+				} `json:"taskSpec"`
+			} `json:"tasks"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal(served, &p); err != nil {
+		t.Fatalf("invalid served content: %v\n%s", err, served)
+	}
+	env := p.Spec.Tasks[0].TaskSpec.Steps[0].Env
+	if env[0].Value != "12345678901234567890123456789012" || env[1].Value != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("setTraceID() env = %v", env)
+	}
+	if p.Metadata.Annotations[TraceIDAnnotation] != "12345678901234567890123456789012" || servedAnnotations[TraceIDAnnotation] != "12345678901234567890123456789012" || servedAnnotations["PipelineRef"] != "build" {
+		t.Errorf("setTraceID() annotations = %v, %v", p.Metadata.Annotations, servedAnnotations)
+	}
+}
@@ -0,0 +1,19 @@
+package wrap
+
+import "strings"
+
+const promoteTaskName = "wrap-promote"
+
+// transactionTarget returns the run-scoped tag the exports of the workspace
+// push to instead of target, in its repository, with TransactionalParam.
+func transactionTarget(target, workspace string) string {
+	return repository(target) + ":wrap-$(context.pipelineRun.uid)-" + workspace
+}
+
+// tagOf returns the tag of the image reference, latest if it has none.
+func tagOf(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
@@ -0,0 +1,135 @@
+package wrap
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Usage describes what a wrapped Pipeline needs at run time, see
+// UsageAnnotation.
+type Usage struct {
+	// Params are the params without a default.
+	Params []string `json:"params,omitempty"`
+	// Workspaces are the workspaces that still have to be bound.
+	Workspaces []string `json:"workspaces,omitempty"`
+	// Credentials are the credentials the injected steps need.
+	Credentials []Credential `json:"credentials,omitempty"`
+	// Tasks are the credentials the injected steps of each task need, for
+	// the PipelineRuns setting the service account of tasks with
+	// taskRunSpecs.
+	Tasks []TaskUsage `json:"tasks,omitempty"`
+}
+
+// TaskUsage lists the registries the service account of a task has to be
+// able to pull from and push to, and the S3 buckets it accesses.
+type TaskUsage struct {
+	Name    string   `json:"name"`
+	Pull    []string `json:"pull,omitempty"`
+	Push    []string `json:"push,omitempty"`
+	Buckets []string `json:"buckets,omitempty"`
+}
+
+// newTaskUsage returns the usage of a task given its imports and exports.
+func newTaskUsage(task string, imports, exports []workspaceTransfer) TaskUsage {
+	pull, push, buckets := sets.NewString(), sets.NewString(), sets.NewString()
+	for _, t := range imports {
+		switch t.Wrapper {
+		case OCIWrapper:
+			pull.Insert(registry(t.Source))
+		case S3Wrapper, GCSWrapper:
+			buckets.Insert(bucket(t.Source))
+		}
+	}
+	for _, t := range exports {
+		switch t.Wrapper {
+		case OCIWrapper:
+			push.Insert(registry(t.Target))
+		case S3Wrapper, GCSWrapper:
+			buckets.Insert(bucket(t.Target))
+		}
+	}
+	return TaskUsage{Name: task, Pull: pull.List(), Push: push.List(), Buckets: buckets.List()}
+}
+
+// Credential is a credential the injected steps need: the registries the
+// service account of the PipelineRun has to be able to push to and pull
+// from, the S3 buckets and the Secret holding the AWS credentials (the
+// ones of the service account if empty), the GCS buckets, or the Secret
+// holding the rsync credentials.
+type Credential struct {
+	Type       string   `json:"type"`
+	Registries []string `json:"registries,omitempty"`
+	Buckets    []string `json:"buckets,omitempty"`
+	Secret     string   `json:"secret,omitempty"`
+}
+
+// pipelineUsage returns what the wrapped Pipeline needs at run time, given
+// the targets of the wrapped workspaces, the overridden import sources and
+// the manifest reference, if any.
+func pipelineUsage(p *v1beta1.Pipeline, targets map[string]string, w wrappers, o stepOptions, sources importSources, manifest string) Usage {
+	var u Usage
+	for _, param := range p.Spec.Params {
+		if param.Default == nil {
+			u.Params = append(u.Params, param.Name)
+		}
+	}
+	for _, ws := range p.Spec.Workspaces {
+		if !ws.Optional {
+			u.Workspaces = append(u.Workspaces, ws.Name)
+		}
+	}
+
+	registries, buckets, gcsBuckets := sets.NewString(), sets.NewString(), sets.NewString()
+	for ws, target := range targets {
+		switch w.get(ws) {
+		case OCIWrapper:
+			registries.Insert(registry(target))
+		case S3Wrapper:
+			buckets.Insert(bucket(target))
+		case GCSWrapper:
+			gcsBuckets.Insert(bucket(target))
+		}
+	}
+	for _, image := range sources {
+		registries.Insert(registry(image))
+	}
+	if manifest != "" && manifest != manifestResult {
+		registries.Insert(registry(manifest))
+	}
+	if registries.Len() > 0 {
+		u.Credentials = append(u.Credentials, Credential{Type: OCIWrapper, Registries: registries.List()})
+	}
+	if buckets.Len() > 0 {
+		u.Credentials = append(u.Credentials, Credential{Type: S3Wrapper, Buckets: buckets.List(), Secret: o.S3.CredentialsSecret})
+	}
+	if gcsBuckets.Len() > 0 {
+		u.Credentials = append(u.Credentials, Credential{Type: GCSWrapper, Buckets: gcsBuckets.List()})
+	}
+	if w.uses(RsyncWrapper) && o.Rsync.CredentialsSecret != "" {
+		u.Credentials = append(u.Credentials, Credential{Type: RsyncWrapper, Secret: o.Rsync.CredentialsSecret})
+	}
+	sort.Strings(u.Params)
+	sort.Strings(u.Workspaces)
+	return u
+}
+
+// bucket returns the bucket of the s3:// or gs:// URL.
+func bucket(url string) string {
+	for _, scheme := range []string{"s3://", "gs://"} {
+		url = strings.TrimPrefix(url, scheme)
+	}
+	return strings.SplitN(url, "/", 2)[0]
+}
+
+// registry returns the registry of the image reference, which may hold
+// variables replaced at run time.
+func registry(ref string) string {
+	if r, err := name.ParseReference(ref); err == nil {
+		return r.Context().RegistryStr()
+	}
+	return strings.SplitN(ref, "/", 2)[0]
+}
@@ -0,0 +1,40 @@
+package wrap
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// vaultOptions holds the settings of the vault credential provider of the
+// wrapstep helper, set as its environment variables, see
+// wrapstep.VaultAddrEnv.
+type vaultOptions struct {
+	// Address is the address of Vault, e.g. https://vault.vault:8200.
+	Address string
+	// AuthPath is the mount path of the kubernetes auth method.
+	AuthPath string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+}
+
+func newVaultOptions(conf map[string]string) vaultOptions {
+	return vaultOptions{
+		Address:   conf["vault-address"],
+		AuthPath:  conf["vault-auth-path"],
+		Namespace: conf["vault-namespace"],
+	}
+}
+
+// env returns the environment variables of the wrapstep steps.
+func (o vaultOptions) env() []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, e := range []corev1.EnvVar{
+		{Name: "VAULT_ADDR", Value: o.Address},
+		{Name: "VAULT_AUTH_PATH", Value: o.AuthPath},
+		{Name: "VAULT_NAMESPACE", Value: o.Namespace},
+	} {
+		if e.Value != "" {
+			env = append(env, e)
+		}
+	}
+	return env
+}
@@ -0,0 +1,73 @@
+package wrap
+
+import (
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	verifyTaskName     = "wrap-verify"
+	verifyMountPath    = "/wrap/verify"
+	verifyVolumeName   = "wrap-verify"
+	defaultVerifyImage = "docker.io/library/busybox:1.36"
+)
+
+// verifyTask returns a finally task importing the final image of each
+// workspace, in verifyMountPath/<workspace>, and running the script in
+// image, if any, once all the tasks of the run succeeded or were skipped.
+// The imports fail on missing images, even with best-effort exports. It
+// also returns the imports of the task.
+func verifyTask(o stepOptions, targets map[string]string, w wrappers, workspaces []string, script, image string) (v1beta1.PipelineTask, []workspaceTransfer) {
+	pt := v1beta1.PipelineTask{
+		Name: verifyTaskName,
+		WhenExpressions: v1beta1.WhenExpressions{{
+			Input:    "$(" + v1beta1.PipelineTasksAggregateStatus + ")",
+			Operator: selection.In,
+			Values:   []string{"Succeeded", "Completed"},
+		}},
+	}
+	s := &v1beta1.TaskSpec{StepTemplate: &v1beta1.StepTemplate{}}
+	var transfers []workspaceTransfer
+	for _, ws := range workspaces {
+		// The imports extract in existing directories
+		volume := verifyVolumeName + "-" + ws
+		s.Volumes = append(s.Volumes, corev1.Volume{
+			Name:         volume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		s.StepTemplate.VolumeMounts = append(s.StepTemplate.VolumeMounts, corev1.VolumeMount{Name: volume, MountPath: verifyMountPath + "/" + ws})
+		t := workspaceTransfer{
+			Workspace:  ws,
+			Wrapper:    w.get(ws),
+			MountPath:  verifyMountPath + "/" + ws,
+			Target:     targets[ws],
+			Source:     targets[ws],
+			Repository: repository(targets[ws]),
+		}
+		if len(resultRefTasks(t.Target)) > 0 {
+			t.Target = paramTarget(&pt, s, ws, t.Target)
+			t.Source = t.Target
+		}
+		transfers = append(transfers, t)
+	}
+
+	o.BestEffort = false
+	s.Steps = importSteps(o, transfers)
+	if o.LayerCachePath != "" && len(byWrapper(transfers)[OCIWrapper]) > 0 {
+		addLayerCacheVolume(s, o.LayerCachePath)
+	}
+	if len(byWrapper(transfers)[ArchiveWrapper]) > 0 {
+		addArchiveVolume(s, o.ArchivePath)
+	}
+	if script != "" {
+		s.Steps = append(s.Steps, o.withSecurityContext([]v1beta1.Step{{
+			Name:       "verify",
+			Image:      image,
+			WorkingDir: verifyMountPath,
+			Script:     script,
+		}})...)
+	}
+	pt.TaskSpec = &v1beta1.EmbeddedTask{TaskSpec: *s}
+	return pt, transfers
+}
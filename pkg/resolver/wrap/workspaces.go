@@ -0,0 +1,248 @@
+package wrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-pipelines/tekton-wrap-pipeline/pkg/wrapstep"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/container"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// allWorkspaces is the WorkspacesParam wrapping all the workspaces the
+// Pipeline declares, its default.
+const allWorkspaces = "*"
+
+// wrappedWorkspaces returns the workspaces of WorkspacesParam, the ones the
+// Pipeline declares for allWorkspaces.
+func wrappedWorkspaces(value string, p *v1beta1.PipelineSpec) (sets.String, error) {
+	if value != allWorkspaces {
+		return sets.NewString(strings.Split(value, ",")...), nil
+	}
+	workspaces := sets.NewString()
+	for _, w := range p.Workspaces {
+		workspaces.Insert(w.Name)
+	}
+	if workspaces.Len() == 0 {
+		return nil, fmt.Errorf("the pipeline declares no workspace to wrap")
+	}
+	return workspaces, nil
+}
+
+// validateWorkspaceParams records in errs the errors of the params
+// rendered or checked for each wrapped workspace: the targets of the ones
+// using the oci wrapper, the TargetsParam, and the ephemeral-workspaces.
+func validateWorkspaceParams(conf, params map[string]string, workspaces []string, w wrappers, errs *ParamsError) {
+	wrapped := sets.NewString(workspaces...)
+	if v := params[EphemeralWorkspacesParam]; v != "" {
+		for _, ws := range strings.Split(v, ",") {
+			if !wrapped.Has(ws) {
+				errs.invalid(fmt.Errorf("invalid value for %s: %s is not a wrapped workspace", EphemeralWorkspacesParam, ws))
+			}
+			if w.get(ws) != OCIWrapper || isArchive(workspaceTarget(params, TargetParam, ws)) {
+				errs.invalid(fmt.Errorf("invalid value for %s: workspace %s isn't wrapped with %s", EphemeralWorkspacesParam, ws, OCIWrapper))
+			}
+		}
+	}
+	if v, ok := params[TargetsParam]; ok {
+		targets, _ := parseTargets(v)
+		for ws := range targets {
+			if !wrapped.Has(ws) {
+				errs.invalid(fmt.Errorf("invalid value for %s: %s is not a wrapped workspace", TargetsParam, ws))
+				continue
+			}
+			// The templates are wrappers targeting TargetParam
+			if s, ok := strategy(w.get(ws)); ok && s.targetParam != TargetParam {
+				errs.invalid(fmt.Errorf("invalid value for %s: the %s wrapper of workspace %s doesn't use %s", TargetsParam, w.get(ws), ws, TargetParam))
+			}
+		}
+	}
+	if _, ok := params[TargetParam]; ok || params[TargetsParam] != "" {
+		for _, ws := range workspaces {
+			if w.get(ws) != OCIWrapper {
+				continue
+			}
+			target := workspaceTarget(params, TargetParam, ws)
+			if target == "" {
+				errs.invalid(fmt.Errorf("workspace %s has no target, neither %s nor %s set it", ws, TargetParam, TargetsParam))
+				continue
+			}
+			if isArchive(target) {
+				if err := FeatureArchiveTargets.check(conf); err != nil {
+					errs.invalid(err)
+					break
+				}
+				if shared, _ := strconv.ParseBool(params[SharedTargetParam]); shared {
+					errs.invalid(fmt.Errorf("%s is not supported with docker-archive targets", SharedTargetParam))
+					break
+				}
+				if c := params[CompressionParam]; c != "" && c != wrapstep.CompressionGzip {
+					errs.invalid(fmt.Errorf("%s %s is not supported with docker-archive targets", CompressionParam, c))
+					break
+				}
+				if err := validateArchiveTarget(sampleTarget(target), conf["archive-path"]); err != nil {
+					errs.invalid(fmt.Errorf("invalid value for %s for workspace %s: %v", TargetParam, ws, err))
+				}
+				continue
+			}
+			if err := validateReference(sampleTarget(target)); err != nil {
+				errs.invalid(fmt.Errorf("invalid value for %s for workspace %s: %v", TargetParam, ws, err))
+			}
+		}
+	}
+	if v, ok := params[ArchiveTargetParam]; ok {
+		for _, ws := range workspaces {
+			if w.get(ws) != OCIWrapper {
+				continue
+			}
+			if err := validateReference(strings.ReplaceAll(v, "{{workspace}}", ws)); err != nil {
+				errs.invalid(fmt.Errorf("invalid value for %s for workspace %s: %v", ArchiveTargetParam, ws, err))
+			}
+		}
+	}
+}
+
+// sampleWorkspace is the workspace the targets are rendered with until the
+// wrapped workspaces are known.
+const sampleWorkspace = "workspace"
+
+// validateSampleTargets records in errs the targets of the oci workspaces
+// which aren't valid image references, before the Pipeline is fetched for
+// allWorkspaces: TargetParam rendered with sampleWorkspace, and the ones
+// of TargetsParam. They are checked again for each workspace when
+// wrapping.
+func validateSampleTargets(params map[string]string, w wrappers, errs *ParamsError) {
+	if v, ok := params[TargetParam]; ok && w.get("") == OCIWrapper && !isArchive(v) {
+		if err := validateReference(sampleTarget(strings.ReplaceAll(v, "{{workspace}}", sampleWorkspace))); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s: %v", TargetParam, err))
+		}
+	}
+	targets, _ := parseTargets(params[TargetsParam])
+	for _, ws := range sets.StringKeySet(targets).List() {
+		if w.get(ws) != OCIWrapper || isArchive(targets[ws]) {
+			continue
+		}
+		if err := validateReference(sampleTarget(targets[ws])); err != nil {
+			errs.invalid(fmt.Errorf("invalid value for %s for workspace %s: %v", TargetsParam, ws, err))
+		}
+	}
+}
+
+// stripWorkspace replaces the workspace declared as name in the TaskSpec
+// with an emptyDir volume mounted at the same path in every step.
+// References to the workspace variables are replaced accordingly.
+// The emptyDir volume is limited to sizeLimit, if any.
+func stripWorkspace(s *v1beta1.TaskSpec, name string, sizeLimit *resource.Quantity) {
+	var declaration v1beta1.WorkspaceDeclaration
+	var declarations []v1beta1.WorkspaceDeclaration
+	for _, d := range s.Workspaces {
+		if d.Name == name {
+			declaration = d
+			continue
+		}
+		declarations = append(declarations, d)
+	}
+	s.Workspaces = declarations
+
+	volumeName := workspaceVolumeName(name)
+	mountPath := declaration.GetMountPath()
+	s.Volumes = append(s.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				SizeLimit: sizeLimit,
+			},
+		},
+	})
+
+	replacements := map[string]string{
+		fmt.Sprintf("workspaces.%s.path", name):   mountPath,
+		fmt.Sprintf("workspaces.%s.bound", name):  "true",
+		fmt.Sprintf("workspaces.%s.claim", name):  "",
+		fmt.Sprintf("workspaces.%s.volume", name): volumeName,
+	}
+	noArrayReplacements := map[string][]string{}
+	for i := range s.Steps {
+		container.ApplyStepReplacements(&s.Steps[i], replacements, noArrayReplacements)
+		s.Steps[i].VolumeMounts = append(s.Steps[i].VolumeMounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: mountPath,
+		})
+	}
+	if s.StepTemplate != nil {
+		container.ApplyStepTemplateReplacements(s.StepTemplate, replacements, noArrayReplacements)
+	}
+	for i := range s.Sidecars {
+		container.ApplySidecarReplacements(&s.Sidecars[i], replacements, noArrayReplacements)
+	}
+}
+
+// markStrippedWorkspaces marks the wrapped workspaces that no task binds
+// anymore as optional in the PipelineSpec and returns their names.
+func markStrippedWorkspaces(p *v1beta1.PipelineSpec, workspaces sets.String) []string {
+	bound := sets.NewString()
+	for _, t := range p.Tasks {
+		for _, w := range t.Workspaces {
+			bound.Insert(w.Workspace)
+		}
+	}
+	for _, t := range p.Finally {
+		for _, w := range t.Workspaces {
+			bound.Insert(w.Workspace)
+		}
+	}
+	var stripped []string
+	for i, w := range p.Workspaces {
+		if workspaces.Has(w.Name) && !bound.Has(w.Name) {
+			p.Workspaces[i].Optional = true
+			stripped = append(stripped, w.Name)
+		}
+	}
+	return stripped
+}
+
+func workspaceVolumeName(workspace string) string {
+	return "wrap-" + workspace
+}
+
+// workspaceSizes holds the emptyDir size limits of stripped workspaces. The
+// empty key holds the limit of the workspaces without one of their own.
+type workspaceSizes map[string]resource.Quantity
+
+func (ws workspaceSizes) get(workspace string) *resource.Quantity {
+	if q, ok := ws[workspace]; ok {
+		return &q
+	}
+	if q, ok := ws[""]; ok {
+		return &q
+	}
+	return nil
+}
+
+// parseWorkspaceSizes parses either a single quantity, applying to all
+// workspaces, or a comma separated list of workspace=quantity.
+func parseWorkspaceSizes(value string) (workspaceSizes, error) {
+	sizes := workspaceSizes{}
+	if value == "" {
+		return sizes, nil
+	}
+	for _, s := range strings.Split(value, ",") {
+		var workspace string
+		if i := strings.Index(s, "="); i >= 0 {
+			workspace, s = strings.TrimSpace(s[:i]), s[i+1:]
+			if workspace == "" {
+				return nil, fmt.Errorf("missing workspace name in %q", value)
+			}
+		}
+		q, err := resource.ParseQuantity(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %v", s, err)
+		}
+		sizes[workspace] = q
+	}
+	return sizes, nil
+}
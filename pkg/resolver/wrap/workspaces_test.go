@@ -0,0 +1,69 @@
+package wrap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+func TestWrappedWorkspaces(t *testing.T) {
+	declared := &v1beta1.PipelineSpec{Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}, {Name: "cache"}}}
+	for _, tc := range []struct {
+		name     string
+		value    string
+		pipeline *v1beta1.PipelineSpec
+		want     []string
+		wantErr  bool
+	}{
+		{name: "listed", value: "source", pipeline: declared, want: []string{"source"}},
+		{name: "all", value: allWorkspaces, pipeline: declared, want: []string{"cache", "source"}},
+		{name: "none declared", value: allWorkspaces, pipeline: &v1beta1.PipelineSpec{}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := wrappedWorkspaces(tc.value, tc.pipeline)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("wrappedWorkspaces() = %v, want error %t", err, tc.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got.List(), tc.want) {
+				t.Errorf("wrappedWorkspaces() = %v, want %v", got.List(), tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateWorkspaceParams(t *testing.T) {
+	params := map[string]string{
+		TargetParam:              "registry.example.com/{{workspace}}",
+		EphemeralWorkspacesParam: "tmp",
+	}
+	errs := &ParamsError{}
+	validateWorkspaceParams(map[string]string{}, params, []string{"source", "Cache"}, wrappers{"": OCIWrapper}, errs)
+	if len(errs.Invalid) != 2 {
+		t.Fatalf("validateWorkspaceParams() = %v, want the invalid target of Cache and the unwrapped ephemeral workspace", errs)
+	}
+}
+
+func TestValidateSampleTargets(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		params   map[string]string
+		wrappers wrappers
+		want     int
+	}{
+		{name: "valid", params: map[string]string{TargetParam: "registry.example.com/{{pipeline}}/{{workspace}}:{{task}}"}, wrappers: wrappers{"": OCIWrapper}},
+		{name: "uppercase repository", params: map[string]string{TargetParam: "registry.example.com/Team/{{workspace}}"}, wrappers: wrappers{"": OCIWrapper}, want: 1},
+		{name: "invalid tag", params: map[string]string{TargetParam: "registry.example.com/{{workspace}}:a/b"}, wrappers: wrappers{"": OCIWrapper}, want: 1},
+		{name: "archive", params: map[string]string{TargetParam: "docker-archive:/artifacts/{{workspace}}.tar"}, wrappers: wrappers{"": OCIWrapper}},
+		{name: "s3", params: map[string]string{TargetParam: "s3://bucket/{{workspace}}.tar"}, wrappers: wrappers{"": S3Wrapper}},
+		{name: "invalid targets", params: map[string]string{TargetParam: "registry.example.com/{{workspace}}", TargetsParam: "source=Registry/Source,cache=registry.example.com/cache"}, wrappers: wrappers{"": OCIWrapper}, want: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := &ParamsError{}
+			validateSampleTargets(tc.params, tc.wrappers, errs)
+			if len(errs.Invalid) != tc.want {
+				t.Errorf("validateSampleTargets() = %v, want %d invalid targets", errs.Invalid, tc.want)
+			}
+		})
+	}
+}
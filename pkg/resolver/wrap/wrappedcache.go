@@ -0,0 +1,319 @@
+package wrap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/tektoncd/pipeline/pkg/apis/config"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+// WrappedPipelineResource is the resource of the WrappedPipelines, see
+// config/cache/.
+var WrappedPipelineResource = schema.GroupVersionResource{
+	Group:    "wrap.tekton.dev",
+	Version:  "v1alpha1",
+	Resource: "wrappedpipelines",
+}
+
+// wrappedPipelineSpec is the spec of a WrappedPipeline: a resolution stored
+// by the resolver for the next identical requests, e.g. of nightly runs.
+type wrappedPipelineSpec struct {
+	// Pipeline is the name of the requested Pipeline.
+	Pipeline string `json:"pipeline"`
+	// Key is the digest of what the resolution depends on besides its
+	// sources: the params, the resolver configuration and the resolver.
+	Key string `json:"key"`
+	// Sources are the digests of the sources the content was wrapped from.
+	Sources wrappedSources `json:"sources"`
+	// Content is the wrapped Pipeline.
+	Content string `json:"content"`
+	// Annotations are the annotations of the resolved resource.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// wrappedSources are the sha256 digests of the Pipeline and of the Tasks it
+// references, invalidating the WrappedPipeline when they change.
+type wrappedSources struct {
+	Pipeline string            `json:"pipeline"`
+	Tasks    map[string]string `json:"tasks,omitempty"`
+}
+
+// cacheEntry locates the WrappedPipeline of a request.
+type cacheEntry struct {
+	Namespace string
+	Name      string
+	Key       string
+	Sources   wrappedSources
+	// Tasks are the Tasks fetched for their digests, by pipeline task,
+	// reused when wrapping on a miss.
+	Tasks map[string]*v1beta1.Task
+}
+
+// cacheEntry returns the WrappedPipeline of the request for the Pipeline,
+// nil if the wrapped-pipeline-cache of the resolver configuration isn't
+// enabled. The Tasks the Pipeline references are fetched to compute their
+// digests. The WrappedPipeline is named after the request alone, so that
+// the resolutions with another configuration or resolver release
+// overwrite it rather than pile up.
+func (r *Resolver) cacheEntry(ctx context.Context, params map[string]string, pipeline *v1beta1.Pipeline, original *OriginalPipeline) (*cacheEntry, error) {
+	conf := framework.GetResolverConfigFromContext(ctx)
+	if enabled, _ := strconv.ParseBool(conf["wrapped-pipeline-cache"]); !enabled || r.dynamicClientSet == nil {
+		return nil, nil
+	}
+	// The minted tokens expire
+	if mint, _ := strconv.ParseBool(conf["mint-registry-tokens"]); mint {
+		return nil, nil
+	}
+	build, err := resolverDigest()
+	if err != nil {
+		return nil, fmt.Errorf("computing the digest of the resolver: %v", err)
+	}
+	namespace := common.RequestNamespace(ctx)
+	key, err := json.Marshal(struct {
+		Type      string            `json:"type"`
+		Namespace string            `json:"namespace"`
+		Params    map[string]string `json:"params"`
+		Config    map[string]string `json:"config"`
+		Resolver  string            `json:"resolver"`
+	}{ResolverType(), namespace, params, conf, build})
+	if err != nil {
+		return nil, err
+	}
+	request, err := json.Marshal(struct {
+		Type   string            `json:"type"`
+		Params map[string]string `json:"params"`
+	}{ResolverType(), params})
+	if err != nil {
+		return nil, err
+	}
+	e := &cacheEntry{
+		Namespace: namespace,
+		Name:      fmt.Sprintf("wrapped-%x", sha256.Sum256(request))[:40],
+		Key:       fmt.Sprintf("%x", sha256.Sum256(key)),
+		Sources:   wrappedSources{Pipeline: original.Digest},
+		Tasks:     map[string]*v1beta1.Task{},
+	}
+	for _, t := range pipeline.Spec.Tasks {
+		if t.TaskRef == nil {
+			continue
+		}
+		task, err := r.getPipelineTask(ctx, t.TaskRef)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't fetch task %s: %v", t.TaskRef.Name, err)
+		}
+		e.Tasks[t.Name] = task
+		data, err := json.Marshal(task)
+		if err != nil {
+			return nil, err
+		}
+		if e.Sources.Tasks == nil {
+			e.Sources.Tasks = map[string]string{}
+		}
+		e.Sources.Tasks[t.TaskRef.Name] = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+	return e, nil
+}
+
+// cached returns the resolution stored in the WrappedPipeline of the entry,
+// nil if there is none or if its sources changed since.
+func (r *Resolver) cached(ctx context.Context, e *cacheEntry) framework.ResolvedResource {
+	logger := logging.FromContext(ctx)
+	obj, err := r.dynamicClientSet.Resource(WrappedPipelineResource).Namespace(e.Namespace).Get(ctx, e.Name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			logger.Infof("failed to get wrapped pipeline %s from namespace %s: %v", e.Name, e.Namespace, err)
+		}
+		return nil
+	}
+	spec := wrappedPipelineSpec{}
+	if m, ok := obj.Object["spec"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &spec); err != nil {
+			logger.Infof("invalid wrapped pipeline %s from namespace %s: %v", e.Name, e.Namespace, err)
+			return nil
+		}
+	}
+	if spec.Key != e.Key || !sameSources(spec.Sources, e.Sources) {
+		return nil
+	}
+	// The feature flags of the cluster may have changed since
+	conf := framework.GetResolverConfigFromContext(ctx)
+	if err := r.checkFeatureFlags(ctx, conf, apiFields([]byte(spec.Content))); err != nil {
+		logger.Infof("wrapped pipeline %s from namespace %s not supported anymore: %v", e.Name, e.Namespace, err)
+		return nil
+	}
+	if err := r.checkTektonVersion(ctx, conf, parseTektonRequirement(pipelineAnnotation([]byte(spec.Content), TektonVersionAnnotation))); err != nil {
+		logger.Infof("wrapped pipeline %s from namespace %s not supported anymore: %v", e.Name, e.Namespace, err)
+		return nil
+	}
+	// Each resolution gets its own trace ID
+	id := newTraceID()
+	content, annotations, err := setTraceID([]byte(spec.Content), spec.Annotations, id)
+	if err != nil {
+		logger.Infof("invalid wrapped pipeline %s from namespace %s: %v", e.Name, e.Namespace, err)
+		return nil
+	}
+	annotations[CachedAnnotation] = e.Name
+	// The images may have drifted since
+	effective := &EffectiveSettings{}
+	if err := json.Unmarshal([]byte(annotations[EffectiveParamsAnnotation]), effective); err == nil {
+		if drifts := r.imageDigests.of(effective.Images); len(drifts) > 0 {
+			if b, err := json.Marshal(drifts); err == nil {
+				annotations[ImageDriftAnnotation] = string(b)
+			}
+		}
+	}
+	logger.With("traceID", id).Infof("served pipeline %s from namespace %s from wrapped pipeline %s", spec.Pipeline, e.Namespace, e.Name)
+	return &cachedResource{content: content, annotations: annotations}
+}
+
+// cache stores the resolution in the WrappedPipeline of the entry, owned by
+// the Pipeline so that they are deleted along. Failures are only logged,
+// the next request wrapping the Pipeline again.
+func (r *Resolver) cache(ctx context.Context, e *cacheEntry, pipeline *v1beta1.Pipeline, resolved framework.ResolvedResource) {
+	logger := logging.FromContext(ctx)
+	// The trace ID is the one of this resolution only
+	content, annotations, err := setTraceID(resolved.Data(), resolved.Annotations(), traceIDPlaceholder)
+	if err != nil {
+		logger.Infof("failed to store wrapped pipeline %s in namespace %s: %v", e.Name, e.Namespace, err)
+		return
+	}
+	delete(annotations, ImageDriftAnnotation)
+	spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&wrappedPipelineSpec{
+		Pipeline:    pipeline.Name,
+		Key:         e.Key,
+		Sources:     e.Sources,
+		Content:     string(content),
+		Annotations: annotations,
+	})
+	if err != nil {
+		logger.Infof("failed to store wrapped pipeline %s in namespace %s: %v", e.Name, e.Namespace, err)
+		return
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": WrappedPipelineResource.GroupVersion().String(),
+		"kind":       "WrappedPipeline",
+		"spec":       spec,
+	}}
+	obj.SetName(e.Name)
+	obj.SetNamespace(e.Namespace)
+	// Owners are of the same namespace
+	if pipeline.UID != "" && pipeline.Namespace == e.Namespace {
+		obj.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: "tekton.dev/v1beta1",
+			Kind:       "Pipeline",
+			Name:       pipeline.Name,
+			UID:        pipeline.UID,
+		}})
+	}
+	client := r.dynamicClientSet.Resource(WrappedPipelineResource).Namespace(e.Namespace)
+	_, err = client.Create(ctx, obj, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// The sources changed since it was stored
+		var existing *unstructured.Unstructured
+		existing, err = client.Get(ctx, e.Name, metav1.GetOptions{})
+		if err == nil {
+			obj.SetResourceVersion(existing.GetResourceVersion())
+			_, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		logger.Infof("failed to store wrapped pipeline %s in namespace %s: %v", e.Name, e.Namespace, err)
+	}
+}
+
+// apiFields returns the APIFieldsAnnotation of the wrapped Pipeline of
+// content, stable if it has none.
+func apiFields(content []byte) string {
+	if v := pipelineAnnotation(content, APIFieldsAnnotation); v != "" {
+		return v
+	}
+	return config.StableAPIFields
+}
+
+// pipelineAnnotation returns the annotation of the wrapped Pipeline of
+// content, empty if it has none.
+func pipelineAnnotation(content []byte, key string) string {
+	p := struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}{}
+	if err := yaml.Unmarshal(content, &p); err != nil {
+		return ""
+	}
+	return p.Metadata.Annotations[key]
+}
+
+func sameSources(a, b wrappedSources) bool {
+	if a.Pipeline != b.Pipeline || len(a.Tasks) != len(b.Tasks) {
+		return false
+	}
+	for name, digest := range a.Tasks {
+		if b.Tasks[name] != digest {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedResource is a resolution served from a WrappedPipeline.
+type cachedResource struct {
+	content     []byte
+	annotations map[string]string
+}
+
+var _ framework.ResolvedResource = &cachedResource{}
+
+func (c *cachedResource) Data() []byte {
+	return c.content
+}
+
+func (c *cachedResource) Annotations() map[string]string {
+	return c.annotations
+}
+
+var (
+	resolverDigestOnce  sync.Once
+	resolverDigestValue string
+	resolverDigestErr   error
+)
+
+// resolverDigest returns the sha256 digest of the executable of the
+// resolver, so that upgrades don't serve the resolutions of the previous
+// release.
+func resolverDigest() (string, error) {
+	resolverDigestOnce.Do(func() {
+		path, err := os.Executable()
+		if err != nil {
+			resolverDigestErr = err
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			resolverDigestErr = err
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			resolverDigestErr = err
+			return
+		}
+		resolverDigestValue = fmt.Sprintf("sha256:%x", h.Sum(nil))
+	})
+	return resolverDigestValue, resolverDigestErr
+}
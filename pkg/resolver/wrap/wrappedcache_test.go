@@ -0,0 +1,103 @@
+package wrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestCacheEntry(t *testing.T) {
+	// Never called, cacheEntry only locates the WrappedPipeline
+	r := &Resolver{dynamicClientSet: dynamic.NewForConfigOrDie(&rest.Config{Host: "http://localhost"})}
+	pipeline := &v1beta1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "ci"},
+		Spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{{
+			Name:     "build",
+			TaskSpec: &v1beta1.EmbeddedTask{},
+		}}},
+	}
+	original := newOriginalPipeline(pipeline, []byte("{}"))
+	entry := func(conf, params map[string]string) *cacheEntry {
+		t.Helper()
+		ctx := framework.InjectResolverConfigToContext(common.InjectRequestNamespace(context.Background(), "ci"), conf)
+		e, err := r.cacheEntry(ctx, params, pipeline, original)
+		if err != nil {
+			t.Fatalf("cacheEntry() = %v", err)
+		}
+		return e
+	}
+	enabled := map[string]string{"wrapped-pipeline-cache": "true"}
+	params := map[string]string{PipelineRefParam: "build", WorkspacesParam: "source", TargetParam: "registry.example.com/{{workspace}}"}
+
+	if e := entry(map[string]string{}, params); e != nil {
+		t.Errorf("cacheEntry() = %v without wrapped-pipeline-cache, want nil", e)
+	}
+	if e := entry(map[string]string{"wrapped-pipeline-cache": "true", "mint-registry-tokens": "true"}, params); e != nil {
+		t.Errorf("cacheEntry() = %v with mint-registry-tokens, want nil", e)
+	}
+
+	base := entry(enabled, params)
+	if len(base.Name) > 63 {
+		t.Errorf("cacheEntry() name %s is longer than 63 characters", base.Name)
+	}
+	reconfigured := entry(map[string]string{"wrapped-pipeline-cache": "true", "default-export-chunks": "2"}, params)
+	if reconfigured.Name != base.Name || reconfigured.Key == base.Key {
+		t.Errorf("cacheEntry() with another configuration = %s/%s, want name %s and another key than %s", reconfigured.Name, reconfigured.Key, base.Name, base.Key)
+	}
+	other := entry(enabled, map[string]string{PipelineRefParam: "test", WorkspacesParam: "source", TargetParam: "registry.example.com/{{workspace}}"})
+	if other.Name == base.Name {
+		t.Errorf("cacheEntry() of another request = %s, want another name", other.Name)
+	}
+}
+
+func TestAPIFields(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+	}{{
+		name:    "alpha",
+		content: "metadata:\n  annotations:\n    wrap.tekton.dev/api-fields: alpha\n",
+		want:    "alpha",
+	}, {
+		name:    "unannotated",
+		content: "metadata:\n  name: build\n",
+		want:    "stable",
+	}, {
+		name:    "invalid",
+		content: "[",
+		want:    "stable",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := apiFields([]byte(tc.content)); got != tc.want {
+				t.Errorf("apiFields() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSameSources(t *testing.T) {
+	base := wrappedSources{Pipeline: "sha256:1", Tasks: map[string]string{"build": "sha256:2"}}
+	for _, tc := range []struct {
+		name  string
+		other wrappedSources
+		want  bool
+	}{
+		{"same", wrappedSources{Pipeline: "sha256:1", Tasks: map[string]string{"build": "sha256:2"}}, true},
+		{"pipeline changed", wrappedSources{Pipeline: "sha256:3", Tasks: map[string]string{"build": "sha256:2"}}, false},
+		{"task changed", wrappedSources{Pipeline: "sha256:1", Tasks: map[string]string{"build": "sha256:3"}}, false},
+		{"task added", wrappedSources{Pipeline: "sha256:1", Tasks: map[string]string{"build": "sha256:2", "test": "sha256:4"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sameSources(base, tc.other); got != tc.want {
+				t.Errorf("sameSources() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+const (
+	// OCIWrapper moves the content of the workspaces in OCI images, one
+	// layer per task.
+	OCIWrapper = "oci"
+	// S3Wrapper moves the content of the workspaces in tarballs stored in
+	// an S3 bucket, see S3TargetParam.
+	S3Wrapper = "s3"
+	// ArchiveWrapper moves the content of the oci workspaces whose target
+	// is a docker-archive tarball, e.g.
+	// docker-archive:/artifacts/{{workspace}}.tar, in a directory of the
+	// nodes, for runs without any registry. It isn't a value of
+	// WrapperParam.
+	ArchiveWrapper = "docker-archive"
+	// GCSWrapper moves the content of the workspaces in tarballs stored in
+	// a Google Cloud Storage bucket, see GCSTargetParam.
+	GCSWrapper = "gcs"
+	// RsyncWrapper synchronizes the content of the workspaces with the
+	// directories of an rsync daemon, see RsyncTargetParam.
+	RsyncWrapper = "rsync"
+	// NoneWrapper doesn't move the content of the workspaces: each task
+	// sees them as bound, e.g. emptyDir scratch space with
+	// StripWorkspacesParam.
+	NoneWrapper = "none"
+)
+
+// wrapperStrategy implements a wrapper: the steps moving the content of
+// the workspaces in and out of the tasks.
+type wrapperStrategy struct {
+	name string
+	// targetParam is the param of the targets of the workspaces, rendered
+	// with {{workspace}}, if any.
+	targetParam string
+	importSteps func(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step
+	exportSteps func(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step
+}
+
+// wrapperStrategies are the wrappers, in the order of their steps in the
+// tasks. WrapperParam selects them, except ArchiveWrapper.
+var wrapperStrategies = []wrapperStrategy{
+	{name: OCIWrapper, targetParam: TargetParam, importSteps: ociImportSteps, exportSteps: ociExportSteps},
+	{name: S3Wrapper, targetParam: S3TargetParam, importSteps: s3ImportSteps, exportSteps: s3ExportSteps},
+	{name: GCSWrapper, targetParam: GCSTargetParam, importSteps: gcsImportSteps, exportSteps: gcsExportSteps},
+	{name: RsyncWrapper, targetParam: RsyncTargetParam, importSteps: rsyncImportSteps, exportSteps: rsyncExportSteps},
+	{name: ArchiveWrapper, targetParam: TargetParam, importSteps: archiveImportSteps, exportSteps: archiveExportSteps},
+	{name: NoneWrapper},
+}
+
+// strategy returns the wrapperStrategy of the wrapper.
+func strategy(wrapper string) (wrapperStrategy, bool) {
+	for _, s := range wrapperStrategies {
+		if s.name == wrapper {
+			return s, true
+		}
+	}
+	return wrapperStrategy{}, false
+}
+
+// wrappers maps the workspaces to the wrapper moving their content. The ""
+// key holds the wrapper of the other workspaces.
+type wrappers map[string]string
+
+func (w wrappers) get(workspace string) string {
+	if wrapper, ok := w[workspace]; ok {
+		return wrapper
+	}
+	return w[""]
+}
+
+// uses tells whether any workspace uses the wrapper.
+func (w wrappers) uses(wrapper string) bool {
+	for _, v := range w {
+		if v == wrapper {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWrappers parses the value of WrapperParam: either a wrapper for all
+// workspaces (e.g. oci), or a comma separated list of workspace=wrapper
+// pairs, where a bare wrapper applies to the other workspaces (e.g.
+// oci,testdata=s3). The wrappers are either built in or wrapper templates.
+func parseWrappers(value string, templates wrapperTemplates) (wrappers, error) {
+	w := wrappers{"": OCIWrapper}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		workspace, wrapper, ok := strings.Cut(part, "=")
+		if !ok {
+			workspace, wrapper = "", part
+		}
+		if _, ok := templates.strategy(wrapper); !ok || wrapper == ArchiveWrapper {
+			return nil, fmt.Errorf("unknown wrapper %q", wrapper)
+		}
+		w[workspace] = wrapper
+	}
+	return w, nil
+}
+
+// byWrapper splits the transfers of a task by wrapper.
+func byWrapper(transfers []workspaceTransfer) map[string][]workspaceTransfer {
+	split := map[string][]workspaceTransfer{}
+	for _, t := range transfers {
+		split[t.Wrapper] = append(split[t.Wrapper], t)
+	}
+	return split
+}
+
+// importSteps returns the steps importing the workspaces, with the
+// wrapper of each workspace, the wrapper templates last.
+func importSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	split := byWrapper(transfers)
+	var steps []v1beta1.Step
+	for _, s := range wrapperStrategies {
+		if len(split[s.name]) > 0 && s.importSteps != nil {
+			steps = append(steps, s.importSteps(o, split[s.name])...)
+		}
+	}
+	for _, name := range o.Templates.names() {
+		if len(split[name]) > 0 {
+			s, _ := o.Templates.strategy(name)
+			steps = append(steps, s.importSteps(o, split[name])...)
+		}
+	}
+	return o.withSecurityContext(o.Faults.inject(steps))
+}
+
+// exportSteps returns the steps exporting the workspaces, with the
+// wrapper of each workspace, the wrapper templates last.
+func exportSteps(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+	split := byWrapper(transfers)
+	var steps []v1beta1.Step
+	for _, s := range wrapperStrategies {
+		if len(split[s.name]) > 0 && s.exportSteps != nil {
+			steps = append(steps, s.exportSteps(o, split[s.name])...)
+		}
+	}
+	for _, name := range o.Templates.names() {
+		if len(split[name]) > 0 {
+			s, _ := o.Templates.strategy(name)
+			steps = append(steps, s.exportSteps(o, split[name])...)
+		}
+	}
+	return o.withSecurityContext(o.Faults.inject(steps))
+}
@@ -0,0 +1,107 @@
+package wrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestParseWrappers(t *testing.T) {
+	for _, tc := range []struct {
+		value     string
+		templates wrapperTemplates
+		want      map[string]string
+		wantErr   bool
+	}{
+		{value: "", want: map[string]string{"source": OCIWrapper}},
+		{value: GCSWrapper, want: map[string]string{"source": GCSWrapper}},
+		{value: "oci,cache=rsync,scratch=none", want: map[string]string{"source": OCIWrapper, "cache": RsyncWrapper, "scratch": NoneWrapper}},
+		{value: ArchiveWrapper, wantErr: true},
+		{value: "source=nfs", wantErr: true},
+		{value: "oci,source=nfs", templates: wrapperTemplates{"nfs": {}}, want: map[string]string{"source": "nfs", "cache": OCIWrapper}},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			w, err := parseWrappers(tc.value, tc.templates)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseWrappers(%q) = %v, want error %t", tc.value, err, tc.wantErr)
+			}
+			for ws, want := range tc.want {
+				if got := w.get(ws); got != want {
+					t.Errorf("parseWrappers(%q) wrapper of %s = %s, want %s", tc.value, ws, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapStrategies(t *testing.T) {
+	workspaces := []string{"source", "scratch"}
+	for _, tc := range []struct {
+		wrapper    string
+		extra      map[string]string
+		conf       map[string]string
+		wantImport string
+		wantExport string
+	}{
+		{wrapper: OCIWrapper, wantImport: "import-workspace", wantExport: "export-workspace"},
+		{wrapper: S3Wrapper, extra: map[string]string{S3TargetParam: "s3://bucket/{{workspace}}.tar"}, wantImport: "s3-import-workspace", wantExport: "s3-export-workspace"},
+		{wrapper: GCSWrapper, extra: map[string]string{GCSTargetParam: "gs://bucket/{{workspace}}.tar"}, wantImport: "gcs-import-workspace", wantExport: "gcs-export-workspace"},
+		{wrapper: RsyncWrapper, extra: map[string]string{RsyncTargetParam: "rsync://cache.example.com/wrap/{{workspace}}"}, wantImport: "rsync-import-workspace", wantExport: "rsync-export-workspace"},
+		{wrapper: "nfs", conf: map[string]string{"wrapper-templates": nfsTemplate}, wantImport: "nfs-import-workspace", wantExport: "nfs-export-workspace"},
+	} {
+		t.Run(tc.wrapper, func(t *testing.T) {
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}, {Name: "scratch"}},
+				Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", workspaces), embeddedTask("build", workspaces, "clone")},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			params := map[string]string{
+				PipelineRefParam: "build",
+				WorkspacesParam:  "source,scratch",
+				TargetParam:      "registry.example.com/{{workspace}}",
+				WrapperParam:     tc.wrapper + ",scratch=" + NoneWrapper,
+			}
+			for k, v := range tc.extra {
+				params[k] = v
+			}
+			conf := tc.conf
+			if conf == nil {
+				conf = map[string]string{}
+			}
+			ctx := framework.InjectResolverConfigToContext(context.Background(), conf)
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params, err := populateParamsWithDefaults(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, effective, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+			steps := map[string]sets.String{}
+			for _, pt := range wrapped.Spec.Tasks {
+				steps[pt.Name] = sets.NewString()
+				for _, s := range pt.TaskSpec.Steps {
+					steps[pt.Name].Insert(s.Name)
+					if strings.Contains(s.Script, "scratch") {
+						t.Errorf("step %s of %s moves the scratch workspace of the none wrapper", s.Name, pt.Name)
+					}
+				}
+			}
+			if !steps["clone"].Has(tc.wantExport) {
+				t.Errorf("task clone has steps %v, want %s", steps["clone"].List(), tc.wantExport)
+			}
+			if !steps["build"].Has(tc.wantImport) {
+				t.Errorf("task build has steps %v, want %s", steps["build"].List(), tc.wantImport)
+			}
+			if _, ok := effective.Targets["scratch"]; ok {
+				t.Errorf("targets = %v, want none for scratch", effective.Targets)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package wrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// wrapperTemplate is a wrapper registered by the cluster admins in the
+// wrapper-templates of the resolver configuration, e.g. to move the
+// content of the workspaces with an in-house tool, so that they control
+// exactly what runs in the injected steps. Its import and export scripts
+// are Go templates executed with a templateData.
+type wrapperTemplate struct {
+	// Image is the image of the steps.
+	Image string `json:"image"`
+	// Import is the script of the step importing the workspaces.
+	Import string `json:"import"`
+	// Export is the script of the step exporting the workspaces.
+	Export string `json:"export"`
+	// SecurityContext is set on the steps instead of the
+	// step-security-context, if any.
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	importScript, exportScript *template.Template
+}
+
+// templateData is what the scripts of the wrapper templates are executed
+// with.
+type templateData struct {
+	// Transfers are the workspaces moved by the step.
+	Transfers []templateTransfer
+	// BestEffort is set when the failed exports only warn, see
+	// ExportFailureParam.
+	BestEffort bool
+	// AllowMissing is set when the import scripts should continue with
+	// an empty workspace if the source doesn't exist, see
+	// AllowMissingBaseParam.
+	AllowMissing bool
+	// Exclude are the patterns of the paths the exports leave out, see
+	// ExcludeParam. Like the built-in wrappers, the export scripts should
+	// also leave out the ones of the wrapignore file of the workspaces.
+	Exclude []string
+}
+
+// templateTransfer is a workspace moved by a step of a wrapper template.
+type templateTransfer struct {
+	// Workspace is the name of the workspace in the Pipeline.
+	Workspace string
+	// MountPath is where the workspace is mounted in the task.
+	MountPath string
+	// Source is where the content is imported from.
+	Source string
+	// Target is where the content is exported to, TargetParam rendered
+	// with the workspace.
+	Target string
+}
+
+// wrapperTemplates maps the names of the wrapper templates, values of
+// WrapperParam, to them.
+type wrapperTemplates map[string]*wrapperTemplate
+
+// parseWrapperTemplates parses the wrapper-templates of the resolver
+// configuration: a YAML map of the names of the wrappers to their image,
+// import and export scripts, and securityContext. The scripts are checked
+// by executing them with a sample workspace.
+func parseWrapperTemplates(v string) (wrapperTemplates, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	t := wrapperTemplates{}
+	if err := yaml.UnmarshalStrict([]byte(v), &t); err != nil {
+		return nil, fmt.Errorf("not a map of wrapper templates: %v", err)
+	}
+	sample := templateData{Transfers: []templateTransfer{{
+		Workspace: "source",
+		MountPath: "/workspace/source",
+		Source:    "registry.example.com/source",
+		Target:    "registry.example.com/source",
+	}}, Exclude: []string{"node_modules"}}
+	for _, name := range t.names() {
+		wt := t[name]
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid wrapper name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if _, ok := strategy(name); ok {
+			return nil, fmt.Errorf("wrapper %s is built in", name)
+		}
+		if wt == nil {
+			return nil, fmt.Errorf("wrapper %s is empty", name)
+		}
+		if err := validateReference(wt.Image); err != nil {
+			return nil, fmt.Errorf("invalid image of wrapper %s: %v", name, err)
+		}
+		for _, script := range []struct {
+			kind, text string
+			tmpl       **template.Template
+		}{
+			{kind: "import", text: wt.Import, tmpl: &wt.importScript},
+			{kind: "export", text: wt.Export, tmpl: &wt.exportScript},
+		} {
+			if strings.TrimSpace(script.text) == "" {
+				return nil, fmt.Errorf("wrapper %s has no %s script", name, script.kind)
+			}
+			tmpl, err := template.New(name + "-" + script.kind).Option("missingkey=error").Parse(script.text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s script of wrapper %s: %v", script.kind, name, err)
+			}
+			for _, bestEffort := range []bool{false, true} {
+				sample.BestEffort = bestEffort
+				if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+					return nil, fmt.Errorf("invalid %s script of wrapper %s: %v", script.kind, name, err)
+				}
+			}
+			*script.tmpl = tmpl
+		}
+	}
+	return t, nil
+}
+
+// names returns the sorted names of the wrapper templates, the order of
+// their steps in the tasks, after the built-in wrappers.
+func (t wrapperTemplates) names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// strategy returns the wrapperStrategy of the wrapper, either built in or
+// a wrapper template. The targets of the templates are the ones of
+// TargetParam.
+func (t wrapperTemplates) strategy(wrapper string) (wrapperStrategy, bool) {
+	if s, ok := strategy(wrapper); ok {
+		return s, true
+	}
+	wt, ok := t[wrapper]
+	if !ok {
+		return wrapperStrategy{}, false
+	}
+	return wrapperStrategy{
+		name:        wrapper,
+		targetParam: TargetParam,
+		importSteps: func(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+			return []v1beta1.Step{wt.step(wrapper+"-import-workspace", wt.importScript, o, transfers)}
+		},
+		exportSteps: func(o stepOptions, transfers []workspaceTransfer) []v1beta1.Step {
+			return []v1beta1.Step{wt.step(wrapper+"-export-workspace", wt.exportScript, o, transfers)}
+		},
+	}, true
+}
+
+// step returns the step running the script executed with the transfers.
+// The scripts were checked when parsed, a failure to execute one still
+// fails the step rather than the resolution.
+func (wt *wrapperTemplate) step(name string, tmpl *template.Template, o stepOptions, transfers []workspaceTransfer) v1beta1.Step {
+	data := templateData{BestEffort: o.BestEffort, AllowMissing: o.AllowMissing, Exclude: o.Exclude}
+	for _, t := range transfers {
+		data.Transfers = append(data.Transfers, templateTransfer{
+			Workspace: t.Workspace,
+			MountPath: t.MountPath,
+			Source:    t.Source,
+			Target:    t.Target,
+		})
+	}
+	var script strings.Builder
+	if err := tmpl.Execute(&script, data); err != nil {
+		script.Reset()
+		fmt.Fprintf(&script, "#!/bin/sh\necho %q >&2\nexit 1\n", fmt.Sprintf("failed to render the script of step %s: %v", name, err))
+	}
+	return v1beta1.Step{
+		Name:            name,
+		Image:           wt.Image,
+		WorkingDir:      "/",
+		Script:          script.String(),
+		SecurityContext: wt.SecurityContext.DeepCopy(),
+	}
+}
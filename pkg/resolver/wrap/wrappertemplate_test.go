@@ -0,0 +1,155 @@
+package wrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+)
+
+const nfsTemplate = `nfs:
+  image: registry.example.com/nfs-copy@sha256:0a5b1b8c2f1b1c8b9f1c1a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f
+  securityContext:
+    runAsUser: 1001
+  import: |
+    #!/bin/sh -e
+    {{- range .Transfers}}
+    cp -r /nfs/{{.Source}}/. {{.MountPath}}
+    {{- end}}
+  export: |
+    #!/bin/sh -e
+    {{- range .Transfers}}
+    cp -r {{.MountPath}}/. /nfs/{{.Target}}{{if $.BestEffort}} || echo "Warning: failed to export {{.Workspace}}"{{end}}
+    {{- end}}
+`
+
+func TestParseWrapperTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr string
+	}{{
+		name: "empty",
+	}, {
+		name:  "valid",
+		value: nfsTemplate,
+		want:  []string{"nfs"},
+	}, {
+		name:    "not a map",
+		value:   "nfs",
+		wantErr: "not a map of wrapper templates",
+	}, {
+		name:    "unknown field",
+		value:   "nfs:\n  image: registry.example.com/nfs\n  import: x\n  export: x\n  command: x\n",
+		wantErr: "not a map of wrapper templates",
+	}, {
+		name:    "built in",
+		value:   "s3:\n  image: registry.example.com/nfs\n  import: x\n  export: x\n",
+		wantErr: "wrapper s3 is built in",
+	}, {
+		name:    "invalid name",
+		value:   "NFS:\n  image: registry.example.com/nfs\n  import: x\n  export: x\n",
+		wantErr: `invalid wrapper name "NFS"`,
+	}, {
+		name:    "invalid image",
+		value:   "nfs:\n  image: Registry/NFS\n  import: x\n  export: x\n",
+		wantErr: "invalid image of wrapper nfs",
+	}, {
+		name:    "missing export",
+		value:   "nfs:\n  image: registry.example.com/nfs\n  import: x\n",
+		wantErr: "wrapper nfs has no export script",
+	}, {
+		name:    "unparsable script",
+		value:   "nfs:\n  image: registry.example.com/nfs\n  import: '{{range .Transfers}}'\n  export: x\n",
+		wantErr: "invalid import script of wrapper nfs",
+	}, {
+		name:    "unknown field of the data",
+		value:   "nfs:\n  image: registry.example.com/nfs\n  import: x\n  export: '{{range .Transfers}}{{.Image}}{{end}}'\n",
+		wantErr: "invalid export script of wrapper nfs",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			templates, err := parseWrapperTemplates(tc.value)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("parseWrapperTemplates() = %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseWrapperTemplates() = %v", err)
+			}
+			if got := templates.names(); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("parseWrapperTemplates() names = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrapperTemplateSteps(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		params     map[string]string
+		wantScript string
+	}{{
+		name:       "fail",
+		params:     map[string]string{},
+		wantScript: "cp -r /workspace/output/. /nfs/cache/source\n",
+	}, {
+		name:       "warn",
+		params:     map[string]string{ExportFailureParam: ExportFailureWarn},
+		wantScript: `cp -r /workspace/output/. /nfs/cache/source || echo "Warning: failed to export source"`,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := &v1beta1.Pipeline{Spec: v1beta1.PipelineSpec{
+				Workspaces: []v1beta1.PipelineWorkspaceDeclaration{{Name: "source"}},
+				Tasks:      []v1beta1.PipelineTask{embeddedTask("clone", []string{"output=source"}), embeddedTask("build", []string{"output=source"}, "clone")},
+			}}
+			pipeline.Name, pipeline.Namespace = "build", "dev"
+			conf := map[string]string{
+				"wrapper-templates":     nfsTemplate,
+				"step-security-context": "runAsNonRoot: true",
+			}
+			params := map[string]string{
+				PipelineRefParam: "build",
+				WorkspacesParam:  "source",
+				TargetParam:      "cache/{{workspace}}",
+				WrapperParam:     "nfs",
+			}
+			for k, v := range tc.params {
+				params[k] = v
+			}
+			ctx := framework.InjectResolverConfigToContext(context.Background(), conf)
+			ctx = common.InjectRequestNamespace(ctx, "dev")
+			params, err := populateParamsWithDefaults(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, effective, err := (&Resolver{}).wrap(ctx, params, pipeline)
+			if err != nil {
+				t.Fatalf("wrap() = %v", err)
+			}
+			var export *v1beta1.Step
+			for i, s := range wrapped.Spec.Tasks[0].TaskSpec.Steps {
+				if s.Name == "nfs-export-workspace" {
+					export = &wrapped.Spec.Tasks[0].TaskSpec.Steps[i]
+				}
+			}
+			if export == nil {
+				t.Fatalf("task clone has no nfs-export-workspace step: %v", wrapped.Spec.Tasks[0].TaskSpec.Steps)
+			}
+			if !strings.Contains(export.Script, tc.wantScript) {
+				t.Errorf("export script = %q, want it to contain %q", export.Script, tc.wantScript)
+			}
+			if export.SecurityContext == nil || export.SecurityContext.RunAsUser == nil || *export.SecurityContext.RunAsUser != 1001 {
+				t.Errorf("export securityContext = %v, want the one of the template", export.SecurityContext)
+			}
+			if !strings.HasPrefix(effective.Images["nfs"], "registry.example.com/nfs-copy@") {
+				t.Errorf("images = %v, want the nfs image", effective.Images)
+			}
+		})
+	}
+}
@@ -0,0 +1,625 @@
+package wrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/resolution/common"
+	"github.com/tektoncd/pipeline/pkg/resolution/resolver/framework"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+)
+
+// wrapping is the state of the wrapping of a Pipeline by wrap: the settings
+// of the params, the tasks and workspaces selected, and what the wrapped
+// tasks gather for the finally tasks and the annotations.
+type wrapping struct {
+	params    map[string]string
+	conf      map[string]string
+	namespace string
+	pipeline  *v1beta1.Pipeline
+	// wrapped is the wrapped Pipeline, starting as a copy of pipeline.
+	wrapped  *v1beta1.Pipeline
+	stepOpts stepOptions
+
+	strip           bool
+	alwaysExport    bool
+	artifactResults bool
+	lineageResult   bool
+	verify          bool
+	order           bool
+	latest          bool
+	transactional   bool
+	onFailure       exportOnFailure
+	manifest        string
+	archiveTarget   string
+	ephemeral       sets.String
+	sources         importSources
+	sizes           workspaceSizes
+	artifacts       artifactTypes
+
+	// workspaces are the wrapped workspaces, moved unless their wrapper is
+	// none.
+	workspaces sets.String
+	moved      sets.String
+	wrappers   wrappers
+	// targets are the images (or URLs) each workspace is exported to.
+	targets   map[string]string
+	taskSpecs map[string]*v1beta1.TaskSpec
+	contracts map[string]contract
+	filter    taskFilter
+	// readers are the tasks importing each export, see exportReaders.
+	readers map[string][]string
+	// readOnly are the bindings only imported, see readOnlyBindings, and
+	// exporting the Pipeline without them.
+	readOnly  sets.String
+	exporting *v1beta1.PipelineSpec
+	limits    podLimits
+
+	used         usedFeatures
+	imports      map[string]string
+	injected     map[string][]InjectedStep
+	taskUsages   []TaskUsage
+	warnings     []string
+	reported     []reportedExport
+	verified     sets.String
+	promotions   []imageTag
+	cleanups     []string
+	exportCounts map[string]int
+	otherTags    map[string][]string
+}
+
+// newWrapping parses the params of the wrapping of pipeline, resolves its
+// tasks and selects the ones to wrap along with their workspaces.
+func (r *Resolver) newWrapping(ctx context.Context, params map[string]string, pipeline *v1beta1.Pipeline) (*wrapping, error) {
+	logger := logging.FromContext(ctx)
+	params = renderPipelineVariable(params, pipeline.Name)
+	w := &wrapping{
+		params:        params,
+		conf:          framework.GetResolverConfigFromContext(ctx),
+		namespace:     common.RequestNamespace(ctx),
+		pipeline:      pipeline,
+		manifest:      params[WorkspaceManifestParam],
+		archiveTarget: params[ArchiveTargetParam],
+		ephemeral:     sets.NewString(),
+		used:          usedFeatures{},
+		imports:       map[string]string{},
+		injected:      map[string][]InjectedStep{},
+		verified:      sets.NewString(),
+		exportCounts:  map[string]int{},
+		otherTags:     map[string][]string{},
+	}
+
+	var err error
+	w.workspaces, err = wrappedWorkspaces(params[WorkspacesParam], &pipeline.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %v", WorkspacesParam, err)
+	}
+	w.strip, _ = strconv.ParseBool(params[StripWorkspacesParam])
+	w.alwaysExport, _ = strconv.ParseBool(params[AlwaysExportParam])
+	w.artifactResults, _ = strconv.ParseBool(params[ArtifactResultsParam])
+	w.onFailure, _ = parseExportOnFailure(params[ExportOnFailureParam])
+	w.lineageResult, _ = strconv.ParseBool(params[LineageResultParam])
+	w.verify, _ = strconv.ParseBool(params[VerifyParam])
+	w.order, _ = strconv.ParseBool(params[OrderTasksParam])
+	w.latest, _ = strconv.ParseBool(params[LatestAliasParam])
+	w.transactional, _ = strconv.ParseBool(params[TransactionalParam])
+	if v := params[EphemeralWorkspacesParam]; v != "" {
+		w.ephemeral.Insert(strings.Split(v, ",")...)
+	}
+	w.sources, _ = parseImportSources(params[ImportSourceParam])
+	if len(w.sources) > 0 {
+		w.used[FeatureImportSource] = true
+	}
+	w.sizes, _ = parseWorkspaceSizes(params[WorkspaceSizeParam])
+	w.artifacts, _ = parseArtifactTypes(params[ArtifactTypeParam])
+	w.stepOpts, err = newStepOptions(w.conf, params)
+	if err != nil {
+		logger.Infof("wrap resolver configuration invalid: %v", err)
+		return nil, err
+	}
+	// Unrelated Pipelines can render the same targets
+	if markers, _ := strconv.ParseBool(w.conf["owner-markers"]); markers {
+		w.stepOpts.Owner = w.namespace + "/" + pipeline.Name
+	}
+
+	// Resolve tasks from Pipeline to embedded and mutate them
+	var skipped sets.String
+	w.taskSpecs, w.contracts, skipped, err = r.resolveTaskSpecs(ctx, pipeline)
+	if err != nil {
+		logger.Infof("failed to resolve task specs from pipeline %s in namespace %s: %v", params[PipelineRefParam], w.namespace, err)
+		return nil, err
+	}
+
+	w.filter, _ = parseTaskFilter(params)
+	w.filter.skipped = skipped
+	if err := w.filter.validate(&pipeline.Spec); err != nil {
+		return nil, err
+	}
+	// The tasks not wrapped neither import nor export
+	transferred := w.filter.spec(&pipeline.Spec, w.workspaces)
+
+	w.wrapped = pipeline.DeepCopy()
+	w.wrappers, _ = parseWrappers(params[WrapperParam], w.stepOpts.Templates)
+	// Nothing reads the workspaces of the none wrapper
+	w.moved = sets.NewString()
+	for _, ws := range w.workspaces.List() {
+		if w.wrappers.get(ws) != NoneWrapper {
+			w.moved.Insert(ws)
+		}
+	}
+	w.readers = exportReaders(transferred, w.moved)
+	// The tasks import the workspaces they declare read-only from the
+	// latest task exporting them
+	w.readOnly = readOnlyBindings(&pipeline.Spec, w.taskSpecs, w.moved)
+	w.exporting = withoutBindings(transferred, w.readOnly)
+	for _, key := range w.readOnly.List() {
+		delete(w.readers, key)
+	}
+	w.limits = newPodLimits(w.conf)
+	if params[WorkspacesParam] == allWorkspaces {
+		errs := &ParamsError{}
+		validateWorkspaceParams(w.conf, params, w.workspaces.List(), w.wrappers, errs)
+		if err := errs.orNil(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.selectTargets(); err != nil {
+		return nil, err
+	}
+	if err := w.sources.validate(&pipeline.Spec, w.workspaces.List(), w.wrappers); err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %v", ImportSourceParam, err)
+	}
+	if err := seedSources(w.sources, &pipeline.Spec, w.workspaces.List(), w.wrappers, params[SeedImageParam]); err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %v", SeedImageParam, err)
+	}
+	return w, nil
+}
+
+// selectTargets renders the target of each workspace that moves. The
+// ephemeral workspaces and the ones of transactional runs are exported to
+// run-scoped targets instead, deleted or promoted by the finally tasks.
+func (w *wrapping) selectTargets() error {
+	w.targets = map[string]string{}
+	for _, ws := range w.workspaces.List() {
+		s, _ := w.stepOpts.Templates.strategy(w.wrappers.get(ws))
+		if s.targetParam == "" {
+			// Nothing moves
+			continue
+		}
+		w.targets[ws] = workspaceTarget(w.params, s.targetParam, ws)
+		if w.wrappers.get(ws) == OCIWrapper && isArchive(w.targets[ws]) {
+			w.wrappers[ws] = ArchiveWrapper
+		}
+		if w.ephemeral.Has(ws) {
+			w.targets[ws] = transactionTarget(w.targets[ws], ws)
+			w.cleanups = append(w.cleanups, w.targets[ws])
+			continue
+		}
+		if w.transactional && w.wrappers.get(ws) == OCIWrapper {
+			w.promotions = append(w.promotions, imageTag{Image: transactionTarget(w.targets[ws], ws), Tag: tagOf(w.targets[ws])})
+			w.otherTags[ws] = append(w.otherTags[ws], tagOf(w.targets[ws]))
+			w.targets[ws] = transactionTarget(w.targets[ws], ws)
+		}
+	}
+	if w.transactional && len(w.promotions) == 0 {
+		return fmt.Errorf("%s requires a workspace wrapped with %s", TransactionalParam, OCIWrapper)
+	}
+	return nil
+}
+
+// wrapTask injects the import and export steps in the i-th task of the
+// wrapped Pipeline, embedding its Task, if it uses a wrapped workspace and
+// isn't filtered out.
+func (w *wrapping) wrapTask(i int) error {
+	t := w.wrapped.Spec.Tasks[i]
+	taskWorkspaces := make([]string, len(t.Workspaces))
+	for j, pw := range t.Workspaces {
+		taskWorkspaces[j] = pw.Workspace
+	}
+	// Skip if not using the workspace
+	if !w.workspaces.HasAny(taskWorkspaces...) || !w.filter.wraps(t.Name) {
+		return nil
+	}
+
+	s := w.taskSpecs[t.Name]
+	transfers, exports, checks, err := w.taskTransfers(i, t, s)
+	if err != nil {
+		return err
+	}
+	snapshots, onSuccess := w.splitExports(t.Name, exports)
+	// The own steps of the task continue on error for the snapshots to run
+	var checkedSteps []string
+	if len(snapshots) > 0 {
+		checkedSteps = continueOnError(s)
+	}
+	ownSteps := len(s.Steps)
+	toImport := w.injectImports(i, t.Name, s, transfers, checks)
+	prepended := len(s.Steps) - ownSteps
+	w.injectExports(s, snapshots, onSuccess, checkedSteps)
+	if i != 0 && len(byWrapper(transfers)[ArchiveWrapper]) > 0 || len(byWrapper(exports)[ArchiveWrapper]) > 0 {
+		addArchiveVolume(s, w.stepOpts.ArchivePath)
+	}
+	w.injected[t.Name] = injectedSteps(s.Steps, prepended, ownSteps)
+	w.warnings = append(w.warnings, w.limits.check(t.Name, s, len(w.injected[t.Name]))...)
+	if err := checkStepNames(s.Steps, w.injected[t.Name]); err != nil {
+		return fmt.Errorf("task %s: %v", t.Name, err)
+	}
+	w.taskUsages = append(w.taskUsages, newTaskUsage(t.Name, toImport, exports))
+	if w.strip {
+		var bindings []v1beta1.WorkspacePipelineTaskBinding
+		for _, pw := range t.Workspaces {
+			if w.workspaces.Has(pw.Workspace) {
+				stripWorkspace(s, pw.Name, w.sizes.get(pw.Workspace))
+				continue
+			}
+			bindings = append(bindings, pw)
+		}
+		w.wrapped.Spec.Tasks[i].Workspaces = bindings
+	}
+	w.wrapped.Spec.Tasks[i].TaskRef = nil
+	if w.wrapped.Spec.Tasks[i].TaskSpec == nil {
+		w.wrapped.Spec.Tasks[i].TaskSpec = &v1beta1.EmbeddedTask{}
+	}
+	w.wrapped.Spec.Tasks[i].TaskSpec.TaskSpec = *s
+	if w.stepOpts.Prefetch {
+		if oci := byWrapper(transfers)[OCIWrapper]; len(oci) > 0 {
+			addPrefetchHints(w.wrapped.Spec.Tasks[i].TaskSpec, oci)
+		}
+	}
+	return nil
+}
+
+// taskTransfers returns the transfers of the wrapped workspaces of the i-th
+// task t, whose Task is s, the ones it exports, and the contract checks of
+// its imports.
+func (w *wrapping) taskTransfers(i int, t v1beta1.PipelineTask, s *v1beta1.TaskSpec) (transfers, exports []workspaceTransfer, checks []contractCheck, err error) {
+	for _, pw := range t.Workspaces {
+		if !w.workspaces.Has(pw.Workspace) || w.wrappers.get(pw.Workspace) == NoneWrapper {
+			continue
+		}
+		target := w.targets[pw.Workspace]
+		transfer := workspaceTransfer{
+			Workspace:  pw.Workspace,
+			Wrapper:    w.wrappers.get(pw.Workspace),
+			MountPath:  mountPath(s, pw.Name),
+			Base:       w.stepOpts.BaseImage,
+			Target:     taskTarget(target, t.Name),
+			Source:     target,
+			Repository: repository(target),
+		}
+		// Each task pushes its own image, onto the one it imports
+		upstream := transfer.Target
+		if strings.Contains(transfer.Source, taskVariable) && i != 0 {
+			switch exporter := latestExporter(w.exporting, t.Name, pw.Workspace); {
+			case exporter != "":
+				upstream = taskTarget(transfer.Source, exporter)
+			case w.sources.get(t.Name, pw.Workspace) != "":
+				upstream = w.sources.get(t.Name, pw.Workspace)
+			default:
+				return nil, nil, nil, fmt.Errorf("task %s imports workspace %s from no single upstream task, %s can't name its image", t.Name, pw.Workspace, taskVariable)
+			}
+		}
+		transfer.Source = upstream
+		if transfer.Wrapper == OCIWrapper {
+			transfer.ArtifactType = w.artifacts.get(pw.Workspace)
+		}
+		// Tekton resolves the task results the target references in
+		// params only
+		if refs := resultRefTasks(transfer.Target); len(refs) > 0 {
+			if err := checkResultRefs(&w.pipeline.Spec, t.Name, pw.Workspace, refs); err != nil {
+				return nil, nil, nil, err
+			}
+			transfer.Target = paramTarget(&w.wrapped.Spec.Tasks[i], s, pw.Workspace, transfer.Target)
+			transfer.Source, upstream = transfer.Target, transfer.Target
+		}
+		if i != 0 {
+			transfer.Base = upstream
+		} else if transfer.Wrapper == ArchiveWrapper {
+			// The base image of wrapstep
+			transfer.Base = ""
+		}
+		// Tarballs have no digest to pin, nor layers to append onto
+		ociTransfer := transfer.Wrapper == OCIWrapper
+		if w.artifactResults && ociTransfer && i != 0 {
+			if exporter := latestExporter(w.exporting, t.Name, pw.Workspace); exporter != "" {
+				transfer.Source = pinImport(&w.wrapped.Spec.Tasks[i], s, exporter, pw.Workspace)
+			}
+		}
+		exporter := latestExporter(w.exporting, t.Name, pw.Workspace)
+		source := w.sources.get(t.Name, pw.Workspace)
+		if source != "" {
+			transfer.Source, exporter = source, ""
+		}
+		if paths := w.contracts[t.Name][pw.Name]; len(paths) > 0 && (i != 0 || source != "") && FeatureWorkspaceContracts.enabled(w.conf) {
+			w.used[FeatureWorkspaceContracts] = true
+			checks = append(checks, contractCheck{Workspace: pw.Name, MountPath: transfer.MountPath, Source: transfer.Source, Exporter: exporter, Paths: paths})
+		}
+		transfers = append(transfers, transfer)
+		if w.readOnly.Has(t.Name + "/" + pw.Workspace) {
+			if !w.strip && (i != 0 || source != "") {
+				writableDeclaration(s, pw.Name)
+			}
+			continue
+		}
+		if w.exports(t.Name, pw.Workspace, ociTransfer) {
+			if w.verify && !w.ephemeral.Has(pw.Workspace) {
+				w.verified.Insert(pw.Workspace)
+			}
+			if (w.artifactResults || w.manifest != "" || w.lineageResult || w.stepOpts.Lineage.URL != "") && ociTransfer {
+				transfer.Result = addArtifactResult(s, pw.Workspace)
+				w.reported = append(w.reported, reportedExport{Task: t.Name, Workspace: pw.Workspace, Result: transfer.Result})
+			}
+			if w.ephemeral.Has(pw.Workspace) {
+				if err := checkEphemeralTag(pw.Workspace, t.Name); err != nil {
+					return nil, nil, nil, fmt.Errorf("invalid value for %s: %v", EphemeralWorkspacesParam, err)
+				}
+				transfer.Tags = []string{ephemeralTag(pw.Workspace, t.Name)}
+				w.cleanups = append(w.cleanups, transfer.Repository+":"+ephemeralTag(pw.Workspace, t.Name))
+			}
+			exports = append(exports, transfer)
+		}
+	}
+	return transfers, exports, checks, nil
+}
+
+// exports returns whether the task exports the workspace: when a
+// downstream task imports it, or when it may be the last export and
+// something reads that one.
+func (w *wrapping) exports(task, workspace string, ociTransfer bool) bool {
+	// Other runs read shared targets
+	// The alias, the promoted tag and the verified image point to the last
+	// export
+	return w.alwaysExport || w.stepOpts.SharedTarget || (w.latest || w.transactional || w.archiveTarget != "") && ociTransfer || w.verify || len(w.readers[task+"/"+workspace]) > 0
+}
+
+// splitExports returns the exports of the task snapshotting the workspaces
+// whatever the outcome of its steps, see ExportOnFailureParam, and the ones
+// only exporting them on success.
+func (w *wrapping) splitExports(task string, exports []workspaceTransfer) (snapshots, onSuccess []workspaceTransfer) {
+	for _, e := range exports {
+		w.exportCounts[e.Workspace]++
+		w.otherTags[e.Workspace] = append(w.otherTags[e.Workspace], e.Tags...)
+		if w.onFailure.matches(task, e.Workspace) {
+			snapshots = append(snapshots, e)
+			continue
+		}
+		onSuccess = append(onSuccess, e)
+	}
+	return snapshots, onSuccess
+}
+
+// injectImports prepends the steps extracting the content of the workspaces
+// to s, along with the contract checks, returning the transfers imported.
+// The first task imports nothing, unless its import is overridden or
+// seeded.
+func (w *wrapping) injectImports(i int, task string, s *v1beta1.TaskSpec, transfers []workspaceTransfer, checks []contractCheck) []workspaceTransfer {
+	toImport := transfers
+	if i == 0 {
+		toImport = nil
+		for _, transfer := range transfers {
+			if w.sources.get(task, transfer.Workspace) != "" {
+				toImport = append(toImport, transfer)
+			}
+		}
+	}
+	if len(toImport) == 0 {
+		return nil
+	}
+	w.imports[task] = importKey(toImport)
+	steps := importSteps(w.stepOpts, toImport)
+	if len(checks) > 0 {
+		steps = append(steps, w.stepOpts.withSecurityContext([]v1beta1.Step{checkContractStep(checks, w.stepOpts.CraneImage)})...)
+	}
+	s.Steps = append(steps, s.Steps...)
+	if w.stepOpts.LayerCachePath != "" && len(byWrapper(toImport)[OCIWrapper]) > 0 {
+		addLayerCacheVolume(s, w.stepOpts.LayerCachePath)
+	}
+	return toImport
+}
+
+// injectExports appends the steps exporting the workspaces to s: the
+// snapshots, then the check failing the task if one of checkedSteps
+// failed, then the exports on success.
+func (w *wrapping) injectExports(s *v1beta1.TaskSpec, snapshots, onSuccess []workspaceTransfer, checkedSteps []string) {
+	if len(snapshots) > 0 {
+		s.Steps = append(s.Steps, snapshotSteps(exportSteps(w.stepOpts, snapshots))...)
+		if len(checkedSteps) > 0 {
+			s.Steps = append(s.Steps, w.stepOpts.withSecurityContext([]v1beta1.Step{checkStepsStep(checkedSteps, w.stepOpts.CraneImage)})...)
+		}
+	}
+	if len(onSuccess) > 0 {
+		s.Steps = append(s.Steps, exportSteps(w.stepOpts, onSuccess)...)
+	}
+}
+
+// addFinally appends the finally tasks of the params to the wrapped
+// Pipeline, along with the results some of them expose.
+func (w *wrapping) addFinally() error {
+	p := &w.wrapped.Spec
+	if w.manifest != "" && len(w.reported) > 0 {
+		if w.manifest != manifestResult {
+			w.taskUsages = append(w.taskUsages, TaskUsage{Name: manifestTaskName, Push: []string{registry(w.manifest)}})
+		}
+		mt := manifestTask(w.reported, w.manifest, w.stepOpts.CraneImage)
+		mt.TaskSpec.Steps = w.stepOpts.withSecurityContext(mt.TaskSpec.Steps)
+		p.Finally = append(p.Finally, mt)
+		p.Results = append(p.Results, v1beta1.PipelineResult{
+			Name:        manifestTaskName,
+			Description: "The images the workspaces were exported to by each task, in JSON",
+			Value:       *v1beta1.NewStructuredValues(fmt.Sprintf("$(finally.%s.results.%s)", manifestTaskName, manifestResultName)),
+		})
+	}
+
+	if w.lineageResult && len(w.reported) > 0 {
+		lt := lineageResultTask(&w.pipeline.Spec, w.reported, w.stepOpts.CraneImage)
+		lt.TaskSpec.Steps = w.stepOpts.withSecurityContext(lt.TaskSpec.Steps)
+		p.Finally = append(p.Finally, lt)
+		p.Results = append(p.Results, v1beta1.PipelineResult{
+			Name:        lineagePipelineResult,
+			Description: "The digests each workspace was exported to by each task, in JSON",
+			Value:       *v1beta1.NewStructuredValues(fmt.Sprintf("$(finally.%s.results.%s)", lineageResultTaskName, lineageResultName)),
+		})
+	}
+
+	if w.stepOpts.Lineage.URL != "" && len(w.reported) > 0 {
+		lt := lineageTask(&w.pipeline.Spec, w.reported, w.stepOpts.Lineage)
+		lt.TaskSpec.Steps = w.stepOpts.withSecurityContext(lt.TaskSpec.Steps)
+		p.Finally = append(p.Finally, lt)
+	}
+
+	if w.transactional {
+		w.taskUsages = append(w.taskUsages, tagUsage(promoteTaskName, w.promotions))
+		pt := tagOnSuccessTask(promoteTaskName, w.promotions, w.stepOpts.CraneImage)
+		pt.TaskSpec.Steps = w.stepOpts.withSecurityContext(pt.TaskSpec.Steps)
+		p.Finally = append(p.Finally, pt)
+	}
+
+	if w.archiveTarget != "" {
+		copies := archiveCopies(w.archiveTarget, w.targets, w.wrappers, w.ephemeral)
+		if len(copies) == 0 {
+			return fmt.Errorf("%s requires a workspace wrapped with %s", ArchiveTargetParam, OCIWrapper)
+		}
+		w.taskUsages = append(w.taskUsages, copyUsage(archiveTaskName, copies))
+		at := copyOnSuccessTask(archiveTaskName, copies, w.stepOpts.CraneImage)
+		at.TaskSpec.Steps = w.stepOpts.withSecurityContext(at.TaskSpec.Steps)
+		p.Finally = append(p.Finally, at)
+	}
+
+	if w.verify && w.verified.Len() > 0 {
+		image := defaultVerifyImage
+		if v, ok := w.conf["verify-image"]; ok {
+			image = v
+		}
+		vt, imports := verifyTask(w.stepOpts, w.targets, w.wrappers, w.verified.List(), w.params[VerifyScriptParam], image)
+		w.taskUsages = append(w.taskUsages, newTaskUsage(verifyTaskName, imports, nil))
+		p.Finally = append(p.Finally, vt)
+	}
+
+	if len(w.cleanups) > 0 {
+		w.taskUsages = append(w.taskUsages, cleanupUsage(w.cleanups))
+		ct := cleanupTask(w.cleanups, w.stepOpts.CraneImage)
+		ct.TaskSpec.Steps = w.stepOpts.withSecurityContext(ct.TaskSpec.Steps)
+		p.Finally = append(p.Finally, ct)
+	}
+
+	if w.latest {
+		published := map[string]string{}
+		for ws, target := range w.targets {
+			if !w.ephemeral.Has(ws) {
+				published[ws] = target
+			}
+		}
+		aliases, err := latestAliases(w.pipeline.Name, published, w.wrappers)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", LatestAliasParam, err)
+		}
+		if len(aliases) == 0 {
+			return fmt.Errorf("%s requires a workspace wrapped with %s", LatestAliasParam, OCIWrapper)
+		}
+		for _, a := range aliases {
+			w.otherTags[a.Workspace] = append(w.otherTags[a.Workspace], a.Tag)
+		}
+		w.taskUsages = append(w.taskUsages, tagUsage(latestTaskName, latestTags(aliases)))
+		lt := tagOnSuccessTask(latestTaskName, latestTags(aliases), w.stepOpts.CraneImage)
+		lt.TaskSpec.Steps = w.stepOpts.withSecurityContext(lt.TaskSpec.Steps)
+		p.Finally = append(p.Finally, lt)
+	}
+	return nil
+}
+
+// finishWrapping post-processes the wrapped Pipeline: it records what was
+// injected, read and exported in its annotations, mounts the registry
+// tokens it mints and traces the injected steps.
+func (r *Resolver) finishWrapping(ctx context.Context, w *wrapping) error {
+	p := w.wrapped
+	if p.Annotations == nil {
+		p.Annotations = map[string]string{}
+	}
+	readersJSON, err := json.Marshal(w.readers)
+	if err != nil {
+		return err
+	}
+	p.Annotations[ExportReadersAnnotation] = string(readersJSON)
+	injectedJSON, err := json.Marshal(w.injected)
+	if err != nil {
+		return err
+	}
+	p.Annotations[InjectedStepsAnnotation] = string(injectedJSON)
+	if len(w.warnings) > 0 {
+		warningsJSON, err := json.Marshal(w.warnings)
+		if err != nil {
+			return err
+		}
+		p.Annotations[WarningsAnnotation] = string(warningsJSON)
+		r.warn(ctx, w.pipeline, "OversizedPod", w.warnings)
+	}
+	if unread := unreadExports(w.readers); len(unread) > 0 {
+		p.Annotations[UnreadExportsAnnotation] = strings.Join(unread, ",")
+	}
+
+	if w.strip {
+		stripped := markStrippedWorkspaces(&p.Spec, w.workspaces)
+		if len(stripped) > 0 {
+			p.Annotations[StrippedWorkspacesAnnotation] = strings.Join(stripped, ",")
+		}
+	}
+
+	var tokenSecret string
+	if mint, _ := strconv.ParseBool(w.conf["mint-registry-tokens"]); mint {
+		allowlist, err := parseRegistryTokenRepositories(w.conf["registry-token-repositories"], w.namespace)
+		if err != nil {
+			return err
+		}
+		scopes, err := registryScopes(w.targets, w.wrappers, w.sources, w.manifest, allowlist)
+		if err != nil {
+			return err
+		}
+		if len(scopes) > 0 {
+			tokenSecret, err = r.mintRegistryTokens(ctx, w.conf, w.namespace, scopes)
+			if err != nil {
+				return err
+			}
+			mountRegistryToken(p, w.injected, len(w.pipeline.Spec.Finally), tokenSecret)
+		}
+	}
+
+	traceID := traceIDFromContext(ctx)
+	if traceID == "" {
+		// Wrapped outside of a resolution, e.g. by the Mutator
+		traceID = newTraceID()
+	}
+	traceSteps(p, w.injected, len(w.pipeline.Spec.Finally), traceID)
+	p.Annotations[TraceIDAnnotation] = traceID
+	p.Annotations[MetadataVersionAnnotation] = MetadataVersion
+
+	u := pipelineUsage(p, w.targets, w.wrappers, w.stepOpts, w.sources, w.manifest)
+	u.Tasks = w.taskUsages
+	if tokenSecret != "" {
+		for i := range u.Credentials {
+			if u.Credentials[i].Type == OCIWrapper {
+				u.Credentials[i].Secret = tokenSecret
+			}
+		}
+	}
+	usageJSON, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	p.Annotations[UsageAnnotation] = string(usageJSON)
+	estimateJSON, err := json.Marshal(storageEstimate(w.targets, w.wrappers, w.exportCounts, w.otherTags, w.ephemeral, w.stepOpts.ExportChunks))
+	if err != nil {
+		return err
+	}
+	p.Annotations[StorageEstimateAnnotation] = string(estimateJSON)
+
+	if w.wrappers.uses(ArchiveWrapper) {
+		w.used[FeatureArchiveTargets] = true
+	}
+	w.used.record(ctx)
+	return nil
+}
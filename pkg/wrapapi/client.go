@@ -0,0 +1,152 @@
+package wrapapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// ChunkSize is the size of the parts large Pipelines are split in, in
+// streams, well below the default maximum message size of gRPC.
+const ChunkSize = 1 << 20
+
+// Client wraps Pipelines with the service.
+type Client struct {
+	conn    grpc.ClientConnInterface
+	timeout time.Duration
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithTimeout sets the deadline of the calls of Wrap whose context has none.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// NewClient returns a Client calling the service through conn, e.g. dialed
+// with grpc.WithPerRPCCredentials(Token{...}).
+func NewClient(conn grpc.ClientConnInterface, opts ...ClientOption) *Client {
+	c := &Client{conn: conn}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Wrap wraps the Pipeline of the request.
+func (c *Client) Wrap(ctx context.Context, req *WrapRequest, opts ...grpc.CallOption) (*WrapResponse, error) {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	out := &WrapResponse{}
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(ContentSubtype)}, opts...)
+	if err := c.conn.Invoke(ctx, "/"+ServiceName+"/Wrap", req, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WrapAll wraps the Pipelines of the requests in a single stream, splitting
+// the large ones, and calls fn with the response to each request, in order.
+// A response with an Error only fails that request, while an error of fn
+// stops the stream. The deadline of ctx applies to the whole stream.
+func (c *Client) WrapAll(ctx context.Context, reqs []*WrapRequest, fn func(*WrapResponse) error, opts ...grpc.CallOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(ContentSubtype)}, opts...)
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/WrapStream", opts...)
+	if err != nil {
+		return err
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sendAll(stream, reqs)
+	}()
+
+	var current *WrapResponse
+	for {
+		resp := &WrapResponse{}
+		if err := stream.RecvMsg(resp); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if current == nil {
+			current = resp
+		} else if resp.ID != current.ID {
+			return fmt.Errorf("response %s received while %s is incomplete", resp.ID, current.ID)
+		} else {
+			current.Pipeline = append(current.Pipeline, resp.Pipeline...)
+			current.Error = resp.Error
+		}
+		if resp.More {
+			continue
+		}
+		current.More = false
+		if err := fn(current); err != nil {
+			return err
+		}
+		current = nil
+	}
+	if current != nil {
+		return fmt.Errorf("response %s is incomplete", current.ID)
+	}
+	return <-sent
+}
+
+// sendAll sends the requests on the stream, splitting their Pipelines in
+// Chunks, then closes it.
+func sendAll(stream grpc.ClientStream, reqs []*WrapRequest) error {
+	for _, req := range reqs {
+		chunks := Chunks(req.Pipeline)
+		for i, chunk := range chunks {
+			part := *req
+			part.Pipeline = chunk
+			part.More = i < len(chunks)-1
+			if err := stream.SendMsg(&part); err != nil {
+				return err
+			}
+		}
+	}
+	return stream.CloseSend()
+}
+
+// Chunks splits data in parts of ChunkSize, the last one possibly shorter.
+// Empty data is a single empty part.
+func Chunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > ChunkSize {
+		chunks = append(chunks, data[:ChunkSize])
+		data = data[ChunkSize:]
+	}
+	return append(chunks, data)
+}
+
+// Token passes a bearer token of the cluster with each call, see
+// grpc.WithPerRPCCredentials.
+type Token struct {
+	// Token is the bearer token, e.g. of a service account.
+	Token string
+	// AllowInsecure passes the token over connections without transport
+	// security, e.g. port-forwarded ones.
+	AllowInsecure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (t Token) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{AuthorizationKey: "Bearer " + t.Token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+func (t Token) RequireTransportSecurity() bool {
+	return !t.AllowInsecure
+}
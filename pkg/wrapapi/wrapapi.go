@@ -0,0 +1,151 @@
+// Package wrapapi is the gRPC service of the wrap resolver, for platforms
+// wrapping Pipelines in batches without creating ResolutionRequests, and its
+// Go client.
+//
+// The messages are encoded in JSON, with the "json" content-subtype (the
+// application/grpc+json content-type), rather than generated from protocol
+// buffers.
+package wrapapi
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	// ServiceName is the name of the gRPC service.
+	ServiceName = "wrap.v1.Wrapper"
+	// ContentSubtype is the content-subtype of the messages.
+	ContentSubtype = "json"
+	// AuthorizationKey is the metadata key of the bearer token of the
+	// callers.
+	AuthorizationKey = "authorization"
+)
+
+// WrapRequest asks to wrap a Pipeline.
+type WrapRequest struct {
+	// ID identifies the request among the ones of a stream, e.g. the name of
+	// the Pipeline.
+	ID string `json:"id,omitempty"`
+	// Namespace is the one the Tasks referenced by the Pipeline are fetched
+	// from, the one of the Pipeline by default.
+	Namespace string `json:"namespace,omitempty"`
+	// Params are the params of the resolver, e.g. workspaces and target.
+	Params map[string]string `json:"params,omitempty"`
+	// Pipeline is the Pipeline in YAML or JSON. In streams, large Pipelines
+	// are split in several requests of the same ID.
+	Pipeline []byte `json:"pipeline,omitempty"`
+	// More is set in streams when more parts of the Pipeline follow.
+	More bool `json:"more,omitempty"`
+}
+
+// WrapResponse holds a wrapped Pipeline.
+type WrapResponse struct {
+	// ID is the one of the request.
+	ID string `json:"id,omitempty"`
+	// Pipeline is the wrapped Pipeline in YAML. In streams, large Pipelines
+	// are split in several responses of the same ID.
+	Pipeline []byte `json:"pipeline,omitempty"`
+	// More is set in streams when more parts of the Pipeline follow.
+	More bool `json:"more,omitempty"`
+	// Error is set in streams when the Pipeline of the request couldn't be
+	// wrapped, the stream going on with the next requests. Calls of Wrap
+	// fail with a status instead.
+	Error string `json:"error,omitempty"`
+}
+
+// WrapperServer is the server API of the service.
+type WrapperServer interface {
+	// Wrap wraps a Pipeline.
+	Wrap(context.Context, *WrapRequest) (*WrapResponse, error)
+	// WrapStream wraps the Pipelines of the requests of the stream, in
+	// order, answering each with a response of the same ID.
+	WrapStream(Wrapper_WrapStreamServer) error
+}
+
+// Wrapper_WrapStreamServer is the server side of WrapStream.
+type Wrapper_WrapStreamServer interface {
+	Send(*WrapResponse) error
+	Recv() (*WrapRequest, error)
+	grpc.ServerStream
+}
+
+// RegisterWrapperServer registers the service on s.
+func RegisterWrapperServer(s *grpc.Server, srv WrapperServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*WrapperServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Wrap",
+		Handler:    wrapHandler,
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "WrapStream",
+		Handler:       wrapStreamHandler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+func wrapHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &WrapRequest{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WrapperServer).Wrap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/Wrap",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WrapperServer).Wrap(ctx, req.(*WrapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func wrapStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WrapperServer).WrapStream(&wrapStreamServer{stream})
+}
+
+type wrapStreamServer struct {
+	grpc.ServerStream
+}
+
+func (s *wrapStreamServer) Send(m *WrapResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *wrapStreamServer) Recv() (*WrapRequest, error) {
+	m := &WrapRequest{}
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// codec encodes the messages in JSON.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return ContentSubtype
+}
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
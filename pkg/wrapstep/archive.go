@@ -0,0 +1,49 @@
+package wrapstep
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// ArchiveScheme prefixes the paths of the docker-archive tarballs used as
+// sources, bases and targets instead of images, e.g.
+// docker-archive:/artifacts/sources.tar, for runs without any registry.
+const ArchiveScheme = "docker-archive:"
+
+// archiveTag is the tag of the image of the docker-archive tarballs.
+var archiveTag = name.MustParseReference("wrap.local/workspace:latest").(name.Tag)
+
+// archivePath returns the path of ref if it is a docker-archive tarball.
+func archivePath(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, ArchiveScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref, ArchiveScheme), true
+}
+
+// archiveImage returns the image of the docker-archive tarball at path.
+func archiveImage(path string) (v1.Image, error) {
+	return tarball.ImageFromPath(path, nil)
+}
+
+// writeArchive writes img in a docker-archive tarball at path. The tarball
+// is written next to it then renamed, so that the image being appended onto
+// can be read from path meanwhile, and readers never see a partial tarball.
+func writeArchive(path string, img v1.Image) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := tarball.WriteToFile(tmp, archiveTag, img); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,47 @@
+package wrapstep
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Platforms are the platforms the images of this project are published for.
+var Platforms = []v1.Platform{
+	{OS: "linux", Architecture: "amd64"},
+	{OS: "linux", Architecture: "arm64"},
+}
+
+// BaseImage returns the image the exports of the first tasks append onto:
+// an empty filesystem, for any platform as it is never run.
+func BaseImage() (v1.Image, error) {
+	return PlatformBaseImage(Platforms[0])
+}
+
+// BaseIndex returns the manifest list of the base image for each of the
+// Platforms, so that pulling it matches the platform of the nodes.
+func BaseIndex() (v1.ImageIndex, error) {
+	var adds []mutate.IndexAddendum
+	for _, platform := range Platforms {
+		platform := platform
+		img, err := PlatformBaseImage(platform)
+		if err != nil {
+			return nil, err
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &platform},
+		})
+	}
+	return mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.DockerManifestList), adds...), nil
+}
+
+// PlatformBaseImage returns the base image for the platform.
+func PlatformBaseImage(platform v1.Platform) (v1.Image, error) {
+	return mutate.ConfigFile(empty.Image, &v1.ConfigFile{
+		OS:           platform.OS,
+		Architecture: platform.Architecture,
+		RootFS:       v1.RootFS{Type: "layers"},
+	})
+}
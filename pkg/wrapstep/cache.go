@@ -0,0 +1,115 @@
+package wrapstep
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// cachedImage reads the compressed layers of the image from a directory
+// shared by the pods of a node, keyed by digest, and stores the layers it
+// had to pull in it.
+type cachedImage struct {
+	v1.Image
+	dir string
+}
+
+func (i *cachedImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	cached := make([]v1.Layer, len(layers))
+	for idx, l := range layers {
+		digest, err := l.Digest()
+		if err != nil {
+			return nil, err
+		}
+		cached[idx], err = partial.CompressedToLayer(&cachedLayer{
+			Layer: l,
+			dir:   i.dir,
+			path:  filepath.Join(i.dir, digest.Algorithm, digest.Hex),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cached, nil
+}
+
+type cachedLayer struct {
+	v1.Layer
+	dir  string
+	path string
+}
+
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) {
+	if f, err := os.Open(l.path); err == nil {
+		log.Printf("Using cached layer %s", l.path)
+		return f, nil
+	}
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	// Other pods may be reading the same layer, write it under a
+	// temporary name and only rename it once complete.
+	tmp, err := os.CreateTemp(l.dir, ".layer-*")
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &cachingReader{rc: rc, tmp: tmp, path: l.path}, nil
+}
+
+// cachingReader copies what is read from rc to tmp, which is renamed to
+// path on Close if rc could be read entirely. The compressed layers of
+// remote images are verified against their digest when reaching EOF.
+type cachingReader struct {
+	rc       io.ReadCloser
+	tmp      *os.File
+	path     string
+	complete bool
+	failed   bool
+}
+
+func (r *cachingReader) Read(b []byte) (int, error) {
+	n, err := r.rc.Read(b)
+	if n > 0 && !r.failed {
+		if _, werr := r.tmp.Write(b[:n]); werr != nil {
+			log.Printf("Not caching layer %s: %v", r.path, werr)
+			r.failed = true
+		}
+	}
+	if err == io.EOF {
+		r.complete = true
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	// Readers of the uncompressed layer usually stop at the end of the
+	// tar stream, before the end of the compressed blob.
+	if !r.complete && !r.failed {
+		io.Copy(io.Discard, r)
+	}
+	err := r.rc.Close()
+	if cerr := r.tmp.Close(); cerr != nil {
+		r.failed = true
+	}
+	if r.complete && !r.failed {
+		if rerr := os.Rename(r.tmp.Name(), r.path); rerr == nil {
+			return err
+		}
+	}
+	os.Remove(r.tmp.Name())
+	return err
+}
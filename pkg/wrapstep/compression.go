@@ -0,0 +1,190 @@
+package wrapstep
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compressions of the exported layers, see ExportOptions.Compression.
+const (
+	// CompressionGzip compresses the layers with gzip, the default.
+	CompressionGzip = "gzip"
+	// CompressionZstd compresses the layers with zstd, faster to compress
+	// and to extract. Registries and runtimes must support OCI zstd
+	// layers.
+	CompressionZstd = "zstd"
+	// CompressionNone pushes the layers uncompressed, e.g. for content
+	// which doesn't compress, or a registry next to the nodes.
+	CompressionNone = "none"
+)
+
+// ociLayerZstd is the media type of the OCI zstd layers, which the
+// go-containerregistry version in use doesn't know.
+const ociLayerZstd types.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+// Compressions returns the compressions of the exported layers.
+func Compressions() []string {
+	return []string{CompressionGzip, CompressionZstd, CompressionNone}
+}
+
+// newLayer returns the layer of the tar streams of opener, compressed with
+// compression.
+func newLayer(compression string, opener func() io.ReadCloser, opts ...tarball.LayerOption) (v1.Layer, error) {
+	switch compression {
+	case "", CompressionGzip:
+		return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return opener(), nil
+		}, opts...)
+	case CompressionZstd:
+		return newStreamLayer(opener, ociLayerZstd, func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		})
+	case CompressionNone:
+		return newStreamLayer(opener, types.OCIUncompressedLayer, func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		})
+	}
+	return nil, fmt.Errorf("unknown compression %q, not one of %v", compression, Compressions())
+}
+
+// compressedImage returns the image in an OCI manifest, for the OCI media
+// types of the layers compressed with compression, or as is for gzip.
+func compressedImage(img v1.Image, compression string) v1.Image {
+	if compression == "" || compression == CompressionGzip {
+		return img
+	}
+	return mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), types.OCIConfigJSON)
+}
+
+// streamLayer is a layer of the tar streams of opener, compressed on the
+// fly. Its digests are computed from a first stream, the tar streams of
+// opener being the same each time.
+type streamLayer struct {
+	opener    func() io.ReadCloser
+	compress  func(io.Writer) (io.WriteCloser, error)
+	mediaType types.MediaType
+	digest    v1.Hash
+	diffID    v1.Hash
+	size      int64
+}
+
+func newStreamLayer(opener func() io.ReadCloser, mediaType types.MediaType, compress func(io.Writer) (io.WriteCloser, error)) (*streamLayer, error) {
+	rc := opener()
+	defer rc.Close()
+	compressed, uncompressed := sha256.New(), sha256.New()
+	size := &countingWriter{}
+	w, err := compress(io.MultiWriter(compressed, size))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, io.TeeReader(rc, uncompressed)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &streamLayer{
+		opener:    opener,
+		compress:  compress,
+		mediaType: mediaType,
+		digest:    v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(compressed.Sum(nil))},
+		diffID:    v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(uncompressed.Sum(nil))},
+		size:      size.n,
+	}, nil
+}
+
+func (l *streamLayer) Digest() (v1.Hash, error)             { return l.digest, nil }
+func (l *streamLayer) DiffID() (v1.Hash, error)             { return l.diffID, nil }
+func (l *streamLayer) Size() (int64, error)                 { return l.size, nil }
+func (l *streamLayer) MediaType() (types.MediaType, error)  { return l.mediaType, nil }
+func (l *streamLayer) Uncompressed() (io.ReadCloser, error) { return l.opener(), nil }
+
+// Compressed compresses a tar stream of opener while it is read.
+func (l *streamLayer) Compressed() (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		rc := l.opener()
+		defer rc.Close()
+		w, err := l.compress(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr, nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// decompressedImage returns the image whose zstd layers are decompressed
+// when extracted, which the go-containerregistry version in use only does
+// for gzip.
+func decompressedImage(img v1.Image) v1.Image {
+	return &zstdImage{Image: img}
+}
+
+type zstdImage struct {
+	v1.Image
+}
+
+func (i *zstdImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for j, l := range layers {
+		if mt, err := l.MediaType(); err == nil && mt == ociLayerZstd {
+			layers[j] = &zstdLayer{Layer: l}
+		}
+	}
+	return layers, nil
+}
+
+type zstdLayer struct {
+	v1.Layer
+}
+
+func (l *zstdLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	d, err := zstd.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &zstdReadCloser{Decoder: d, compressed: rc}, nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+	compressed io.Closer
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return r.compressed.Close()
+}
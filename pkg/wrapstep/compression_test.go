@@ -0,0 +1,75 @@
+package wrapstep
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestExportCompression(t *testing.T) {
+	for _, tc := range []struct {
+		compression string
+		chunks      int
+		want        types.MediaType
+	}{
+		{compression: "", want: types.DockerLayer},
+		{compression: CompressionGzip, want: types.DockerLayer},
+		{compression: CompressionZstd, want: ociLayerZstd},
+		{compression: CompressionZstd, chunks: 2, want: ociLayerZstd},
+		{compression: CompressionNone, want: types.OCIUncompressedLayer},
+	} {
+		t.Run(tc.compression, func(t *testing.T) {
+			server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			defer server.Close()
+			target := strings.TrimPrefix(server.URL, "http://") + "/source:latest"
+			dir := t.TempDir()
+			for _, file := range []string{"a", "b"} {
+				if err := os.WriteFile(filepath.Join(dir, file), []byte("content of "+file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := Export(context.Background(), ExportOptions{Path: dir, Target: target, Compression: tc.compression, Chunks: tc.chunks}); err != nil {
+				t.Fatalf("Export() = %v", err)
+			}
+
+			ref, err := name.ParseReference(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := remote.Image(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layers := mustLayers(t, img)
+			if mt, err := layers[len(layers)-1].MediaType(); err != nil || mt != tc.want {
+				t.Errorf("media type of the exported layer = %s, %v, want %s", mt, err, tc.want)
+			}
+
+			imported := t.TempDir()
+			if err := Import(context.Background(), ImportOptions{Source: target, Path: imported}); err != nil {
+				t.Fatalf("Import() = %v", err)
+			}
+			for _, file := range []string{"a", "b"} {
+				if b, err := os.ReadFile(filepath.Join(imported, file)); err != nil || string(b) != "content of "+file {
+					t.Errorf("imported %s = %q, %v, want %q", file, b, err, "content of "+file)
+				}
+			}
+		})
+	}
+}
+
+func TestNewLayerUnknownCompression(t *testing.T) {
+	if _, err := newLayer("lz4", func() io.ReadCloser { return Tar(t.TempDir()) }); err == nil {
+		t.Error("newLayer(lz4) = nil, want an error")
+	}
+}
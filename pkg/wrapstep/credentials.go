@@ -0,0 +1,173 @@
+package wrapstep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// CredentialProvider provides the credentials of the registries the
+// imports and exports access, e.g. from a static docker config, a cloud
+// keychain or Vault. Organizations with their own secret backend register
+// a provider with RegisterCredentialProvider in their build of wrapstep,
+// without forking the transfer code.
+type CredentialProvider interface {
+	// Keychain returns the keychain of the provider, given the argument
+	// of the provider in the credentials spec, see Credentials.
+	Keychain(ctx context.Context, arg string) (authn.Keychain, error)
+}
+
+// CredentialProviderFunc is a function implementing CredentialProvider.
+type CredentialProviderFunc func(ctx context.Context, arg string) (authn.Keychain, error)
+
+// Keychain calls f.
+func (f CredentialProviderFunc) Keychain(ctx context.Context, arg string) (authn.Keychain, error) {
+	return f(ctx, arg)
+}
+
+// DefaultCredentials is the credentials spec of the transfers by default:
+// the docker config of the step, set up by Tekton from the Secrets of the
+// service account.
+const DefaultCredentials = "default"
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]CredentialProvider{
+		"default": CredentialProviderFunc(func(context.Context, string) (authn.Keychain, error) {
+			return authn.DefaultKeychain, nil
+		}),
+		"docker-config": CredentialProviderFunc(dockerConfigKeychain),
+		"helper":        CredentialProviderFunc(helperKeychain),
+		"vault":         CredentialProviderFunc(vaultKeychain),
+	}
+)
+
+// RegisterCredentialProvider makes the provider available under the name
+// in the credentials specs. It panics if the name is already taken.
+func RegisterCredentialProvider(name string, p CredentialProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("credential provider %s is already registered", name))
+	}
+	providers[name] = p
+}
+
+// CredentialProviders returns the names of the registered providers.
+func CredentialProviders() []string {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Credentials returns the keychain of a credentials spec: a comma separated
+// list of <provider> or <provider>=<arg>, the first provider having the
+// credentials of a registry winning. The built-in providers are:
+//   - default: the docker config of the step.
+//   - docker-config=<path>: the static docker config file at path, e.g. a
+//     mounted Secret.
+//   - helper=<name>: the docker-credential-<name> credential helper, in
+//     the PATH of the image, e.g. ecr-login.
+//   - vault=<role>@<path>: the secret at path in Vault, read after logging
+//     in with the kubernetes auth method as role, see VaultAddrEnv.
+func Credentials(ctx context.Context, spec string) (authn.Keychain, error) {
+	var keychains []authn.Keychain
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		providersMu.Lock()
+		p, ok := providers[name]
+		providersMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown credential provider %s, expected one of %s", name, strings.Join(CredentialProviders(), ","))
+		}
+		kc, err := p.Keychain(ctx, arg)
+		if err != nil {
+			return nil, fmt.Errorf("credential provider %s: %v", name, err)
+		}
+		keychains = append(keychains, kc)
+	}
+	if len(keychains) == 0 {
+		return authn.DefaultKeychain, nil
+	}
+	return authn.NewMultiKeychain(keychains...), nil
+}
+
+// staticKeychain resolves the credentials of registries from the auths of
+// a docker config.
+type staticKeychain map[string]authn.AuthConfig
+
+func (k staticKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	for _, key := range []string{registry, "https://" + registry, "https://" + registry + "/v1/", "http://" + registry} {
+		if cfg, ok := k[key]; ok {
+			return authn.FromConfig(cfg), nil
+		}
+	}
+	return authn.Anonymous, nil
+}
+
+func dockerConfigKeychain(_ context.Context, path string) (authn.Keychain, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the path of the docker config is required, e.g. docker-config=/etc/wrap/config.json")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Auths staticKeychain `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %v", path, err)
+	}
+	return config.Auths, nil
+}
+
+// execHelper runs a docker credential helper.
+type execHelper string
+
+func (h execHelper) Get(serverURL string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+string(h), "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s failed: %v: %s", h, err, stderr.String())
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s answered invalid credentials: %v", h, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+func helperKeychain(_ context.Context, name string) (authn.Keychain, error) {
+	if name == "" {
+		return nil, fmt.Errorf("the name of the credential helper is required, e.g. helper=ecr-login")
+	}
+	if _, err := exec.LookPath("docker-credential-" + name); err != nil {
+		return nil, err
+	}
+	return authn.NewKeychainFromHelper(execHelper(name)), nil
+}
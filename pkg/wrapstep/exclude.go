@@ -0,0 +1,63 @@
+package wrapstep
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WrapignoreFile is the file at the root of a workspace listing the paths
+// its exports leave out, one pattern per line. Blank lines and lines
+// starting with # are ignored.
+const WrapignoreFile = ".wrapignore"
+
+// excludes returns the patterns of the paths of dir left out of its
+// exports: the given ones, and the ones of its WrapignoreFile, if any.
+func excludes(dir string, patterns []string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, WrapignoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return patterns, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	all := append([]string{}, patterns...)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		all = append(all, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", WrapignoreFile, err)
+	}
+	return all, nil
+}
+
+// excluded reports whether the path rel, relative to the exported
+// directory, matches one of the patterns. Like tar --exclude, a pattern
+// matches the path or any of its trailing parts, e.g. node_modules matches
+// a/node_modules. The content of an excluded directory is excluded too.
+func excluded(rel string, patterns []string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		for sub := rel; ; {
+			if ok, _ := path.Match(p, sub); ok {
+				return true
+			}
+			i := strings.Index(sub, "/")
+			if i < 0 {
+				break
+			}
+			sub = sub[i+1:]
+		}
+	}
+	return false
+}
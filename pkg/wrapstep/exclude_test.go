@@ -0,0 +1,76 @@
+package wrapstep
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTarExclude(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		exclude    []string
+		wrapignore string
+		want       []string
+	}{{
+		name: "nothing excluded",
+		want: []string{"a.log", "app/", "app/main.go", "app/node_modules/", "app/node_modules/dep.js", "node_modules/", "node_modules/dep.js"},
+	}, {
+		name:    "directory at any depth",
+		exclude: []string{"node_modules"},
+		want:    []string{"a.log", "app/", "app/main.go"},
+	}, {
+		name:    "path",
+		exclude: []string{"app/node_modules"},
+		want:    []string{"a.log", "app/", "app/main.go", "node_modules/", "node_modules/dep.js"},
+	}, {
+		name:       "wrapignore",
+		exclude:    []string{"*.log"},
+		wrapignore: "# dependencies\n\nnode_modules\n",
+		want:       []string{".wrapignore", "app/", "app/main.go"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range []string{"a.log", "app/main.go", "app/node_modules/dep.js", "node_modules/dep.js"} {
+				path := filepath.Join(dir, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.wrapignore != "" {
+				if err := os.WriteFile(filepath.Join(dir, WrapignoreFile), []byte(tc.wrapignore), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			exclude, err := excludes(dir, tc.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc := Tar(dir, exclude...)
+			defer rc.Close()
+			var got []string
+			tr := tar.NewReader(rc)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, hdr.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("tarred %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,556 @@
+package wrapstep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ErrConflict is returned when the target of a compare-and-swap export
+// kept being updated concurrently, see compareAndSwap for its guarantees.
+var ErrConflict = errors.New("target was updated concurrently")
+
+// ErrOwned is returned when the target of an export is owned by another
+// Pipeline, see ExportOptions.Owner.
+var ErrOwned = errors.New("target is owned by another pipeline")
+
+// OwnerAnnotation is the annotation of the manifests of the pushed images
+// holding their owner, see ExportOptions.Owner.
+const OwnerAnnotation = "wrap.tekton.dev/owner"
+
+// ExportOptions describes how to export the content of a workspace.
+type ExportOptions struct {
+	// Path is the directory to export.
+	Path string
+	// Base is the image the content is appended onto, or a docker-archive
+	// tarball prefixed with ArchiveScheme. An empty base means the content
+	// is the only layer of the image.
+	Base string
+	// Target is the image reference to push to, or a docker-archive
+	// tarball prefixed with ArchiveScheme to write.
+	Target string
+	// CompareAndSwap appends the content onto the image the target
+	// currently points to, falling back to Base if there is none, and
+	// only moves the target if it didn't move meanwhile. The swap is
+	// atomic on the registries honoring If-Match on manifests only, see
+	// compareAndSwap.
+	CompareAndSwap bool
+	// Retries is how many times a compare-and-swap export is retried
+	// when it conflicts with another export.
+	Retries int
+	// MaxRate limits the transfer rate with the registry, in bytes per
+	// second. The rate isn't limited if 0.
+	MaxRate int64
+	// Chunks splits the content in as many layers of similar sizes,
+	// compressed and pushed concurrently. Imports flatten the layers
+	// back together.
+	Chunks int
+	// ResultPath is a file the pushed image is reported in, as a JSON
+	// object with its uri and digest, e.g. the path of a Tekton result.
+	// Nothing is reported if empty.
+	ResultPath string
+	// ArtifactType is the media type of the config of the pushed image,
+	// which registries take as its artifact type, in an OCI manifest. The
+	// image keeps the one of its base if empty.
+	ArtifactType string
+	// Tags are other tags of the pushed image, in the repository of the
+	// target.
+	Tags []string
+	// Owner stamps the pushed image as owned by a Pipeline, as
+	// <namespace>/<pipeline> in its OwnerAnnotation, and refuses to push
+	// to a target whose image is owned by another one, unless Force is
+	// set. Images aren't stamped nor checked if empty.
+	Owner string
+	// Force pushes to targets owned by another Pipeline.
+	Force bool
+	// Exclude are the patterns of the paths of Path left out of the
+	// exported content, with the ones of its WrapignoreFile, see Tar.
+	Exclude []string
+	// Compression is the compression of the pushed layers, one of
+	// Compressions, gzip if empty. The image is then pushed in an OCI
+	// manifest, unless gzip.
+	Compression string
+	// Keychain provides the credentials of the registry, see Credentials.
+	// The docker config of the step is used if nil.
+	Keychain authn.Keychain
+}
+
+// Result reports the image an export pushed, the uri being its repository.
+type Result struct {
+	URI    string `json:"uri"`
+	Digest string `json:"digest"`
+}
+
+// Export appends the content of the workspace as a new layer and pushes
+// the resulting image to the target, reporting it in ResultPath if set.
+func Export(ctx context.Context, opts ExportOptions) error {
+	if path, ok := archivePath(opts.Target); ok {
+		return exportArchive(opts, path)
+	}
+	target, err := name.ParseReference(opts.Target)
+	if err != nil {
+		return fmt.Errorf("invalid target %s: %v", opts.Target, err)
+	}
+	var layerOpts []tarball.LayerOption
+	if opts.ArtifactType != "" {
+		layerOpts = append(layerOpts, tarball.WithMediaType(types.OCILayer))
+	}
+	layers, err := contentLayers(opts.Path, opts.Exclude, opts.Chunks, opts.Compression, layerOpts...)
+	if err != nil {
+		return fmt.Errorf("couldn't create layers from %s: %v", opts.Path, err)
+	}
+	remoteOpts := remoteOptions(ctx, opts.Keychain, opts.MaxRate)
+	if len(layers) > 1 {
+		remoteOpts = append(remoteOpts, remote.WithJobs(len(layers)))
+	}
+
+	digest, err := push(ctx, target, opts, layers, remoteOpts)
+	if err != nil {
+		return err
+	}
+	if err := tag(target.Context().Digest(digest.String()), opts.Tags, remoteOpts); err != nil {
+		return err
+	}
+	if opts.ResultPath == "" {
+		return nil
+	}
+	return writeResult(opts.ResultPath, Result{URI: target.Context().Name(), Digest: digest.String()})
+}
+
+// exportArchive appends the content of the workspace as a new layer and
+// writes the resulting image in the docker-archive tarball at path.
+func exportArchive(opts ExportOptions, path string) error {
+	if opts.CompareAndSwap {
+		return fmt.Errorf("compare-and-swap exports to %s are not supported", opts.Target)
+	}
+	if opts.ArtifactType != "" {
+		return fmt.Errorf("artifact types of exports to %s are not supported", opts.Target)
+	}
+	if len(opts.Tags) > 0 {
+		return fmt.Errorf("tags of exports to %s are not supported", opts.Target)
+	}
+	if opts.Owner != "" {
+		return fmt.Errorf("owners of exports to %s are not supported", opts.Target)
+	}
+	if opts.Compression != "" && opts.Compression != CompressionGzip {
+		return fmt.Errorf("%s compression of exports to %s is not supported", opts.Compression, opts.Target)
+	}
+	layers, err := contentLayers(opts.Path, opts.Exclude, opts.Chunks, "")
+	if err != nil {
+		return fmt.Errorf("couldn't create layers from %s: %v", opts.Path, err)
+	}
+	base, err := baseImage(opts.Base, nil)
+	if err != nil {
+		return err
+	}
+	img, err := mutate.AppendLayers(base, layers...)
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(path, img); err != nil {
+		return err
+	}
+	if opts.ResultPath == "" {
+		return nil
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	return writeResult(opts.ResultPath, Result{URI: opts.Target, Digest: digest.String()})
+}
+
+// tag tags the pushed image with the tags, in its repository.
+func tag(pushed name.Digest, tags []string, remoteOpts []remote.Option) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	desc, err := remote.Get(pushed, remoteOpts...)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		ref := pushed.Context().Tag(t)
+		if err := remote.Tag(ref, desc, remoteOpts...); err != nil {
+			return fmt.Errorf("couldn't tag %s: %v", ref, err)
+		}
+	}
+	return nil
+}
+
+func writeResult(path string, r Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// push pushes the layers to the target and returns the digest of the
+// pushed image.
+func push(ctx context.Context, target name.Reference, opts ExportOptions, layers []v1.Layer, remoteOpts []remote.Option) (v1.Hash, error) {
+	if !opts.CompareAndSwap {
+		if err := checkOwner(target, opts, remoteOpts); err != nil {
+			return v1.Hash{}, err
+		}
+		base, err := baseImage(opts.Base, remoteOpts)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		img, err := mutate.AppendLayers(base, layers...)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		img = owned(typed(compressedImage(img, opts.Compression), opts.ArtifactType), opts.Owner)
+		if err := remote.Write(target, img, remoteOpts...); err != nil {
+			return v1.Hash{}, err
+		}
+		return img.Digest()
+	}
+
+	for attempt := 0; ; attempt++ {
+		digest, err := compareAndSwap(ctx, target, opts, layers, remoteOpts)
+		if !errors.Is(err, ErrConflict) {
+			return digest, err
+		}
+		if attempt >= opts.Retries {
+			return v1.Hash{}, fmt.Errorf("couldn't export %s to %s after %d attempts: %w", opts.Path, opts.Target, attempt+1, err)
+		}
+		log.Printf("Export to %s conflicted with another export, retrying", opts.Target)
+		select {
+		case <-ctx.Done():
+			return v1.Hash{}, ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+// compareAndSwap appends layers onto the image target points to and moves
+// target to the result only if it still points to that image. The image is
+// pushed by digest, then target is moved with a conditional manifest PUT,
+// If-Match the digest it pointed to or If-None-Match * if it didn't exist,
+// which the registries supporting it refuse with 412 Precondition Failed
+// when target moved meanwhile. The other registries ignore the condition:
+// target is then checked right before the PUT, and read back after it, the
+// export failing with ErrConflict unless the image it points to holds the
+// layers of the image it was appended onto along with the new ones. On
+// these registries, an export moving target between that check and the PUT
+// can still be overwritten without either export noticing, which is logged
+// when honorsIfMatch finds the registry ignoring If-Match.
+func compareAndSwap(ctx context.Context, target name.Reference, opts ExportOptions, layers []v1.Layer, remoteOpts []remote.Option) (v1.Hash, error) {
+	current, err := head(target, remoteOpts)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	var currentImg v1.Image
+	if current != nil {
+		currentImg, err = remote.Image(target.Context().Digest(current.Digest.String()), remoteOpts...)
+		if err == nil {
+			err = checkOwnerOf(currentImg, target, opts)
+		}
+	} else {
+		currentImg, err = baseImage(opts.Base, remoteOpts)
+	}
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	var baseLayers []v1.Layer
+	if current != nil {
+		if baseLayers, err = currentImg.Layers(); err != nil {
+			return v1.Hash{}, err
+		}
+	}
+	img, err := mutate.AppendLayers(currentImg, layers...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	img = owned(typed(compressedImage(img, opts.Compression), opts.ArtifactType), opts.Owner)
+	digest, err := img.Digest()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if err := remote.Write(target.Context().Digest(digest.String()), img, remoteOpts...); err != nil {
+		return v1.Hash{}, err
+	}
+
+	latest, err := head(target, remoteOpts)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if digestOf(latest) != digestOf(current) {
+		return v1.Hash{}, ErrConflict
+	}
+	if current != nil {
+		honored, err := honorsIfMatch(ctx, target, currentImg, opts)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		if !honored {
+			log.Printf("Warning: %s ignores If-Match on manifests, the exports to %s racing with this one can be overwritten unnoticed", target.Context().RegistryStr(), opts.Target)
+		}
+	}
+	if err := putManifestIf(ctx, target, img, current, opts); err != nil {
+		return v1.Hash{}, err
+	}
+
+	pushed, err := remote.Image(target, remoteOpts...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	ok, err := hasLayers(pushed, append(baseLayers, layers...))
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if !ok {
+		return v1.Hash{}, ErrConflict
+	}
+	return pushed.Digest()
+}
+
+// mismatchedDigest is the digest honorsIfMatch expects manifests not to
+// match.
+const mismatchedDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// honorsIfMatch returns whether the registry of target refuses a manifest
+// PUT to target If-Match a digest it doesn't point to. The probe puts the
+// manifest of current, the image target points to, so that a registry
+// ignoring the condition leaves target as is.
+func honorsIfMatch(ctx context.Context, target name.Reference, current v1.Image, opts ExportOptions) (bool, error) {
+	err := putManifest(ctx, target, current, "If-Match", fmt.Sprintf("%q", mismatchedDigest), opts)
+	if errors.Is(err, ErrConflict) {
+		return true, nil
+	}
+	return false, err
+}
+
+// putManifestIf points target to the pushed image, on condition that it
+// still points to current, or doesn't exist if nil. It returns ErrConflict
+// if the registry refuses the condition.
+func putManifestIf(ctx context.Context, target name.Reference, img v1.Image, current *v1.Descriptor, opts ExportOptions) error {
+	if current != nil {
+		return putManifest(ctx, target, img, "If-Match", fmt.Sprintf("%q", current.Digest.String()), opts)
+	}
+	return putManifest(ctx, target, img, "If-None-Match", "*", opts)
+}
+
+// putManifest points target to img with a manifest PUT carrying the
+// condition header, returning ErrConflict if the registry refuses it.
+func putManifest(ctx context.Context, target name.Reference, img v1.Image, header, condition string, opts ExportOptions) error {
+	keychain := opts.Keychain
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	repo := target.Context()
+	auth, err := keychain.Resolve(repo)
+	if err != nil {
+		return err
+	}
+	var rt http.RoundTripper = http.DefaultTransport
+	if opts.MaxRate > 0 {
+		rt = rateLimitedTransport(opts.MaxRate)
+	}
+	t, err := transport.NewWithContext(ctx, repo.Registry, auth, rt, []string{repo.Scope(transport.PushScope)})
+	if err != nil {
+		return err
+	}
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", repo.Registry.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), target.Identifier())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(manifest))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(mediaType))
+	req.Header.Set(header, condition)
+	resp, err := (&http.Client{Transport: t}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return ErrConflict
+	}
+	return transport.CheckError(resp, http.StatusOK, http.StatusCreated, http.StatusAccepted)
+}
+
+// typed returns the image with artifactType as the media type of its
+// config, in an OCI manifest, or as is if empty.
+func typed(img v1.Image, artifactType string) v1.Image {
+	if artifactType == "" {
+		return img
+	}
+	return mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), types.MediaType(artifactType))
+}
+
+// owned returns the image stamped as owned by owner, or as is if empty.
+func owned(img v1.Image, owner string) v1.Image {
+	if owner == "" {
+		return img
+	}
+	return mutate.Annotations(img, map[string]string{OwnerAnnotation: owner}).(v1.Image)
+}
+
+// checkOwner returns ErrOwned if the image target points to is owned by
+// another Pipeline than the one of the export, unless forced. Images
+// without owner, e.g. pushed before owners were stamped, can be pushed to.
+func checkOwner(target name.Reference, opts ExportOptions, remoteOpts []remote.Option) error {
+	if opts.Owner == "" || opts.Force {
+		return nil
+	}
+	current, err := head(target, remoteOpts)
+	if err != nil || current == nil {
+		return err
+	}
+	img, err := remote.Image(target.Context().Digest(current.Digest.String()), remoteOpts...)
+	if err != nil {
+		return err
+	}
+	return checkOwnerOf(img, target, opts)
+}
+
+func checkOwnerOf(img v1.Image, target name.Reference, opts ExportOptions) error {
+	if opts.Owner == "" || opts.Force {
+		return nil
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	if owner := m.Annotations[OwnerAnnotation]; owner != "" && owner != opts.Owner {
+		return fmt.Errorf("%w: %s belongs to %s, not %s", ErrOwned, target, owner, opts.Owner)
+	}
+	return nil
+}
+
+// head returns the descriptor of ref, or nil if it doesn't exist.
+func head(ref name.Reference, remoteOpts []remote.Option) (*v1.Descriptor, error) {
+	desc, err := remote.Head(ref, remoteOpts...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return desc, err
+}
+
+func digestOf(desc *v1.Descriptor) string {
+	if desc == nil {
+		return ""
+	}
+	return desc.Digest.String()
+}
+
+func hasLayers(img v1.Image, layers []v1.Layer) (bool, error) {
+	imgLayers, err := img.Layers()
+	if err != nil {
+		return false, err
+	}
+	digests := map[v1.Hash]bool{}
+	for _, l := range imgLayers {
+		d, err := l.Digest()
+		if err != nil {
+			return false, err
+		}
+		digests[d] = true
+	}
+	for _, l := range layers {
+		d, err := l.Digest()
+		if err != nil {
+			return false, err
+		}
+		if !digests[d] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// contentLayers returns the layers holding the content of path, without
+// the paths matching the exclude patterns or the ones of its
+// WrapignoreFile, split in chunks layers if more than one, compressed with
+// compression.
+func contentLayers(path string, exclude []string, chunks int, compression string, opts ...tarball.LayerOption) ([]v1.Layer, error) {
+	exclude, err := excludes(path, exclude)
+	if err != nil {
+		return nil, err
+	}
+	single := func() ([]v1.Layer, error) {
+		layer, err := newLayer(compression, func() io.ReadCloser {
+			return Tar(path, exclude...)
+		}, opts...)
+		return []v1.Layer{layer}, err
+	}
+	if chunks <= 1 {
+		return single()
+	}
+	entries, err := walk(path, exclude)
+	if err != nil {
+		return nil, err
+	}
+	var parts [][]entry
+	for _, part := range split(entries, chunks) {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return single()
+	}
+	// Creating a layer compresses it to compute its digest, do it
+	// concurrently as well.
+	layers := make([]v1.Layer, len(parts))
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part []entry) {
+			defer wg.Done()
+			layers[i], errs[i] = newLayer(compression, func() io.ReadCloser {
+				return tarEntries(path, part)
+			}, opts...)
+		}(i, part)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return layers, nil
+}
+
+func baseImage(base string, remoteOpts []remote.Option) (v1.Image, error) {
+	if base == "" {
+		return BaseImage()
+	}
+	if path, ok := archivePath(base); ok {
+		return archiveImage(path)
+	}
+	ref, err := name.ParseReference(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base %s: %v", base, err)
+	}
+	return remote.Image(ref, remoteOpts...)
+}
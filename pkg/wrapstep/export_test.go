@@ -0,0 +1,249 @@
+package wrapstep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// conditionalRegistry serves a registry, honoring If-Match and
+// If-None-Match on the manifest PUTs to tags if conditional. beforeTagPut
+// and afterTagPut run around the next manifest PUT to a tag once set, but
+// the probes of honorsIfMatch, e.g. to push concurrently.
+type conditionalRegistry struct {
+	inner        http.Handler
+	conditional  bool
+	mu           sync.Mutex
+	beforeTagPut func()
+	afterTagPut  func()
+}
+
+func (c *conditionalRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(r.URL.Path, "/manifests/")
+	if r.Method != http.MethodPut || len(path) != 2 || strings.HasPrefix(path[1], "sha256:") {
+		c.inner.ServeHTTP(w, r)
+		return
+	}
+	var before, after func()
+	if r.Header.Get("If-Match") != fmt.Sprintf("%q", mismatchedDigest) {
+		c.mu.Lock()
+		before, after = c.beforeTagPut, c.afterTagPut
+		c.beforeTagPut, c.afterTagPut = nil, nil
+		c.mu.Unlock()
+	}
+	if before != nil {
+		before()
+	}
+	if c.conditional {
+		head := httptest.NewRecorder()
+		c.inner.ServeHTTP(head, httptest.NewRequest(http.MethodHead, r.URL.Path, nil))
+		current := ""
+		if head.Code == http.StatusOK {
+			current = fmt.Sprintf("%q", head.Header().Get("Docker-Content-Digest"))
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != current || r.Header.Get("If-None-Match") == "*" && current != "" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	}
+	c.inner.ServeHTTP(w, r)
+	if after != nil {
+		after()
+	}
+}
+
+func TestExportCompareAndSwap(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		conditional bool
+		// concurrentBefore pushes concurrently before the PUT of the
+		// export, after its check, concurrentAfter right after it.
+		concurrentBefore, concurrentAfter bool
+	}{
+		{name: "conditional", conditional: true},
+		{name: "conditional, concurrent push", conditional: true, concurrentBefore: true},
+		{name: "unconditional"},
+		{name: "unconditional, overwritten", concurrentAfter: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := &conditionalRegistry{inner: registry.New(registry.Logger(log.New(io.Discard, "", 0))), conditional: tc.conditional}
+			server := httptest.NewServer(reg)
+			defer server.Close()
+			target, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/cache:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			initial, err := random.Image(64, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := remote.Write(target, initial); err != nil {
+				t.Fatal(err)
+			}
+			concurrent, err := random.Layer(64, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The concurrent export appends onto the initial image too
+			push := func() {
+				img, err := mutate.AppendLayers(initial, concurrent)
+				if err == nil {
+					err = remote.Write(target, img)
+				}
+				if err != nil {
+					t.Errorf("concurrent push: %v", err)
+				}
+			}
+			if tc.concurrentBefore {
+				reg.beforeTagPut = push
+			}
+			if tc.concurrentAfter {
+				reg.afterTagPut = push
+			}
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var logs strings.Builder
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+			if err := Export(context.Background(), ExportOptions{Path: dir, Target: target.String(), CompareAndSwap: true, Retries: 1}); err != nil {
+				t.Fatalf("Export() = %v", err)
+			}
+			if warned := strings.Contains(logs.String(), "ignores If-Match"); warned == tc.conditional {
+				t.Errorf("Export() warned about If-Match being ignored: %t, want %t", warned, !tc.conditional)
+			}
+
+			img, err := remote.Image(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []v1.Layer{mustLayers(t, initial)[0]}
+			if tc.concurrentBefore || tc.concurrentAfter {
+				want = append(want, concurrent)
+			}
+			if ok, err := hasLayers(img, want); err != nil || !ok {
+				t.Errorf("the target lost layers of the initial image or of the concurrent push: %v", err)
+			}
+			layers := mustLayers(t, img)
+			if len(layers) != len(want)+1 {
+				t.Errorf("the target has %d layers, want %d", len(layers), len(want)+1)
+			}
+		})
+	}
+}
+
+func TestExportCompareAndSwapConflict(t *testing.T) {
+	reg := &conditionalRegistry{inner: registry.New(registry.Logger(log.New(io.Discard, "", 0))), conditional: true}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://") + "/cache:latest"
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The PUT of the export races with another push, without retries
+	reg.beforeTagPut = func() {
+		img, err := mutate.AppendLayers(empty.Image)
+		if err == nil {
+			err = remote.Write(ref, img)
+		}
+		if err != nil {
+			t.Errorf("concurrent push: %v", err)
+		}
+	}
+	dir := t.TempDir()
+	err = Export(context.Background(), ExportOptions{Path: dir, Target: target, CompareAndSwap: true})
+	if err == nil || !strings.Contains(err.Error(), ErrConflict.Error()) {
+		t.Errorf("Export() = %v, want %v", err, ErrConflict)
+	}
+}
+
+func mustLayers(t *testing.T, img v1.Image) []v1.Layer {
+	t.Helper()
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layers
+}
+
+func TestExportOwner(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// exists pushes an image to the target beforehand, owned by owner
+		// if set.
+		exists  bool
+		owner   string
+		force   bool
+		cas     bool
+		wantErr bool
+	}{
+		{name: "new target"},
+		{name: "unowned target", exists: true},
+		{name: "own target", exists: true, owner: "ci/build"},
+		{name: "own target, compare-and-swap", exists: true, owner: "ci/build", cas: true},
+		{name: "target of another pipeline", exists: true, owner: "ci/deploy", wantErr: true},
+		{name: "target of another pipeline, compare-and-swap", exists: true, owner: "ci/deploy", cas: true, wantErr: true},
+		{name: "target of another pipeline, forced", exists: true, owner: "ci/deploy", force: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			defer server.Close()
+			target, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/cache:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.exists {
+				initial, err := random.Image(64, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := remote.Write(target, owned(initial, tc.owner)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dir := t.TempDir()
+			err = Export(context.Background(), ExportOptions{Path: dir, Target: target.String(), CompareAndSwap: tc.cas, Owner: "ci/build", Force: tc.force})
+			if tc.wantErr {
+				if !errors.Is(err, ErrOwned) {
+					t.Errorf("Export() = %v, want %v", err, ErrOwned)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Export() = %v", err)
+			}
+			img, err := remote.Image(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Annotations[OwnerAnnotation]; got != "ci/build" {
+				t.Errorf("the target is owned by %q, want ci/build", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package wrapstep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// MarkerFile is written at the root of an imported workspace, with the
+// digest of the image its content was extracted from.
+const MarkerFile = ".wrap-digest"
+
+// ImportOptions describes how to import the content of a workspace.
+type ImportOptions struct {
+	// Source is the image to import the content from, or a docker-archive
+	// tarball prefixed with ArchiveScheme.
+	Source string
+	// Path is the directory to extract the content in.
+	Path string
+	// CacheDir is a directory where pulled layers are kept by digest, to
+	// be reused by the next imports on the same node. No cache is used if
+	// empty.
+	CacheDir string
+	// MaxRate limits the transfer rate with the registry, in bytes per
+	// second. The rate isn't limited if 0.
+	MaxRate int64
+	// Wait is how long to wait for the registry to serve the source, e.g.
+	// a replica of the one the upstream export pushed to. The source isn't
+	// waited for if 0.
+	Wait time.Duration
+	// AllowMissing leaves the workspace as is when the source doesn't
+	// exist, e.g. when the task exporting it was skipped, instead of
+	// failing. Other failures still fail the import.
+	AllowMissing bool
+	// Keychain provides the credentials of the registry, see Credentials.
+	// The docker config of the step is used if nil.
+	Keychain authn.Keychain
+}
+
+// Import extracts the flattened filesystem of the source image in the
+// workspace. Extraction is skipped if the marker file of the workspace
+// shows it already holds the content of the image, e.g. when a step is
+// retried or the workspace volume is reused.
+func Import(ctx context.Context, opts ImportOptions) error {
+	img, err := sourceImage(ctx, opts)
+	if err != nil && opts.AllowMissing && missing(err) {
+		log.Printf("Warning: %s doesn't exist, continuing with an empty workspace", opts.Source)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	marker := filepath.Join(opts.Path, MarkerFile)
+	if b, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(b)) == digest.String() {
+		log.Printf("Workspace content of %s is already extracted in %s, skipping", opts.Source, opts.Path)
+		return nil
+	}
+
+	if opts.CacheDir != "" {
+		img = &cachedImage{Image: img, dir: opts.CacheDir}
+	}
+	rc := mutate.Extract(decompressedImage(img))
+	defer rc.Close()
+	if err := Untar(rc, opts.Path); err != nil {
+		return err
+	}
+	return os.WriteFile(marker, []byte(digest.String()+"\n"), 0o644)
+}
+
+// missing tells whether err is the failure to get a source which doesn't
+// exist: a registry answering NotFound, a registry never serving it within
+// the wait, or a missing docker-archive tarball.
+func missing(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	return errors.Is(err, errNotVisible) || errors.Is(err, os.ErrNotExist)
+}
+
+// sourceImage returns the image to import, from a registry or a
+// docker-archive tarball.
+func sourceImage(ctx context.Context, opts ImportOptions) (v1.Image, error) {
+	if path, ok := archivePath(opts.Source); ok {
+		return archiveImage(path)
+	}
+	ref, err := name.ParseReference(opts.Source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %s: %v", opts.Source, err)
+	}
+	remoteOpts := remoteOptions(ctx, opts.Keychain, opts.MaxRate)
+	if opts.Wait > 0 {
+		if err := waitForManifest(ctx, ref, remoteOpts, opts.Wait); err != nil {
+			return nil, err
+		}
+	}
+	return remote.Image(ref, remoteOpts...)
+}
+
+func remoteOptions(ctx context.Context, keychain authn.Keychain, maxRate int64) []remote.Option {
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+	}
+	if maxRate > 0 {
+		opts = append(opts, remote.WithTransport(rateLimitedTransport(maxRate)))
+	}
+	return opts
+}
@@ -0,0 +1,45 @@
+package wrapstep
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+func TestImportAllowMissing(t *testing.T) {
+	inner := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/broken/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	for _, tc := range []struct {
+		name         string
+		source       string
+		allowMissing bool
+		wantErr      bool
+	}{
+		{name: "missing image", source: host + "/source:latest", wantErr: true},
+		{name: "missing image allowed", source: host + "/source:latest", allowMissing: true},
+		{name: "missing archive allowed", source: ArchiveScheme + filepath.Join(t.TempDir(), "source.tar"), allowMissing: true},
+		{name: "registry failure", source: host + "/broken:latest", allowMissing: true, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Import(context.Background(), ImportOptions{Source: tc.source, Path: t.TempDir(), AllowMissing: tc.allowMissing})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Import() = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package wrapstep
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Prefetch pulls the layers of the source image in the layer cache of the
+// node, so that the next imports on the node don't have to pull them.
+func Prefetch(ctx context.Context, source, cacheDir string) error {
+	ref, err := name.ParseReference(source)
+	if err != nil {
+		return fmt.Errorf("invalid source %s: %v", source, err)
+	}
+	img, err := remote.Image(ref, remoteOptions(ctx, nil, 0)...)
+	if err != nil {
+		return err
+	}
+	layers, err := (&cachedImage{Image: img, dir: cacheDir}).Layers()
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		rc, err := l.Compressed()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, rc)
+		if cerr := rc.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package wrapstep
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedTransport limits the rate at which the bodies of requests are
+// sent, and the rate at which the bodies of responses are received, to
+// bytesPerSecond each.
+func rateLimitedTransport(bytesPerSecond int64) http.RoundTripper {
+	return &limitedTransport{
+		inner:    remote.DefaultTransport,
+		upload:   rate.NewLimiter(rate.Limit(bytesPerSecond), burst(bytesPerSecond)),
+		download: rate.NewLimiter(rate.Limit(bytesPerSecond), burst(bytesPerSecond)),
+	}
+}
+
+type limitedTransport struct {
+	inner            http.RoundTripper
+	upload, download *rate.Limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		body := req.Body
+		req = req.Clone(req.Context())
+		req.Body = &limitedReader{ctx: req.Context(), rc: body, limiter: t.upload}
+	}
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &limitedReader{ctx: req.Context(), rc: resp.Body, limiter: t.download}
+	return resp, nil
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	rc      io.ReadCloser
+	limiter *rate.Limiter
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	if len(b) > r.limiter.Burst() {
+		b = b[:r.limiter.Burst()]
+	}
+	n, err := r.rc.Read(b)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	return r.rc.Close()
+}
+
+// burst lets reads of up to 32KiB through at once, unless the rate is
+// lower than that.
+func burst(bytesPerSecond int64) int {
+	if bytesPerSecond < 32*1024 {
+		return int(bytesPerSecond)
+	}
+	return 32 * 1024
+}
@@ -0,0 +1,220 @@
+package wrapstep
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Tar returns a tar stream of the content of dir, with paths relative to
+// dir, without the paths matching the exclude patterns. Walking dir happens
+// while the stream is read.
+func Tar(dir string, exclude ...string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		entries, err := walk(dir, exclude)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(writeTar(pw, dir, entries))
+	}()
+	return pr
+}
+
+// tarEntries returns a tar stream of the given entries of dir.
+func tarEntries(dir string, entries []entry) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, dir, entries))
+	}()
+	return pr
+}
+
+// entry is a file of a directory to archive.
+type entry struct {
+	rel  string
+	info os.FileInfo
+}
+
+// walk returns the entries of dir not matching the exclude patterns, in
+// lexical order.
+func walk(dir string, exclude []string) ([]entry, error) {
+	var entries []entry
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if excluded(rel, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries = append(entries, entry{rel: rel, info: info})
+		return nil
+	})
+	return entries, err
+}
+
+// split distributes the entries of dir in n parts of similar sizes. The
+// first part holds all the directories and links, regular files are
+// distributed from the biggest to the smallest in the smallest part.
+func split(entries []entry, n int) [][]entry {
+	parts := make([][]entry, n)
+	sizes := make([]int64, n)
+	var files []entry
+	for _, e := range entries {
+		if e.info.Mode().IsRegular() {
+			files = append(files, e)
+			continue
+		}
+		parts[0] = append(parts[0], e)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].info.Size() > files[j].info.Size()
+	})
+	for _, f := range files {
+		smallest := 0
+		for i := range sizes {
+			if sizes[i] < sizes[smallest] {
+				smallest = i
+			}
+		}
+		parts[smallest] = append(parts[smallest], f)
+		sizes[smallest] += f.info.Size()
+	}
+	for _, p := range parts {
+		sort.SliceStable(p, func(i, j int) bool { return p[i].rel < p[j].rel })
+	}
+	return parts
+}
+
+func writeTar(w io.Writer, dir string, entries []entry) error {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		if err := writeEntry(tw, dir, e); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, dir string, e entry) error {
+	path := filepath.Join(dir, e.rel)
+	var link string
+	if e.info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(e.info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(e.rel)
+	if e.info.IsDir() {
+		hdr.Name += "/"
+	}
+	// Owners don't make sense from one pod to another
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !e.info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Untar extracts the tar stream r into dir. Entries can't be extracted
+// outside of dir.
+func Untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := within(dir, hdr.Name)
+		if path == dir {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode); err != nil {
+				return err
+			}
+			if err := os.Chmod(path, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			if err := os.Chmod(path, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := replace(path, func() error { return os.Symlink(hdr.Linkname, path) }); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			if err := replace(path, func() error { return os.Link(within(dir, hdr.Linkname), path) }); err != nil {
+				return err
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Skipping %s, unsupported type %c\n", hdr.Name, hdr.Typeflag)
+			continue
+		}
+		if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+// within returns the path of name relative to dir, without escaping dir.
+func within(dir, name string) string {
+	return filepath.Join(dir, filepath.Clean("/"+strings.TrimPrefix(name, "./")))
+}
+
+func replace(path string, create func() error) error {
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	return create()
+}
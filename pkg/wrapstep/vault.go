@@ -0,0 +1,193 @@
+package wrapstep
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+const (
+	// VaultAddrEnv is the environment variable holding the address of
+	// Vault, e.g. https://vault.vault:8200.
+	VaultAddrEnv = "VAULT_ADDR"
+	// VaultAuthPathEnv is the environment variable holding the mount path
+	// of the kubernetes auth method, kubernetes by default.
+	VaultAuthPathEnv = "VAULT_AUTH_PATH"
+	// VaultNamespaceEnv is the environment variable holding the Vault
+	// Enterprise namespace, if any.
+	VaultNamespaceEnv = "VAULT_NAMESPACE"
+	// VaultCACertEnv is the environment variable holding the path of the
+	// CA certificate of Vault, if not trusted by the image.
+	VaultCACertEnv = "VAULT_CACERT"
+)
+
+// serviceAccountTokenPath is the token of the service account of the step,
+// logging in to Vault.
+var serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultKeychain returns the keychain of the registry credentials read from
+// Vault, given <role>@<path>: the step logs in with the kubernetes auth
+// method as role, using the token of its service account, reads the secret
+// at path and revokes its Vault token. The secret either holds the auths
+// of a docker config (in auths, or in .dockerconfigjson), or the username
+// and password of registry. Secrets engines issuing short-lived
+// credentials keep them out of the namespaces altogether.
+func vaultKeychain(ctx context.Context, arg string) (authn.Keychain, error) {
+	role, path, ok := strings.Cut(arg, "@")
+	if !ok || role == "" || path == "" {
+		return nil, fmt.Errorf("the role and the path of the secret are required, e.g. vault=wrap@secret/data/registries")
+	}
+	addr := os.Getenv(VaultAddrEnv)
+	if addr == "" {
+		return nil, fmt.Errorf("%s isn't set", VaultAddrEnv)
+	}
+	c, err := newVaultClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading the token of the service account: %v", err)
+	}
+	authPath := os.Getenv(VaultAuthPathEnv)
+	if authPath == "" {
+		authPath = "kubernetes"
+	}
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))}
+	if err := c.do(ctx, http.MethodPost, "auth/"+strings.Trim(authPath, "/")+"/login", body, &login); err != nil {
+		return nil, fmt.Errorf("login as %s: %v", role, err)
+	}
+	c.token = login.Auth.ClientToken
+	defer func() {
+		// The token is only needed to read the secret.
+		_ = c.do(ctx, http.MethodPost, "auth/token/revoke-self", nil, nil)
+	}()
+
+	var secret struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, strings.Trim(path, "/"), nil, &secret); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return parseVaultSecret(secret.Data)
+}
+
+// parseVaultSecret returns the keychain of the data of a secret, unwrapping
+// the one of the version 2 of the kv secrets engine.
+func parseVaultSecret(raw json.RawMessage) (authn.Keychain, error) {
+	var data struct {
+		Data             json.RawMessage `json:"data"`
+		Auths            staticKeychain  `json:"auths"`
+		DockerConfigJSON string          `json:".dockerconfigjson"`
+		Registry         string          `json:"registry"`
+		Username         string          `json:"username"`
+		Password         string          `json:"password"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+	switch {
+	case len(data.Auths) > 0:
+		return data.Auths, nil
+	case data.DockerConfigJSON != "":
+		var config struct {
+			Auths staticKeychain `json:"auths"`
+		}
+		if err := json.Unmarshal([]byte(data.DockerConfigJSON), &config); err != nil {
+			return nil, fmt.Errorf("invalid .dockerconfigjson: %v", err)
+		}
+		return config.Auths, nil
+	case data.Registry != "":
+		return staticKeychain{data.Registry: {Username: data.Username, Password: data.Password}}, nil
+	case len(data.Data) > 0 && string(data.Data) != "null":
+		return parseVaultSecret(data.Data)
+	}
+	return nil, fmt.Errorf("the secret holds neither auths, .dockerconfigjson nor registry, username and password")
+}
+
+// vaultClient calls the HTTP API of Vault.
+type vaultClient struct {
+	addr      string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+func newVaultClient(addr string) (*vaultClient, error) {
+	c := &vaultClient{
+		addr:      strings.TrimSuffix(addr, "/"),
+		namespace: os.Getenv(VaultNamespaceEnv),
+		client:    http.DefaultClient,
+	}
+	if path := os.Getenv(VaultCACertEnv); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", path)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		c.client = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+func (c *vaultClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+path, body)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(b, &e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(e.Errors, ", "))
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
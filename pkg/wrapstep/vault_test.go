@@ -0,0 +1,136 @@
+package wrapstep
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestVaultKeychain(t *testing.T) {
+	serviceAccountTokenPath = filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(serviceAccountTokenPath, []byte("jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		arg     string
+		secret  string
+		want    authn.AuthConfig
+		wantErr string
+	}{{
+		name:   "kv v2",
+		arg:    "wrap@secret/data/registries",
+		secret: `{"data": {"data": {"registry": "registry.example.com", "username": "bot", "password": "s3cr3t"}}}`,
+		want:   authn.AuthConfig{Username: "bot", Password: "s3cr3t"},
+	}, {
+		name:   "auths",
+		arg:    "wrap@secret/registries",
+		secret: `{"data": {"auths": {"registry.example.com": {"username": "bot", "password": "s3cr3t"}}}}`,
+		want:   authn.AuthConfig{Username: "bot", Password: "s3cr3t"},
+	}, {
+		name:   "docker config",
+		arg:    "wrap@secret/registries",
+		secret: `{"data": {".dockerconfigjson": "{\"auths\": {\"https://registry.example.com\": {\"auth\": \"Ym90OnMzY3IzdA==\"}}}"}}`,
+		want:   authn.AuthConfig{Auth: "Ym90OnMzY3IzdA=="},
+	}, {
+		name:    "denied role",
+		arg:     "other@secret/registries",
+		wantErr: "login as other: 403 Forbidden: permission denied",
+	}, {
+		name:    "no credentials",
+		arg:     "wrap@secret/registries",
+		secret:  `{"data": {"token": "abc"}}`,
+		wantErr: "the secret holds neither auths",
+	}, {
+		name:    "no path",
+		arg:     "wrap",
+		wantErr: "the role and the path of the secret are required",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var revoked bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Vault-Namespace") != "team" {
+					http.Error(w, `{"errors": ["no namespace"]}`, http.StatusBadRequest)
+					return
+				}
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/k8s/login":
+					var login map[string]string
+					if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login["role"] != "wrap" || login["jwt"] != "jwt" {
+						http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+						return
+					}
+					w.Write([]byte(`{"auth": {"client_token": "token"}}`))
+				case r.Header.Get("X-Vault-Token") != "token":
+					http.Error(w, `{"errors": ["missing client token"]}`, http.StatusForbidden)
+				case r.Method == http.MethodGet && "/v1/"+strings.SplitN(tc.arg, "@", 2)[1] == r.URL.Path:
+					w.Write([]byte(tc.secret))
+				case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/token/revoke-self":
+					revoked = true
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			t.Setenv(VaultAddrEnv, server.URL)
+			t.Setenv(VaultAuthPathEnv, "/k8s/")
+			t.Setenv(VaultNamespaceEnv, "team")
+
+			kc, err := vaultKeychain(context.Background(), tc.arg)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("vaultKeychain() = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !revoked {
+				t.Error("vaultKeychain() didn't revoke its Vault token")
+			}
+			got := resolveConfig(t, kc, "registry.example.com/source")
+			if got != tc.want {
+				t.Errorf("vaultKeychain() resolved %+v, want %+v", got, tc.want)
+			}
+			if got := resolveConfig(t, kc, "other.example.com/source"); got != (authn.AuthConfig{}) {
+				t.Errorf("vaultKeychain() resolved %+v for another registry, want anonymous", got)
+			}
+		})
+	}
+}
+
+func TestVaultKeychainNotConfigured(t *testing.T) {
+	t.Setenv(VaultAddrEnv, "")
+	if _, err := vaultKeychain(context.Background(), "wrap@secret/registries"); err == nil || !strings.Contains(err.Error(), VaultAddrEnv) {
+		t.Errorf("vaultKeychain() without %s = %v, want an error", VaultAddrEnv, err)
+	}
+}
+
+// resolveConfig returns the credentials kc resolves for the registry of
+// image.
+func resolveConfig(t *testing.T, kc authn.Keychain, image string) authn.AuthConfig {
+	t.Helper()
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := kc.Resolve(ref.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := auth.Authorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *cfg
+}
@@ -0,0 +1,41 @@
+package wrapstep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// waitPoll is how often waitForManifest asks the registry for the
+// manifest.
+var waitPoll = 2 * time.Second
+
+// errNotVisible is returned when the registry didn't serve the manifest
+// within the wait.
+var errNotVisible = errors.New("still not visible in the registry")
+
+// waitForManifest waits up to timeout for the registry to serve the
+// manifest of ref. Replicated registries may not serve an image right
+// after it was pushed to another replica, failing the imports following
+// the export with NotFound.
+func waitForManifest(ctx context.Context, ref name.Reference, remoteOpts []remote.Option, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		desc, err := head(ref, remoteOpts)
+		if err != nil || desc != nil {
+			return err
+		}
+		log.Printf("Waiting for %s to be visible in the registry", ref)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s is %w after %s", ref, errNotVisible, timeout)
+		case <-time.After(waitPoll):
+		}
+	}
+}
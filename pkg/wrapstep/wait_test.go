@@ -0,0 +1,63 @@
+package wrapstep
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestWaitForManifest(t *testing.T) {
+	waitPoll = 10 * time.Millisecond
+	for _, tc := range []struct {
+		name string
+		// misses is how many manifest requests the replica misses the
+		// image for.
+		misses  int32
+		wantErr bool
+	}{
+		{name: "visible", misses: 0},
+		{name: "replicated", misses: 3},
+		{name: "never visible", misses: 1000, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inner := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+			var misses int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodHead && atomic.AddInt32(&misses, 1) <= tc.misses {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				inner.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/source:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := random.Image(64, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := remote.Write(ref, img); err != nil {
+				t.Fatal(err)
+			}
+			// Only the replica misses it
+			atomic.StoreInt32(&misses, 0)
+			err = waitForManifest(context.Background(), ref, nil, 500*time.Millisecond)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("waitForManifest() = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}